@@ -266,12 +266,15 @@ func main() {
 	_ = tx.Rollback()
 	log.Printf("Connection acquired from database [%s]", *dbType)
 
-	var hostSwitch server.HostSwitch
+	var hostSwitch *server.HostSwitch
 	var mailDaemon *guerrilla.Daemon
 	var taskScheduler resource.TaskScheduler
+	var documentStore *resource.YjsDocumentStore
+	var outboxWorker *resource.OutboxDeliveryWorker
 	var certManager *resource.CertificateManager
 	var configStore *resource.ConfigStore
 	var ftpServer *server2.FtpServer
+	var sftpServer *server.DaptinSftpServer
 	var imapServerInstance *imapServer.Server
 	var olricDb *olric.Olric
 
@@ -296,10 +299,10 @@ func main() {
 		resource.CheckErr(err, "failed to start cache server")
 	}()
 
-	hostSwitch, mailDaemon, taskScheduler, configStore, certManager,
-		ftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db, *localStoragePath, olricDb)
+	hostSwitch, mailDaemon, taskScheduler, documentStore, outboxWorker, configStore, certManager,
+		ftpServer, sftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db, *localStoragePath, olricDb)
 	rhs := RestartHandlerServer{
-		HostSwitch: &hostSwitch,
+		HostSwitch: hostSwitch,
 	}
 
 	if *runtimeMode == "profile" {
@@ -339,11 +342,21 @@ func main() {
 		startTime := time.Now()
 
 		log.Printf("Close down services and db connection")
+		hostSwitch.Close()
 		taskScheduler.StopTasks()
+		documentStore.Stop()
+		outboxWorker.Stop()
 		if ftpServer != nil {
 			ftpServer.Stop()
 		}
 
+		if sftpServer != nil {
+			err = sftpServer.Close()
+			if err != nil {
+				log.Printf("Failed to close sftp server: %v", err)
+			}
+		}
+
 		if mailDaemon != nil {
 			mailDaemon.Shutdown()
 		}
@@ -353,6 +366,9 @@ func main() {
 			if err != nil {
 				log.Printf("Failed to close imap server connections: %v", err)
 			}
+			if imapBackend, ok := imapServerInstance.Backend.(*resource.DaptinImapBackend); ok {
+				imapBackend.Close()
+			}
 		}
 
 		log.Printf("All connections closed")
@@ -363,9 +379,9 @@ func main() {
 			return
 		}
 
-		hostSwitch, mailDaemon, taskScheduler, configStore, certManager,
-			ftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db1, *localStoragePath, olricDb)
-		rhs.HostSwitch = &hostSwitch
+		hostSwitch, mailDaemon, taskScheduler, documentStore, outboxWorker, configStore, certManager,
+			ftpServer, sftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db1, *localStoragePath, olricDb)
+		rhs.HostSwitch = hostSwitch
 		err = db.Close()
 		auth.CheckErr(err, "Failed to close old db connection")
 		log.Printf("Restart complete, took %f seconds", float64(time.Now().UnixNano()-startTime.UnixNano())/float64(1000000000))
@@ -8,7 +8,8 @@ import (
 
 func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.ConfigStore,
 	cruds map[string]*resource.DbResource, mailDaemon *guerrilla.Daemon,
-	hostSwitch HostSwitch, certificateManager *resource.CertificateManager) []resource.ActionPerformerInterface {
+	hostSwitch *HostSwitch, certificateManager *resource.CertificateManager,
+	outboxWorker *resource.OutboxDeliveryWorker) []resource.ActionPerformerInterface {
 
 	performers := make([]resource.ActionPerformerInterface, 0)
 
@@ -48,6 +49,10 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create data import performer")
 	performers = append(performers, importDataPerformer)
 
+	schemaMigratePerformer, err := resource.NewSchemaMigratePerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create schema migrate performer")
+	performers = append(performers, schemaMigratePerformer)
+
 	oauth2redirect, err := resource.NewOauthLoginBeginActionPerformer(initConfig, cruds, configStore)
 	resource.CheckErr(err, "Failed to create oauth2 request performer")
 	performers = append(performers, oauth2redirect)
@@ -84,6 +89,22 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create generate jwt performer")
 	performers = append(performers, generateJwtPerformer)
 
+	refreshJwtPerformer, err := resource.NewRefreshJwtTokenPerformer(configStore, cruds)
+	resource.CheckErr(err, "Failed to create refresh jwt performer")
+	performers = append(performers, refreshJwtPerformer)
+
+	revokeJwtPerformer, err := resource.NewRevokeJwtTokenPerformer(cruds)
+	resource.CheckErr(err, "Failed to create revoke jwt performer")
+	performers = append(performers, revokeJwtPerformer)
+
+	generateApiKeyPerformer, err := resource.NewGenerateApiKeyPerformer(cruds)
+	resource.CheckErr(err, "Failed to create generate api key performer")
+	performers = append(performers, generateApiKeyPerformer)
+
+	accountUnlockPerformer, err := resource.NewAccountUnlockPerformer(cruds)
+	resource.CheckErr(err, "Failed to create account unlock performer")
+	performers = append(performers, accountUnlockPerformer)
+
 	NewNetworkRequestPerformer, err := resource.NewNetworkRequestPerformer(initConfig, cruds)
 	resource.CheckErr(err, "Failed to create generate network request performer")
 	performers = append(performers, NewNetworkRequestPerformer)
@@ -104,7 +125,7 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create mail server sync performer")
 	performers = append(performers, mailServerSync)
 
-	mailSendAction, err := resource.NewMailSendActionPerformer(cruds, mailDaemon, certificateManager)
+	mailSendAction, err := resource.NewMailSendActionPerformer(cruds, mailDaemon, certificateManager, outboxWorker)
 	resource.CheckErr(err, "Failed to create mail send performer")
 	performers = append(performers, mailSendAction)
 
@@ -156,7 +177,7 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create cloudStoreSiteCreateActionPerformer")
 	performers = append(performers, cloudStoreSiteCreateActionPerformer)
 
-	acmeTlsCertificateGenerateActionPerformer, err := resource.NewAcmeTlsCertificateGenerateActionPerformer(cruds, configStore, hostSwitch.handlerMap["api"])
+	acmeTlsCertificateGenerateActionPerformer, err := resource.NewAcmeTlsCertificateGenerateActionPerformer(cruds, configStore, hostSwitch.handler("api"))
 	resource.CheckErr(err, "Failed to create acme tls certificate generator")
 	performers = append(performers, acmeTlsCertificateGenerateActionPerformer)
 
@@ -164,6 +185,18 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create self tls certificate generator")
 	performers = append(performers, selfTlsCertificateGenerateActionPerformer)
 
+	exchangeFailureReplayPerformer, err := resource.NewExchangeFailureReplayPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create exchange failure replay performer")
+	performers = append(performers, exchangeFailureReplayPerformer)
+
+	exchangeFailureDiscardPerformer, err := resource.NewExchangeFailureDiscardPerformer(cruds)
+	resource.CheckErr(err, "Failed to create exchange failure discard performer")
+	performers = append(performers, exchangeFailureDiscardPerformer)
+
+	taskRunNowPerformer, err := resource.NewTaskRunNowPerformer(TaskScheduler)
+	resource.CheckErr(err, "Failed to create task run now performer")
+	performers = append(performers, taskRunNowPerformer)
+
 	integrationInstallationPerformer, err := resource.NewIntegrationInstallationPerformer(initConfig, cruds, configStore)
 	resource.CheckErr(err, "Failed to create integration installation performer")
 	performers = append(performers, integrationInstallationPerformer)
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ApiKeyHeader carries an api key minted with the generate_api_key action, used instead of a jwt token by
+// machine clients
+const ApiKeyHeader = "X-API-Key"
+
+const ApiKeyTableName = "api_key"
+
+var ErrInvalidApiKey = errors.New("invalid api key")
+
+// ApiKeyScopes narrow what a session created from an api key can do. Each scope is "<verb>:<name>" where the
+// verb is one of read, create, update, delete for tables and execute for actions, and the name is a table name,
+// an action name or *. "*:order" allows every verb on order. A * name never matches the api_key table so a key
+// can mint other keys only when it says so explicitly
+type ApiKeyScopes []string
+
+// ParseApiKeyScopes reads a comma or whitespace separated scope list
+func ParseApiKeyScopes(value string) ApiKeyScopes {
+	scopes := make(ApiKeyScopes, 0)
+	for _, scope := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		scopes = append(scopes, strings.ToLower(scope))
+	}
+	return scopes
+}
+
+// ValidateApiKeyScopes checks every scope is a known verb followed by a name
+func ValidateApiKeyScopes(scopes ApiKeyScopes) error {
+	for _, scope := range scopes {
+		parts := strings.SplitN(scope, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("invalid scope [%v], expected <verb>:<name>", scope)
+		}
+		switch parts[0] {
+		case "read", "create", "update", "delete", "execute", "*":
+		default:
+			return fmt.Errorf("invalid verb in scope [%v]", scope)
+		}
+	}
+	return nil
+}
+
+// ApiKeyScopeVerb is the scope verb needed for a http method on a table
+func ApiKeyScopeVerb(method string) string {
+	switch strings.ToUpper(method) {
+	case "GET":
+		return "read"
+	case "POST":
+		return "create"
+	case "PUT", "PATCH":
+		return "update"
+	case "DELETE":
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+func (s ApiKeyScopes) allows(verb string, name string) bool {
+	for _, scope := range s {
+		parts := strings.SplitN(scope, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] != verb && !(parts[0] == "*" && verb != "execute") {
+			continue
+		}
+		if parts[1] == name || (parts[1] == "*" && name != ApiKeyTableName) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsTable is true if the session can call method on the table, always true for sessions not created
+// from an api key
+func (s *SessionUser) AllowsTable(tableName string, method string) bool {
+	if s.ApiKeyScopes == nil {
+		return true
+	}
+	return s.ApiKeyScopes.allows(ApiKeyScopeVerb(method), tableName)
+}
+
+// AllowsAction is true if the session can invoke the action, always true for sessions not created from an
+// api key
+func (s *SessionUser) AllowsAction(actionName string) bool {
+	if s.ApiKeyScopes == nil {
+		return true
+	}
+	return s.ApiKeyScopes.allows("execute", actionName)
+}
+
+// HashApiKey is the hash stored for an api key, the key itself is never stored
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ApiKeyCheck identifies the user of the api key in the request and narrows the session to the scopes of the
+// key. The time and ip of the call are recorded on the key
+func (a *AuthMiddleware) ApiKeyCheck(req *http.Request) (*SessionUser, error) {
+
+	key := req.Header.Get(ApiKeyHeader)
+
+	query, args, err := statementbuilder.Squirrel.Select(
+		goqu.I("k.id"), goqu.I("k.scopes"), goqu.I("k.expires_at"), goqu.I("k.enabled"),
+		goqu.I("u.id"), goqu.I("u.reference_id")).
+		From(goqu.T(ApiKeyTableName).As("k")).
+		Join(goqu.T("user_account").As("u"), goqu.On(goqu.Ex{
+			"u.id": goqu.I("k.user_account_id"),
+		})).
+		Where(goqu.Ex{"k.key_hash": HashApiKey(key)}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var keyId, userId int64
+	var scopes, userReferenceId string
+	var expiresAt interface{}
+	var enabled bool
+	err = a.db.QueryRowx(query, args...).Scan(&keyId, &scopes, &expiresAt, &enabled, &userId, &userReferenceId)
+	if err != nil {
+		return nil, ErrInvalidApiKey
+	}
+	if !enabled {
+		return nil, ErrInvalidApiKey
+	}
+	if expiry, ok := ParseTimeValue(expiresAt); ok && expiry.Before(time.Now()) {
+		return nil, ErrInvalidApiKey
+	}
+
+	userGroups, err := a.GetUserGroups(userId, userReferenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err = statementbuilder.Squirrel.Update(ApiKeyTableName).
+		Set(goqu.Record{
+			"last_used_at": time.Now(),
+			"last_used_ip": RequestClientIp(req),
+		}).
+		Where(goqu.Ex{"id": keyId}).ToSQL()
+	if err == nil {
+		_, err = a.db.Exec(query, args...)
+	}
+	CheckErr(err, "Failed to record use of api key [%v]", keyId)
+
+	return &SessionUser{
+		UserId:          userId,
+		UserReferenceId: userReferenceId,
+		Groups:          userGroups,
+		ApiKeyScopes:    ParseApiKeyScopes(scopes),
+	}, nil
+}
+
+// RequestClientIp is the address the request came from, without the port
+func RequestClientIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// ParseTimeValue reads a timestamp column, which the drivers return as a time.Time or as text
+func ParseTimeValue(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+			parsed, err := time.Parse(layout, v)
+			if err == nil {
+				return parsed, true
+			}
+		}
+	case []byte:
+		return ParseTimeValue(string(v))
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,49 @@
+package auth
+
+import "testing"
+
+func TestApiKeyScopes(t *testing.T) {
+
+	sessionUser := &SessionUser{
+		ApiKeyScopes: ParseApiKeyScopes("read:order, create:order\n*:invoice execute:export_data read:*"),
+	}
+
+	if !sessionUser.AllowsTable("order", "GET") || !sessionUser.AllowsTable("order", "POST") {
+		t.Errorf("expected read and create on order")
+	}
+	if sessionUser.AllowsTable("order", "DELETE") || sessionUser.AllowsTable("order", "PATCH") {
+		t.Errorf("expected no delete or update on order")
+	}
+	if !sessionUser.AllowsTable("invoice", "DELETE") {
+		t.Errorf("expected every verb on invoice")
+	}
+	if !sessionUser.AllowsTable("customer", "GET") || sessionUser.AllowsTable("customer", "POST") {
+		t.Errorf("expected read only on other tables")
+	}
+	if sessionUser.AllowsTable(ApiKeyTableName, "GET") {
+		t.Errorf("a wildcard should not give access to api keys")
+	}
+	if !sessionUser.AllowsAction("export_data") || sessionUser.AllowsAction("signin") {
+		t.Errorf("expected only the export_data action")
+	}
+	if sessionUser.ApiKeyScopes.allows("execute", "invoice") {
+		t.Errorf("* verb should not allow actions")
+	}
+
+	jwtUser := &SessionUser{}
+	if !jwtUser.AllowsTable(ApiKeyTableName, "DELETE") || !jwtUser.AllowsAction("signin") {
+		t.Errorf("sessions without an api key should not be narrowed")
+	}
+}
+
+func TestValidateApiKeyScopes(t *testing.T) {
+
+	if err := ValidateApiKeyScopes(ParseApiKeyScopes("read:order,execute:*")); err != nil {
+		t.Errorf("expected valid scopes: %v", err)
+	}
+	for _, invalid := range []string{"order", "read:", "drop:order"} {
+		if ValidateApiKeyScopes(ParseApiKeyScopes(invalid)) == nil {
+			t.Errorf("expected [%v] to be invalid", invalid)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/artpar/api2go"
 	"github.com/buraksezer/olric"
@@ -100,14 +101,15 @@ func (a *AuthMiddleware) SetUserUserGroupCrud(curd ResourceAdapter) {
 
 var jwtMiddleware *jwtmiddleware.JWTMiddleware
 
-func InitJwtMiddleware(secret []byte, issuer string, db *olric.Olric) {
+func InitJwtMiddleware(keys *JwtSigningKeys, issuer string, db *olric.Olric) {
 	if jwtmiddleware.TokenCache == nil {
 		jwtmiddleware.TokenCache, _ = db.NewDMap("token-cache")
 	}
+	if jwtmiddleware.RevokedTokens == nil {
+		jwtmiddleware.RevokedTokens, _ = db.NewDMap("revoked-tokens")
+	}
 	jwtMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		},
+		ValidationKeyGetter: keys.ValidationKey,
 		Issuer: issuer,
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err string) {
 			//log.Printf("Guest request [%v]: %v", err, r.Header)
@@ -116,7 +118,7 @@ func InitJwtMiddleware(secret []byte, issuer string, db *olric.Olric) {
 		// When set, the middleware verifies that tokens are signed with the specific signing algorithm
 		// If the signing method is not constant the ValidationKeyGetter callback can be used to implement additional checks
 		// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
-		SigningMethod: jwt.SigningMethodHS256,
+		SigningMethod: keys.Method,
 		UserProperty:  "user",
 		Extractor: jwtmiddleware.FromFirst(
 			jwtmiddleware.FromAuthHeader,
@@ -132,6 +134,18 @@ func InitJwtMiddleware(secret []byte, issuer string, db *olric.Olric) {
 	})
 }
 
+// RevokeTokenId rejects the tokens with the jti until they expire
+func RevokeTokenId(jti string, expiresAt time.Time) error {
+	if jwtmiddleware.RevokedTokens == nil {
+		return errors.New("revoked tokens are not initialised")
+	}
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return jwtmiddleware.RevokedTokens.PutEx("jti-"+jti, true, ttl)
+}
+
 func StartsWith(bigStr string, smallString string) bool {
 	if len(bigStr) < len(smallString) {
 		return false
@@ -164,12 +178,21 @@ func (a *AuthMiddleware) BasicAuthCheckMiddlewareWithHttp(req *http.Request, wri
 	if len(tokenValueParts) > 1 {
 		password = tokenValueParts[1]
 	}
+	clientIp := RequestClientIp(req)
+	err = LoginAttempts.Check(username, clientIp)
+	if err != nil {
+		return
+	}
 	existingPasswordHash, err := a.userCrud.GetUserPassword(username)
 	if err != nil {
+		LoginAttempts.Failure("basic", username, clientIp)
 		return
 	}
 
-	if BcryptCheckStringHash(password, existingPasswordHash) {
+	if !BcryptCheckStringHash(password, existingPasswordHash) {
+		LoginAttempts.Failure("basic", username, clientIp)
+	} else {
+		LoginAttempts.Success(username)
 		token = &jwt.Token{
 			Claims: jwt.MapClaims{
 				"name":  strings.Split(username, "@")[0],
@@ -246,6 +269,15 @@ func (a *AuthMiddleware) AuthCheckMiddlewareWithHttp(req *http.Request, writer h
 		olricCache, _ = a.olricDb.NewDMap("auth-cache")
 	}
 
+	if req.Header.Get(ApiKeyHeader) != "" {
+		sessionUser, err := a.ApiKeyCheck(req)
+		if err != nil {
+			log.Warnf("failed to identify api key in auth middleware: %v", err)
+			return false, false, req
+		}
+		return true, false, req.WithContext(context.WithValue(req.Context(), "user", sessionUser))
+	}
+
 	hasUser := false
 
 	userJwtToken, err := jwtMiddleware.CheckJWT(writer, req)
@@ -379,45 +411,10 @@ func (a *AuthMiddleware) AuthCheckMiddlewareWithHttp(req *http.Request, writer h
 
 					} else {
 
-						query, args1, err := UserGroupSelectQuery.Where(goqu.Ex{"uug.user_account_id": userId}).ToSQL()
-
-						stmt1, err := a.db.Preparex(query)
+						userGroups, err = a.GetUserGroups(userId, referenceId)
 						if err != nil {
-							log.Errorf("[382] failed to prepare statment: %v", err)
 							return false, true, nil
 						}
-						defer func(stmt1 *sqlx.Stmt) {
-							err := stmt1.Close()
-							if err != nil {
-								log.Errorf("failed to close prepared statement: %v", err)
-							}
-						}(stmt1)
-
-						rows, err := stmt1.Queryx(args1...)
-						defer func(rows *sqlx.Rows) {
-							err := rows.Close()
-							if err != nil {
-								log.Errorf("failed to close result after fetching user in auth")
-							}
-						}(rows)
-
-						if err != nil {
-							log.Errorf("Failed to get user group permissions: %v", err)
-						} else {
-							//cols, _ := rows.Columns()
-							log.Errorf("Usergroup selection query for user [%v] : [%v]", email, query)
-							for rows.Next() {
-								var p GroupPermission
-								err = rows.StructScan(&p)
-								p.ObjectReferenceId = referenceId
-								if err != nil {
-									log.Errorf("failed to scan group permission struct: %v", err)
-									continue
-								}
-								userGroups = append(userGroups, p)
-							}
-
-						}
 					}
 
 					//log.Printf("Group permissions :%v", userGroups)
@@ -473,6 +470,54 @@ func (a *AuthMiddleware) AuthCheckMiddlewareWithHttp(req *http.Request, writer h
 	return okToContinue, abortRequest, req
 }
 
+// GetUserGroups reads the groups the user belongs to along with the permission of each membership
+func (a *AuthMiddleware) GetUserGroups(userId int64, referenceId string) ([]GroupPermission, error) {
+
+	userGroups := make([]GroupPermission, 0)
+
+	query, args, err := UserGroupSelectQuery.Where(goqu.Ex{"uug.user_account_id": userId}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := a.db.Preparex(query)
+	if err != nil {
+		log.Errorf("[382] failed to prepare statment: %v", err)
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		log.Errorf("Failed to get user group permissions: %v", err)
+		return userGroups, nil
+	}
+	defer func(rows *sqlx.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Errorf("failed to close result after fetching user in auth")
+		}
+	}(rows)
+
+	for rows.Next() {
+		var p GroupPermission
+		err = rows.StructScan(&p)
+		p.ObjectReferenceId = referenceId
+		if err != nil {
+			log.Errorf("failed to scan group permission struct: %v", err)
+			continue
+		}
+		userGroups = append(userGroups, p)
+	}
+
+	return userGroups, nil
+}
+
 func (a *AuthMiddleware) AuthCheckMiddleware(c *gin.Context) {
 
 	ok, abort, newRequest := a.AuthCheckMiddlewareWithHttp(c.Request, c.Writer, false)
@@ -491,6 +536,8 @@ type SessionUser struct {
 	UserId          int64
 	UserReferenceId string
 	Groups          []GroupPermission
+	// ApiKeyScopes is set when the session comes from an api key, nil otherwise
+	ApiKeyScopes ApiKeyScopes
 }
 
 type GroupPermission struct {
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+)
+
+const (
+	JwtAlgorithmHS256 = "HS256"
+	JwtAlgorithmRS256 = "RS256"
+	JwtAlgorithmES256 = "ES256"
+)
+
+// JwtSigningKeys signs the tokens issued by daptin and validates the tokens presented to it. HS256 tokens are
+// signed with the shared jwt secret, RS256 and ES256 tokens with a private key whose public part is published
+// as a JWK set so other services can verify the tokens without knowing any secret
+type JwtSigningKeys struct {
+	Method     jwt.SigningMethod
+	KeyId      string
+	secret     []byte
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+// NewJwtSigningKeys creates the keys for the algorithm, privateKeyPem is only used for RS256 and ES256
+func NewJwtSigningKeys(algorithm string, secret []byte, privateKeyPem string) (*JwtSigningKeys, error) {
+
+	keys := &JwtSigningKeys{
+		secret: secret,
+	}
+
+	var err error
+	switch algorithm {
+	case "", JwtAlgorithmHS256:
+		keys.Method = jwt.SigningMethodHS256
+		return keys, nil
+	case JwtAlgorithmRS256:
+		keys.Method = jwt.SigningMethodRS256
+		var privateKey *rsa.PrivateKey
+		privateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKeyPem))
+		if err == nil {
+			keys.privateKey = privateKey
+			keys.publicKey = &privateKey.PublicKey
+		}
+	case JwtAlgorithmES256:
+		keys.Method = jwt.SigningMethodES256
+		var privateKey *ecdsa.PrivateKey
+		privateKey, err = jwt.ParseECPrivateKeyFromPEM([]byte(privateKeyPem))
+		if err == nil {
+			keys.privateKey = privateKey
+			keys.publicKey = &privateKey.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt signing algorithm [%v]", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v private key: %v", algorithm, err)
+	}
+
+	publicKeyDer, err := x509.MarshalPKIXPublicKey(keys.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	keyIdHash := sha256.Sum256(publicKeyDer)
+	keys.KeyId = base64.RawURLEncoding.EncodeToString(keyIdHash[:])[0:16]
+
+	return keys, nil
+}
+
+// GenerateJwtPrivateKeyPem creates a new private key for RS256 or ES256 signing
+func GenerateJwtPrivateKeyPem(algorithm string) (string, error) {
+
+	var block *pem.Block
+	switch algorithm {
+	case JwtAlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}
+	case JwtAlgorithmES256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: der,
+		}
+	default:
+		return "", fmt.Errorf("no private key is used for jwt signing algorithm [%v]", algorithm)
+	}
+
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// IsAsymmetric is true when the tokens are signed with a private key
+func (k *JwtSigningKeys) IsAsymmetric() bool {
+	return k.privateKey != nil
+}
+
+// SignClaims creates a signed token for the claims
+func (k *JwtSigningKeys) SignClaims(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	if !k.IsAsymmetric() {
+		return token.SignedString(k.secret)
+	}
+	token.Header["kid"] = k.KeyId
+	return token.SignedString(k.privateKey)
+}
+
+// ValidationKey is the jwt.Keyfunc for tokens signed by these keys
+func (k *JwtSigningKeys) ValidationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	if !k.IsAsymmetric() {
+		return k.secret, nil
+	}
+	return k.publicKey, nil
+}
+
+// Jwks is the JWK set with the public key, empty when tokens are signed with the shared secret
+func (k *JwtSigningKeys) Jwks() map[string]interface{} {
+
+	keys := make([]map[string]interface{}, 0)
+
+	switch publicKey := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		keys = append(keys, map[string]interface{}{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"kid": k.KeyId,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		})
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		keys = append(keys, map[string]interface{}{
+			"kty": "EC",
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"kid": k.KeyId,
+			"crv": publicKey.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(padJwkCoordinate(publicKey.X.Bytes(), size)),
+			"y":   base64.RawURLEncoding.EncodeToString(padJwkCoordinate(publicKey.Y.Bytes(), size)),
+		})
+	}
+
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
+
+func padJwkCoordinate(value []byte, size int) []byte {
+	if len(value) >= size {
+		return value
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(value):], value)
+	return padded
+}
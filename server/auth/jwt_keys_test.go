@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+)
+
+func TestJwtSigningKeysAsymmetric(t *testing.T) {
+
+	for _, algorithm := range []string{JwtAlgorithmRS256, JwtAlgorithmES256} {
+		privateKeyPem, err := GenerateJwtPrivateKeyPem(algorithm)
+		if err != nil {
+			t.Fatalf("failed to generate %v key: %v", algorithm, err)
+		}
+		keys, err := NewJwtSigningKeys(algorithm, []byte("secret"), privateKeyPem)
+		if err != nil {
+			t.Fatalf("failed to load %v key: %v", algorithm, err)
+		}
+
+		tokenString, err := keys.SignClaims(jwt.MapClaims{"email": "a@example.com"})
+		if err != nil {
+			t.Fatalf("failed to sign with %v: %v", algorithm, err)
+		}
+
+		token, err := jwt.Parse(tokenString, keys.ValidationKey)
+		if err != nil || !token.Valid {
+			t.Errorf("%v token did not validate: %v", algorithm, err)
+			continue
+		}
+		if token.Header["kid"] != keys.KeyId {
+			t.Errorf("%v token has kid [%v], expected [%v]", algorithm, token.Header["kid"], keys.KeyId)
+		}
+
+		jwks := keys.Jwks()["keys"].([]map[string]interface{})
+		if len(jwks) != 1 || jwks[0]["kid"] != keys.KeyId || jwks[0]["alg"] != algorithm {
+			t.Errorf("unexpected %v jwks: %v", algorithm, jwks)
+		}
+
+		hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{}).SignedString([]byte("secret"))
+		if _, err = jwt.Parse(hmacToken, keys.ValidationKey); err == nil {
+			t.Errorf("%v keys should not accept HS256 tokens", algorithm)
+		}
+	}
+}
+
+func TestJwtSigningKeysHS256(t *testing.T) {
+
+	keys, err := NewJwtSigningKeys("", []byte("secret"), "")
+	if err != nil {
+		t.Fatalf("failed to create HS256 keys: %v", err)
+	}
+
+	tokenString, err := keys.SignClaims(jwt.MapClaims{"email": "a@example.com"})
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if _, err = jwt.Parse(tokenString, keys.ValidationKey); err != nil {
+		t.Errorf("HS256 token did not validate: %v", err)
+	}
+	if len(keys.Jwks()["keys"].([]map[string]interface{})) != 0 {
+		t.Errorf("no public key should be published for HS256")
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/buraksezer/olric"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// LoginAttemptStore is the subset of an olric DMap used to count failures, shared by every node of the cluster
+type LoginAttemptStore interface {
+	Incr(key string, delta int) (int, error)
+	Expire(key string, timeout time.Duration) error
+	Get(key string) (interface{}, error)
+	PutEx(key string, value interface{}, timeout time.Duration) error
+	Delete(key string) error
+}
+
+// LoginAttemptPolicy decides when an account or an ip address is locked out
+type LoginAttemptPolicy struct {
+	// MaxFailures per account within the FailureWindow before the account is locked
+	MaxFailures int
+	// MaxFailuresPerIp per ip address within the FailureWindow before the ip address is locked
+	MaxFailuresPerIp int
+	FailureWindow    time.Duration
+	LockoutDuration  time.Duration
+	// BaseDelay is the delay after the first failure, doubled with every failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultLoginAttemptPolicy = LoginAttemptPolicy{
+	MaxFailures:      5,
+	MaxFailuresPerIp: 50,
+	FailureWindow:    15 * time.Minute,
+	LockoutDuration:  15 * time.Minute,
+	BaseDelay:        500 * time.Millisecond,
+	MaxDelay:         8 * time.Second,
+}
+
+// LockoutEvent is raised when an account or an ip address is locked out, Account is empty for ip lockouts
+type LockoutEvent struct {
+	Service     string
+	Account     string
+	IpAddress   string
+	Failures    int
+	LockedUntil time.Time
+}
+
+// LoginLockedError is returned while the account or the ip address is locked out
+type LoginLockedError struct {
+	LockedUntil time.Time
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again after %v", e.LockedUntil.Format(time.RFC3339))
+}
+
+// LoginAttemptTracker counts failed logins on sign in, otp, basic auth, smtp, imap and ftp. A nil tracker
+// allows everything, so the servers work before it is initialised
+type LoginAttemptTracker struct {
+	Policy    LoginAttemptPolicy
+	store     LoginAttemptStore
+	OnLockout func(event LockoutEvent)
+	sleep     func(time.Duration)
+}
+
+// LoginAttempts is the tracker used by every login path
+var LoginAttempts *LoginAttemptTracker
+
+func NewLoginAttemptTracker(store LoginAttemptStore, policy LoginAttemptPolicy) *LoginAttemptTracker {
+	return &LoginAttemptTracker{
+		Policy: policy,
+		store:  store,
+		sleep:  time.Sleep,
+	}
+}
+
+// InitLoginAttemptTracker creates the shared tracker over the "login-attempts" DMap
+func InitLoginAttemptTracker(db *olric.Olric, policy LoginAttemptPolicy, onLockout func(event LockoutEvent)) error {
+	dmap, err := db.NewDMap("login-attempts")
+	if err != nil {
+		return err
+	}
+	LoginAttempts = NewLoginAttemptTracker(dmap, policy)
+	LoginAttempts.OnLockout = onLockout
+	return nil
+}
+
+func loginAccountKey(account string) string {
+	return "account-" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func loginIpKey(ip string) string {
+	return "ip-" + ip
+}
+
+// Check returns a LoginLockedError if the account or the ip address is locked out. Call it before checking the
+// credentials so a locked account cannot be probed
+func (t *LoginAttemptTracker) Check(account string, ip string) error {
+	if t == nil {
+		return nil
+	}
+	for _, key := range t.keys(account, ip) {
+		value, err := t.store.Get("lock-" + key)
+		if err != nil {
+			continue
+		}
+		lockedUntil, ok := value.(int64)
+		if ok && time.Now().Unix() < lockedUntil {
+			return &LoginLockedError{LockedUntil: time.Unix(lockedUntil, 0)}
+		}
+	}
+	return nil
+}
+
+// Failure counts a failed login and locks the account or the ip address once it has failed too many times.
+// The caller is held back for a delay which grows with every failure of the account
+func (t *LoginAttemptTracker) Failure(service string, account string, ip string) {
+	if t == nil {
+		return
+	}
+
+	accountFailures := 0
+	for _, key := range t.keys(account, ip) {
+		failures, err := t.store.Incr(key, 1)
+		if err != nil {
+			log.Errorf("Failed to count login failure for [%v]: %v", key, err)
+			continue
+		}
+		if failures == 1 {
+			err = t.store.Expire(key, t.Policy.FailureWindow)
+			CheckErr(err, "Failed to set expiry on login failures of [%v]", key)
+		}
+
+		limit := t.Policy.MaxFailuresPerIp
+		event := LockoutEvent{Service: service, IpAddress: ip, Failures: failures}
+		if key == loginAccountKey(account) {
+			accountFailures = failures
+			limit = t.Policy.MaxFailures
+			event.Account = account
+		}
+		if limit < 1 || failures != limit {
+			continue
+		}
+
+		event.LockedUntil = time.Now().Add(t.Policy.LockoutDuration)
+		err = t.store.PutEx("lock-"+key, event.LockedUntil.Unix(), t.Policy.LockoutDuration)
+		if err != nil {
+			log.Errorf("Failed to lock [%v] out: %v", key, err)
+			continue
+		}
+		log.Warnf("Locked out [%v] after %d failed %v logins", key, failures, service)
+		if t.OnLockout != nil {
+			t.OnLockout(event)
+		}
+	}
+
+	if delay := t.Delay(accountFailures); delay > 0 {
+		t.sleep(delay)
+	}
+}
+
+// Delay is how long a caller is held back after the account failed failures times
+func (t *LoginAttemptTracker) Delay(failures int) time.Duration {
+	if failures < 1 || t.Policy.BaseDelay <= 0 {
+		return 0
+	}
+	delay := t.Policy.BaseDelay
+	for i := 1; i < failures && delay < t.Policy.MaxDelay; i++ {
+		delay = delay * 2
+	}
+	if t.Policy.MaxDelay > 0 && delay > t.Policy.MaxDelay {
+		delay = t.Policy.MaxDelay
+	}
+	return delay
+}
+
+// Success clears the failures of the account, failures of the ip address are kept
+func (t *LoginAttemptTracker) Success(account string) {
+	if t == nil || account == "" {
+		return
+	}
+	err := t.store.Delete(loginAccountKey(account))
+	CheckErr(err, "Failed to clear login failures of [%v]", account)
+}
+
+// Unlock lifts the lockout of the account and clears its failures
+func (t *LoginAttemptTracker) Unlock(account string) error {
+	if t == nil {
+		return nil
+	}
+	err := t.store.Delete("lock-" + loginAccountKey(account))
+	if err != nil {
+		return err
+	}
+	return t.store.Delete(loginAccountKey(account))
+}
+
+func (t *LoginAttemptTracker) keys(account string, ip string) []string {
+	keys := make([]string, 0, 2)
+	if account != "" {
+		keys = append(keys, loginAccountKey(account))
+	}
+	if ip != "" {
+		keys = append(keys, loginIpKey(ip))
+	}
+	return keys
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type memoryLoginAttemptStore struct {
+	values map[string]interface{}
+}
+
+func (m *memoryLoginAttemptStore) Incr(key string, delta int) (int, error) {
+	value, _ := m.values[key].(int)
+	m.values[key] = value + delta
+	return value + delta, nil
+}
+
+func (m *memoryLoginAttemptStore) Expire(key string, timeout time.Duration) error {
+	return nil
+}
+
+func (m *memoryLoginAttemptStore) Get(key string) (interface{}, error) {
+	value, ok := m.values[key]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return value, nil
+}
+
+func (m *memoryLoginAttemptStore) PutEx(key string, value interface{}, timeout time.Duration) error {
+	m.values[key] = value
+	return nil
+}
+
+func (m *memoryLoginAttemptStore) Delete(key string) error {
+	delete(m.values, key)
+	return nil
+}
+
+func TestLoginAttemptTrackerLockout(t *testing.T) {
+
+	policy := DefaultLoginAttemptPolicy
+	policy.MaxFailures = 3
+	policy.MaxFailuresPerIp = 5
+	tracker := NewLoginAttemptTracker(&memoryLoginAttemptStore{values: map[string]interface{}{}}, policy)
+
+	delays := make([]time.Duration, 0)
+	tracker.sleep = func(delay time.Duration) {
+		delays = append(delays, delay)
+	}
+	lockouts := make([]LockoutEvent, 0)
+	tracker.OnLockout = func(event LockoutEvent) {
+		lockouts = append(lockouts, event)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := tracker.Check("User@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("account locked too early after %d failures", i)
+		}
+		tracker.Failure("signin", "User@example.com", "10.0.0.1")
+	}
+
+	if _, ok := tracker.Check("user@example.com", "10.0.0.2").(*LoginLockedError); !ok {
+		t.Errorf("expected the account to be locked from any ip")
+	}
+	if len(lockouts) != 1 || lockouts[0].Account != "User@example.com" || lockouts[0].Failures != 3 {
+		t.Errorf("unexpected lockout events: %v", lockouts)
+	}
+	if len(delays) != 3 || delays[1] != 2*delays[0] || delays[2] != 2*delays[1] {
+		t.Errorf("expected doubling delays, got %v", delays)
+	}
+
+	if err := tracker.Unlock("user@example.com"); err != nil || tracker.Check("user@example.com", "10.0.0.2") != nil {
+		t.Errorf("expected the account to be unlocked: %v", err)
+	}
+
+	tracker.Failure("ftp", "other@example.com", "10.0.0.1")
+	tracker.Failure("ftp", "another@example.com", "10.0.0.1")
+	if _, ok := tracker.Check("fresh@example.com", "10.0.0.1").(*LoginLockedError); !ok {
+		t.Errorf("expected the ip to be locked after 5 failures across accounts")
+	}
+	if len(lockouts) != 2 || lockouts[1].Account != "" || lockouts[1].IpAddress != "10.0.0.1" {
+		t.Errorf("expected an ip lockout event, got %v", lockouts)
+	}
+}
+
+func TestLoginAttemptTrackerSuccessClearsFailures(t *testing.T) {
+
+	policy := DefaultLoginAttemptPolicy
+	policy.MaxFailures = 2
+	policy.BaseDelay = 0
+	tracker := NewLoginAttemptTracker(&memoryLoginAttemptStore{values: map[string]interface{}{}}, policy)
+
+	tracker.Failure("imap", "user@example.com", "")
+	tracker.Success("user@example.com")
+	tracker.Failure("imap", "user@example.com", "")
+	if tracker.Check("user@example.com", "") != nil {
+		t.Errorf("a successful login should reset the failures of the account")
+	}
+
+	var nilTracker *LoginAttemptTracker
+	nilTracker.Failure("smtp", "user@example.com", "")
+	if nilTracker.Check("user@example.com", "") != nil {
+		t.Errorf("a nil tracker should allow every login")
+	}
+}
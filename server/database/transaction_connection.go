@@ -0,0 +1,35 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrNestedTransaction = errors.New("a transaction is already in progress on this connection")
+
+// TransactionConnection lets an open *sqlx.Tx be used wherever a DatabaseConnection is expected
+// so that reads made while the transaction is open can see its uncommitted writes
+type TransactionConnection struct {
+	*sqlx.Tx
+	parent DatabaseConnection
+}
+
+func NewTransactionConnection(tx *sqlx.Tx, parent DatabaseConnection) *TransactionConnection {
+	return &TransactionConnection{
+		Tx:     tx,
+		parent: parent,
+	}
+}
+
+func (tc *TransactionConnection) Stats() sql.DBStats {
+	return tc.parent.Stats()
+}
+
+func (tc *TransactionConnection) Beginx() (*sqlx.Tx, error) {
+	return nil, ErrNestedTransaction
+}
+
+func (tc *TransactionConnection) MustBegin() *sqlx.Tx {
+	panic(ErrNestedTransaction)
+}
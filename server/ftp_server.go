@@ -3,11 +3,11 @@ package server
 import (
 	"bytes"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -27,9 +27,8 @@ type DaptinFtpDriver struct {
 	tlsConfig               *tls.Config             // TLS config (if applies)
 	DaptinFtpServerSettings DaptinFtpServerSettings // Our settings
 	nbClients               int32                   // Number of clients
-	Sites                   map[string]SubSiteAssetCache
 	CertManager             *resource.CertificateManager
-	cruds                   map[string]*resource.DbResource
+	VirtualFs               *VirtualFs
 }
 
 // ClientDriver defines a very basic client driver
@@ -37,6 +36,7 @@ type ClientDriver struct {
 	BaseDir    string // Base directory from which to server file
 	CurrentDir string
 	FtpDriver  *DaptinFtpDriver
+	Session    *VirtualFsSession
 }
 
 // DaptinFtpServerSettings defines our settings
@@ -46,20 +46,14 @@ type DaptinFtpServerSettings struct {
 }
 
 // NewDaptinFtpDriver creates a new driver
-func NewDaptinFtpDriver(cruds map[string]*resource.DbResource, certManager *resource.CertificateManager, ftp_interface string, sites []SubSiteAssetCache) (*DaptinFtpDriver, error) {
-
-	siteMap := make(map[string]SubSiteAssetCache)
-	for _, site := range sites {
-		siteMap[site.Hostname] = site
-	}
+func NewDaptinFtpDriver(virtualFs *VirtualFs, certManager *resource.CertificateManager, ftp_interface string) (*DaptinFtpDriver, error) {
 
 	ftpLogger := log.New()
 	drv := &DaptinFtpDriver{
 		Logger:      ftpLogger,
 		BaseDir:     "/",
-		Sites:       siteMap,
 		CertManager: certManager,
-		cruds:       cruds,
+		VirtualFs:   virtualFs,
 		DaptinFtpServerSettings: DaptinFtpServerSettings{
 			MaxConnections: 100,
 			Server: server.Settings{
@@ -123,12 +117,12 @@ func (driver *DaptinFtpDriver) GetTLSConfig() (*tls.Config, error) {
 		return driver.tlsConfig, nil
 	}
 	firstSite := ""
-	for s := range driver.Sites {
-		firstSite = s
+	for hostname := range driver.VirtualFs.Sites() {
+		firstSite = hostname
 		break
 	}
 
-	tls1, _, _, _, _, err := driver.CertManager.GetTLSConfig(driver.Sites[firstSite].Hostname, true)
+	tls1, _, _, _, _, err := driver.CertManager.GetTLSConfig(firstSite, true)
 	if err != nil {
 		return nil, err
 	}
@@ -161,18 +155,21 @@ func (driver *DaptinFtpDriver) WelcomeUser(cc server.ClientContext) (string, err
 // AuthUser authenticates the user and selects an handling driver
 func (driver *DaptinFtpDriver) AuthUser(cc server.ClientContext, user, pass string) (server.ClientHandlingDriver, error) {
 
-	userAccount, err := driver.cruds["user_account"].GetUserAccountRowByEmail(user)
+	clientIp, _, _ := net.SplitHostPort(cc.RemoteAddr().String())
+	err := driver.VirtualFs.CheckPassword(user, pass, clientIp, "ftp")
 	if err != nil {
 		return nil, err
 	}
 
-	if !resource.BcryptCheckStringHash(pass, userAccount["password"].(string)) {
-		return nil, fmt.Errorf("could not authenticate you")
+	session, err := driver.VirtualFs.NewSession(user, clientIp, "ftp")
+	if err != nil {
+		return nil, err
 	}
 	return &ClientDriver{
 		BaseDir:    "/",
 		CurrentDir: "/",
 		FtpDriver:  driver,
+		Session:    session,
 	}, nil
 }
 
@@ -182,161 +179,78 @@ func (driver *DaptinFtpDriver) UserLeft(cc server.ClientContext) {
 }
 
 func (driver *ClientDriver) SetFileMtime(cc server.ClientContext, path string, mtime time.Time) error {
-
-	dirParts := strings.Split(path, "/")
-
-	if len(dirParts) == 2 {
-		subsiteName := dirParts[1]
-		_, ok := driver.FtpDriver.Sites[subsiteName]
-		if !ok {
-			return errors.New("invalid path " + subsiteName)
-		}
-		driver.CurrentDir = subsiteName
-	}
-
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) + strings.Join(dirParts[2:], string(os.PathSeparator))
-	return os.Chtimes(path, mtime, mtime)
+	return driver.Session.Chtimes(path, mtime)
 }
 
 // ChangeDirectory changes the current working directory
 func (driver *ClientDriver) ChangeDirectory(cc server.ClientContext, directory string) error {
 
-	var err error
 	log.Printf("Change directory: [%v]", directory)
 
-	if directory == "/" {
-		driver.CurrentDir = "/"
-		return nil
+	info, err := driver.Session.Stat(directory)
+	if err != nil {
+		return err
 	}
-
-	dirParts := strings.Split(directory, "/")
-	if len(dirParts) == 2 {
-		subsiteName := dirParts[1]
-		_, ok := driver.FtpDriver.Sites[subsiteName]
-		if !ok {
-			return errors.New("invalid site " + subsiteName)
-		}
-		driver.CurrentDir = subsiteName
-	} else {
-		newDirName := dirParts[1]
-		_, ok := driver.FtpDriver.Sites[newDirName]
-		if !ok {
-			err = errors.New(fmt.Sprintf("no such path %v", directory))
-		} else {
-			driver.CurrentDir = newDirName
-			cdPath := driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) + strings.Join(dirParts[2:], string(os.PathSeparator))
-			log.Printf("CD Path: %v", cdPath)
-			_, err = os.Stat(cdPath)
-		}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", directory)
 	}
-	//driver.CurrentDir = directory
-	return err
+	driver.CurrentDir = directory
+	return nil
 }
 
 // MakeDirectory creates a directory
 func (driver *ClientDriver) MakeDirectory(cc server.ClientContext, path string) error {
-
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(path, "/")[2:], string(os.PathSeparator))
-
-	if len(strings.Split(path, "/")) == 2 {
-		return errors.New("cannot create new directory in /")
-	}
-
-	if driver.CurrentDir == "/" {
-		return errors.New("cannot create new directory in /")
-	}
-
-	return os.Mkdir(path, 0750)
+	return driver.Session.Mkdir(path)
 }
 
 // ListFiles lists the files of a directory
 func (driver *ClientDriver) ListFiles(cc server.ClientContext, directory string) ([]os.FileInfo, error) {
-
-	var err error
 	log.Printf("List files: [%v][%v]", driver.CurrentDir, directory)
-	files := make([]os.FileInfo, 0)
-	//files, err := ioutil.ReadDir(directory)
-
-	// We add a virtual dir
-	if directory == "/" {
-		for site := range driver.FtpDriver.Sites {
-			files = append(files, virtualFileInfo{
-				name: site,
-				mode: os.FileMode(0666) | os.ModeDir,
-				//size: 4096,
-			})
-		}
-
-	} else {
-		path := driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-			strings.Join(strings.Split(directory, "/")[2:], string(os.PathSeparator))
-		files, err = ioutil.ReadDir(path)
-	}
-	log.Printf("list Path: %v", files)
-
-	return files, err
+	return driver.Session.ReadDir(directory)
 }
 
 // OpenFile opens a file in 3 possible modes: read, write, appending write (use appropriate flags)
 func (driver *ClientDriver) OpenFile(cc server.ClientContext, path string, flag int) (server.FileStream, error) {
 
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(path, "/")[2:], string(os.PathSeparator))
-
-	// If we are writing and we are not in append mode, we should remove the file
+	// If we are writing and we are not in append mode, we should replace the file
 	if (flag & os.O_WRONLY) != 0 {
 		flag |= os.O_CREATE
 		if (flag & os.O_APPEND) == 0 {
-			if err := os.Remove(path); err != nil {
-				fmt.Println("Problem removing file", path, "err:", err)
-			}
+			flag |= os.O_TRUNC
 		}
 	}
 
-	return os.OpenFile(path, flag, 0600)
+	file, err := driver.Session.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
 }
 
 // GetFileInfo gets some info around a file or a directory
 func (driver *ClientDriver) GetFileInfo(cc server.ClientContext, path string) (os.FileInfo, error) {
-
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(path, "/")[2:], string(os.PathSeparator))
-
 	log.Printf("Get file info [%v]", path)
-
-	return os.Stat(path)
+	return driver.Session.Stat(path)
 }
 
-// CanAllocate gives the approval to allocate some data
+// CanAllocate gives the approval to allocate some data, within the upload quota of the user
 func (driver *ClientDriver) CanAllocate(cc server.ClientContext, size int) (bool, error) {
-	return true, nil
+	return driver.Session.CanAllocate(int64(size))
 }
 
 // ChmodFile changes the attributes of the file
 func (driver *ClientDriver) ChmodFile(cc server.ClientContext, path string, mode os.FileMode) error {
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(path, "/")[2:], string(os.PathSeparator))
-
-	return os.Chmod(path, mode)
+	return driver.Session.Chmod(path, mode)
 }
 
 // DeleteFile deletes a file or a directory
 func (driver *ClientDriver) DeleteFile(cc server.ClientContext, path string) error {
-	path = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(path, "/")[2:], string(os.PathSeparator))
-
-	return os.Remove(path)
+	return driver.Session.Remove(path)
 }
 
-// RenameFile renames a file or a directory
+// RenameFile renames a file or a directory, both paths need to be in the same site or cloud store
 func (driver *ClientDriver) RenameFile(cc server.ClientContext, from, to string) error {
-	from = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(from, "/")[2:], string(os.PathSeparator))
-	to = driver.FtpDriver.Sites[driver.CurrentDir].LocalSyncPath + string(os.PathSeparator) +
-		strings.Join(strings.Split(to, "/")[2:], string(os.PathSeparator))
-
-	return os.Rename(from, to)
+	return driver.Session.Rename(from, to)
 }
 
 // The virtual file is an example of how you can implement a purely virtual file
@@ -368,36 +282,6 @@ func (f *virtualFile) Write(buffer []byte) (int, error) {
 	return 0, nil
 }
 
-type virtualFileInfo struct {
-	name string
-	size int64
-	mode os.FileMode
-}
-
-func (f virtualFileInfo) Name() string {
-	return f.name
-}
-
-func (f virtualFileInfo) Size() int64 {
-	return f.size
-}
-
-func (f virtualFileInfo) Mode() os.FileMode {
-	return f.mode
-}
-
-func (f virtualFileInfo) IsDir() bool {
-	return f.mode.IsDir()
-}
-
-func (f virtualFileInfo) ModTime() time.Time {
-	return time.Now().UTC()
-}
-
-func (f virtualFileInfo) Sys() interface{} {
-	return nil
-}
-
 func externalIP() (string, error) {
 	// If you need to take a bet, amazon is about as reliable & sustainable a service as you can get
 	rsp, err := http.Get("http://checkip.amazonaws.com")
@@ -509,8 +509,36 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		//}
 		//
 
+		// aggregate rows carry the grouped columns, the projections and the time bucket
+		aggregateFields := graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"count": &graphql.Field{
+				Type: graphql.Int,
+			},
+			resource.TimeBucketColumn: &graphql.Field{
+				Type:        graphql.String,
+				Description: "start of the time bucket when timesample is set",
+			},
+		}
+		for _, column := range table.Columns {
+			if _, ok := aggregateFields[column.ColumnName]; ok {
+				continue
+			}
+			aggregateFields[column.ColumnName] = &graphql.Field{
+				Type:        graphql.String,
+				Description: column.ColumnDescription,
+			}
+		}
+		aggregateType := graphql.NewObject(graphql.ObjectConfig{
+			Name:        "aggregate_" + table.TableName,
+			Fields:      aggregateFields,
+			Description: "Aggregate of " + table.TableName,
+		})
+
 		rootFields["aggregate"+strcase.ToCamel(table.TableName)] = &graphql.Field{
-			Type:        graphql.NewList(inputTypesMap[table.TableName]),
+			Type:        graphql.NewList(aggregateType),
 			Description: "Aggregates for " + strings.ReplaceAll(table.TableName, "_", " "),
 			Args: graphql.FieldConfigArgument{
 				"group": &graphql.ArgumentConfig{
@@ -531,6 +559,22 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				"order": &graphql.ArgumentConfig{
 					Type: graphql.NewList(graphql.String),
 				},
+				"timesample": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "group rows by minute, hour, day, week, month or year",
+				},
+				"timefrom": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "start of the time range (inclusive)",
+				},
+				"timeto": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "end of the time range (exclusive)",
+				},
+				"timecolumn": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "column to sample on, created_at by default",
+				},
 			},
 			Resolve: func(table resource.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
 
@@ -543,8 +587,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						sessionUser = user.(*auth.SessionUser)
 					}
 
-					perm := resources[table.TableName].GetObjectPermissionByWhereClause("world", "table_name", table.TableName)
-					if sessionUser == nil || !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+					if sessionUser == nil {
 						return nil, errors.New("unauthorized")
 					}
 
@@ -564,7 +607,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						filters := params.Args["filter"].([]interface{})
 						aggReq.Filter = make([]string, 0)
 						for _, grp := range filters {
-							aggReq.Filter = append(aggReq.GroupBy, grp.(string))
+							aggReq.Filter = append(aggReq.Filter, grp.(string))
 						}
 					}
 
@@ -572,7 +615,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						havingClauseList := params.Args["having"].([]interface{})
 						aggReq.Having = make([]string, 0)
 						for _, grp := range havingClauseList {
-							aggReq.Having = append(aggReq.GroupBy, grp.(string))
+							aggReq.Having = append(aggReq.Having, grp.(string))
 						}
 					}
 
@@ -598,11 +641,45 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						}
 					}
 
+					if params.Args["timesample"] != nil {
+						aggReq.TimeSample = resource.TimeStamp(params.Args["timesample"].(string))
+					}
+					if params.Args["timefrom"] != nil {
+						aggReq.TimeFrom = params.Args["timefrom"].(string)
+					}
+					if params.Args["timeto"] != nil {
+						aggReq.TimeTo = params.Args["timeto"].(string)
+					}
+					if params.Args["timecolumn"] != nil {
+						aggReq.TimeColumn = params.Args["timecolumn"].(string)
+					}
+
+					if !aggReq.AllowedBy(sessionUser) {
+						return nil, errors.New("unauthorized")
+					}
+					perm := resources[table.TableName].GetObjectPermissionByWhereClause("world", "table_name", table.TableName)
+					if !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+						return nil, errors.New("unauthorized")
+					}
+					err := resources[table.TableName].CheckQueryableColumns(sessionUser, aggReq.Tables(), aggReq.Expressions())
+					if err != nil {
+						return nil, err
+					}
+
 					//params.Args["query"].(string)
 					//aggReq.Query =
 
 					aggResponse, err := resources[table.TableName].DataStats(aggReq)
-					return aggResponse.Data, err
+					if err != nil {
+						return nil, err
+					}
+
+					results := make([]map[string]interface{}, 0)
+					for _, row := range aggResponse.Data {
+						row.Attributes["id"] = row.Id
+						results = append(results, row.Attributes)
+					}
+					return results, nil
 				}
 			}(table),
 		}
@@ -1049,11 +1126,21 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		Fields: mutationFields,
 	})
 
-	var err error
-	Schema, err = graphql.NewSchema(graphql.SchemaConfig{
+	schemaConfig := graphql.SchemaConfig{
 		Query:    rootQuery,
 		Mutation: mutationType,
-	})
+	}
+
+	subscriptionFields := makeGraphqlSubscriptionFields(cmsConfig, resources, inputTypesMap, &filterArgument, &queryArgument)
+	if len(subscriptionFields) > 0 {
+		schemaConfig.Subscription = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Subscription",
+			Fields: subscriptionFields,
+		})
+	}
+
+	var err error
+	Schema, err = graphql.NewSchema(schemaConfig)
 	if err != nil {
 		log.Errorf("Failed to generate graphql schema: %v", err)
 	}
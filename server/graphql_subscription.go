@@ -0,0 +1,465 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/iancoleman/strcase"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// messages of the graphql-ws protocol (subscriptions-transport-ws)
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionError     = "connection_error"
+	gqlConnectionKeepAlive = "ka"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+	gqlStop                = "stop"
+)
+
+const graphqlWsProtocol = "graphql-ws"
+
+const graphqlKeepAliveInterval = 20 * time.Second
+
+// graphqlSubscriptionField is the table and the event type a subscription field listens to
+type graphqlSubscriptionField struct {
+	TableName string
+	EventType string
+}
+
+// graphqlSubscriptionFields maps the subscription root fields to their topic, filled by MakeGraphqlSchema
+var graphqlSubscriptionFields = map[string]graphqlSubscriptionField{}
+
+var graphqlSubscriptionEvents = map[string]string{
+	"Created": "create",
+	"Updated": "update",
+	"Deleted": "delete",
+}
+
+// makeGraphqlSubscriptionFields adds <table>Created, <table>Updated and <table>Deleted fields for every
+// table which is not hidden. The fields resolve the row from the event being delivered, and resolve to
+// nil when the user cannot read the row or it does not match the filter/query arguments
+func makeGraphqlSubscriptionFields(cmsConfig *resource.CmsConfig, resources map[string]*resource.DbResource,
+	inputTypesMap map[string]*graphql.Object, filterArgument *graphql.ArgumentConfig, queryArgument *graphql.ArgumentConfig) graphql.Fields {
+
+	subscriptionFields := graphql.Fields{}
+
+	for _, table := range cmsConfig.Tables {
+		if table.IsJoinTable || table.IsHidden || len(table.TableName) < 1 {
+			continue
+		}
+
+		for suffix, eventType := range graphqlSubscriptionEvents {
+			fieldName := strcase.ToLowerCamel(table.TableName) + suffix
+			graphqlSubscriptionFields[fieldName] = graphqlSubscriptionField{
+				TableName: table.TableName,
+				EventType: eventType,
+			}
+
+			subscriptionFields[fieldName] = &graphql.Field{
+				Type:        inputTypesMap[table.TableName],
+				Description: fmt.Sprintf("Rows of %v as they are %v", strings.ReplaceAll(table.TableName, "_", " "), strings.ToLower(suffix)),
+				Args: graphql.FieldConfigArgument{
+					"filter": filterArgument,
+					"query":  queryArgument,
+				},
+				Resolve: func(table resource.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
+					return func(params graphql.ResolveParams) (interface{}, error) {
+
+						root, ok := params.Source.(map[string]interface{})
+						if !ok {
+							return nil, nil
+						}
+						eventMessage, ok := root["event"].(resource.EventMessage)
+						if !ok {
+							return nil, nil
+						}
+
+						sessionUser, ok := params.Context.Value("user").(*auth.SessionUser)
+						if !ok || sessionUser == nil {
+							return nil, nil
+						}
+
+						if !sessionUser.AllowsTable(table.TableName, "GET") {
+							return nil, nil
+						}
+
+						permission := resources["world"].GetRowPermission(eventMessage.EventData)
+						if !permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+							return nil, nil
+						}
+						eventData := resources["world"].StripUnreadableColumns(sessionUser, []map[string]interface{}{eventMessage.EventData})[0]
+
+						if filter, ok := params.Args["filter"].(string); ok && filter != "" {
+							if !matchKeywordFilter(table, eventData, filter) {
+								return nil, nil
+							}
+						}
+
+						queries := make([]resource.Query, 0)
+						if queryList, ok := params.Args["query"].([]interface{}); ok {
+							for _, qu := range queryList {
+								q, ok := qu.(map[string]interface{})
+								if !ok {
+									continue
+								}
+								column, _ := q["column"].(string)
+								operator, _ := q["operator"].(string)
+								queries = append(queries, resource.Query{
+									ColumnName: column,
+									Operator:   operator,
+									Value:      q["value"],
+								})
+							}
+						}
+						matched, err := resource.MatchQueries(eventData, queries)
+						if err != nil || !matched {
+							return nil, err
+						}
+
+						// the event data is shared by all the listeners of the topic
+						row := make(map[string]interface{}, len(eventData)+1)
+						for key, value := range eventData {
+							row[key] = value
+						}
+						if _, ok := row["id"]; !ok {
+							row["id"] = row["reference_id"]
+						}
+						return row, nil
+					}
+				}(table),
+			}
+		}
+	}
+
+	return subscriptionFields
+}
+
+// matchKeywordFilter searches the keyword in the indexed name/label/email columns, like the filter
+// param of the find all api
+func matchKeywordFilter(table resource.TableInfo, data map[string]interface{}, keyword string) bool {
+	keyword = strings.ToLower(keyword)
+	for _, col := range table.Columns {
+		if !col.IsIndexed || (col.ColumnType != "name" && col.ColumnType != "label" && col.ColumnType != "email") {
+			continue
+		}
+		value, ok := data[col.ColumnName]
+		if !ok || value == nil {
+			continue
+		}
+		if strings.Contains(strings.ToLower(fmt.Sprintf("%v", value)), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+type graphqlWsMessage struct {
+	Id      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type graphqlWsStartPayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+type graphqlWsConnection struct {
+	ws            *websocket.Conn
+	user          *auth.SessionUser
+	schema        *graphql.Schema
+	dtopicMap     *map[string]*olric.DTopic
+	subscriptions map[string]graphqlWsSubscription
+	writeLock     sync.Mutex
+	lock          sync.Mutex
+	done          chan bool
+}
+
+type graphqlWsSubscription struct {
+	topic      string
+	listenerId uint64
+}
+
+func (conn *graphqlWsConnection) send(message graphqlWsMessage) {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	err := websocket.JSON.Send(conn.ws, message)
+	if err != nil {
+		log.Printf("Failed to send graphql subscription message: %v", err)
+	}
+}
+
+func (conn *graphqlWsConnection) sendError(id string, errs ...error) {
+	conn.send(graphqlWsMessage{
+		Id:      id,
+		Type:    gqlError,
+		Payload: gqlerrors.FormatErrors(errs...),
+	})
+}
+
+// start validates the subscription and listens to the topic of its field
+func (conn *graphqlWsConnection) start(id string, payload graphqlWsStartPayload) {
+
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(payload.Query),
+			Name: "GraphQL subscription",
+		}),
+	})
+	if err != nil {
+		conn.sendError(id, err)
+		return
+	}
+
+	validation := graphql.ValidateDocument(conn.schema, document, graphql.SpecifiedRules)
+	if !validation.IsValid {
+		conn.send(graphqlWsMessage{
+			Id:      id,
+			Type:    gqlError,
+			Payload: validation.Errors,
+		})
+		return
+	}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operationDefinition, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if payload.OperationName == "" || (operationDefinition.Name != nil && operationDefinition.Name.Value == payload.OperationName) {
+			operation = operationDefinition
+			break
+		}
+	}
+	if operation == nil || operation.Operation != ast.OperationTypeSubscription {
+		conn.sendError(id, fmt.Errorf("only subscription operations are supported over websocket"))
+		return
+	}
+	if operation.SelectionSet == nil || len(operation.SelectionSet.Selections) != 1 {
+		conn.sendError(id, fmt.Errorf("a subscription must select exactly one field"))
+		return
+	}
+	field, ok := operation.SelectionSet.Selections[0].(*ast.Field)
+	if !ok {
+		conn.sendError(id, fmt.Errorf("a subscription must select exactly one field"))
+		return
+	}
+	resultKey := field.Name.Value
+	if field.Alias != nil {
+		resultKey = field.Alias.Value
+	}
+
+	subscriptionField, ok := graphqlSubscriptionFields[field.Name.Value]
+	if !ok {
+		conn.sendError(id, fmt.Errorf("unknown subscription [%v]", field.Name.Value))
+		return
+	}
+
+	topic, ok := (*conn.dtopicMap)[subscriptionField.TableName]
+	if !ok {
+		conn.sendError(id, fmt.Errorf("no events are published for [%v]", subscriptionField.TableName))
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), "user", conn.user)
+
+	listenerId, err := topic.AddListener(func(message olric.DTopicMessage) {
+		eventMessage, ok := message.Message.(resource.EventMessage)
+		if !ok || eventMessage.EventType != subscriptionField.EventType {
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         *conn.schema,
+			RequestString:  payload.Query,
+			VariableValues: payload.Variables,
+			OperationName:  payload.OperationName,
+			RootObject: map[string]interface{}{
+				"event": eventMessage,
+			},
+			Context: ctx,
+		})
+
+		if !result.HasErrors() {
+			data, ok := result.Data.(map[string]interface{})
+			if !ok || data[resultKey] == nil {
+				return
+			}
+		}
+
+		conn.send(graphqlWsMessage{
+			Id:      id,
+			Type:    gqlData,
+			Payload: result,
+		})
+	})
+	if err != nil {
+		conn.sendError(id, err)
+		return
+	}
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if existing, ok := conn.subscriptions[id]; ok {
+		conn.removeListener(existing)
+	}
+	conn.subscriptions[id] = graphqlWsSubscription{
+		topic:      subscriptionField.TableName,
+		listenerId: listenerId,
+	}
+}
+
+func (conn *graphqlWsConnection) removeListener(subscription graphqlWsSubscription) {
+	topic, ok := (*conn.dtopicMap)[subscription.topic]
+	if !ok {
+		return
+	}
+	err := topic.RemoveListener(subscription.listenerId)
+	resource.CheckErr(err, "Failed to remove graphql subscription listener from [%v]", subscription.topic)
+}
+
+func (conn *graphqlWsConnection) stop(id string) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	subscription, ok := conn.subscriptions[id]
+	if !ok {
+		return
+	}
+	conn.removeListener(subscription)
+	delete(conn.subscriptions, id)
+}
+
+func (conn *graphqlWsConnection) close() {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	for id, subscription := range conn.subscriptions {
+		conn.removeListener(subscription)
+		delete(conn.subscriptions, id)
+	}
+	close(conn.done)
+}
+
+func (conn *graphqlWsConnection) keepAlive() {
+	ticker := time.NewTicker(graphqlKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			conn.send(graphqlWsMessage{Type: gqlConnectionKeepAlive})
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+func (conn *graphqlWsConnection) listen() {
+	defer conn.close()
+
+	for {
+		var message struct {
+			Id      string                `json:"id"`
+			Type    string                `json:"type"`
+			Payload graphqlWsStartPayload `json:"payload"`
+		}
+		err := websocket.JSON.Receive(conn.ws, &message)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Printf("Failed to read graphql subscription message: %v", err)
+			return
+		}
+
+		switch message.Type {
+		case gqlConnectionInit:
+			conn.send(graphqlWsMessage{Type: gqlConnectionAck})
+			conn.send(graphqlWsMessage{Type: gqlConnectionKeepAlive})
+			go conn.keepAlive()
+		case gqlStart:
+			conn.start(message.Id, message.Payload)
+		case gqlStop:
+			conn.stop(message.Id)
+			conn.send(graphqlWsMessage{Id: message.Id, Type: gqlComplete})
+		case gqlConnectionTerminate:
+			return
+		default:
+			conn.send(graphqlWsMessage{
+				Id:      message.Id,
+				Type:    gqlError,
+				Payload: []gqlerrors.FormattedError{gqlerrors.NewFormattedError("unknown message type " + message.Type)},
+			})
+		}
+	}
+}
+
+// CreateGraphqlSubscriptionHandler serves graphql subscriptions over the graphql-ws websocket protocol
+func CreateGraphqlSubscriptionHandler(schema *graphql.Schema, dtopicMap *map[string]*olric.DTopic) func(*gin.Context) {
+
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, request *http.Request) error {
+			// answer with the graphql-ws sub protocol only when the client asked for it
+			requested := config.Protocol
+			config.Protocol = nil
+			for _, protocol := range requested {
+				if protocol == graphqlWsProtocol {
+					config.Protocol = []string{graphqlWsProtocol}
+				}
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer func() {
+				_ = ws.Close()
+			}()
+
+			sessionUser, ok := ws.Request().Context().Value("user").(*auth.SessionUser)
+			if !ok || sessionUser == nil {
+				_ = websocket.JSON.Send(ws, graphqlWsMessage{
+					Type:    gqlConnectionError,
+					Payload: gqlerrors.NewFormattedError("unauthorized"),
+				})
+				return
+			}
+
+			conn := &graphqlWsConnection{
+				ws:            ws,
+				user:          sessionUser,
+				schema:        schema,
+				dtopicMap:     dtopicMap,
+				subscriptions: make(map[string]graphqlWsSubscription),
+				done:          make(chan bool),
+			}
+			conn.listen()
+		},
+	}
+
+	return func(c *gin.Context) {
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// IsWebsocketUpgrade is true for requests asking to switch to the websocket protocol
+func IsWebsocketUpgrade(request *http.Request) bool {
+	return strings.ToLower(request.Header.Get("Upgrade")) == "websocket"
+}
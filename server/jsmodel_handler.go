@@ -53,13 +53,6 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 			sessionUser = user.(*auth.SessionUser)
 		}
 
-		perm := cruds[typeName].GetObjectPermissionByWhereClause("world", "table_name", typeName)
-		if sessionUser == nil || !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
-			log.Infof("user [%v] not allowed to execute aggregate on [%v]", sessionUser, typeName)
-			c.AbortWithStatus(403)
-			return
-		}
-
 		aggReq := resource.AggregationRequest{}
 
 		aggReq.RootEntity = typeName
@@ -71,8 +64,29 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 		aggReq.TimeSample = resource.TimeStamp(c.Query("timesample"))
 		aggReq.TimeFrom = c.Query("timefrom")
 		aggReq.TimeTo = c.Query("timeto")
+		aggReq.TimeColumn = c.Query("timecolumn")
 		aggReq.Order = c.QueryArray("order")
 
+		if sessionUser == nil || !aggReq.AllowedBy(sessionUser) {
+			log.Infof("user [%v] not allowed to execute aggregate on [%v]", sessionUser, aggReq.Tables())
+			c.AbortWithStatus(403)
+			return
+		}
+
+		perm := cruds[typeName].GetObjectPermissionByWhereClause("world", "table_name", typeName)
+		if !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+			log.Infof("user [%v] not allowed to execute aggregate on [%v]", sessionUser, typeName)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		err := cruds[typeName].CheckQueryableColumns(sessionUser, aggReq.Tables(), aggReq.Expressions())
+		if err != nil {
+			log.Infof("user [%v] not allowed to execute aggregate on [%v]: %v", sessionUser, typeName, err)
+			c.AbortWithStatus(403)
+			return
+		}
+
 		aggResponse, err := cruds[typeName].DataStats(aggReq)
 
 		if err != nil {
@@ -87,6 +101,65 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 
 }
 
+// CreateExportHandler streams all the rows of a table the user can read, filtered by the query, filter
+// and fields params of the find all api, as ndjson, csv or sql insert statements
+func CreateExportHandler(cruds map[string]*resource.DbResource) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		typeName := c.Param("typename")
+		dbResource, ok := cruds[typeName]
+		if !ok {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		format := c.DefaultQuery("format", resource.ExportFormatNdjson)
+		if !resource.IsValidExportFormat(format) {
+			c.JSON(400, resource.NewDaptinError("Invalid export format", "format should be one of ndjson, csv or sql"))
+			return
+		}
+
+		user := c.Request.Context().Value("user")
+		var sessionUser *auth.SessionUser
+		if user != nil {
+			sessionUser = user.(*auth.SessionUser)
+		}
+
+		if sessionUser == nil || !sessionUser.AllowsTable(typeName, "GET") {
+			log.Infof("user [%v] not allowed to export [%v]", sessionUser, typeName)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		perm := dbResource.GetObjectPermissionByWhereClause("world", "table_name", typeName)
+		if !perm.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+			log.Infof("user [%v] not allowed to export [%v]", sessionUser, typeName)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		req := api2go.Request{
+			PlainRequest: c.Request,
+			QueryParams:  c.Request.URL.Query(),
+		}
+
+		c.Header("Content-Type", resource.ExportContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"daptin_dump_%s.%s\"", typeName, format))
+		c.Status(200)
+
+		err := dbResource.StreamExport(req, format, c.Writer)
+		if err != nil {
+			log.Errorf("failed to export [%v] as %v: %v", typeName, format, err)
+			if !c.Writer.Written() {
+				c.Writer.Header().Del("Content-Disposition")
+				c.Writer.Header().Del("Content-Type")
+				c.AbortWithStatusJSON(500, resource.NewDaptinError("Failed to export data", "export failed - "+err.Error()))
+			}
+		}
+	}
+}
+
 
 func CreateMetaHandler(initConfig *resource.CmsConfig) func(*gin.Context) {
 
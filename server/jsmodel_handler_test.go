@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+// serveWithApiKey calls the handler as a session created from an api key with the scopes
+func serveWithApiKey(handler func(*gin.Context), scopes string, typeName string, url string) int {
+	gin.SetMode(gin.TestMode)
+	response := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(response)
+	sessionUser := &auth.SessionUser{
+		UserReferenceId: "u1",
+		ApiKeyScopes:    auth.ParseApiKeyScopes(scopes),
+	}
+	c.Request = httptest.NewRequest("GET", url, nil)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "user", sessionUser))
+	c.Params = gin.Params{{Key: "typename", Value: typeName}}
+	handler(c)
+	return response.Code
+}
+
+func TestApiKeyScopesOnExportAndAggregate(t *testing.T) {
+	cruds := map[string]*resource.DbResource{
+		"order":    {},
+		"customer": {},
+	}
+
+	exportHandler := CreateExportHandler(cruds)
+	if code := serveWithApiKey(exportHandler, "read:order", "customer", "/export/customer"); code != http.StatusForbidden {
+		t.Errorf("expected a key scoped to order to not export customer, got %v", code)
+	}
+
+	statsHandler := CreateStatsHandler(nil, cruds)
+	if code := serveWithApiKey(statsHandler, "read:order", "customer", "/aggregate/customer"); code != http.StatusForbidden {
+		t.Errorf("expected a key scoped to order to not aggregate customer, got %v", code)
+	}
+	code := serveWithApiKey(statsHandler, "read:order", "order",
+		"/aggregate/order?join=customer@eq(customer.id,order.customer_id)")
+	if code != http.StatusForbidden {
+		t.Errorf("expected a key scoped to order to not join customer in an aggregate, got %v", code)
+	}
+}
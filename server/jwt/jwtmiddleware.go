@@ -17,6 +17,26 @@ type errorHandler func(w http.ResponseWriter, r *http.Request, err string)
 
 var TokenCache *olric.DMap
 
+// RevokedTokens holds the jti of the tokens which were revoked before they expired
+var RevokedTokens *olric.DMap
+
+// IsRevoked checks the jti of the token against the revoked tokens
+func IsRevoked(token *jwt.Token) bool {
+	if RevokedTokens == nil || token == nil {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return false
+	}
+	_, err := RevokedTokens.Get("jti-" + jti)
+	return err == nil
+}
+
 // TokenExtractor is a function that takes a request as input and returns
 // either a token or an error.  An error should only be returned if an attempt
 // to specify a token was found, but the information was somehow incorrectly
@@ -179,6 +199,9 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) (*jwt.T
 		tok, err := TokenCache.Get(k)
 		if err == nil {
 			cachedToken := tok.(jwt.Token)
+			if IsRevoked(&cachedToken) {
+				return nil, errors.New("token is revoked")
+			}
 			return &cachedToken, nil
 		}
 	}
@@ -242,6 +265,12 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) (*jwt.T
 		return nil, errors.New("Token is invalid")
 	}
 
+	if IsRevoked(parsedToken) {
+		m.logf("Token is revoked")
+		m.Options.ErrorHandler(w, r, "The token is revoked")
+		return nil, errors.New("Token is revoked")
+	}
+
 	m.logf("JWT: %v", parsedToken)
 
 	if TokenCache != nil {
@@ -260,6 +289,9 @@ func (m *JWTMiddleware) CheckExtractedJWT(w http.ResponseWriter, token string) (
 		tok, err := TokenCache.Get(k)
 		if err == nil {
 			cachedToken := tok.(jwt.Token)
+			if IsRevoked(&cachedToken) {
+				return nil, errors.New("token is revoked")
+			}
 			return &cachedToken, nil
 		}
 	}
@@ -310,6 +342,11 @@ func (m *JWTMiddleware) CheckExtractedJWT(w http.ResponseWriter, token string) (
 		return nil, errors.New("token is invalid")
 	}
 
+	if IsRevoked(parsedToken) {
+		m.logf("Token is revoked")
+		return nil, errors.New("token is revoked")
+	}
+
 	m.logf("JWT: %v", parsedToken)
 
 	if TokenCache != nil {
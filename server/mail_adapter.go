@@ -19,7 +19,6 @@ import (
 	"github.com/daptin/daptin/server/resource"
 	"github.com/emersion/go-message"
 	_ "github.com/emersion/go-message/charset"
-	mailpacket "github.com/emersion/go-message/mail"
 	"github.com/emersion/go-msgauth/dkim"
 	log "github.com/sirupsen/logrus"
 	"github.com/smancke/mailck"
@@ -97,8 +96,14 @@ func (dsa *DaptinSmtpAuthenticator) VerifyLOGIN(login, passwordBase64 string) bo
 	if err != nil {
 		return false
 	}
+	// the smtp authenticator does not see the connection, so only the account is tracked
+	err = auth.LoginAttempts.Check(string(username), "")
+	if err != nil {
+		return false
+	}
 	mailAccount, err := dsa.dbResource.GetUserMailAccountRowByEmail(string(username))
 	if err != nil {
+		auth.LoginAttempts.Failure("smtp", string(username), "")
 		return false
 	}
 	password, err := base64.StdEncoding.DecodeString(passwordBase64)
@@ -107,9 +112,11 @@ func (dsa *DaptinSmtpAuthenticator) VerifyLOGIN(login, passwordBase64 string) bo
 	}
 
 	if resource.BcryptCheckStringHash(string(password), mailAccount["password"].(string)) {
+		auth.LoginAttempts.Success(string(username))
 		return true
 	}
 
+	auth.LoginAttempts.Failure("smtp", string(username), "")
 	return false
 }
 
@@ -149,7 +156,8 @@ func DaptinSmtpAuthenticatorCreator(dbResource *resource.DbResource) func(config
 	}
 }
 
-func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *resource.CertificateManager) func() backends.Decorator {
+func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *resource.CertificateManager,
+	outboxWorker *resource.OutboxDeliveryWorker) func() backends.Decorator {
 
 	return func() backends.Decorator {
 		var config *SQLProcessorConfig
@@ -219,13 +227,11 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 						message1, err := mail1.ReadMessage(bytes.NewReader(mailBytes))
 						message1.Header.Date()
 
-						parsedMail, err := mailpacket.CreateReader(bytes.NewReader(mailBytes))
-						resource.CheckErr(err, "Failed to parse mail from bytes")
-
+						inboundMail, err := resource.ParseInboundMail(mailBytes, e.MailFrom.String(), rcpt.String())
 						if message.IsUnknownCharset(err) {
 							log.Println("Unknown encoding:", err)
-						} else if err != nil {
-							log.Fatal(err)
+						} else {
+							resource.CheckErr(err, "Failed to parse mail from bytes")
 						}
 
 						log.Printf("Authorized login: %v", e.AuthorizedLogin)
@@ -365,6 +371,22 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 							mailboxName = "Spam"
 						}
 
+						flagList := []string{"\\Recent"}
+						spam := false
+						if spamScore > 50 {
+							flagList = append(flagList, "\\Spam")
+							spam = true
+						}
+
+						// rules are not run for spam, it stays in the Spam mailbox
+						matchedRules := make([]resource.MailRule, 0)
+						if mailboxName != "Spam" {
+							mailRules, err := dbResource.GetMailRules()
+							resource.CheckErr(err, "Failed to load mail rules")
+							matchedRules = resource.MatchMailRules(mailRules, inboundMail)
+							mailboxName, flagList = resource.MailRuleDisposition(matchedRules, mailboxName, flagList)
+						}
+
 						mailBox, err := dbResource.GetMailAccountBox(mailAccount["id"].(int64), mailboxName)
 
 						if err != nil {
@@ -383,25 +405,8 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 							PlainRequest: pr,
 						}
 
-						spam := false
-						flags := "\\Recent"
-						if spamScore > 50 {
-							flags += ",\\Spam"
-							spam = true
-						}
-
-						hasAttachment := false
-						for part, err := parsedMail.NextPart(); err != nil; {
-							if err != nil {
-								break
-							}
-							a := part.Header
-							_, ok := a.(*mailpacket.AttachmentHeader)
-							if ok {
-								hasAttachment = true
-								break
-							}
-						}
+						flags := strings.Join(flagList, ",")
+						hasAttachment := len(inboundMail.Attachments) > 0
 
 						model := api2go.Api2GoModel{
 							Data: map[string]interface{}{
@@ -432,6 +437,18 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 								"size":             mailSize,
 							},
 						}
+						if hasAttachment {
+							// the upload changes the file entries, the rule actions get their own
+							attachments := make([]interface{}, 0, len(inboundMail.Attachments))
+							for _, attachment := range inboundMail.Attachments {
+								file := make(map[string]interface{})
+								for key, value := range attachment {
+									file[key] = value
+								}
+								attachments = append(attachments, file)
+							}
+							model.Data["attachments"] = attachments
+						}
 						_, err = dbResource.Cruds["mail"].Create(&model, *req)
 						resource.CheckErr(err, "Failed to store mail")
 						//err1 := dbResource.Cruds["mail"].IncrementMailBoxUid(mailBox["id"].(int64), nextUid+1)
@@ -440,6 +457,14 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 						if err != nil {
 							return backends.NewResult(fmt.Sprint("554 Error: could not save email")), backends.StorageError
 						}
+
+						// connected imap clients of the mailbox are told about the new mail
+						err = dbResource.PublishMailBoxChange("create", map[string]interface{}{
+							"mail_box_id": mailBox["reference_id"],
+						})
+						resource.CheckErr(err, "Failed to publish new mail in mailbox [%v]", mailboxName)
+
+						dbResource.RunMailRuleActions(matchedRules, inboundMail, outboxWorker)
 					}
 
 					// continue to the next Processor in the decorator chain
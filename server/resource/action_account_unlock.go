@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+)
+
+type accountUnlockActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *accountUnlockActionPerformer) Name() string {
+	return "account.unlock"
+}
+
+// DoAction lifts the lockout of an account after too many failed logins, only administrators can unlock
+func (d *accountUnlockActionPerformer) DoAction(request Outcome, inFieldMap map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	user, _ := inFieldMap["user"].(map[string]interface{})
+	userReferenceId, _ := user["reference_id"].(string)
+	if userReferenceId == "" || !d.cruds[USER_ACCOUNT_TABLE_NAME].IsAdmin(userReferenceId) {
+		return nil, nil, []error{errors.New("only administrators can unlock accounts")}
+	}
+
+	email, _ := inFieldMap["email"].(string)
+	if email == "" {
+		return nil, nil, []error{errors.New("email is missing")}
+	}
+
+	err := auth.LoginAttempts.Unlock(email)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	err = d.cruds[LOGIN_LOCKOUT_TABLE_NAME].SaveLoginLockoutEvent(LoginLockoutEventUnlocked, auth.LockoutEvent{
+		Service: "admin",
+		Account: email,
+	})
+	CheckErr(err, "Failed to save unlock of [%v]", email)
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", fmt.Sprintf("Unlocked %v", email), "Account unlocked")))
+
+	return nil, responses, nil
+}
+
+func NewAccountUnlockPerformer(cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := accountUnlockActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -175,6 +175,22 @@ func (d *fileUploadActionPerformer) DoAction(request Outcome, inFields map[strin
 		}
 		rootPath = rootPath + atPath
 	}
+	storeProvider := inFields["store_provider"].(string)
+	UploadLocalDirectoryToStore(d.cruds, tempDirectoryPath, rootPath, storeProvider, inFields["oauth_token_id"])
+
+	restartAttrs := make(map[string]interface{})
+	restartAttrs["type"] = "success"
+	restartAttrs["message"] = "Cloud storage file upload queued"
+	restartAttrs["title"] = "Success"
+	actionResponse := NewActionResponse("client.notify", restartAttrs)
+	responses = append(responses, actionResponse)
+
+	return nil, responses, nil
+}
+
+// UploadLocalDirectoryToStore copies the contents of the directory to the root path of a cloud store in the
+// background and removes the directory a while after
+func UploadLocalDirectoryToStore(cruds map[string]*DbResource, tempDirectoryPath string, rootPath string, storeProvider string, oauthToken interface{}) {
 	args := []string{
 		tempDirectoryPath,
 		rootPath,
@@ -182,20 +198,19 @@ func (d *fileUploadActionPerformer) DoAction(request Outcome, inFields map[strin
 	log.Printf("Upload source target %v %v", tempDirectoryPath, rootPath)
 
 	var token *oauth2.Token
+	var err error
 	oauthConf := &oauth2.Config{}
-	oauthTokenId1 := inFields["oauth_token_id"]
-	if oauthTokenId1 == nil {
+	oauthTokenId, ok := oauthToken.(string)
+	if !ok || oauthTokenId == "" {
 		log.Printf("No oauth token set for target store")
 	} else {
-		oauthTokenId := oauthTokenId1.(string)
-		token, oauthConf, err = d.cruds["oauth_token"].GetTokenByTokenReferenceId(oauthTokenId)
+		token, oauthConf, err = cruds["oauth_token"].GetTokenByTokenReferenceId(oauthTokenId)
 		CheckErr(err, "Failed to get oauth2 token for store sync")
 	}
 
 	jsonToken, err := json.Marshal(token)
 	CheckErr(err, "Failed to marshal access token to json")
 
-	storeProvider := inFields["store_provider"].(string)
 	config.FileSet(storeProvider, "client_id", oauthConf.ClientID)
 	config.FileSet(storeProvider, "type", storeProvider)
 	config.FileSet(storeProvider, "client_secret", oauthConf.ClientSecret)
@@ -243,15 +258,6 @@ func (d *fileUploadActionPerformer) DoAction(request Outcome, inFields map[strin
 
 		return err
 	})
-
-	restartAttrs := make(map[string]interface{})
-	restartAttrs["type"] = "success"
-	restartAttrs["message"] = "Cloud storage file upload queued"
-	restartAttrs["title"] = "Success"
-	actionResponse := NewActionResponse("client.notify", restartAttrs)
-	responses = append(responses, actionResponse)
-
-	return nil, responses, nil
 }
 
 func NewFileUploadActionPerformer(cruds map[string]*DbResource) (ActionPerformerInterface, error) {
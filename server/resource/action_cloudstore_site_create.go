@@ -91,11 +91,16 @@ func (d *cloudStoreSiteCreateActionPerformer) DoAction(request Outcome, inFields
 		"name":           hostname,
 	}
 	newSite := api2go.NewApi2GoModelWithData("site", nil, 0, nil, newSiteData)
-	_, err = d.cruds["site"].CreateWithoutFilter(newSite, createRequest)
+	createdSite, err := d.cruds["site"].CreateWithoutFilter(newSite, createRequest)
 	CheckErr(err, "Failed to create new site")
 	if err != nil {
 		return nil, nil, []error{err}
 	}
+	// the site is hosted once the event reaches the servers, without a restart
+	d.cruds["site"].AfterCommit(func() {
+		err := d.cruds["site"].PublishTableEvent("site", "create", createdSite)
+		CheckErr(err, "Failed to publish create event for site [%v]", hostname)
+	})
 
 	log.Printf("Upload source target for site create %v %v", tempDirectoryPath, rootPath)
 
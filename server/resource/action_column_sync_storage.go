@@ -96,8 +96,9 @@ func (d *syncColumnStorageActionPerformer) DoAction(request Outcome, inFields ma
 			log.Errorf("Source or destination is null")
 			return nil
 		}
-		dir := sync.CopyDir(ctx, fdst, fsrc, true)
-		return dir
+		return d.cruds["world"].SyncAssetFolder(ctx, cacheFolder, func(ctx context.Context) error {
+			return sync.CopyDir(ctx, fdst, fsrc, true)
+		})
 	})
 
 	restartAttrs := make(map[string]interface{})
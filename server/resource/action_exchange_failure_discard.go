@@ -0,0 +1,55 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"strconv"
+)
+
+type exchangeFailureDiscardActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *exchangeFailureDiscardActionPerformer) Name() string {
+	return "exchange.failure.discard"
+}
+
+// DoAction marks the exchange_failure row as discarded, the row is kept for reference
+func (d *exchangeFailureDiscardActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	failureId, ok := inFields["exchange_failure_id"].(string)
+	if !ok {
+		return nil, nil, []error{errors.New("exchange_failure_id is missing")}
+	}
+
+	failure, _, err := d.cruds[EXCHANGE_FAILURE_TABLE_NAME].GetSingleRowByReferenceId(EXCHANGE_FAILURE_TABLE_NAME, failureId, nil)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	attempts, err := strconv.ParseInt(fmt.Sprintf("%v", failure["attempt_count"]), 10, 64)
+	CheckErr(err, "Failed to read attempt count of exchange failure [%v]", failureId)
+
+	err = d.cruds[EXCHANGE_FAILURE_TABLE_NAME].UpdateExchangeFailureStatus(failureId, ExchangeFailureStatusDiscarded, attempts, nil)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", "Exchange failure discarded", "Success")))
+
+	return nil, responses, nil
+}
+
+func NewExchangeFailureDiscardPerformer(cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := exchangeFailureDiscardActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
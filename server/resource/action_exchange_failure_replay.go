@@ -0,0 +1,109 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+type exchangeFailureReplayActionPerformer struct {
+	cruds     map[string]*DbResource
+	cmsConfig *CmsConfig
+}
+
+func (d *exchangeFailureReplayActionPerformer) Name() string {
+	return "exchange.failure.replay"
+}
+
+// DoAction runs the exchange again once for the payload stored in the exchange_failure row, a failed webhook
+// delivery is sent again to its webhook only
+func (d *exchangeFailureReplayActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	failureId, ok := inFields["exchange_failure_id"].(string)
+	if !ok {
+		return nil, nil, []error{errors.New("exchange_failure_id is missing")}
+	}
+
+	failure, _, err := d.cruds[EXCHANGE_FAILURE_TABLE_NAME].GetSingleRowByReferenceId(EXCHANGE_FAILURE_TABLE_NAME, failureId, nil)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	status := fmt.Sprintf("%s", failure["status"])
+	if status != ExchangeFailureStatusFailed {
+		return nil, nil, []error{fmt.Errorf("exchange failure is already %v", status)}
+	}
+
+	exchangeName := fmt.Sprintf("%s", failure["exchange_name"])
+	var exchangeContract *ExchangeContract
+	for i, exchange := range d.cmsConfig.ExchangeContracts {
+		if exchange.Name == exchangeName {
+			exchangeContract = &d.cmsConfig.ExchangeContracts[i]
+			break
+		}
+	}
+	if exchangeContract == nil {
+		return nil, nil, []error{fmt.Errorf("no data exchange named [%v]", exchangeName)}
+	}
+
+	row := make(map[string]interface{})
+	err = json.Unmarshal([]byte(fmt.Sprintf("%s", failure["payload"])), &row)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	attempts, err := strconv.ParseInt(fmt.Sprintf("%v", failure["attempt_count"]), 10, 64)
+	CheckErr(err, "Failed to read attempt count of exchange failure [%v]", failureId)
+
+	exchangeExecution := NewExchangeExecution(*exchangeContract, &d.cruds)
+	// the event which changed the row, create, update or delete
+	if failure["event_type"] != nil {
+		exchangeExecution.Method = fmt.Sprintf("%s", failure["event_type"])
+	}
+	handler, err := exchangeExecution.Handler()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	log.Printf("Replaying failed exchange [%v] for [%v]", exchangeContract.Name, failureId)
+	var replayErr error
+	webhook, isWebhook := handler.(*WebhookExternalExchange)
+	if isWebhook && failure["webhook_reference_id"] != nil && fmt.Sprintf("%s", failure["webhook_reference_id"]) != "" {
+		replayErr = webhook.Redeliver(fmt.Sprintf("%s", failure["webhook_reference_id"]), row)
+	} else {
+		_, replayErr = handler.ExecuteTarget(row)
+	}
+
+	status = ExchangeFailureStatusReplayed
+	if replayErr != nil {
+		status = ExchangeFailureStatusFailed
+	}
+	err = d.cruds[EXCHANGE_FAILURE_TABLE_NAME].UpdateExchangeFailureStatus(failureId, status, attempts+1, replayErr)
+	CheckErr(err, "Failed to update exchange failure [%v]", failureId)
+
+	if replayErr != nil {
+		responses = append(responses, NewActionResponse("client.notify",
+			NewClientNotification("error", "Replay failed: "+replayErr.Error(), "Failed")))
+		return nil, responses, []error{replayErr}
+	}
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", "Exchange replayed", "Success")))
+
+	return nil, responses, nil
+}
+
+func NewExchangeFailureReplayPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := exchangeFailureReplayActionPerformer{
+		cruds:     cruds,
+		cmsConfig: initConfig,
+	}
+
+	return &handler, nil
+
+}
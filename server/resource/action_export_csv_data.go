@@ -69,15 +69,11 @@ func (d *exportCsvDataPerformer) DoAction(request Outcome, inFields map[string]i
 
 		if len(contentArray) == 0 {
 			csvFile.WriteString("No data\n")
+			continue
 		}
 
-		var columnKeys []string
 		csvWriter := gocsv.NewSafeCSVWriter(csvFileWriter)
-		firstRow := contentArray[0]
-
-		for colName := range firstRow {
-			columnKeys = append(columnKeys, colName)
-		}
+		columnKeys := ExportColumnNames(contentArray[0])
 
 		csvWriter.Write(columnKeys)
 
@@ -88,6 +84,7 @@ func (d *exportCsvDataPerformer) DoAction(request Outcome, inFields map[string]i
 			}
 			csvWriter.Write(dataRow)
 		}
+		csvWriter.Flush()
 		csvFile.WriteString("\n")
 	}
 
@@ -0,0 +1,90 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type generateApiKeyActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *generateApiKeyActionPerformer) Name() string {
+	return "api_key.generate"
+}
+
+// DoAction mints an api key for the user calling the action. The key is in the response and cannot be seen again
+func (d *generateApiKeyActionPerformer) DoAction(request Outcome, inFieldMap map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	user, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, nil, []error{errors.New("sign in to generate an api key")}
+	}
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", user["id"]), 10, 64)
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("invalid user: %v", err)}
+	}
+
+	name, _ := inFieldMap["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil, []error{errors.New("api key name is missing")}
+	}
+
+	scopeValue, _ := inFieldMap["scopes"].(string)
+	scopes := auth.ParseApiKeyScopes(scopeValue)
+	if len(scopes) == 0 {
+		return nil, nil, []error{errors.New("api key needs at least one scope")}
+	}
+	err = auth.ValidateApiKeyScopes(scopes)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	var expiresAt time.Time
+	if expiresInDays, ok := inFieldMap["expires_in_days"]; ok && expiresInDays != nil && fmt.Sprintf("%v", expiresInDays) != "" {
+		days, err := strconv.ParseFloat(fmt.Sprintf("%v", expiresInDays), 64)
+		if err != nil || days <= 0 {
+			return nil, nil, []error{fmt.Errorf("invalid expires_in_days [%v]", expiresInDays)}
+		}
+		expiresAt = time.Now().Add(time.Duration(days * float64(24*time.Hour)))
+	}
+
+	key, referenceId, err := d.cruds[auth.ApiKeyTableName].IssueApiKey(userId, name, scopes, expiresAt)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	apiKey := map[string]interface{}{
+		"reference_id": referenceId,
+		"name":         name,
+		"key":          key,
+		"scopes":       scopeValue,
+	}
+	if !expiresAt.IsZero() {
+		apiKey["expires_at"] = expiresAt.Format(time.RFC3339)
+	}
+
+	responses = append(responses, NewActionResponse("api_key", apiKey))
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", "Copy the key now, it will not be shown again", "Api key created")))
+
+	return nil, responses, nil
+}
+
+func NewGenerateApiKeyPerformer(cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := generateApiKeyActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
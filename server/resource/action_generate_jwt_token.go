@@ -3,18 +3,14 @@ package resource
 import (
 	"fmt"
 	"github.com/artpar/api2go"
-	"github.com/artpar/go.uuid"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/daptin/daptin/server/auth"
 	"github.com/doug-martin/goqu/v9"
 	log "github.com/sirupsen/logrus"
-	"time"
 )
 
 type generateJwtTokenActionPerformer struct {
 	cruds          map[string]*DbResource
-	secret         []byte
-	tokenLifeTime  int
-	jwtTokenIssuer string
+	tokenGenerator *JwtTokenGenerator
 }
 
 func (d *generateJwtTokenActionPerformer) Name() string {
@@ -47,10 +43,22 @@ func (d *generateJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap m
 		return nil, nil, []error{fmt.Errorf("email or password is empty")}
 	}
 
+	account := fmt.Sprintf("%v", email)
+	clientIp, _ := inFieldMap["client_ip"].(string)
+	if !skipPasswordCheck {
+		err := auth.LoginAttempts.Check(account, clientIp)
+		if err != nil {
+			responses = append(responses, NewActionResponse("client.notify",
+				NewClientNotification("error", err.Error(), "Failed")))
+			return nil, responses, nil
+		}
+	}
+
 	existingUsers, _, err := d.cruds[USER_ACCOUNT_TABLE_NAME].GetRowsByWhereClause("user_account", nil, goqu.Ex{"email": email})
 
 	responseAttrs := make(map[string]interface{})
 	if err != nil || len(existingUsers) < 1 {
+		auth.LoginAttempts.Failure("signin", account, clientIp)
 		responseAttrs["type"] = "error"
 		responseAttrs["message"] = "Invalid username or password"
 		responseAttrs["title"] = "Failed"
@@ -60,31 +68,32 @@ func (d *generateJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap m
 		existingUser := existingUsers[0]
 		if skipPasswordCheck || (existingUser["password"] != nil && BcryptCheckStringHash(password, existingUser["password"].(string))) {
 
-			// Create a new token object, specifying signing method and the claims
-			// you would like it to contain.
-			u, _ := uuid.NewV4()
-			timeNow := time.Now()
-
-			timeNow.Add(-2 * time.Minute) // allow clock skew of 2 minutes
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-				"email": existingUser["email"],
-				"sub":   existingUser["reference_id"],
-				"name":  existingUser["name"],
-				"nbf":   timeNow.Unix(),
-				"exp":   timeNow.Add(time.Duration(d.tokenLifeTime) * time.Hour).Unix(),
-				"iss":   d.jwtTokenIssuer,
-				"iat":   timeNow.Unix(),
-				"jti":   u.String(),
-			})
-
-			// Sign and get the complete encoded token as a string using the secret
-			tokenString, err := token.SignedString(d.secret)
-			//fmt.Printf("%v %v", tokenString, err)
+			if !skipPasswordCheck {
+				auth.LoginAttempts.Success(account)
+			}
+
+			tokenString, jti, expiresAt, err := d.tokenGenerator.AccessToken(existingUser)
 			if err != nil {
 				log.Errorf("Failed to sign string: %v", err)
 				return nil, nil, []error{err}
 			}
 
+			userId, ok := existingUser["id"].(int64)
+			if !ok {
+				userId, err = d.cruds[USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(USER_ACCOUNT_TABLE_NAME, existingUser["reference_id"].(string))
+				if err != nil {
+					return nil, nil, []error{err}
+				}
+			}
+
+			device, _ := inFieldMap["device"].(string)
+			refreshToken, _, err := d.cruds[REFRESH_TOKEN_TABLE_NAME].IssueRefreshToken(userId, device,
+				"", jti, expiresAt, d.tokenGenerator.RefreshTokenLifeTime)
+			if err != nil {
+				log.Errorf("Failed to issue refresh token: %v", err)
+				return nil, nil, []error{err}
+			}
+
 			responseAttrs = make(map[string]interface{})
 			responseAttrs["value"] = string(tokenString)
 			responseAttrs["key"] = "token"
@@ -92,6 +101,11 @@ func (d *generateJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap m
 			actionResponse := NewActionResponse("client.store.set", responseAttrs)
 			responses = append(responses, actionResponse)
 
+			responses = append(responses, NewActionResponse("client.store.set", map[string]interface{}{
+				"key":   "refresh_token",
+				"value": refreshToken,
+			}))
+
 			cookieResponseAttrs := make(map[string]interface{})
 			cookieResponseAttrs["value"] = string(tokenString) + "; SameSite=Strict"
 			cookieResponseAttrs["key"] = "token"
@@ -113,6 +127,7 @@ func (d *generateJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap m
 			responses = append(responses, NewActionResponse("client.redirect", responseAttrs))
 
 		} else {
+			auth.LoginAttempts.Failure("signin", account, clientIp)
 			responseAttrs = make(map[string]interface{})
 			responseAttrs["type"] = "error"
 			responseAttrs["title"] = "Failed"
@@ -127,29 +142,14 @@ func (d *generateJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap m
 
 func NewGenerateJwtTokenPerformer(configStore *ConfigStore, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
 
-	secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
-
-	tokenLifeTimeHours, err := configStore.GetConfigIntValueFor("jwt.token.life.hours", "backend")
-	CheckErr(err, "No default jwt token life time set in configuration")
-	if err != nil {
-		err = configStore.SetConfigIntValueFor("jwt.token.life.hours", 24*3, "backend")
-		CheckErr(err, "Failed to store default jwt token life time")
-		tokenLifeTimeHours = 24 * 3 // 3 days
-	}
-
-	jwtTokenIssuer, err := configStore.GetConfigValueFor("jwt.token.issuer", "backend")
-	CheckErr(err, "No default jwt token issuer set")
+	tokenGenerator, err := NewJwtTokenGenerator(configStore)
 	if err != nil {
-		uid, _ := uuid.NewV4()
-		jwtTokenIssuer = "daptin-" + uid.String()[0:6]
-		err = configStore.SetConfigValueFor("jwt.token.issuer", jwtTokenIssuer, "backend")
+		return nil, err
 	}
 
 	handler := generateJwtTokenActionPerformer{
 		cruds:          cruds,
-		secret:         []byte(secret),
-		tokenLifeTime:  tokenLifeTimeHours,
-		jwtTokenIssuer: jwtTokenIssuer,
+		tokenGenerator: tokenGenerator,
 	}
 
 	return &handler, nil
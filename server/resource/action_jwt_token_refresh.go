@@ -0,0 +1,92 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+type refreshJwtTokenActionPerformer struct {
+	cruds          map[string]*DbResource
+	tokenGenerator *JwtTokenGenerator
+}
+
+func (d *refreshJwtTokenActionPerformer) Name() string {
+	return "jwt.token.refresh"
+}
+
+// DoAction exchanges a refresh token for a new access token and a new refresh token. The refresh token can be
+// used only once, the access token issued along with it stops working as well
+func (d *refreshJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	refreshToken, _ := inFieldMap["refresh_token"].(string)
+	if refreshToken == "" {
+		return nil, nil, []error{errors.New("refresh_token is missing")}
+	}
+
+	refreshTokens := d.cruds[REFRESH_TOKEN_TABLE_NAME]
+	usedToken, err := refreshTokens.UseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	userId, err := strconv.ParseInt(fmt.Sprintf("%v", usedToken[USER_ACCOUNT_ID_COLUMN]), 10, 64)
+	if err != nil {
+		return nil, nil, []error{ErrInvalidRefreshToken}
+	}
+	userAccount, err := d.cruds[USER_ACCOUNT_TABLE_NAME].GetIdToObject(USER_ACCOUNT_TABLE_NAME, userId)
+	if err != nil {
+		return nil, nil, []error{ErrInvalidRefreshToken}
+	}
+
+	tokenString, jti, expiresAt, err := d.tokenGenerator.AccessToken(userAccount)
+	if err != nil {
+		log.Errorf("Failed to sign string: %v", err)
+		return nil, nil, []error{err}
+	}
+
+	device, _ := usedToken["device"].(string)
+	familyId := fmt.Sprintf("%v", usedToken["family_id"])
+	newRefreshToken, newReferenceId, err := refreshTokens.IssueRefreshToken(userId, device, familyId, jti, expiresAt,
+		d.tokenGenerator.RefreshTokenLifeTime)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	err = refreshTokens.MarkRefreshTokenReplaced(fmt.Sprintf("%v", usedToken["reference_id"]), newReferenceId)
+	CheckErr(err, "Failed to mark refresh token [%v] as replaced", usedToken["reference_id"])
+
+	responses = append(responses, NewActionResponse("client.store.set", map[string]interface{}{
+		"key":   "token",
+		"value": tokenString,
+	}))
+	responses = append(responses, NewActionResponse("client.store.set", map[string]interface{}{
+		"key":   "refresh_token",
+		"value": newRefreshToken,
+	}))
+	responses = append(responses, NewActionResponse("client.cookie.set", map[string]interface{}{
+		"key":   "token",
+		"value": tokenString + "; SameSite=Strict",
+	}))
+
+	return nil, responses, nil
+}
+
+func NewRefreshJwtTokenPerformer(configStore *ConfigStore, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	tokenGenerator, err := NewJwtTokenGenerator(configStore)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := refreshJwtTokenActionPerformer{
+		cruds:          cruds,
+		tokenGenerator: tokenGenerator,
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,67 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/doug-martin/goqu/v9"
+)
+
+type revokeJwtTokenActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *revokeJwtTokenActionPerformer) Name() string {
+	return "jwt.token.revoke"
+}
+
+// DoAction revokes the refresh tokens of a user, and the access tokens issued with them. Only the tokens of the
+// device are revoked when a device is given. The user is the one from user_account_id, or the caller without it
+func (d *revokeJwtTokenActionPerformer) DoAction(request Outcome, inFieldMap map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	userReferenceId, _ := inFieldMap["user_account_id"].(string)
+	if userReferenceId == "" {
+		user, ok := inFieldMap["user"].(map[string]interface{})
+		if ok {
+			userReferenceId, _ = user["reference_id"].(string)
+		}
+	}
+	if userReferenceId == "" {
+		return nil, nil, []error{errors.New("no user to revoke tokens of")}
+	}
+
+	userId, err := d.cruds[USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(USER_ACCOUNT_TABLE_NAME, userReferenceId)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	where := goqu.Ex{
+		USER_ACCOUNT_ID_COLUMN: userId,
+	}
+	device, _ := inFieldMap["device"].(string)
+	if device != "" {
+		where["device"] = device
+	}
+
+	revoked, err := d.cruds[REFRESH_TOKEN_TABLE_NAME].RevokeRefreshTokens(where)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", fmt.Sprintf("Revoked %d sessions", revoked), "Tokens revoked")))
+
+	return nil, responses, nil
+}
+
+func NewRevokeJwtTokenPerformer(cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := revokeJwtTokenActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
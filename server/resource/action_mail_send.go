@@ -1,169 +1,357 @@
 package resource
 
 import (
-	"bytes"
-	"crypto/x509"
-	"encoding/pem"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/artpar/api2go"
 	"github.com/artpar/go-guerrilla"
-	"github.com/artpar/go-guerrilla/mail"
-	mta "github.com/artpar/go-smtp-mta"
-	"github.com/emersion/go-msgauth/dkim"
+	"github.com/daptin/daptin/server/auth"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"path/filepath"
 	"strings"
-	"time"
 )
 
 type mailSendActionPerformer struct {
 	cruds              map[string]*DbResource
 	mailDaemon         *guerrilla.Daemon
 	certificateManager *CertificateManager
+	outboxWorker       *OutboxDeliveryWorker
 }
 
 func (d *mailSendActionPerformer) Name() string {
 	return "mail.send"
 }
 
+// DoAction builds a multipart mail from the text, html, template and attachments in the fields, signs it with
+// the DKIM key of the sender domain and queues it in the outbox for every to, cc and bcc recipient
 func (d *mailSendActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
 
-	//log.Printf("Sync mail servers")
 	responses := make([]ActionResponse, 0)
+	sessionUser, _ := request.Attributes["user"].(*auth.SessionUser)
 
-	mailTo := inFields["to"].(string)
-	subject := inFields["subject"].(string)
-	mailFrom := inFields["from"].(string)
-	mailBody := inFields["body"].(string)
-	mailServer, useMailServer := inFields["mail_server_hostname"]
-
-
-	if !useMailServer {
-
-		var body bytes.Buffer
-
-		mimeHeaders := "MIME-version: 1.0;\nContent-Type: text/plain; charset=\"UTF-8\";\n\n"
-		body.Write([]byte(fmt.Sprintf("Subject: %v \n%s\n\n", subject, mimeHeaders)))
+	composedMail, err := d.composeMail(inFields, sessionUser)
+	if err != nil {
+		log.Errorf("Failed to compose mail: %v", err)
+		return nil, nil, []error{err}
+	}
 
-		body.Write([]byte(mailBody))
+	mailBytes, err := composedMail.Build()
+	if err != nil {
+		log.Errorf("Failed to build mail to [%v]: %v", composedMail.Recipients(), err)
+		return nil, nil, []error{err}
+	}
 
+	_, fromDomain, err := splitMailAddress(composedMail.From.Address)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
 
-		mailFromAddress, err := mail.NewAddress(mailFrom)
+	// mails sent as a mail server have to be signed, others are signed when a key for the domain exists
+	mailServer, useMailServer := inFields["mail_server_hostname"]
+	if useMailServer {
+		_, err = d.cruds["mail_server"].GetObjectByWhereClause("mail_server", "hostname", mailServer)
 		if err != nil {
-			log.Errorf("Mail from value is not a valid address [%v]: %v", mailFrom, err)
-			return nil, nil, []error{err}
-		}
-		i2 := mta.Sender{
-			Hostname: mailFromAddress.Host,
+			log.Errorf("Failed to get mail server details for sending as: %v", mailServer)
+			return nil, nil, []error{fmt.Errorf("failed to get mail server details for sending as: %v", mailServer)}
 		}
-		bodyBytes := body.Bytes()
-		err = (&i2).Send(mailFrom, []string{mailTo}, bytes.NewReader(bodyBytes))
+	}
+
+	_, _, privateKeyPemByte, _, _, err := d.certificateManager.GetTLSConfig(fromDomain, false)
+	if err == nil && len(privateKeyPemByte) > 0 {
+		signedMail, err := SignMailWithDkim(mailBytes, fromDomain, privateKeyPemByte)
 		if err != nil {
-			log.Errorf("Failed to send mail to [%v]: %v", mailTo, err)
-			log.Errorf("Mail: %v", string(bodyBytes))
+			log.Errorf("Failed to sign outgoing mail via dkim, not sending it ahead [%v]", err)
 			return nil, nil, []error{err}
 		}
+		mailBytes = signedMail
+	} else if useMailServer {
+		log.Errorf("Failed to get private key for domain [%v]", fromDomain)
+		log.Errorf("Refusing to send mail without signing")
+		return nil, nil, []error{fmt.Errorf("no private key to sign mail from [%v]", fromDomain)}
+	}
+
+	referenceIds, err := d.outboxWorker.Enqueue(composedMail.From.Address, composedMail.Recipients(), mailBytes)
+	if err != nil {
+		log.Errorf("Failed to queue mail to [%v]: %v", composedMail.Recipients(), err)
+		return nil, nil, []error{err}
+	}
+
+	// the mail is delivered in the background, its status is on the outbox rows
+	responses = append(responses, NewActionResponse("outbox", map[string]interface{}{
+		"reference_id": referenceIds,
+		"status":       OutboxStatusQueued,
+	}))
+
+	return nil, responses, nil
+}
+
+func (d *mailSendActionPerformer) composeMail(inFields map[string]interface{}, sessionUser *auth.SessionUser) (ComposedMail, error) {
 
-	} else {
+	var composedMail ComposedMail
 
-		mailServerObj, err := d.cruds["mail_server"].GetObjectByWhereClause("mail_server", "hostname", mailServer)
+	mailFrom, _ := inFields["from"].(string)
+	from, err := mail.ParseAddress(mailFrom)
+	if err != nil {
+		return composedMail, fmt.Errorf("mail from value is not a valid address [%v]: %v", mailFrom, err)
+	}
+	composedMail.From = from
+
+	for _, field := range []string{"to", "cc", "bcc"} {
+		addresses, err := ParseMailAddressList(inFields[field])
 		if err != nil {
-			log.Errorf("Failed to get mail server details for sending as: %v", mailServer)
-			return nil, nil, []error{fmt.Errorf("failed to get mail server details for sending as: %v", mailServer)}
+			return composedMail, fmt.Errorf("invalid %v addresses: %v", field, err)
+		}
+		switch field {
+		case "to":
+			composedMail.To = addresses
+		case "cc":
+			composedMail.Cc = addresses
+		case "bcc":
+			composedMail.Bcc = addresses
 		}
+	}
 
-		var emailEnvelope *mail.Envelope
-		mailFromAddress, err := mail.NewAddress(mailTo)
+	if templateName, ok := inFields["template"].(string); ok && templateName != "" {
+		mailTemplate, err := d.cruds[MAIL_TEMPLATE_TABLE_NAME].GetMailTemplate(templateName, mailLanguages(inFields["language"]))
 		if err != nil {
-			log.Errorf("Invalid mail-to mailToAddress [%v]: %v", mailTo, err)
-			return nil, nil, []error{err}
+			return composedMail, err
 		}
-		mailToAddress, err := mail.NewAddress(mailTo)
+		composedMail.Subject, composedMail.Text, composedMail.Html, err = mailTemplate.Render(inFields)
 		if err != nil {
-			log.Errorf("Invalid mail-to mailToAddress [%v]: %v", mailTo, err)
-			return nil, nil, []error{err}
+			return composedMail, err
 		}
+	}
 
-		emailEnvelope = &mail.Envelope{
-			MailFrom:       *mailFromAddress,
-			RcptTo:         []mail.Address{*mailToAddress},
-			Subject:        subject,
-			DeliveryHeader: "Return-PATH: admin@" + mailServerObj["hostname"].(string) + "\n",
-		}
+	// values in the fields are used over the ones from the template
+	if subject, ok := inFields["subject"].(string); ok && subject != "" {
+		composedMail.Subject = subject
+	}
+	if text, ok := inFields["text"].(string); ok && text != "" {
+		composedMail.Text = text
+	} else if body, ok := inFields["body"].(string); ok && body != "" {
+		composedMail.Text = body
+	}
+	if html, ok := inFields["html"].(string); ok && html != "" {
+		composedMail.Html = html
+	}
 
-		fmt.Printf("Original Mail: \n%s\n", string(mailBody))
+	attachments, err := d.getAttachments(inFields["attachments"], sessionUser)
+	if err != nil {
+		return composedMail, err
+	}
+	composedMail.Attachments = attachments
 
-		_, _, privateKeyPemByte, _, _, err := d.certificateManager.GetTLSConfig(emailEnvelope.MailFrom.Host, false)
-		if err != nil {
-			log.Errorf("Failed to get private key for domain [%v]", emailEnvelope.MailFrom.Host)
-			log.Errorf("Refusing to send mail without signing")
-			return nil, nil, []error{err}
+	return composedMail, nil
+}
+
+// mailLanguages reads the language field, a single language or the preference list of the language middleware
+func mailLanguages(value interface{}) []string {
+	switch languages := value.(type) {
+	case string:
+		return strings.Split(languages, ",")
+	case []string:
+		return languages
+	case []interface{}:
+		list := make([]string, 0, len(languages))
+		for _, language := range languages {
+			if languageString, ok := language.(string); ok {
+				list = append(list, languageString)
+			}
 		}
+		return list
+	}
+	return nil
+}
 
-		//log.Printf("Private key [%v] %v", emailEnvelope.MailFrom.Host, string(privateKeyPemByte))
-		//log.Printf("Public key [%v] %v", emailEnvelope.MailFrom.Host, string(publicKeyBytes))
+// getAttachments resolves each attachment from an asset column of a row the user can read, a file in a local
+// cloud store or base64 contents given in the field itself
+func (d *mailSendActionPerformer) getAttachments(value interface{}, sessionUser *auth.SessionUser) ([]MailAttachment, error) {
 
-		block, _ := pem.Decode([]byte(privateKeyPemByte))
+	attachments := make([]MailAttachment, 0)
+	if value == nil {
+		return attachments, nil
+	}
 
-		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	items := make([]map[string]interface{}, 0)
+	switch list := value.(type) {
+	case []interface{}:
+		for _, item := range list {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid attachment [%v]", item)
+			}
+			items = append(items, itemMap)
+		}
+	case []map[string]interface{}:
+		items = list
+	case map[string]interface{}:
+		items = append(items, list)
+	default:
+		return nil, fmt.Errorf("invalid attachments [%v]", value)
+	}
 
+	for _, item := range items {
+		var itemAttachments []MailAttachment
+		var err error
+		if tableName, ok := item["table"].(string); ok {
+			itemAttachments, err = d.getAssetAttachments(tableName, item, sessionUser)
+		} else if cloudStoreName, ok := item["cloud_store"].(string); ok {
+			itemAttachments, err = d.getCloudStoreAttachment(cloudStoreName, item, sessionUser)
+		} else {
+			itemAttachments, err = fileToMailAttachments([]map[string]interface{}{item}, "")
+		}
 		if err != nil {
-			return nil, nil, []error{err}
+			return nil, err
 		}
+		attachments = append(attachments, itemAttachments...)
+	}
 
-		options := &dkim.SignOptions{
-			Selector:               "d1",
-			HeaderCanonicalization: dkim.CanonicalizationRelaxed,
-			BodyCanonicalization:   dkim.CanonicalizationRelaxed,
-			Domain:                 emailEnvelope.MailFrom.Host,
-			Signer:                 privateKey,
-		}
+	return attachments, nil
+}
 
-		newMailString := fmt.Sprintf("From: %s\r\nSubject: %s\r\nTo: %s\r\nDate: %s\r\n", emailEnvelope.MailFrom.String(), emailEnvelope.Subject, mailToAddress.String(), time.Now().Format(time.RFC822Z))
+func (d *mailSendActionPerformer) getAssetAttachments(tableName string, item map[string]interface{}, sessionUser *auth.SessionUser) ([]MailAttachment, error) {
 
-		for headerName, headerValue := range emailEnvelope.Header {
-			headerNameSmall := strings.ToLower(headerName)
+	columnName, _ := item["column"].(string)
+	referenceId, _ := item["reference_id"].(string)
+	fileName, _ := item["name"].(string)
 
-			if headerNameSmall == "date" || headerNameSmall == "to" || headerNameSmall == "from" || headerNameSmall == "subject" {
-				continue
-			}
-			for _, val := range headerValue {
-				newMailString = newMailString + headerName + ": " + val + "\r\n"
-			}
-		}
+	dbResource, ok := d.cruds[tableName]
+	if !ok {
+		return nil, fmt.Errorf("no such table [%v]", tableName)
+	}
+	columnInfo, ok := dbResource.TableInfo().GetColumnByName(columnName)
+	if !ok || !columnInfo.IsForeignKey || columnInfo.ForeignKeyData.DataSource != "cloud_store" {
+		return nil, fmt.Errorf("[%v][%v] is not an asset column", tableName, columnName)
+	}
 
-		newMailString = newMailString + "\r\n" + mailBody
+	// FindOne checks the permission of the user on the row and resolves the file contents of the column
+	httpReq := &http.Request{
+		Method: "GET",
+	}
+	httpReq = httpReq.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	req := api2go.Request{
+		PlainRequest: httpReq,
+		QueryParams: map[string][]string{
+			"included_relations": {columnName},
+		},
+	}
 
-		var b bytes.Buffer
-		if err := dkim.Sign(&b, bytes.NewReader([]byte(newMailString)), options); err != nil {
-			log.Errorf("Failed to sign outgoing mail via dkim, not sending it ahead [%v]", err)
-			return nil, nil, []error{err}
-		}
+	response, err := dbResource.FindOne(referenceId, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment from [%v][%v]: %v", tableName, referenceId, err)
+	}
+	row, ok := response.Result().(*api2go.Api2GoModel)
+	if !ok || row == nil {
+		return nil, fmt.Errorf("failed to read attachment from [%v][%v]", tableName, referenceId)
+	}
+	files, _ := row.Data[columnName].([]map[string]interface{})
+
+	return fileToMailAttachments(files, fileName)
+}
+
+func (d *mailSendActionPerformer) getCloudStoreAttachment(cloudStoreName string, item map[string]interface{}, sessionUser *auth.SessionUser) ([]MailAttachment, error) {
+
+	filePath, _ := item["path"].(string)
+	if sessionUser == nil {
+		return nil, errors.New("cloud store attachments need a user")
+	}
+
+	cloudStoreRow, err := d.cruds["cloud_store"].GetObjectByWhereClause("cloud_store", "name", cloudStoreName)
+	if err != nil || cloudStoreRow == nil {
+		return nil, fmt.Errorf("no such cloud store [%v]", cloudStoreName)
+	}
+	if !d.cruds["cloud_store"].GetRowPermission(cloudStoreRow).CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+		return nil, fmt.Errorf("not allowed to read cloud store [%v]", cloudStoreName)
+	}
+
+	cloudStore, err := d.cruds["cloud_store"].GetCloudStoreByName(cloudStoreName)
+	if err != nil {
+		return nil, err
+	}
+	if cloudStore.StoreProvider != "local" {
+		return nil, fmt.Errorf("attachments can only be read from local cloud stores, [%v] is %v", cloudStoreName, cloudStore.StoreProvider)
+	}
+
+	cleanPath := filepath.Clean("/" + filePath)
+	if filePath == "" || cleanPath == "/" {
+		return nil, fmt.Errorf("attachment path is missing for cloud store [%v]", cloudStoreName)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(cloudStore.RootPath, cleanPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment [%v] from cloud store [%v]: %v", filePath, cloudStoreName, err)
+	}
 
-		finalMail := b.Bytes()
-		fmt.Printf("Mail\n%s", string(finalMail))
-		log.Printf("Final Mail: From [%v] to [%v] [%v]", emailEnvelope.MailFrom.String(), mailToAddress.String(), string(finalMail))
+	name, _ := item["name"].(string)
+	if name == "" {
+		name = filepath.Base(cleanPath)
+	}
+	contentType, _ := item["type"].(string)
 
-		i2 := mta.Sender{
-			Hostname: emailEnvelope.MailFrom.Host,
+	return []MailAttachment{{
+		Name:        name,
+		ContentType: contentType,
+		Contents:    contents,
+	}}, nil
+}
+
+// fileToMailAttachments converts file entries with base64 contents, as stored in asset columns and returned by
+// actions which generate files, only the file named fileName is used when it is not empty
+func fileToMailAttachments(files []map[string]interface{}, fileName string) ([]MailAttachment, error) {
+
+	attachments := make([]MailAttachment, 0)
+	for _, file := range files {
+		name, _ := file["name"].(string)
+		if fileName != "" && name != fileName {
+			continue
 		}
-		err = (&i2).Send(emailEnvelope.MailFrom.String(), []string{mailToAddress.String()}, bytes.NewReader(finalMail))
 
-		if err != nil {
-			log.Errorf("Failed to send mail: %v", err)
-			return nil, nil, []error{err}
+		encoded, _ := file["contents"].(string)
+		if encoded == "" {
+			encoded, _ = file["file"].(string)
+		}
+		// data urls carry the content type before the contents
+		contentType, _ := file["type"].(string)
+		if strings.HasPrefix(encoded, "data:") && strings.Contains(encoded, ",") {
+			parts := strings.SplitN(encoded, ",", 2)
+			if contentType == "" {
+				contentType = strings.TrimSuffix(strings.TrimPrefix(parts[0], "data:"), ";base64")
+			}
+			encoded = parts[1]
 		}
 
+		contents, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("attachment [%v] is not base64 encoded: %v", name, err)
+		}
+		if name == "" {
+			name = "attachment"
+		}
+		attachments = append(attachments, MailAttachment{
+			Name:        name,
+			ContentType: contentType,
+			Contents:    contents,
+		})
 	}
 
-	return nil, responses, nil
+	if fileName != "" && len(attachments) == 0 {
+		return nil, fmt.Errorf("no file named [%v] to attach", fileName)
+	}
+	return attachments, nil
 }
 
-func NewMailSendActionPerformer(cruds map[string]*DbResource, mailDaemon *guerrilla.Daemon, certificateManager *CertificateManager) (ActionPerformerInterface, error) {
+func NewMailSendActionPerformer(cruds map[string]*DbResource, mailDaemon *guerrilla.Daemon,
+	certificateManager *CertificateManager, outboxWorker *OutboxDeliveryWorker) (ActionPerformerInterface, error) {
 
 	handler := mailSendActionPerformer{
 		cruds:              cruds,
 		mailDaemon:         mailDaemon,
 		certificateManager: certificateManager,
+		outboxWorker:       outboxWorker,
 	}
 
 	return &handler, nil
@@ -26,7 +26,7 @@ type otpLoginVerifyActionPerformer struct {
 	tokenLifeTime    int
 	jwtTokenIssuer   string
 	otpKey           string
-	secret           []byte
+	signingKeys      *auth.JwtSigningKeys
 	totpSecret       string
 }
 
@@ -46,6 +46,17 @@ func (d *otpLoginVerifyActionPerformer) DoAction(request Outcome, inFieldMap map
 		}
 	}
 	email, ok := inFieldMap["email"]
+
+	account := fmt.Sprintf("%v", email)
+	if email == nil || email == "" {
+		account = fmt.Sprintf("%v", inFieldMap["mobile"])
+	}
+	clientIp, _ := inFieldMap["client_ip"].(string)
+	err = auth.LoginAttempts.Check(account, clientIp)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
 	var userAccount map[string]interface{}
 	var userOtpProfile map[string]interface{}
 	if email == nil || email == "" {
@@ -84,8 +95,10 @@ func (d *otpLoginVerifyActionPerformer) DoAction(request Outcome, inFieldMap map
 	})
 	if !ok {
 		log.Errorf("Failed to validate otp key")
+		auth.LoginAttempts.Failure("otp", account, clientIp)
 		return nil, nil, []error{errors.New("Invalid OTP")}
 	}
+	auth.LoginAttempts.Success(account)
 
 	if userOtpProfile["verified"].(int64) == 0 {
 		model := api2go.NewApi2GoModelWithData("user_otp_account", nil, 0, nil, userOtpProfile)
@@ -125,7 +138,7 @@ func (d *otpLoginVerifyActionPerformer) DoAction(request Outcome, inFieldMap map
 	} else {
 
 		u, _ := uuid.NewV4()
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		tokenString, err := d.signingKeys.SignClaims(jwt.MapClaims{
 			"email":   userAccount["email"],
 			"name":    userAccount["name"],
 			"nbf":     time.Now().Unix(),
@@ -136,9 +149,6 @@ func (d *otpLoginVerifyActionPerformer) DoAction(request Outcome, inFieldMap map
 			"jti":     u.String(),
 		})
 
-		// Sign and get the complete encoded token as a string using the secret
-		tokenString, err := token.SignedString(d.secret)
-		fmt.Printf("%v %v", tokenString, err)
 		if err != nil {
 			log.Errorf("Failed to sign string: %v", err)
 			return nil, nil, []error{err}
@@ -170,9 +180,10 @@ func (d *otpLoginVerifyActionPerformer) DoAction(request Outcome, inFieldMap map
 
 func NewOtpLoginVerifyActionPerformer(cruds map[string]*DbResource, configStore *ConfigStore) (ActionPerformerInterface, error) {
 
-	configStore.GetConfigValueFor("jwt.secret", "backend")
-
-	jwtSecret, err := configStore.GetConfigValueFor("jwt.secret", "backend")
+	signingKeys, err := LoadJwtSigningKeys(configStore)
+	if err != nil {
+		return nil, err
+	}
 	encryptionSecret, _ := configStore.GetConfigValueFor("encryption.secret", "backend")
 
 	tokenLifeTimeHours, err := configStore.GetConfigIntValueFor("jwt.token.life.hours", "backend")
@@ -196,7 +207,7 @@ func NewOtpLoginVerifyActionPerformer(cruds map[string]*DbResource, configStore
 		tokenLifeTime:    tokenLifeTimeHours,
 		configStore:      configStore,
 		encryptionSecret: []byte(encryptionSecret),
-		secret:           []byte(jwtSecret),
+		signingKeys:      signingKeys,
 		jwtTokenIssuer:   jwtTokenIssuer,
 	}
 
@@ -0,0 +1,83 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	log "github.com/sirupsen/logrus"
+)
+
+type schemaMigrateActionPerformer struct {
+	cmsConfig *CmsConfig
+	cruds     map[string]*DbResource
+}
+
+func (d *schemaMigrateActionPerformer) Name() string {
+	return "world.schema.migrate"
+}
+
+// DoAction plans the migration of the database to the tables in the config. Unless dry_run is false
+// only the plan is returned, destructive changes are applied only when include_destructive is true
+func (d *schemaMigrateActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	dryRun := true
+	if value, ok := inFields["dry_run"]; ok && value != nil {
+		dryRun = isTruthy(value)
+	}
+	includeDestructive := isTruthy(inFields["include_destructive"])
+
+	db := d.cruds["world"].connection
+	plan, err := PlanSchemaMigration(d.cmsConfig.Tables, db)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	result := map[string]interface{}{
+		"changes":    plan.Changes,
+		"statements": plan.Statements(includeDestructive),
+		"applied":    false,
+	}
+
+	if dryRun || plan.IsEmpty() {
+		responses = append(responses, NewActionResponse("client.notify",
+			NewClientNotification("message", plan.String(), "Schema migration plan")))
+		responses = append(responses, NewActionResponse("schema_migration", result))
+		return nil, responses, nil
+	}
+
+	appliedBy := ""
+	userId := int64(0)
+	user, ok := inFields["user"].(map[string]interface{})
+	if ok {
+		appliedBy = fmt.Sprintf("%v", user["email"])
+		userId, err = d.cruds[USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(USER_ACCOUNT_TABLE_NAME, fmt.Sprintf("%v", user["reference_id"]))
+		if err != nil {
+			log.Errorf("Failed to get id of user [%v] applying schema migration: %v", user["reference_id"], err)
+		}
+	}
+
+	statements, err := ApplySchemaMigration(db, plan, includeDestructive, appliedBy, userId)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	result["statements"] = statements
+	result["applied"] = true
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", fmt.Sprintf("Applied %d statements", len(statements)), "Schema migrated")))
+	responses = append(responses, NewActionResponse("schema_migration", result))
+
+	return nil, responses, nil
+}
+
+func NewSchemaMigratePerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := schemaMigrateActionPerformer{
+		cmsConfig: initConfig,
+		cruds:     cruds,
+	}
+
+	return &handler, nil
+
+}
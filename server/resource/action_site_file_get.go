@@ -22,7 +22,7 @@ func (d *cloudStoreFileGetActionPerformer) DoAction(request Outcome, inFields ma
 	path := inFields["path"].(string)
 	siteReferenceId := inFields["site_id"].(string)
 
-	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache[siteReferenceId]
+	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache.Get(siteReferenceId)
 	if siteCacheFolder == nil {
 
 		restartAttrs := make(map[string]interface{})
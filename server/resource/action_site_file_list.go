@@ -20,7 +20,7 @@ func (d *cloudStoreFileListActionPerformer) DoAction(request Outcome, inFields m
 	path := inFields["path"].(string)
 	siteReferenceId := inFields["site_id"].(string)
 
-	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache[siteReferenceId]
+	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache.Get(siteReferenceId)
 
 	if siteCacheFolder == nil {
 
@@ -39,7 +39,7 @@ func (d *syncSiteStorageActionPerformer) DoAction(request Outcome, inFields map[
 	}
 
 	oauthTokenId := cloudStore.OAutoTokenId
-	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache[siteId]
+	siteCacheFolder := d.cruds["cloud_store"].SubsiteFolderCache.Get(siteId)
 	if siteCacheFolder == nil {
 		log.Printf("No sub-site cache found on local")
 		return nil, nil, []error{errors.New("no site found here")}
@@ -110,11 +110,12 @@ func (d *syncSiteStorageActionPerformer) DoAction(request Outcome, inFields map[
 		defaultConfig.DeleteMode = fs.DeleteModeBefore
 		defaultConfig.AutoConfirm = true
 
-		if srcFileName == "" {
-			err = sync.Sync(ctx, fdst, fsrc, true)
-		} else {
-			err = operations.CopyFile(ctx, fdst, fsrc, srcFileName, srcFileName)
-		}
+		err = d.cruds["site"].SyncAssetFolder(ctx, siteCacheFolder, func(ctx context.Context) error {
+			if srcFileName == "" {
+				return sync.Sync(ctx, fdst, fsrc, true)
+			}
+			return operations.CopyFile(ctx, fdst, fsrc, srcFileName, srcFileName)
+		})
 
 		if is_hugo_site && err == nil {
 			log.Printf("Starting hugo build for %v", tempDirectoryPath)
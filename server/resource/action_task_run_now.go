@@ -0,0 +1,45 @@
+package resource
+
+import (
+	"errors"
+	"github.com/artpar/api2go"
+)
+
+type taskRunNowActionPerformer struct {
+	scheduler TaskScheduler
+}
+
+func (d *taskRunNowActionPerformer) Name() string {
+	return "task.run"
+}
+
+// DoAction runs the task in the background without waiting for its schedule, the run shows up in task_execution
+func (d *taskRunNowActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	taskId, ok := inFields["task_id"].(string)
+	if !ok {
+		return nil, nil, []error{errors.New("task_id is missing")}
+	}
+
+	err := d.scheduler.RunTask(taskId)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", "Task started", "Success")))
+
+	return nil, responses, nil
+}
+
+func NewTaskRunNowPerformer(scheduler TaskScheduler) (ActionPerformerInterface, error) {
+
+	handler := taskRunNowActionPerformer{
+		scheduler: scheduler,
+	}
+
+	return &handler, nil
+
+}
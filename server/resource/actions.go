@@ -37,6 +37,10 @@ type Action struct {
 	OutFields               []Outcome
 	Validations             []ColumnTag
 	Conformations           []ColumnTag
+	// Transactional actions run all the POST/PATCH/DELETE/GET outcomes in a single database transaction
+	// which is rolled back if any outcome fails. EXECUTE outcomes are performed by the action performers
+	// on their own connection and are not part of the transaction
+	Transactional bool
 }
 
 // ActionRow represents an action instance on the database
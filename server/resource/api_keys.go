@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every api key so leaked keys are easy to spot in logs and code
+const apiKeyPrefix = "dak_"
+
+// apiKeyVisibleLength is the number of leading characters of a key kept in key_prefix to tell keys apart
+const apiKeyVisibleLength = 12
+
+// IssueApiKey creates an api key for the user with the scopes, a zero expiresAt never expires. The key is
+// returned only here, the api_key row keeps its hash
+func (dr *DbResource) IssueApiKey(userId int64, name string, scopes auth.ApiKeyScopes, expiresAt time.Time) (string, string, error) {
+
+	randomValue, err := newRandomTokenValue()
+	if err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + randomValue
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", "", err
+	}
+
+	record := goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.UserCRUD,
+		USER_ACCOUNT_ID_COLUMN: userId,
+		"name":                 name,
+		"key_hash":             auth.HashApiKey(key),
+		"key_prefix":           key[0:apiKeyVisibleLength],
+		"scopes":               strings.Join(scopes, ","),
+		"enabled":              true,
+		"created_at":           time.Now(),
+	}
+	if !expiresAt.IsZero() {
+		record["expires_at"] = expiresAt
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(auth.ApiKeyTableName).Rows(record).ToSQL()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to save api key: %v", err)
+	}
+
+	return key, u.String(), nil
+}
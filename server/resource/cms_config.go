@@ -21,7 +21,7 @@ type CmsConfig struct {
 	Actions                  []Action
 	ExchangeContracts        []ExchangeContract
 	Hostname                 string
-	SubSites                 map[string]SubSiteInformation
+	SubSites                 map[string]SubSiteInformation // replaced on every reload of the sites, read with HostSwitch.SubSites
 	Tasks                    []Task
 	Streams                  []StreamContract
 	ActionPerformers         []ActionPerformerInterface
@@ -0,0 +1,272 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"unicode"
+)
+
+// columnReadPermissions are the read permissions a column mask has to keep for the column to be readable by
+// everyone who can read its row
+const columnReadPermissions = auth.GuestRead | auth.UserRead | auth.GroupRead
+
+// ColumnPermission narrows the permission of a row to the mask set on one of its columns. A column without a
+// mask follows the permission of the row
+func (p PermissionInstance) ColumnPermission(mask uint64) PermissionInstance {
+	if mask == 0 {
+		return p
+	}
+
+	columnMask := auth.AuthPermission(mask)
+	groups := make([]auth.GroupPermission, len(p.UserGroupId))
+	for i, group := range p.UserGroupId {
+		group.Permission = group.Permission & columnMask
+		groups[i] = group
+	}
+
+	return PermissionInstance{
+		UserId:      p.UserId,
+		UserGroupId: groups,
+		Permission:  p.Permission & columnMask,
+	}
+}
+
+// HasColumnPermissions is true when at least one column of the table has a permission mask
+func (ti *TableInfo) HasColumnPermissions() bool {
+	for _, col := range ti.Columns {
+		if col.Permission != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryableColumn is true when the column is readable by everyone who can read its row. Other masked columns can
+// not be filtered, sorted, grouped or aggregated on by users other than administrators, as the result of the query
+// would tell their values to users who cannot read them
+func (ti *TableInfo) QueryableColumn(columnName string) bool {
+	col, ok := ti.GetColumnByName(columnName)
+	if !ok || col.Permission == 0 {
+		return true
+	}
+	return auth.AuthPermission(col.Permission)&columnReadPermissions == columnReadPermissions
+}
+
+// CheckQueryableColumns returns a 403 error when one of the expressions of a filter, sort, group by or projection
+// refers to a column of the tables which is not queryable by the user. Columns are matched as "table.column", or
+// by their name alone in any of the tables
+func (dr *DbResource) CheckQueryableColumns(sessionUser *auth.SessionUser, tableNames []string, expressions []string) error {
+
+	for _, expression := range expressions {
+		for _, identifier := range columnIdentifiers(expression) {
+			tableName, columnName := "", identifier
+			if i := strings.LastIndex(identifier, "."); i > -1 {
+				tableName, columnName = identifier[:i], identifier[i+1:]
+			}
+			for _, queriedTable := range tableNames {
+				if tableName != "" && tableName != queriedTable {
+					continue
+				}
+				tableResource, ok := dr.Cruds[queriedTable]
+				if !ok || tableResource.tableInfo.QueryableColumn(columnName) {
+					continue
+				}
+				if sessionUser != nil && dr.IsAdmin(sessionUser.UserReferenceId) {
+					return nil
+				}
+				return api2go.NewHTTPError(fmt.Errorf("column [%v] of [%v] cannot be used in a query", columnName, queriedTable), "ColumnPermissionChecker", 403)
+			}
+		}
+	}
+	return nil
+}
+
+// columnIdentifiers are the words of an expression which can name a column, as "salary" and "employee.salary"
+// in "sum(employee.salary)"
+func columnIdentifiers(expression string) []string {
+	return strings.FieldsFunc(expression, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.'
+	})
+}
+
+// StripUnreadableColumns removes the columns the user is not allowed to read from each row. Rows are copied before
+// any column is removed, rows of tables without column permissions are returned as they are
+func (dr *DbResource) StripUnreadableColumns(sessionUser *auth.SessionUser, rows []map[string]interface{}) []map[string]interface{} {
+
+	if sessionUser == nil {
+		sessionUser = &auth.SessionUser{}
+	}
+
+	isAdminChecked := false
+	isAdmin := false
+	// the groups of the rows are loaded for all the rows of a table at once
+	rowGroups := make(map[string]map[string][]auth.GroupPermission)
+
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		typeName, _ := row["__type"].(string)
+		rowResource, ok := dr.Cruds[typeName]
+		if !ok || !rowResource.tableInfo.HasColumnPermissions() {
+			continue
+		}
+
+		if !isAdminChecked {
+			isAdmin = dr.IsAdmin(sessionUser.UserReferenceId)
+			isAdminChecked = true
+		}
+		if isAdmin {
+			return rows
+		}
+
+		groups, ok := rowGroups[typeName]
+		if !ok {
+			groups = dr.getRowUserGroups(typeName, rows)
+			rowGroups[typeName] = groups
+		}
+		rowPermission := dr.getRowPermission(row, groups)
+		readableRow := make(map[string]interface{}, len(row))
+		for columnName, value := range row {
+			col, ok := rowResource.tableInfo.GetColumnByName(columnName)
+			if ok && !rowPermission.ColumnPermission(col.Permission).CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+				continue
+			}
+			readableRow[columnName] = value
+		}
+		rows[i] = readableRow
+	}
+
+	return rows
+}
+
+// getRowUserGroups loads the groups of the rows of the table in one query, by the reference id of the row. Every
+// row of the table gets an entry, nil when it has no groups
+func (dr *DbResource) getRowUserGroups(typeName string, rows []map[string]interface{}) map[string][]auth.GroupPermission {
+
+	groups := make(map[string][]auth.GroupPermission)
+	typeResource, ok := dr.Cruds[typeName]
+	if !ok || typeName == "usergroup" || strings.Index(typeName, "_has_") > -1 || !typeResource.model.HasMany("usergroup") {
+		return groups
+	}
+
+	referenceIds := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if rowType, _ := row["__type"].(string); rowType != typeName {
+			continue
+		}
+		if referenceId, ok := row["reference_id"].(string); ok {
+			referenceIds = append(referenceIds, referenceId)
+		}
+	}
+	if len(referenceIds) == 0 {
+		return groups
+	}
+
+	rel := api2go.TableRelation{
+		Subject:     typeName,
+		SubjectName: typeName + "_id",
+		Object:      "usergroup",
+		ObjectName:  "usergroup_id",
+		Relation:    "has_many_and_belongs_to_many",
+	}
+	query, args, err := statementbuilder.Squirrel.Select(
+		goqu.I(rel.GetSubject()+".reference_id").As("objectreferenceid"),
+		goqu.I("usergroup_id.reference_id").As("groupreferenceid"),
+		goqu.I(rel.GetJoinTableName()+".reference_id").As("relationreferenceid"),
+		goqu.I(rel.GetJoinTableName()+".permission").As("permission"),
+	).From(goqu.T(rel.GetSubject())).
+		Join(goqu.T(rel.GetJoinTableName()).As(rel.GetJoinTableName()),
+			goqu.On(goqu.Ex{
+				fmt.Sprintf("%v.%v", rel.GetJoinTableName(), rel.GetSubjectName()): goqu.I(fmt.Sprintf("%v.%v", rel.GetSubject(), "id")),
+			})).
+		Join(goqu.T(rel.GetObject()).As(rel.GetObjectName()),
+			goqu.On(goqu.Ex{
+				fmt.Sprintf("%v.%v", rel.GetJoinTableName(), rel.GetObjectName()): goqu.I(fmt.Sprintf("%v.%v", rel.GetObjectName(), "id")),
+			})).
+		Where(goqu.Ex{
+			rel.GetSubject() + ".reference_id": referenceIds,
+		}).ToSQL()
+	if err != nil {
+		log.Errorf("Failed to create permission select query: %v", err)
+		return groups
+	}
+
+	stmt, err := dr.connection.Preparex(query)
+	if err != nil {
+		log.Errorf("[column permission] failed to prepare statment: %v", err)
+		return groups
+	}
+	defer func(stmt *sqlx.Stmt) {
+		err := stmt.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt)
+
+	res, err := stmt.Queryx(args...)
+	if err != nil {
+		log.Errorf("Failed to get groups of [%v] rows: %v", typeName, err)
+		return groups
+	}
+	defer res.Close()
+
+	for _, referenceId := range referenceIds {
+		groups[referenceId] = nil
+	}
+	for res.Next() {
+		var g auth.GroupPermission
+		err = res.StructScan(&g)
+		if err != nil {
+			log.Errorf("Failed to scan group permission: %v", err)
+			continue
+		}
+		groups[g.ObjectReferenceId] = append(groups[g.ObjectReferenceId], g)
+	}
+	return groups
+}
+
+// CheckWritableColumns returns a 403 error for the first column in attributes the user is not allowed to write. A new
+// row is checked with the user as its owner, an existing row against its own permission
+func (dr *DbResource) CheckWritableColumns(sessionUser *auth.SessionUser, attributes map[string]interface{}, existingRow map[string]interface{}) error {
+
+	if !dr.tableInfo.HasColumnPermissions() {
+		return nil
+	}
+
+	var rowPermission PermissionInstance
+	if existingRow == nil {
+		rowPermission = PermissionInstance{
+			UserId:     sessionUser.UserReferenceId,
+			Permission: auth.ALLOW_ALL_PERMISSIONS,
+		}
+	} else {
+		rowPermission = dr.GetRowPermission(existingRow)
+	}
+
+	for columnName := range attributes {
+		col, ok := dr.tableInfo.GetColumnByName(columnName)
+		if !ok || col.Permission == 0 {
+			continue
+		}
+		columnPermission := rowPermission.ColumnPermission(col.Permission)
+
+		allowed := false
+		if existingRow == nil {
+			allowed = columnPermission.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups)
+		} else {
+			allowed = columnPermission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups)
+		}
+		if !allowed {
+			return api2go.NewHTTPError(fmt.Errorf("column [%v] of [%v] cannot be written by [%v]", col.ColumnName, dr.tableInfo.TableName, sessionUser.UserReferenceId), "ColumnPermissionChecker", 403)
+		}
+	}
+
+	return nil
+}
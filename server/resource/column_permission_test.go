@@ -0,0 +1,177 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"testing"
+)
+
+func TestColumnPermission(t *testing.T) {
+
+	row := PermissionInstance{
+		UserId: "owner",
+		UserGroupId: []auth.GroupPermission{
+			{
+				GroupReferenceId: "hr",
+				Permission:       auth.GroupCRUD,
+			},
+		},
+		Permission: auth.GuestRead | auth.UserCRUD | auth.GroupCRUD,
+	}
+	hrGroups := []auth.GroupPermission{{GroupReferenceId: "hr"}}
+
+	if !row.ColumnPermission(0).CanRead("guest", nil) {
+		t.Errorf("a column without a mask should follow the row permission")
+	}
+
+	salary := row.ColumnPermission(uint64(auth.GroupRead | auth.GroupUpdate))
+	if salary.CanRead("guest", nil) || salary.CanRead("owner", nil) {
+		t.Errorf("salary should not be readable by guests or the owner")
+	}
+	if !salary.CanRead("someone", hrGroups) || !salary.CanUpdate("someone", hrGroups) {
+		t.Errorf("salary should be readable and writable by hr")
+	}
+	if row.UserGroupId[0].Permission != auth.GroupCRUD {
+		t.Errorf("masking a column should not change the row permission")
+	}
+
+	status := row.ColumnPermission(uint64(auth.GuestRead | auth.UserRead | auth.UserUpdate | auth.GroupRead))
+	if !status.CanUpdate("owner", nil) || status.CanUpdate("someone", hrGroups) {
+		t.Errorf("status should be writable only by the owner")
+	}
+	if !status.CanRead("guest", nil) {
+		t.Errorf("status should be readable by guests")
+	}
+}
+
+func TestCheckWritableColumnsOnCreate(t *testing.T) {
+
+	dr := &DbResource{
+		tableInfo: &TableInfo{
+			TableName: "employee",
+			Columns: []api2go.ColumnInfo{
+				{ColumnName: "name"},
+				{ColumnName: "salary", Permission: uint64(auth.GroupRead | auth.GroupUpdate)},
+				{ColumnName: "status", Permission: uint64(auth.UserCRUD | auth.GroupRead)},
+			},
+		},
+	}
+	user := &auth.SessionUser{UserReferenceId: "owner"}
+
+	err := dr.CheckWritableColumns(user, map[string]interface{}{"name": "a", "status": "active"}, nil)
+	if err != nil {
+		t.Errorf("owner should be able to set name and status: %v", err)
+	}
+
+	err = dr.CheckWritableColumns(user, map[string]interface{}{"name": "a", "salary": 100}, nil)
+	httpErr, ok := err.(api2go.HTTPError)
+	if !ok || httpErr.Status() != 403 {
+		t.Errorf("expected a 403 for writing salary, got: %v", err)
+	}
+
+	unrestricted := &DbResource{tableInfo: &TableInfo{TableName: "note"}}
+	if unrestricted.CheckWritableColumns(user, map[string]interface{}{"salary": 100}, nil) != nil {
+		t.Errorf("tables without column permissions should not be checked")
+	}
+}
+
+func TestCheckQueryableColumns(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	employee := &DbResource{
+		connection: db,
+		tableInfo: &TableInfo{
+			TableName: "employee",
+			Columns: []api2go.ColumnInfo{
+				{ColumnName: "name"},
+				{ColumnName: "salary", Permission: uint64(auth.GroupRead | auth.GroupUpdate)},
+				{ColumnName: "status", Permission: uint64(auth.GuestRead | auth.UserCRUD | auth.GroupRead)},
+			},
+		},
+	}
+	employee.Cruds = map[string]*DbResource{"employee": employee}
+	user := &auth.SessionUser{UserReferenceId: "owner"}
+
+	if employee.CheckQueryableColumns(user, []string{"employee"}, []string{"name", "-status", "count(employee.status)"}) != nil {
+		t.Errorf("expected columns readable by everyone who can read the row to be queryable")
+	}
+	for _, expression := range []string{"salary", "-salary", "sum(employee.salary)", "employee.salary"} {
+		err = employee.CheckQueryableColumns(user, []string{"employee"}, []string{expression})
+		httpErr, ok := err.(api2go.HTTPError)
+		if !ok || httpErr.Status() != 403 {
+			t.Errorf("expected a 403 for querying [%v], got: %v", expression, err)
+		}
+	}
+	if employee.CheckQueryableColumns(user, []string{"employee"}, []string{"department.salary"}) != nil {
+		t.Errorf("expected the salary of another table to be queryable")
+	}
+
+	aggReq := AggregationRequest{
+		RootEntity:    "department",
+		Join:          []string{"employee@eq(employee.department_id,department.id)"},
+		ProjectColumn: []string{"avg(employee.salary)"},
+	}
+	if employee.CheckQueryableColumns(user, aggReq.Tables(), aggReq.Expressions()) == nil {
+		t.Errorf("expected an aggregate over a joined salary to be rejected")
+	}
+	aggReq.ProjectColumn = []string{"count"}
+	aggReq.GroupBy = []string{"employee.status"}
+	if err = employee.CheckQueryableColumns(user, aggReq.Tables(), aggReq.Expressions()); err != nil {
+		t.Errorf("expected an aggregate grouped by status to be allowed: %v", err)
+	}
+}
+
+func TestStripUnreadableColumnsLoadsGroupsOfAllRows(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table employee (id integer primary key, reference_id varchar(40))",
+		"create table usergroup (id integer primary key, reference_id varchar(40))",
+		"create table employee_employee_id_has_usergroup_usergroup_id (id integer primary key, reference_id varchar(40), employee_id integer, usergroup_id integer, permission integer)",
+		"insert into employee (id, reference_id) values (1, 'e1'), (2, 'e2')",
+		"insert into usergroup (id, reference_id) values (1, 'hr')",
+		"insert into employee_employee_id_has_usergroup_usergroup_id (reference_id, employee_id, usergroup_id, permission) values ('r1', 1, 1, " +
+			strconv.Itoa(int(auth.GroupCRUD)) + ")",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	employee := &DbResource{
+		connection: db,
+		model: api2go.NewApi2GoModel("employee", nil, 0, []api2go.TableRelation{
+			{Subject: "employee", Object: "usergroup", Relation: "has_many"},
+		}),
+		tableInfo: &TableInfo{
+			TableName: "employee",
+			Columns: []api2go.ColumnInfo{
+				{ColumnName: "name"},
+				{ColumnName: "salary", Permission: uint64(auth.GroupRead | auth.GroupUpdate)},
+			},
+		},
+	}
+	employee.Cruds = map[string]*DbResource{"employee": employee}
+	hrUser := &auth.SessionUser{UserReferenceId: "someone", Groups: []auth.GroupPermission{{GroupReferenceId: "hr"}}}
+
+	rows := employee.StripUnreadableColumns(hrUser, []map[string]interface{}{
+		{"__type": "employee", "reference_id": "e1", "user_account_id": "owner", "permission": int64(auth.GroupCRUD), "name": "a", "salary": 100},
+		{"__type": "employee", "reference_id": "e2", "user_account_id": "owner", "permission": int64(auth.GroupCRUD), "name": "b", "salary": 200},
+	})
+	if rows[0]["salary"] != 100 {
+		t.Errorf("expected hr to read the salary of an employee shared with hr: %v", rows[0])
+	}
+	if _, ok := rows[1]["salary"]; ok || rows[1]["name"] != nil {
+		t.Errorf("expected hr to not read an employee not shared with hr: %v", rows[1])
+	}
+}
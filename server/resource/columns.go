@@ -262,8 +262,9 @@ var SystemActions = []Action{
 				Type:   "otp.login.verify",
 				Method: "EXECUTE",
 				Attributes: map[string]interface{}{
-					"otp":    "~otp",
-					"mobile": "~mobile_number",
+					"otp":       "~otp",
+					"mobile":    "~mobile_number",
+					"client_ip": "~client_ip",
 				},
 			},
 		},
@@ -328,8 +329,9 @@ var SystemActions = []Action{
 				Type:   "otp.login.verify",
 				Method: "EXECUTE",
 				Attributes: map[string]interface{}{
-					"otp":    "~otp",
-					"mobile": "~mobile_number",
+					"otp":       "~otp",
+					"mobile":    "~mobile_number",
+					"client_ip": "~client_ip",
 				},
 			},
 		},
@@ -407,6 +409,85 @@ var SystemActions = []Action{
 			},
 		},
 	},
+	{
+		Name:             "replay_exchange_failure",
+		Label:            "Replay failed exchange",
+		OnType:           "exchange_failure",
+		InstanceOptional: false,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "exchange.failure.replay",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"exchange_failure_id": "$.reference_id",
+				},
+			},
+		},
+	},
+	{
+		Name:             "run_now",
+		Label:            "Run now",
+		OnType:           "task",
+		InstanceOptional: false,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "task.run",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"task_id": "$.reference_id",
+				},
+			},
+		},
+	},
+	{
+		Name:             "migrate_schema",
+		Label:            "Migrate schema",
+		OnType:           "world",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:         "Dry run",
+				ColumnName:   "dry_run",
+				ColumnType:   "truefalse",
+				DefaultValue: "true",
+			},
+			{
+				Name:         "Include destructive changes",
+				ColumnName:   "include_destructive",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "world.schema.migrate",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"dry_run":             "~dry_run",
+					"include_destructive": "~include_destructive",
+					"user":                "~user",
+				},
+			},
+		},
+	},
+	{
+		Name:             "discard_exchange_failure",
+		Label:            "Discard failed exchange",
+		OnType:           "exchange_failure",
+		InstanceOptional: false,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "exchange.failure.discard",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"exchange_failure_id": "$.reference_id",
+				},
+			},
+		},
+	},
 	{
 		Name:             "sync_site_storage",
 		Label:            "Sync site storage",
@@ -1213,8 +1294,9 @@ var SystemActions = []Action{
 				Type:   "otp.login.verify",
 				Method: "EXECUTE",
 				Attributes: map[string]interface{}{
-					"otp":   "~otp",
-					"email": "~email",
+					"otp":       "~otp",
+					"email":     "~email",
+					"client_ip": "~client_ip",
 				},
 			},
 			{
@@ -1266,14 +1348,146 @@ var SystemActions = []Action{
 				ColumnType: "password",
 				IsNullable: false,
 			},
+			{
+				Name:       "device",
+				ColumnName: "device",
+				ColumnType: "label",
+				IsNullable: true,
+			},
 		},
 		OutFields: []Outcome{
 			{
 				Type:   "jwt.token",
 				Method: "EXECUTE",
 				Attributes: map[string]interface{}{
-					"email":    "~email",
-					"password": "~password",
+					"email":     "~email",
+					"password":  "~password",
+					"device":    "~device",
+					"client_ip": "~client_ip",
+				},
+			},
+		},
+	},
+	{
+		Name:             "refresh_token",
+		Label:            "Refresh token",
+		InstanceOptional: true,
+		OnType:           USER_ACCOUNT_TABLE_NAME,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "refresh_token",
+				ColumnName: "refresh_token",
+				ColumnType: "hidden",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "jwt.token.refresh",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"refresh_token": "~refresh_token",
+				},
+			},
+		},
+	},
+	{
+		Name:             "logout",
+		Label:            "Sign out",
+		InstanceOptional: true,
+		OnType:           USER_ACCOUNT_TABLE_NAME,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "device",
+				ColumnName: "device",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "jwt.token.revoke",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":   "~user",
+					"device": "~device",
+				},
+			},
+		},
+	},
+	{
+		Name:             "generate_api_key",
+		Label:            "Generate api key",
+		InstanceOptional: true,
+		OnType:           USER_ACCOUNT_TABLE_NAME,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+			{
+				Name:       "scopes",
+				ColumnName: "scopes",
+				ColumnType: "content",
+				IsNullable: false,
+			},
+			{
+				Name:       "expires_in_days",
+				ColumnName: "expires_in_days",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "api_key.generate",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":            "~user",
+					"name":            "~name",
+					"scopes":          "~scopes",
+					"expires_in_days": "~expires_in_days",
+				},
+			},
+		},
+	},
+	{
+		Name:     "unlock_account",
+		Label:    "Unlock account",
+		OnType:   USER_ACCOUNT_TABLE_NAME,
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "account.unlock",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"email": "$.email",
+					"user":  "~user",
+				},
+			},
+		},
+	},
+	{
+		Name:   "revoke_tokens",
+		Label:  "Revoke sessions",
+		OnType: USER_ACCOUNT_TABLE_NAME,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "device",
+				ColumnName: "device",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "jwt.token.revoke",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user_account_id": "$.reference_id",
+					"device":          "~device",
 				},
 			},
 		},
@@ -1901,6 +2115,124 @@ var StandardTables = []TableInfo{
 				DataType:   "varchar(100)",
 				ColumnType: "label",
 			},
+			{
+				Name:         "concurrency_policy",
+				ColumnName:   "concurrency_policy",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				DefaultValue: "'allow'",
+				IsNullable:   true,
+			},
+		},
+	},
+	{
+		TableName:     "task_execution",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-history",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "task_reference_id",
+				ColumnName: "task_reference_id",
+				DataType:   "varchar(64)",
+				ColumnType: "alias",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "task_name",
+				ColumnName: "task_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				IsIndexed:    true,
+				DefaultValue: "'running'",
+			},
+			{
+				Name:       "triggered_by",
+				ColumnName: "triggered_by",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "started_at",
+				ColumnName: "started_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "ended_at",
+				ColumnName: "ended_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "duration_ms",
+				ColumnName: "duration_ms",
+				DataType:   "int(11)",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				Name:       "responses",
+				ColumnName: "responses",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "error",
+				ColumnName: "error",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName:     "schema_migration",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-database",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "changes",
+				ColumnName: "changes",
+				DataType:   "text",
+				ColumnType: "json",
+			},
+			{
+				Name:       "statements",
+				ColumnName: "statements",
+				DataType:   "text",
+				ColumnType: "content",
+			},
+			{
+				Name:       "applied_by",
+				ColumnName: "applied_by",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "includes_destructive",
+				ColumnName:   "includes_destructive",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
 		},
 	},
 	//{
@@ -2406,222 +2738,1098 @@ var StandardTables = []TableInfo{
 				ColumnType: "json",
 				DataType:   "text",
 			},
+			{
+				Name:       "retry_policy",
+				ColumnName: "retry_policy",
+				ColumnType: "json",
+				DataType:   "text",
+				IsNullable: true,
+			},
 		},
 	},
 	{
-		TableName:     "oauth_token",
+		TableName:     "exchange_failure",
 		IsHidden:      true,
 		DefaultGroups: adminsGroup,
+		Icon:          "fa-exclamation-triangle",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "access_token",
-				ColumnName: "access_token",
-				ColumnType: "encrypted",
-				DataType:   "varchar(1000)",
-			},
-			{
-				Name:       "expires_in",
-				ColumnName: "expires_in",
-				ColumnType: "measurement",
-				DataType:   "int(11)",
+				Name:       "exchange_name",
+				ColumnName: "exchange_name",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsIndexed:  true,
 			},
 			{
-				Name:       "refresh_token",
-				ColumnName: "refresh_token",
-				ColumnType: "encrypted",
-				DataType:   "varchar(1000)",
-			},
-			{
-				Name:       "token_type",
-				ColumnName: "token_type",
+				Name:       "event_type",
+				ColumnName: "event_type",
 				ColumnType: "label",
 				DataType:   "varchar(20)",
+				IsNullable: true,
+			},
+			{
+				Name:       "webhook_reference_id",
+				ColumnName: "webhook_reference_id",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsNullable: true,
+			},
+			{
+				Name:       "payload",
+				ColumnName: "payload",
+				ColumnType: "json",
+				DataType:   "text",
+			},
+			{
+				Name:       "error",
+				ColumnName: "error",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
+			},
+			{
+				Name:         "attempt_count",
+				ColumnName:   "attempt_count",
+				ColumnType:   "measurement",
+				DataType:     "int(11)",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "last_status_code",
+				ColumnName: "last_status_code",
+				ColumnType: "measurement",
+				DataType:   "int(11)",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_attempt_at",
+				ColumnName: "last_attempt_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				ColumnType:   "label",
+				DataType:     "varchar(20)",
+				IsIndexed:    true,
+				DefaultValue: "'failed'",
 			},
 		},
 	},
 	{
-		TableName:     "cloud_store",
-		DefaultGroups: adminsGroup,
+		TableName:     "webhook",
 		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-paper-plane",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "Name",
+				Name:       "name",
 				ColumnName: "name",
 				ColumnType: "label",
-				DataType:   "varchar(100)",
+				DataType:   "varchar(200)",
 				IsUnique:   true,
+				IsIndexed:  true,
 			},
 			{
-				Name:       "store_type",
-				ColumnName: "store_type",
-				ColumnType: "label",
-				DataType:   "varchar(100)",
+				Name:       "url",
+				ColumnName: "url",
+				ColumnType: "url",
+				DataType:   "varchar(1000)",
 			},
 			{
-				Name:       "store_provider",
-				ColumnName: "store_provider",
-				ColumnType: "label",
-				DataType:   "varchar(100)",
+				Name:       "secret",
+				ColumnName: "secret",
+				ColumnType: "encrypted",
+				DataType:   "varchar(500)",
+				IsNullable: true,
 			},
 			{
-				Name:       "root_path",
-				ColumnName: "root_path",
+				Name:         "event_types",
+				ColumnName:   "event_types",
+				ColumnType:   "label",
+				DataType:     "varchar(200)",
+				DefaultValue: "'create,update,delete'",
+			},
+			{
+				Name:       "table_filter",
+				ColumnName: "table_filter",
 				ColumnType: "label",
 				DataType:   "varchar(1000)",
+				IsNullable: true,
 			},
 			{
-				Name:       "store_parameters",
-				ColumnName: "store_parameters",
-				ColumnType: "json",
-				DataType:   "text",
+				Name:         "enabled",
+				ColumnName:   "enabled",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "true",
 			},
 		},
 	},
 	{
-		TableName:     "site",
-		DefaultGroups: adminsGroup,
+		TableName:     "webhook_delivery",
 		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-history",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "name",
-				ColumnName: "name",
-				ColumnType: "label",
-				DataType:   "varchar(100)",
+				Name:       "webhook_reference_id",
+				ColumnName: "webhook_reference_id",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsIndexed:  true,
 			},
 			{
-				Name:       "hostname",
-				ColumnName: "hostname",
+				Name:       "webhook_name",
+				ColumnName: "webhook_name",
 				ColumnType: "label",
-				DataType:   "varchar(100)",
+				DataType:   "varchar(200)",
 			},
 			{
-				Name:              "path",
-				ColumnName:        "path",
-				ColumnType:        "label",
-				DataType:          "varchar(100)",
-				ColumnDescription: "path on the cloud store to host as base directory",
+				Name:       "delivery_id",
+				ColumnName: "delivery_id",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsIndexed:  true,
 			},
 			{
-				Name:         "enable",
-				ColumnName:   "enable",
-				ColumnType:   "truefalse",
-				DataType:     "bool",
-				DefaultValue: "true",
+				Name:       "event_type",
+				ColumnName: "event_type",
+				ColumnType: "label",
+				DataType:   "varchar(20)",
 			},
 			{
-				Name:         "enable_https",
-				ColumnName:   "enable_https",
-				ColumnType:   "truefalse",
-				DataType:     "bool",
-				DefaultValue: "false",
+				Name:       "table_name",
+				ColumnName: "table_name",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
 			},
 			{
-				Name:         "ftp_enabled",
-				ColumnName:   "ftp_enabled",
-				ColumnType:   "truefalse",
-				DataType:     "bool",
-				DefaultValue: "false",
+				Name:         "attempt",
+				ColumnName:   "attempt",
+				ColumnType:   "measurement",
+				DataType:     "int(11)",
+				DefaultValue: "1",
 			},
 			{
-				Name:         "site_type",
-				ColumnName:   "site_type",
+				Name:         "status",
+				ColumnName:   "status",
 				ColumnType:   "label",
 				DataType:     "varchar(20)",
-				DefaultValue: "'static'",
+				IsIndexed:    true,
+				DefaultValue: "'delivered'",
+			},
+			{
+				Name:       "status_code",
+				ColumnName: "status_code",
+				ColumnType: "measurement",
+				DataType:   "int(11)",
+				IsNullable: true,
+			},
+			{
+				Name:       "latency_ms",
+				ColumnName: "latency_ms",
+				ColumnType: "measurement",
+				DataType:   "int(11)",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_body",
+				ColumnName: "response_body",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
+			},
+			{
+				Name:       "error",
+				ColumnName: "error",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
 			},
 		},
 	},
 	{
-		TableName:     "mail_server",
-		IsHidden:      true,
-		Icon:          "fa-envelope",
-		DefaultGroups: adminsGroup,
+		TableName: "api_key",
+		Icon:      "fa-key",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "hostname",
-				ColumnName: "hostname",
-				DataType:   "varchar(100)",
+				Name:       "name",
+				ColumnName: "name",
 				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsIndexed:  true,
 			},
 			{
-				Name:         "is_enabled",
-				ColumnName:   "is_enabled",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:           "key_hash",
+				ColumnName:     "key_hash",
+				ColumnType:     "hidden",
+				DataType:       "varchar(64)",
+				IsIndexed:      true,
+				IsUnique:       true,
+				ExcludeFromApi: true,
 			},
 			{
-				Name:         "listen_interface",
-				ColumnName:   "listen_interface",
-				DataType:     "varchar(100)",
-				ColumnType:   "label",
-				DefaultValue: "'0.0.0.0:465'",
+				Name:       "key_prefix",
+				ColumnName: "key_prefix",
+				ColumnType: "label",
+				DataType:   "varchar(20)",
 			},
 			{
-				Name:         "max_size",
-				ColumnName:   "max_size",
-				DataType:     "int(11)",
-				ColumnType:   "measurement",
-				DefaultValue: "10000",
+				Name:       "scopes",
+				ColumnName: "scopes",
+				ColumnType: "content",
+				DataType:   "text",
 			},
 			{
-				Name:         "max_clients",
-				ColumnName:   "max_clients",
-				DataType:     "int(11)",
-				ColumnType:   "measurement",
-				DefaultValue: "20",
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
 			},
 			{
-				Name:         "xclient_on",
-				ColumnName:   "xclient_on",
-				DataType:     "bool",
+				Name:         "enabled",
+				ColumnName:   "enabled",
 				ColumnType:   "truefalse",
-				DefaultValue: "false",
-			},
-			{
-				Name:         "always_on_tls",
-				ColumnName:   "always_on_tls",
 				DataType:     "bool",
-				ColumnType:   "truefalse",
 				DefaultValue: "true",
 			},
 			{
-				Name:         "authentication_required",
-				ColumnName:   "authentication_required",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "true",
+				Name:       "last_used_at",
+				ColumnName: "last_used_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_used_ip",
+				ColumnName: "last_used_ip",
+				ColumnType: "label",
+				DataType:   "varchar(50)",
+				IsNullable: true,
 			},
 		},
 	},
 	{
-		TableName:     "mail_account",
+		TableName: "ssh_key",
+		Icon:      "fa-terminal",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+			},
+			{
+				Name:       "public_key",
+				ColumnName: "public_key",
+				ColumnType: "content",
+				DataType:   "text",
+			},
+		},
+	},
+	{
+		TableName:     "login_lockout",
 		IsHidden:      true,
 		DefaultGroups: adminsGroup,
-		Icon:          "fa-envelope",
+		Icon:          "fa-lock",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "username",
-				ColumnName: "username",
-				DataType:   "varchar(100)",
+				Name:       "event",
+				ColumnName: "event",
 				ColumnType: "label",
-				IsUnique:   true,
+				DataType:   "varchar(20)",
+				IsIndexed:  true,
 			},
 			{
-				Name:       "password",
-				ColumnName: "password",
-				ColumnType: "password",
+				Name:       "service",
+				ColumnName: "service",
+				ColumnType: "label",
+				DataType:   "varchar(20)",
 			},
 			{
-				Name:       "password_md5",
-				ColumnName: "password_md5",
-				ColumnType: "md5-bcrypt",
+				Name:       "account",
+				ColumnName: "account",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+				IsIndexed:  true,
+			},
+			{
+				Name:       "ip_address",
+				ColumnName: "ip_address",
+				ColumnType: "label",
+				DataType:   "varchar(50)",
+				IsNullable: true,
+			},
+			{
+				Name:         "failures",
+				ColumnName:   "failures",
+				ColumnType:   "measurement",
+				DataType:     "int(11)",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "locked_until",
+				ColumnName: "locked_until",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName:     "refresh_token",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-key",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:           "token_hash",
+				ColumnName:     "token_hash",
+				ColumnType:     "hidden",
+				DataType:       "varchar(64)",
+				IsIndexed:      true,
+				IsUnique:       true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:       "family_id",
+				ColumnName: "family_id",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "device",
+				ColumnName: "device",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+				IsIndexed:  true,
+			},
+			{
+				Name:       "access_token_jti",
+				ColumnName: "access_token_jti",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+			},
+			{
+				Name:       "access_token_expires_at",
+				ColumnName: "access_token_expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+			},
+			{
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+			},
+			{
+				Name:       "revoked_at",
+				ColumnName: "revoked_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+			{
+				Name:       "replaced_by",
+				ColumnName: "replaced_by",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName:     "revoked_token",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-ban",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "jti",
+				ColumnName: "jti",
+				ColumnType: "alias",
+				DataType:   "varchar(64)",
+				IsIndexed:  true,
+				IsUnique:   true,
+			},
+			{
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsIndexed:  true,
+			},
+		},
+	},
+	{
+		TableName:     "oauth_token",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "access_token",
+				ColumnName: "access_token",
+				ColumnType: "encrypted",
+				DataType:   "varchar(1000)",
+			},
+			{
+				Name:       "expires_in",
+				ColumnName: "expires_in",
+				ColumnType: "measurement",
+				DataType:   "int(11)",
+			},
+			{
+				Name:       "refresh_token",
+				ColumnName: "refresh_token",
+				ColumnType: "encrypted",
+				DataType:   "varchar(1000)",
+			},
+			{
+				Name:       "token_type",
+				ColumnName: "token_type",
+				ColumnType: "label",
+				DataType:   "varchar(20)",
+			},
+		},
+	},
+	{
+		TableName:     "cloud_store",
+		DefaultGroups: adminsGroup,
+		IsHidden:      true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "Name",
+				ColumnName: "name",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsUnique:   true,
+			},
+			{
+				Name:       "store_type",
+				ColumnName: "store_type",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+			},
+			{
+				Name:       "store_provider",
+				ColumnName: "store_provider",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+			},
+			{
+				Name:       "root_path",
+				ColumnName: "root_path",
+				ColumnType: "label",
+				DataType:   "varchar(1000)",
+			},
+			{
+				Name:       "store_parameters",
+				ColumnName: "store_parameters",
+				ColumnType: "json",
+				DataType:   "text",
+			},
+			{
+				Name:              "sync_mode",
+				ColumnName:        "sync_mode",
+				ColumnType:        "label",
+				DataType:          "varchar(20)",
+				DefaultValue:      "'pull'",
+				ColumnDescription: "pull to only copy the store to the local cache, bidirectional to also write local changes back",
+			},
+			{
+				Name:              "conflict_policy",
+				ColumnName:        "conflict_policy",
+				ColumnType:        "label",
+				DataType:          "varchar(20)",
+				DefaultValue:      "'newest_wins'",
+				ColumnDescription: "newest_wins, remote_wins or keep_both for files changed both locally and in the store",
+			},
+		},
+	},
+	{
+		TableName:     "site",
+		DefaultGroups: adminsGroup,
+		IsHidden:      true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+			},
+			{
+				Name:       "hostname",
+				ColumnName: "hostname",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+			},
+			{
+				Name:              "path",
+				ColumnName:        "path",
+				ColumnType:        "label",
+				DataType:          "varchar(100)",
+				ColumnDescription: "path on the cloud store to host as base directory",
+			},
+			{
+				Name:         "enable",
+				ColumnName:   "enable",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "true",
+			},
+			{
+				Name:         "enable_https",
+				ColumnName:   "enable_https",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "ftp_enabled",
+				ColumnName:   "ftp_enabled",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "site_type",
+				ColumnName:   "site_type",
+				ColumnType:   "label",
+				DataType:     "varchar(20)",
+				DefaultValue: "'static'",
+			},
+			{
+				Name:              "sync_mode",
+				ColumnName:        "sync_mode",
+				ColumnType:        "label",
+				DataType:          "varchar(20)",
+				DefaultValue:      "'pull'",
+				ColumnDescription: "pull to only copy the store to the local cache, bidirectional to also write local changes back",
+			},
+			{
+				Name:              "conflict_policy",
+				ColumnName:        "conflict_policy",
+				ColumnType:        "label",
+				DataType:          "varchar(20)",
+				DefaultValue:      "'newest_wins'",
+				ColumnDescription: "newest_wins, remote_wins or keep_both for files changed both locally and in the store",
+			},
+		},
+	},
+	{
+		TableName:     "mail_server",
+		IsHidden:      true,
+		Icon:          "fa-envelope",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "hostname",
+				ColumnName: "hostname",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "is_enabled",
+				ColumnName:   "is_enabled",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "listen_interface",
+				ColumnName:   "listen_interface",
+				DataType:     "varchar(100)",
+				ColumnType:   "label",
+				DefaultValue: "'0.0.0.0:465'",
+			},
+			{
+				Name:         "max_size",
+				ColumnName:   "max_size",
+				DataType:     "int(11)",
+				ColumnType:   "measurement",
+				DefaultValue: "10000",
+			},
+			{
+				Name:         "max_clients",
+				ColumnName:   "max_clients",
+				DataType:     "int(11)",
+				ColumnType:   "measurement",
+				DefaultValue: "20",
+			},
+			{
+				Name:         "xclient_on",
+				ColumnName:   "xclient_on",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "always_on_tls",
+				ColumnName:   "always_on_tls",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "true",
+			},
+			{
+				Name:         "authentication_required",
+				ColumnName:   "authentication_required",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "true",
+			},
+		},
+	},
+	{
+		TableName:     "mail_account",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-envelope",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "username",
+				ColumnName: "username",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsUnique:   true,
+			},
+			{
+				Name:       "password",
+				ColumnName: "password",
+				ColumnType: "password",
+			},
+			{
+				Name:       "password_md5",
+				ColumnName: "password_md5",
+				ColumnType: "md5-bcrypt",
+			},
+		},
+	},
+	{
+		TableName:     "mail_box",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "subscribed",
+				ColumnName:   "subscribed",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "true",
+			},
+			{
+				Name:         "uidvalidity",
+				ColumnName:   "uidvalidity",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+			},
+			{
+				Name:         "nextuid",
+				ColumnName:   "nextuid",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+			},
+			{
+				Name:       "attributes",
+				ColumnName: "attributes",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "flags",
+				ColumnName: "flags",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "permanent_flags",
+				ColumnName: "permanent_flags",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "highest_mod_sequence",
+				ColumnName:   "highest_mod_sequence",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+			},
+		},
+	},
+	{
+		TableName:     "mail",
+		IsHidden:      true,
+		Icon:          "fa-envelope",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "message_id",
+				ColumnName: "message_id",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "mail_id",
+				ColumnName: "mail_id",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "from_address",
+				ColumnName: "from_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "internal_date",
+				ColumnName: "internal_date",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+			},
+			{
+				Name:       "to_address",
+				ColumnName: "to_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "reply_to_address",
+				ColumnName: "reply_to_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "sender_address",
+				ColumnName: "sender_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "subject",
+				ColumnName: "subject",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "body",
+				ColumnName: "body",
+				DataType:   "text",
+				ColumnType: "label",
+			},
+			{
+				Name:       "mail",
+				ColumnName: "mail",
+				ColumnType: "gzip",
+				DataType:   "blob",
+			},
+			{
+				Name:       "spam_score",
+				ColumnName: "spam_score",
+				ColumnType: "measurement",
+				DataType:   "float",
+			},
+			{
+				Name:       "hash",
+				ColumnName: "hash",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "content_type",
+				ColumnName: "content_type",
+				DataType:   "text",
+				ColumnType: "content",
+			},
+			{
+				Name:       "recipient",
+				ColumnName: "recipient",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "has_attachment",
+				ColumnName:   "has_attachment",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:       "ip_addr",
+				ColumnName: "ip_addr",
+				DataType:   "varchar(30)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "return_path",
+				ColumnName: "return_path",
+				DataType:   "VARCHAR(255)",
+				ColumnType: "content",
+			},
+			{
+				Name:         "is_tls",
+				ColumnName:   "is_tls",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "seen",
+				ColumnName:   "seen",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "recent",
+				ColumnName:   "recent",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "true",
+			},
+			{
+				Name:         "deleted",
+				ColumnName:   "deleted",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "spam",
+				ColumnName:   "spam",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+			{
+				Name:       "size",
+				ColumnName: "size",
+				DataType:   "int(11)",
+				ColumnType: "value",
+			},
+			{
+				Name:         "flags",
+				ColumnName:   "flags",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "",
+			},
+			{
+				Name:         "attachments",
+				ColumnName:   "attachments",
+				IsForeignKey: true,
+				IsNullable:   true,
+				ColumnType:   "file.*",
+				DataType:     "longblob",
+				ForeignKeyData: api2go.ForeignKeyData{
+					DataSource: "cloud_store",
+					Namespace:  "localstore",
+					KeyName:    "mail_attachments",
+				},
+			},
+			{
+				Name:       "sent_at",
+				ColumnName: "sent_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+				IsIndexed:  true,
+			},
+			{
+				Name:         "mod_sequence",
+				ColumnName:   "mod_sequence",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+				IsIndexed:    true,
+			},
+		},
+	},
+	{
+		TableName:     "mail_search_term",
+		IsHidden:      true,
+		Icon:          "fa-search",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "mail_id",
+				ColumnName: "mail_id",
+				DataType:   "int(11)",
+				ColumnType: "measurement",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "field",
+				ColumnName: "field",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "term",
+				ColumnName: "term",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+		},
+	},
+	{
+		TableName:     "outbox",
+		IsHidden:      true,
+		Icon:          "fa-envelope",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "from_address",
+				ColumnName: "from_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "to_address",
+				ColumnName: "to_address",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "to_host",
+				ColumnName: "to_host",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "mail",
+				ColumnName: "mail",
+				ColumnType: "gzip",
+				DataType:   "blob",
+			},
+			{
+				Name:         "sent",
+				ColumnName:   "sent",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "false",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				ColumnType:   "label",
+				DataType:     "varchar(20)",
+				IsIndexed:    true,
+				DefaultValue: "'queued'",
+			},
+			{
+				Name:         "attempts",
+				ColumnName:   "attempts",
+				ColumnType:   "measurement",
+				DataType:     "int(11)",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "next_attempt_at",
+				ColumnName: "next_attempt_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+				IsIndexed:  true,
+			},
+			{
+				Name:       "last_attempt_at",
+				ColumnName: "last_attempt_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_error",
+				ColumnName: "last_error",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
+			},
+			{
+				Name:       "mx_host",
+				ColumnName: "mx_host",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+			},
+			{
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+			{
+				Name:       "sent_at",
+				ColumnName: "sent_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName:     "mail_template",
+		IsHidden:      true,
+		Icon:          "fa-envelope",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:         "language",
+				ColumnName:   "language",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "subject",
+				ColumnName: "subject",
+				DataType:   "varchar(500)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "text_body",
+				ColumnName: "text_body",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:       "html_body",
+				ColumnName: "html_body",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
 			},
 		},
 	},
 	{
-		TableName:     "mail_box",
+		TableName:     "mail_rule",
 		IsHidden:      true,
+		Icon:          "fa-filter",
 		DefaultGroups: adminsGroup,
 		Columns: []api2go.ColumnInfo{
 			{
@@ -2631,241 +3839,281 @@ var StandardTables = []TableInfo{
 				ColumnType: "label",
 			},
 			{
-				Name:         "subscribed",
-				ColumnName:   "subscribed",
+				Name:         "enabled",
+				ColumnName:   "enabled",
 				DataType:     "bool",
 				ColumnType:   "truefalse",
 				DefaultValue: "true",
 			},
 			{
-				Name:         "uidvalidity",
-				ColumnName:   "uidvalidity",
-				DataType:     "int(11)",
-				ColumnType:   "value",
-				DefaultValue: "1",
-			},
-			{
-				Name:         "nextuid",
-				ColumnName:   "nextuid",
+				Name:         "priority",
+				ColumnName:   "priority",
 				DataType:     "int(11)",
-				ColumnType:   "value",
-				DefaultValue: "1",
+				ColumnType:   "measurement",
+				DefaultValue: "100",
 			},
 			{
-				Name:       "attributes",
-				ColumnName: "attributes",
-				DataType:   "varchar(100)",
+				Name:       "recipient_pattern",
+				ColumnName: "recipient_pattern",
+				DataType:   "varchar(500)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "flags",
-				ColumnName: "flags",
-				DataType:   "varchar(100)",
+				Name:       "sender_pattern",
+				ColumnName: "sender_pattern",
+				DataType:   "varchar(500)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "permanent_flags",
-				ColumnName: "permanent_flags",
-				DataType:   "varchar(100)",
+				Name:       "subject_pattern",
+				ColumnName: "subject_pattern",
+				DataType:   "varchar(500)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
-		},
-	},
-	{
-		TableName:     "mail",
-		IsHidden:      true,
-		Icon:          "fa-envelope",
-		DefaultGroups: adminsGroup,
-		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "message_id",
-				ColumnName: "message_id",
-				DataType:   "varchar(100)",
-				ColumnType: "label",
+				Name:       "header_conditions",
+				ColumnName: "header_conditions",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
 			},
 			{
-				Name:       "mail_id",
-				ColumnName: "mail_id",
+				Name:       "move_to_mailbox",
+				ColumnName: "move_to_mailbox",
 				DataType:   "varchar(100)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "from_address",
-				ColumnName: "from_address",
+				Name:       "set_flags",
+				ColumnName: "set_flags",
 				DataType:   "varchar(200)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "internal_date",
-				ColumnName: "internal_date",
-				DataType:   "timestamp",
-				ColumnType: "datetime",
+				Name:       "forward_to",
+				ColumnName: "forward_to",
+				DataType:   "varchar(500)",
+				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "to_address",
-				ColumnName: "to_address",
-				DataType:   "varchar(200)",
+				Name:       "entity_name",
+				ColumnName: "entity_name",
+				DataType:   "varchar(100)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "reply_to_address",
-				ColumnName: "reply_to_address",
-				DataType:   "varchar(200)",
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "sender_address",
-				ColumnName: "sender_address",
-				DataType:   "varchar(200)",
-				ColumnType: "label",
+				Name:       "attributes",
+				ColumnName: "attributes",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
 			},
 			{
-				Name:       "subject",
-				ColumnName: "subject",
-				DataType:   "varchar(200)",
-				ColumnType: "label",
+				Name:         "stop_processing",
+				ColumnName:   "stop_processing",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
 			},
+		},
+	},
+	{
+		TableName:     "file_upload",
+		IsHidden:      true,
+		Icon:          "fa-upload",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "body",
-				ColumnName: "body",
-				DataType:   "text",
+				Name:       "file_name",
+				ColumnName: "file_name",
+				DataType:   "varchar(500)",
 				ColumnType: "label",
 			},
 			{
-				Name:       "mail",
-				ColumnName: "mail",
-				ColumnType: "gzip",
-				DataType:   "blob",
+				Name:       "size",
+				ColumnName: "size",
+				DataType:   "bigint",
+				ColumnType: "measurement",
 			},
 			{
-				Name:       "spam_score",
-				ColumnName: "spam_score",
-				ColumnType: "measurement",
-				DataType:   "float",
+				Name:         "upload_offset",
+				ColumnName:   "upload_offset",
+				DataType:     "bigint",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
 			},
 			{
-				Name:       "hash",
-				ColumnName: "hash",
-				DataType:   "varchar(100)",
+				Name:       "checksum",
+				ColumnName: "checksum",
+				DataType:   "varchar(200)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "content_type",
-				ColumnName: "content_type",
-				DataType:   "text",
-				ColumnType: "content",
+				Name:       "target",
+				ColumnName: "target",
+				DataType:   "varchar(1000)",
+				ColumnType: "label",
 			},
 			{
-				Name:       "recipient",
-				ColumnName: "recipient",
-				DataType:   "varchar(200)",
+				Name:       "path",
+				ColumnName: "path",
+				DataType:   "varchar(1000)",
 				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:         "has_attachment",
-				ColumnName:   "has_attachment",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:       "local_path",
+				ColumnName: "local_path",
+				DataType:   "varchar(1000)",
+				ColumnType: "label",
 			},
 			{
-				Name:       "ip_addr",
-				ColumnName: "ip_addr",
-				DataType:   "varchar(30)",
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				DefaultValue: "'uploading'",
+				IsIndexed:    true,
+			},
+		},
+	},
+	{
+		TableName:     "ftp_change_log",
+		IsHidden:      true,
+		Icon:          "fa-history",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "site_id",
+				ColumnName: "site_id",
+				DataType:   "varchar(100)",
 				ColumnType: "label",
+				IsNullable: true,
+				IsIndexed:  true,
 			},
 			{
-				Name:       "return_path",
-				ColumnName: "return_path",
-				DataType:   "VARCHAR(255)",
-				ColumnType: "content",
+				Name:       "user_reference_id",
+				ColumnName: "user_reference_id",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
 			},
 			{
-				Name:         "is_tls",
-				ColumnName:   "is_tls",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:       "operation",
+				ColumnName: "operation",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
 			},
 			{
-				Name:         "seen",
-				ColumnName:   "seen",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:       "path",
+				ColumnName: "path",
+				DataType:   "varchar(1000)",
+				ColumnType: "label",
 			},
 			{
-				Name:         "recent",
-				ColumnName:   "recent",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "true",
+				Name:       "new_path",
+				ColumnName: "new_path",
+				DataType:   "varchar(1000)",
+				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:         "deleted",
-				ColumnName:   "deleted",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:         "size",
+				ColumnName:   "size",
+				DataType:     "bigint",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
 			},
 			{
-				Name:         "spam",
-				ColumnName:   "spam",
-				DataType:     "bool",
-				ColumnType:   "truefalse",
-				DefaultValue: "false",
+				Name:       "client_ip",
+				ColumnName: "client_ip",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
-				Name:       "size",
-				ColumnName: "size",
-				DataType:   "int(11)",
-				ColumnType: "value",
+				Name:       "cloud_store_id",
+				ColumnName: "cloud_store_id",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+				IsIndexed:  true,
 			},
 			{
-				Name:         "flags",
-				ColumnName:   "flags",
-				DataType:     "varchar(500)",
+				Name:         "protocol",
+				ColumnName:   "protocol",
+				DataType:     "varchar(10)",
 				ColumnType:   "label",
-				DefaultValue: "",
+				DefaultValue: "'ftp'",
 			},
 		},
 	},
 	{
-		TableName:     "outbox",
+		TableName:     "document_update",
 		IsHidden:      true,
-		Icon:          "fa-envelope",
+		Icon:          "fa-file",
 		DefaultGroups: adminsGroup,
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "from_address",
-				ColumnName: "from_address",
+				Name:       "document_name",
+				ColumnName: "document_name",
 				DataType:   "varchar(200)",
 				ColumnType: "label",
+				IsIndexed:  true,
 			},
 			{
-				Name:       "to_address",
-				ColumnName: "to_address",
-				DataType:   "varchar(200)",
-				ColumnType: "label",
+				Name:       "update_offset",
+				ColumnName: "update_offset",
+				DataType:   "int(11)",
+				ColumnType: "measurement",
 			},
 			{
-				Name:       "to_host",
-				ColumnName: "to_host",
+				Name:       "contents",
+				ColumnName: "contents",
+				DataType:   "mediumtext",
+				ColumnType: "content",
+			},
+		},
+	},
+	{
+		TableName:     "document_snapshot",
+		IsHidden:      true,
+		Icon:          "fa-history",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "document_name",
+				ColumnName: "document_name",
 				DataType:   "varchar(200)",
 				ColumnType: "label",
+				IsIndexed:  true,
 			},
 			{
-				Name:       "mail",
-				ColumnName: "mail",
-				ColumnType: "gzip",
-				DataType:   "blob",
+				Name:       "size",
+				ColumnName: "size",
+				DataType:   "int(11)",
+				ColumnType: "measurement",
 			},
 			{
-				Name:         "sent",
-				ColumnName:   "sent",
-				ColumnType:   "truefalse",
-				DataType:     "bool",
-				DefaultValue: "false",
+				Name:       "contents",
+				ColumnName: "contents",
+				DataType:   "mediumtext",
+				ColumnType: "content",
 			},
 		},
 	},
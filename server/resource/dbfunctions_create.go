@@ -33,7 +33,7 @@ func CreateUniqueConstraints(initConfig *CmsConfig, db *sqlx.Tx) {
 
 		if len(table.CompositeKeys) > 0 {
 			for _, compositeKeyCols := range table.CompositeKeys {
-				indexName := "i" + GetMD5HashString("index_cl_"+strings.Join(compositeKeyCols, ",")+"_unique")
+				indexName := CompositeKeyIndexName(compositeKeyCols)
 
 				if existingIndexes[indexName] {
 					continue
@@ -64,7 +64,7 @@ func CreateUniqueConstraints(initConfig *CmsConfig, db *sqlx.Tx) {
 				continue
 			}
 
-			indexName := "i" + GetMD5HashString("index_join_"+table.TableName+"_"+"_unique")
+			indexName := JoinTableIndexName(table.TableName)
 			if existingIndexes[indexName] {
 				continue
 			}
@@ -85,12 +85,14 @@ func CreateIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
 
 	tx := db.MustBegin()
 	existingIndexes := GetExistingIndexes(tx)
+	err := tx.Rollback()
+	CheckErr(err, "Failed to close transaction after reading existing indexes")
 
 	for _, table := range initConfig.Tables {
 		for _, column := range table.Columns {
 
 			if column.IsUnique {
-				indexName := "u" + GetMD5HashString("index_"+table.TableName+"_"+column.ColumnName+"_unique")
+				indexName := UniqueColumnIndexName(table.TableName, column.ColumnName)
 				if existingIndexes[indexName] {
 					continue
 				}
@@ -101,7 +103,7 @@ func CreateIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
 					log.Printf("Failed to create index on Table[%v][%v]: %v", table.TableName, column.ColumnName, err)
 				}
 			} else if column.IsIndexed {
-				indexName := "i" + GetMD5HashString("index_"+table.TableName+"_"+column.ColumnName+"_index")
+				indexName := ColumnIndexName(table.TableName, column.ColumnName)
 				if existingIndexes[indexName] {
 					continue
 				}
@@ -117,6 +119,24 @@ func CreateIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
 	}
 }
 
+// UniqueColumnIndexName and the functions below name the indexes created by daptin,
+// a letter followed by a md5 hash
+func UniqueColumnIndexName(tableName string, columnName string) string {
+	return "u" + GetMD5HashString("index_"+tableName+"_"+columnName+"_unique")
+}
+
+func ColumnIndexName(tableName string, columnName string) string {
+	return "i" + GetMD5HashString("index_"+tableName+"_"+columnName+"_index")
+}
+
+func CompositeKeyIndexName(columnNames []string) string {
+	return "i" + GetMD5HashString("index_cl_"+strings.Join(columnNames, ",")+"_unique")
+}
+
+func JoinTableIndexName(tableName string) string {
+	return "i" + GetMD5HashString("index_join_"+tableName+"_"+"_unique")
+}
+
 func GetExistingIndexes(db *sqlx.Tx) map[string]bool {
 
 	existingIndexes := make(map[string]bool)
@@ -141,9 +161,17 @@ WHERE
 		return nil
 	}
 
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
 	rows, err := stmt1.Queryx()
 	CheckErr(err, "Failed to check existing indexes")
 	if err == nil {
+		defer rows.Close()
 		for rows.Next() {
 			var indexName string
 			err = rows.Scan(&indexName)
@@ -765,7 +793,8 @@ func MakeCreateTableQuery(tableInfo *TableInfo, sqlDriverName string) string {
 	return createTableQuery
 }
 
-func getColumnLine(c *api2go.ColumnInfo, sqlDriverName string) string {
+// getColumnDataType is the type the column is created with in the database
+func getColumnDataType(c *api2go.ColumnInfo, sqlDriverName string) string {
 
 	datatype := c.DataType
 
@@ -790,9 +819,21 @@ func getColumnLine(c *api2go.ColumnInfo, sqlDriverName string) string {
 		datatype = "bytea"
 	}
 
+	return datatype
+}
+
+// isColumnNullable tells if the column is created as null, timestamps without a default are always nullable
+func isColumnNullable(c *api2go.ColumnInfo, sqlDriverName string) bool {
+	return c.IsNullable || (getColumnDataType(c, sqlDriverName) == "timestamp" && c.DefaultValue == "")
+}
+
+func getColumnLine(c *api2go.ColumnInfo, sqlDriverName string) string {
+
+	datatype := getColumnDataType(c, sqlDriverName)
+
 	columnParams := []string{c.ColumnName, datatype}
 
-	if datatype == "timestamp" && c.DefaultValue == "" {
+	if isColumnNullable(c, sqlDriverName) {
 		c.IsNullable = true
 	}
 
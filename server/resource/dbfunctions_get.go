@@ -2,6 +2,7 @@ package resource
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/statementbuilder"
@@ -152,6 +153,9 @@ type SubSite struct {
 	UserId       *int64 `db:"user_account_id"`
 	ReferenceId  string `db:"reference_id"`
 	Enable       bool   `db:"enable"`
+	// SyncMode and ConflictPolicy of the site cache folder, see AssetFolderCache
+	SyncMode       string `db:"sync_mode"`
+	ConflictPolicy string `db:"conflict_policy"`
 }
 
 type CloudStore struct {
@@ -169,6 +173,8 @@ type CloudStore struct {
 	DeletedAt       *time.Time
 	ReferenceId     string
 	Permission      PermissionInstance
+	SyncMode        string
+	ConflictPolicy  string
 }
 
 func (resource *DbResource) GetAllCloudStores() ([]CloudStore, error) {
@@ -231,6 +237,8 @@ func (resource *DbResource) GetAllCloudStores() ([]CloudStore, error) {
 		cloudStore.StoreProvider = storeMap["store_provider"].(string)
 		cloudStore.StoreType = storeMap["store_type"].(string)
 		cloudStore.RootPath = storeMap["root_path"].(string)
+		cloudStore.SyncMode, _ = storeMap["sync_mode"].(string)
+		cloudStore.ConflictPolicy, _ = storeMap["conflict_policy"].(string)
 
 		version, ok := storeMap["version"].(int64)
 		if !ok {
@@ -318,6 +326,8 @@ func (resource *DbResource) GetCloudStoreByName(name string) (CloudStore, error)
 		if row["oauth_token_id"] != nil {
 			cloudStore.OAutoTokenId = row["oauth_token_id"].(string)
 		}
+		cloudStore.SyncMode, _ = row["sync_mode"].(string)
+		cloudStore.ConflictPolicy, _ = row["conflict_policy"].(string)
 	}
 
 	return cloudStore, nil
@@ -344,20 +354,49 @@ func (resource *DbResource) GetCloudStoreByReferenceId(referenceID string) (Clou
 		if row["oauth_token_id"] != nil {
 			cloudStore.OAutoTokenId = row["oauth_token_id"].(string)
 		}
+		cloudStore.SyncMode, _ = row["sync_mode"].(string)
+		cloudStore.ConflictPolicy, _ = row["conflict_policy"].(string)
 	}
 
 	return cloudStore, nil
 
 }
 
+// taskSelectQuery selects the columns of the task table scanned by scanTask
+// along with the email of the user the task runs as
+func taskSelectQuery() *goqu.SelectDataset {
+	return statementbuilder.Squirrel.Select(goqu.I("t.id"), goqu.I("t.reference_id"), goqu.I("t.name"),
+		goqu.I("t.action_name"), goqu.I("t.entity_name"), goqu.I("t.schedule"),
+		goqu.I("t.active"), goqu.I("t.attributes"), goqu.I("u.email"), goqu.I("t.concurrency_policy")).
+		From(goqu.T("task").As("t")).
+		LeftJoin(goqu.T(USER_ACCOUNT_TABLE_NAME).As("u"), goqu.On(goqu.Ex{
+			"u.id": goqu.I("t.as_user_id"),
+		}))
+}
+
+func scanTask(rows *sqlx.Rows) (Task, error) {
+	var task Task
+	var asUserEmail, concurrencyPolicy *string
+	err := rows.Scan(&task.Id, &task.ReferenceId, &task.Name, &task.ActionName, &task.EntityName, &task.Schedule,
+		&task.Active, &task.AttributesJson, &asUserEmail, &concurrencyPolicy)
+	if err != nil {
+		return task, err
+	}
+	if asUserEmail != nil {
+		task.AsUserEmail = *asUserEmail
+	}
+	if concurrencyPolicy != nil {
+		task.ConcurrencyPolicy = *concurrencyPolicy
+	}
+	err = json.Unmarshal([]byte(task.AttributesJson), &task.Attributes)
+	return task, err
+}
+
 func (resource *DbResource) GetAllTasks() ([]Task, error) {
 
 	var tasks []Task
 
-	s, v, err := statementbuilder.Squirrel.Select(goqu.I("t.name"),
-		goqu.I("t.action_name"), goqu.I("t.entity_name"), goqu.I("t.schedule"),
-		goqu.I("t.active"), goqu.I("t.attributes"), goqu.I("t.as_user_id")).
-		From(goqu.T("task").As("t")).ToSQL()
+	s, v, err := taskSelectQuery().ToSQL()
 	if err != nil {
 		return tasks, err
 	}
@@ -387,16 +426,11 @@ func (resource *DbResource) GetAllTasks() ([]Task, error) {
 	}(rows)
 
 	for rows.Next() {
-		var task Task
-		err = rows.Scan(&task.Name, &task.ActionName, &task.EntityName, &task.Schedule, &task.Active, &task.AttributesJson, &task.AsUserEmail)
+		task, err := scanTask(rows)
 		if err != nil {
 			log.Errorf("failed to scan task from db to struct: %v", err)
 			continue
 		}
-		err = json.Unmarshal([]byte(task.AttributesJson), &task.Attributes)
-		if CheckErr(err, "failed to unmarshal attributes for task") {
-			continue
-		}
 		tasks = append(tasks, task)
 	}
 
@@ -404,6 +438,45 @@ func (resource *DbResource) GetAllTasks() ([]Task, error) {
 
 }
 
+func (resource *DbResource) GetTaskByReferenceId(referenceId string) (Task, error) {
+
+	var task Task
+
+	s, v, err := taskSelectQuery().Where(goqu.Ex{"t.reference_id": referenceId}).ToSQL()
+	if err != nil {
+		return task, err
+	}
+
+	stmt1, err := resource.connection.Preparex(s)
+	if err != nil {
+		log.Errorf("[410] failed to prepare statment: %v", err)
+		return task, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(v...)
+	if err != nil {
+		return task, err
+	}
+	defer func(rows *sqlx.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Errorf("[427] failed to close result after value scan in defer")
+		}
+	}(rows)
+
+	if !rows.Next() {
+		return task, errors.New("no such task")
+	}
+
+	return scanTask(rows)
+}
+
 func (resource *DbResource) GetAllSites() ([]SubSite, error) {
 
 	var sites []SubSite
@@ -413,7 +486,9 @@ func (resource *DbResource) GetAllSites() ([]SubSite, error) {
 		goqu.I("s.cloud_store_id"),
 		goqu.I("s."+USER_ACCOUNT_ID_COLUMN), goqu.I("s.path"),
 		goqu.I("s.reference_id"), goqu.I("s.id"), goqu.I("s.enable"),
-		goqu.I("s.site_type"), goqu.I("s.ftp_enabled")).
+		goqu.I("s.site_type"), goqu.I("s.ftp_enabled"),
+		goqu.COALESCE(goqu.I("s.sync_mode"), SyncModePull).As("sync_mode"),
+		goqu.COALESCE(goqu.I("s.conflict_policy"), ConflictPolicyNewestWins).As("conflict_policy")).
 		From(goqu.T("site").As("s")).ToSQL()
 	if err != nil {
 		return sites, err
@@ -105,12 +105,13 @@ func UpdateTasksData(initConfig *CmsConfig, db database.DatabaseConnection) erro
 
 			s, v, err = statementbuilder.Squirrel.Update("task").
 				Set(goqu.Record{
-					"active":      newTask.Active,
-					"schedule":    newTask.Schedule,
-					"attributes":  toJson(newTask.Attributes),
-					"action_name": newTask.ActionName,
-					"entity_name": newTask.EntityName,
-				}).ToSQL()
+					"active":             newTask.Active,
+					"schedule":           newTask.Schedule,
+					"attributes":         toJson(newTask.Attributes),
+					"action_name":        newTask.ActionName,
+					"entity_name":        newTask.EntityName,
+					"concurrency_policy": newTask.ConcurrencyPolicy,
+				}).Where(goqu.Ex{"name": newTask.Name}).ToSQL()
 
 		} else {
 
@@ -121,9 +122,9 @@ func UpdateTasksData(initConfig *CmsConfig, db database.DatabaseConnection) erro
 			refId := uuidRef.String()
 			s, v, err = statementbuilder.Squirrel.Insert("task").
 				Cols("name", "schedule", "active",
-					"action_name", "entity_name", "reference_id", "attributes", "created_at").
+					"action_name", "entity_name", "reference_id", "attributes", "concurrency_policy", "created_at").
 				Vals([]interface{}{newTask.Name, newTask.Schedule, newTask.Active,
-					newTask.ActionName, newTask.EntityName, refId, toJson(newTask.Attributes), time.Now()}).ToSQL()
+					newTask.ActionName, newTask.EntityName, refId, toJson(newTask.Attributes), newTask.ConcurrencyPolicy, time.Now()}).ToSQL()
 
 		}
 
@@ -374,6 +375,8 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 			CheckErr(err, "Failed to marshal target attrs to json")
 			attrsJson, err := json.Marshal(exchange.Attributes)
 			CheckErr(err, "Failed to marshal target attrs to json")
+			retryPolicyJson, err := json.Marshal(exchange.RetryPolicy)
+			CheckErr(err, "Failed to marshal retry policy to json")
 
 			s, v, err = statementbuilder.Squirrel.
 				Update("data_exchange").
@@ -384,6 +387,7 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 					"attributes":           attrsJson,
 					"target_type":          exchange.TargetType,
 					"options":              optionsJson,
+					"retry_policy":         retryPolicyJson,
 					"updated_at":           time.Now(),
 					USER_ACCOUNT_ID_COLUMN: adminId,
 				}).
@@ -407,17 +411,20 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 
 			targetAttrsJson, err := json.Marshal(exchange.TargetAttributes)
 			CheckErr(err, "Failed to marshal target attributes to json")
+
+			retryPolicyJson, err := json.Marshal(exchange.RetryPolicy)
+			CheckErr(err, "Failed to marshal retry policy to json")
 			u, _ := uuid.NewV4()
 
 			s, v, err = statementbuilder.Squirrel.
 				Insert("data_exchange").
 				Cols("permission", "name", "source_attributes",
 					"source_type", "target_attributes", "target_type", "attributes",
-					"options", "created_at", USER_ACCOUNT_ID_COLUMN, "reference_id").
+					"options", "retry_policy", "created_at", USER_ACCOUNT_ID_COLUMN, "reference_id").
 				Vals([]interface{}{
 					auth.DEFAULT_PERMISSION, exchange.Name,
 					sourceAttrsJson, exchange.SourceType, targetAttrsJson,
-					exchange.TargetType, attrsJson, optionsJson,
+					exchange.TargetType, attrsJson, optionsJson, retryPolicyJson,
 					time.Now(), adminId, u.String()}).
 				ToSQL()
 
@@ -434,7 +441,7 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 	s, v, err := statementbuilder.Squirrel.Select(
 		"name", "source_attributes",
 		"source_type", "target_attributes", "attributes",
-		"target_type", "options", "as_user_id").
+		"target_type", "options", "as_user_id", "retry_policy", "reference_id").
 		From("data_exchange").ToSQL()
 
 	stmt1, err := db.Preparex(s)
@@ -460,12 +467,12 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 	if err == nil {
 		for rows.Next() {
 
-			var name, source_type, target_type string
-			var source_attributes, target_attributes, options, attrsJson []byte
+			var name, source_type, target_type, reference_id string
+			var source_attributes, target_attributes, options, attrsJson, retry_policy []byte
 			var user_account_id *int64
 
 			var ec ExchangeContract
-			err = rows.Scan(&name, &source_attributes, &source_type, &target_attributes, &attrsJson, &target_type, &options, &user_account_id)
+			err = rows.Scan(&name, &source_attributes, &source_type, &target_attributes, &attrsJson, &target_type, &options, &user_account_id, &retry_policy, &reference_id)
 			CheckErr(err, "[433] Failed to Scan existing exchange contract")
 			if user_account_id == nil {
 				log.Errorf("as_user_id is not set for data exchange setup [%v], skipping", name)
@@ -494,6 +501,12 @@ func UpdateExchanges(initConfig *CmsConfig, db database.DatabaseConnection) {
 			err = json.Unmarshal(options, &ec.Options)
 			CheckErr(err, "Failed to unmarshal exchange options")
 
+			if len(retry_policy) > 0 {
+				err = json.Unmarshal(retry_policy, &ec.RetryPolicy)
+				CheckErr(err, "Failed to unmarshal exchange retry policy")
+			}
+			ec.ReferenceId = reference_id
+
 			ec.AsUserId = *user_account_id
 
 			allExchanges = append(allExchanges, ec)
@@ -726,7 +726,7 @@ func (dbResource *DbResource) BecomeAdmin(userId int64) bool {
 	query, args, err = statementbuilder.Squirrel.Update("action").
 		Set(goqu.Record{"permission": int64(auth.GuestPeek | auth.GuestExecute | auth.UserRead | auth.UserExecute | auth.GroupRead | auth.GroupExecute)}).
 		Where(goqu.Ex{
-			"action_name": "signin",
+			"action_name": []string{"signin", "refresh_token"},
 		}).
 		ToSQL()
 	if err != nil {
@@ -742,6 +742,12 @@ func (dbResource *DbResource) BecomeAdmin(userId int64) bool {
 }
 
 func (dr *DbResource) GetRowPermission(row map[string]interface{}) PermissionInstance {
+	return dr.getRowPermission(row, nil)
+}
+
+// getRowPermission uses the groups in loadedGroups, by the reference id of the row, when they were loaded for many
+// rows at once
+func (dr *DbResource) getRowPermission(row map[string]interface{}, loadedGroups map[string][]auth.GroupPermission) PermissionInstance {
 
 	refId, ok := row["reference_id"]
 	if !ok {
@@ -782,7 +788,11 @@ func (dr *DbResource) GetRowPermission(row map[string]interface{}) PermissionIns
 
 	if loc == -1 && dr.Cruds[rowType].model.HasMany("usergroup") {
 
-		perm.UserGroupId = dr.GetObjectUserGroupsByWhere(rowType, "reference_id", refId.(string))
+		if rowGroups, ok := loadedGroups[refId.(string)]; ok {
+			perm.UserGroupId = rowGroups
+		} else {
+			perm.UserGroupId = dr.GetObjectUserGroupsByWhere(rowType, "reference_id", refId.(string))
+		}
 
 	} else if rowType == "usergroup" {
 		originalGroupId, _ := row["reference_id"]
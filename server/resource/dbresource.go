@@ -32,17 +32,23 @@ type DbResource struct {
 	configStore        *ConfigStore
 	contextCache       map[string]interface{}
 	defaultGroups      []int64
-	contextLock        sync.RWMutex
+	// contextLock guards contextCache, shared with the resources of the transactions made from this one
+	contextLock        *sync.RWMutex
 	OlricDb            *olric.Olric
 	AssetFolderCache   map[string]map[string]*AssetFolderCache
-	SubsiteFolderCache map[string]*AssetFolderCache
+	SubsiteFolderCache *SiteFolderCache
 	MailSender         func(e *mail.Envelope, task backends.SelectTask) (backends.Result, error)
+	transaction        *ResourceTransaction
 }
 
 type AssetFolderCache struct {
 	LocalSyncPath string
 	Keyname       string
 	CloudStore    CloudStore
+	// SyncMode is SyncModePull or SyncModeBidirectional, ConflictPolicy resolves files changed on both sides
+	SyncMode       string
+	ConflictPolicy string
+	syncState      folderSyncState
 }
 
 func (afc *AssetFolderCache) GetFileByName(fileName string) (*os.File, error) {
@@ -52,7 +58,12 @@ func (afc *AssetFolderCache) GetFileByName(fileName string) (*os.File, error) {
 }
 func (afc *AssetFolderCache) DeleteFileByName(fileName string) error {
 
-	return os.Remove(afc.LocalSyncPath + string(os.PathSeparator) + fileName)
+	filePath := afc.LocalSyncPath + string(os.PathSeparator) + fileName
+	err := os.Remove(filePath)
+	if err == nil {
+		afc.RecordChange(filePath, LocalChangeDelete)
+	}
+	return err
 
 }
 
@@ -124,6 +135,7 @@ func (afc *AssetFolderCache) UploadFiles(files []interface{}) error {
 				if err != nil {
 					return errors.WithMessage(err, "Failed to write data to local file store ")
 				}
+				afc.RecordChange(localFilePath, LocalChangeWrite)
 			}
 		}
 	}
@@ -151,9 +163,9 @@ func NewDbResource(model *api2go.Api2GoModel, db database.DatabaseConnection,
 		OlricDb:            olricDb,
 		defaultGroups:      GroupNamesToIds(db, tableInfo.DefaultGroups),
 		contextCache:       make(map[string]interface{}),
-		contextLock:        sync.RWMutex{},
+		contextLock:        &sync.RWMutex{},
 		AssetFolderCache:   make(map[string]map[string]*AssetFolderCache),
-		SubsiteFolderCache: make(map[string]*AssetFolderCache),
+		SubsiteFolderCache: NewSiteFolderCache(),
 	}
 }
 func GroupNamesToIds(db database.DatabaseConnection, groupsName []string) []int64 {
@@ -512,13 +524,19 @@ func (dr *DbResource) UpdateMailFlags(mailBoxId int64, mailId int64, newFlags []
 		seen = true
 	}
 
+	modSequence, err := dr.NextMailBoxModSequence(mailBoxId)
+	if err != nil {
+		return err
+	}
+
 	query, args, err := statementbuilder.Squirrel.
 		Update("mail").
 		Set(goqu.Record{
-			"flags":   strings.Join(newFlags, ","),
-			"seen":    seen,
-			"recent":  recent,
-			"deleted": deleted,
+			"flags":        strings.Join(newFlags, ","),
+			"seen":         seen,
+			"recent":       recent,
+			"deleted":      deleted,
+			"mod_sequence": modSequence,
 		}).
 		Where(goqu.Ex{
 			"mail_box_id": mailBoxId,
@@ -570,10 +588,21 @@ func (dr *DbResource) ExpungeMailBox(mailBoxId int64) (int64, error) {
 	}
 	rows.Close()
 
+	return dr.DeleteMails(ids)
+}
+
+// DeleteMails removes mails with their search terms and usergroup relations
+func (dr *DbResource) DeleteMails(ids []interface{}) (int64, error) {
+
 	if len(ids) < 1 {
 		return 0, nil
 	}
 
+	err := dr.DeleteMailSearchTerms(ids)
+	if err != nil {
+		return 0, err
+	}
+
 	query, args, err := statementbuilder.Squirrel.Delete("mail_mail_id_has_usergroup_usergroup_id").Where(goqu.Ex{
 		"mail_id": ids,
 	}).ToSQL()
@@ -1,9 +1,12 @@
 package resource
 
 import (
+	"fmt"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
 	//"bytes"
 	"bytes"
 )
@@ -36,6 +39,74 @@ type ExchangeContract struct {
 	Options          map[string]interface{}
 	ReferenceId      string `db:"reference_id"`
 	AsUserId         int64
+	RetryPolicy      ExchangeRetryPolicy `db:"retry_policy"`
+}
+
+// ExchangeRetryPolicy decides how many times a failed exchange target is tried again and how long to
+// wait between the attempts. The wait starts at BackoffSeconds and is multiplied by BackoffMultiplier
+// after every attempt, up to MaxBackoffSeconds. Errors returned by the target are always retried, http
+// responses only if their status code is one of RetryableStatusCodes.
+// Rows which still fail after MaxAttempts are saved in the exchange_failure table
+type ExchangeRetryPolicy struct {
+	MaxAttempts          int
+	BackoffSeconds       float64
+	BackoffMultiplier    float64
+	MaxBackoffSeconds    float64
+	RetryableStatusCodes []int
+}
+
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// Attempts is the total number of tries including the first one, at least 1
+func (rp ExchangeRetryPolicy) Attempts() int {
+	if rp.MaxAttempts < 1 {
+		return 1
+	}
+	return rp.MaxAttempts
+}
+
+// Backoff is the time to wait after the attempt number `attempt` (starting from 1) has failed
+func (rp ExchangeRetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := rp.BackoffSeconds
+	if backoff <= 0 {
+		backoff = 1
+	}
+	multiplier := rp.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	backoff = backoff * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoffSeconds > 0 && backoff > rp.MaxBackoffSeconds {
+		backoff = rp.MaxBackoffSeconds
+	}
+	return time.Duration(backoff * float64(time.Second))
+}
+
+func (rp ExchangeRetryPolicy) IsRetryable(err error) bool {
+	statusErr, ok := err.(*ExchangeStatusError)
+	if !ok {
+		return true
+	}
+	statusCodes := rp.RetryableStatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	for _, code := range statusCodes {
+		if code == statusErr.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// ExchangeStatusError is returned by the rest targets when the remote responds with an error status
+type ExchangeStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (ese *ExchangeStatusError) Error() string {
+	return fmt.Sprintf("target responded with status %d: %s", ese.StatusCode, ese.Body)
 }
 
 var objectSuffix = []byte("{")
@@ -56,26 +127,17 @@ func (c *ColumnMapping) UnmarshalJSON(payload []byte) error {
 
 type ExchangeExecution struct {
 	ExchangeContract ExchangeContract
-	cruds            *map[string]*DbResource
+	// Method is the http method of the request which changed the rows, or the stored event type when a failure
+	// is replayed
+	Method string
+	cruds  *map[string]*DbResource
 }
 
 func (ec *ExchangeExecution) Execute(data []map[string]interface{}) (result map[string]interface{}, err error) {
 
-	var handler ExternalExchange
-
-	switch ec.ExchangeContract.TargetType {
-	case "action":
-		handler = NewActionExchangeHandler(ec.ExchangeContract, *ec.cruds)
-		break
-	case "rest":
-		handler, err = NewRestExchangeHandler(ec.ExchangeContract)
-		if err != nil {
-			return nil, err
-		}
-		break
-	default:
-		log.Errorf("exchange contract: target: 'self' is not yet implemented")
-		return nil, errors.New("unknown target in exchange, not yet implemented")
+	handler, err := ec.Handler()
+	if err != nil {
+		return nil, err
 	}
 
 	//targetAttrs := ec.ExchangeContract.TargetAttributes
@@ -85,15 +147,54 @@ func (ec *ExchangeExecution) Execute(data []map[string]interface{}) (result map[
 	//}
 
 	for _, row := range data {
-		result, err = handler.ExecuteTarget(row)
+		var attempts int
+		result, attempts, err = ec.ExecuteWithRetry(handler, row)
 		if err != nil {
-			log.Errorf("Failed to execute target for [%v]: %v", row["__type"], err)
+			log.Errorf("Failed to execute target for [%v] after %d attempts: %v", row["__type"], attempts, err)
+			ec.SaveFailure(row, attempts, err)
 		}
 	}
 
 	return result, err
 }
 
+// Handler creates the external exchange for the target type of the contract
+func (ec *ExchangeExecution) Handler() (ExternalExchange, error) {
+	switch ec.ExchangeContract.TargetType {
+	case "action":
+		return NewActionExchangeHandler(ec.ExchangeContract, *ec.cruds), nil
+	case "rest":
+		return NewRestExchangeHandler(ec.ExchangeContract)
+	case "webhook":
+		return NewWebhookExchangeHandler(ec.ExchangeContract, *ec.cruds, ec.Method), nil
+	default:
+		log.Errorf("exchange contract: target: 'self' is not yet implemented")
+		return nil, errors.New("unknown target in exchange, not yet implemented")
+	}
+}
+
+// ExecuteWithRetry executes the target for one row, trying again as per the retry policy of the contract.
+// Returns the number of attempts made along with the result of the last attempt
+func (ec *ExchangeExecution) ExecuteWithRetry(handler ExternalExchange, row map[string]interface{}) (map[string]interface{}, int, error) {
+
+	policy := ec.ExchangeContract.RetryPolicy
+	var result map[string]interface{}
+	var err error
+
+	attempt := 1
+	for ; ; attempt++ {
+		result, err = handler.ExecuteTarget(row)
+		if err == nil || attempt >= policy.Attempts() || !policy.IsRetryable(err) {
+			break
+		}
+		backoff := policy.Backoff(attempt)
+		log.Warnf("Exchange [%v] attempt %d failed, retrying in %v: %v", ec.ExchangeContract.Name, attempt, backoff, err)
+		time.Sleep(backoff)
+	}
+
+	return result, attempt, err
+}
+
 func NewExchangeExecution(exchange ExchangeContract, cruds *map[string]*DbResource) *ExchangeExecution {
 
 	return &ExchangeExecution{
@@ -46,17 +46,51 @@ func (g *ActionExchangeHandler) ExecuteTarget(row map[string]interface{}) (map[s
 		},
 	}
 
-	userRow, _, err := g.cruds[USER_ACCOUNT_TABLE_NAME].GetSingleRowById(USER_ACCOUNT_TABLE_NAME, g.exchangeContract.AsUserId, nil)
+	sessionUser, err := g.cruds[USER_ACCOUNT_TABLE_NAME].ExchangeSessionUser(g.exchangeContract.AsUserId)
 	if err != nil {
-		return nil, errors.New("user account not found to execute data exchange with action")
+		return nil, err
+	}
+
+	req.PlainRequest = req.PlainRequest.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+
+	request.Attributes["subject"] = row
+	request.Attributes[tableName+"_id"] = row["reference_id"]
+	response, err := g.cruds[tableName].HandleActionRequest(request, req)
+
+	log.Printf("Response from action exchange execution: %v", response)
+	CheckErr(err, "Error from action exchange execution: %v")
+
+	res := make(map[string]interface{})
+	for _, r := range response {
+		res[fmt.Sprintf("%v", r.ResponseType)] = r.Attributes
+	}
+
+	return res, err
+}
+
+func NewActionExchangeHandler(exchangeContract ExchangeContract, cruds map[string]*DbResource) ExternalExchange {
+
+	return &ActionExchangeHandler{
+		exchangeContract: exchangeContract,
+		cruds:            cruds,
+	}
+}
+
+// ExchangeSessionUser is the user a data exchange runs as, with the groups it belongs to
+func (dr *DbResource) ExchangeSessionUser(userId int64) (*auth.SessionUser, error) {
+
+	userRow, _, err := dr.GetSingleRowById(USER_ACCOUNT_TABLE_NAME, userId, nil)
+	if err != nil {
+		return nil, errors.New("user account not found to execute data exchange")
 	}
 	userReferenceId := userRow["reference_id"].(string)
 
-	query, args1, err := auth.UserGroupSelectQuery.Where(goqu.Ex{"uug.user_account_id": g.exchangeContract.AsUserId}).ToSQL()
+	query, args1, err := auth.UserGroupSelectQuery.Where(goqu.Ex{"uug.user_account_id": userId}).ToSQL()
 
-	stmt1, err := g.cruds[USER_ACCOUNT_TABLE_NAME].connection.Preparex(query)
+	stmt1, err := dr.connection.Preparex(query)
 	if err != nil {
 		log.Errorf("[59] failed to prepare statment: %v", err)
+		return nil, err
 	}
 
 	defer func(stmt1 *sqlx.Stmt) {
@@ -73,8 +107,6 @@ func (g *ActionExchangeHandler) ExecuteTarget(row map[string]interface{}) (map[s
 		log.Errorf("Failed to get user group permissions: %v", err)
 	} else {
 		defer rows.Close()
-		//cols, _ := rows.Columns()
-		//log.Printf("Columns: %v", cols)
 		for rows.Next() {
 			var p auth.GroupPermission
 			err = rows.StructScan(&p)
@@ -88,33 +120,9 @@ func (g *ActionExchangeHandler) ExecuteTarget(row map[string]interface{}) (map[s
 
 	}
 
-	sessionUser := auth.SessionUser{
-		UserId:          g.exchangeContract.AsUserId,
+	return &auth.SessionUser{
+		UserId:          userId,
 		UserReferenceId: userReferenceId,
 		Groups:          userGroups,
-	}
-
-	req.PlainRequest = req.PlainRequest.WithContext(context.WithValue(context.Background(), "user", &sessionUser))
-
-	request.Attributes["subject"] = row
-	request.Attributes[tableName+"_id"] = row["reference_id"]
-	response, err := g.cruds[tableName].HandleActionRequest(request, req)
-
-	log.Printf("Response from action exchange execution: %v", response)
-	CheckErr(err, "Error from action exchange execution: %v")
-
-	res := make(map[string]interface{})
-	for _, r := range response {
-		res[fmt.Sprintf("%v", r.ResponseType)] = r.Attributes
-	}
-
-	return res, err
-}
-
-func NewActionExchangeHandler(exchangeContract ExchangeContract, cruds map[string]*DbResource) ExternalExchange {
-
-	return &ActionExchangeHandler{
-		exchangeContract: exchangeContract,
-		cruds:            cruds,
-	}
+	}, nil
 }
@@ -0,0 +1,99 @@
+package resource
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const EXCHANGE_FAILURE_TABLE_NAME = "exchange_failure"
+
+const (
+	ExchangeFailureStatusFailed    = "failed"
+	ExchangeFailureStatusReplayed  = "replayed"
+	ExchangeFailureStatusDiscarded = "discarded"
+)
+
+// SaveFailure stores a row which could not be delivered to the target in the exchange_failure table,
+// from where it can be replayed or discarded later
+func (ec *ExchangeExecution) SaveFailure(row map[string]interface{}, attempts int, failure error) {
+	SaveExchangeFailure(*ec.cruds, ec.ExchangeContract, WebhookEventType(ec.Method), "", row, attempts, failure)
+}
+
+// SaveExchangeFailure adds the exchange_failure row for a row the exchange gave up on. The event type which
+// changed the row is kept for the replay, and webhookReferenceId is the webhook a failed delivery was meant
+// for, so the replay only goes to that webhook
+func SaveExchangeFailure(cruds map[string]*DbResource, exchangeContract ExchangeContract, eventType string,
+	webhookReferenceId string, row map[string]interface{}, attempts int, failure error) {
+
+	crud, ok := cruds[EXCHANGE_FAILURE_TABLE_NAME]
+	if !ok {
+		log.Errorf("No %v table to save failed exchange [%v]", EXCHANGE_FAILURE_TABLE_NAME, exchangeContract.Name)
+		return
+	}
+
+	payload, err := json.Marshal(row)
+	if err != nil {
+		log.Errorf("Failed to marshal payload of failed exchange [%v]: %v", exchangeContract.Name, err)
+		return
+	}
+
+	statusCode := 0
+	if statusErr, ok := failure.(*ExchangeStatusError); ok {
+		statusCode = statusErr.StatusCode
+	}
+
+	httpRequest := &http.Request{
+		Method: "POST",
+	}
+	httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), "user", &auth.SessionUser{
+		UserId: exchangeContract.AsUserId,
+	}))
+
+	_, err = crud.CreateWithoutFilter(api2go.NewApi2GoModelWithData(EXCHANGE_FAILURE_TABLE_NAME, nil, 0, nil, map[string]interface{}{
+		"exchange_name":        exchangeContract.Name,
+		"event_type":           eventType,
+		"webhook_reference_id": webhookReferenceId,
+		"payload":              string(payload),
+		"error":                failure.Error(),
+		"attempt_count":        attempts,
+		"last_status_code":     statusCode,
+		"status":               ExchangeFailureStatusFailed,
+		"last_attempt_at":      time.Now(),
+	}), api2go.Request{
+		PlainRequest: httpRequest,
+	})
+	CheckErr(err, "Failed to save failed exchange [%v]", exchangeContract.Name)
+}
+
+// UpdateExchangeFailureStatus records the outcome of a replay or discard on an exchange_failure row
+func (dr *DbResource) UpdateExchangeFailureStatus(referenceId string, status string, attempts int64, failure error) error {
+
+	record := goqu.Record{
+		"status":          status,
+		"attempt_count":   attempts,
+		"last_attempt_at": time.Now(),
+		"updated_at":      time.Now(),
+	}
+	if failure != nil {
+		record["error"] = failure.Error()
+		if statusErr, ok := failure.(*ExchangeStatusError); ok {
+			record["last_status_code"] = statusErr.StatusCode
+		}
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update(EXCHANGE_FAILURE_TABLE_NAME).
+		Set(record).
+		Where(goqu.Ex{"reference_id": referenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
@@ -146,6 +146,17 @@ func (g *RestExternalExchange) ExecuteTarget(row map[string]interface{}) (map[st
 
 	res := make(map[string]interface{})
 	res["headers"] = response.Header()
+	res["status"] = response.StatusCode()
+	if err == nil && response.StatusCode() >= 400 {
+		body := response.String()
+		if len(body) > 500 {
+			body = body[:500]
+		}
+		err = &ExchangeStatusError{
+			StatusCode: response.StatusCode(),
+			Body:       body,
+		}
+	}
 	if err != nil {
 		bodyBytes, err := ioutil.ReadAll(response.RawBody())
 		if err == nil {
@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingExchange struct {
+	failures int
+	calls    int
+	err      error
+}
+
+func (fe *failingExchange) ExecuteTarget(row map[string]interface{}) (map[string]interface{}, error) {
+	fe.calls += 1
+	if fe.calls <= fe.failures {
+		return nil, fe.err
+	}
+	return map[string]interface{}{"ok": true}, nil
+}
+
+func TestExchangeRetryPolicyBackoff(t *testing.T) {
+
+	policy := ExchangeRetryPolicy{
+		BackoffSeconds:    1,
+		BackoffMultiplier: 3,
+		MaxBackoffSeconds: 5,
+	}
+
+	if policy.Backoff(1) != time.Second {
+		t.Errorf("expected first backoff of 1s, got %v", policy.Backoff(1))
+	}
+	if policy.Backoff(2) != 3*time.Second {
+		t.Errorf("expected second backoff of 3s, got %v", policy.Backoff(2))
+	}
+	if policy.Backoff(3) != 5*time.Second {
+		t.Errorf("expected backoff to be capped at 5s, got %v", policy.Backoff(3))
+	}
+	if (ExchangeRetryPolicy{}).Attempts() != 1 {
+		t.Errorf("expected a single attempt without a retry policy")
+	}
+}
+
+func TestExchangeRetryPolicyIsRetryable(t *testing.T) {
+
+	policy := ExchangeRetryPolicy{}
+	if !policy.IsRetryable(errors.New("connection refused")) {
+		t.Errorf("expected errors without a status code to be retried")
+	}
+	if !policy.IsRetryable(&ExchangeStatusError{StatusCode: 503}) {
+		t.Errorf("expected 503 to be retried by default")
+	}
+	if policy.IsRetryable(&ExchangeStatusError{StatusCode: 400}) {
+		t.Errorf("expected 400 to not be retried by default")
+	}
+
+	policy.RetryableStatusCodes = []int{400}
+	if !policy.IsRetryable(&ExchangeStatusError{StatusCode: 400}) {
+		t.Errorf("expected 400 to be retried when listed")
+	}
+}
+
+func TestExchangeExecuteWithRetry(t *testing.T) {
+
+	execution := NewExchangeExecution(ExchangeContract{
+		Name: "test",
+		RetryPolicy: ExchangeRetryPolicy{
+			MaxAttempts:    3,
+			BackoffSeconds: 0.001,
+		},
+	}, nil)
+
+	handler := &failingExchange{failures: 2, err: errors.New("unavailable")}
+	result, attempts, err := execution.ExecuteWithRetry(handler, map[string]interface{}{})
+	if err != nil || result == nil {
+		t.Errorf("expected the third attempt to succeed: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	handler = &failingExchange{failures: 5, err: &ExchangeStatusError{StatusCode: 404}}
+	_, attempts, err = execution.ExecuteWithRetry(handler, map[string]interface{}{})
+	if err == nil {
+		t.Errorf("expected an error for a 404 response")
+	}
+	if attempts != 1 {
+		t.Errorf("expected a 404 response to not be retried, got %d attempts", attempts)
+	}
+}
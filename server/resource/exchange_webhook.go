@@ -0,0 +1,401 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const WEBHOOK_TABLE_NAME = "webhook"
+const WEBHOOK_DELIVERY_TABLE_NAME = "webhook_delivery"
+
+const (
+	WebhookDeliveryStatusDelivered = "delivered"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+const (
+	WebhookDeliveryHeader  = "X-Daptin-Delivery"
+	WebhookEventHeader     = "X-Daptin-Event"
+	WebhookTimestampHeader = "X-Daptin-Timestamp"
+	WebhookSignatureHeader = "X-Daptin-Signature"
+)
+
+// webhookResponseExcerptLength is the number of bytes of the response body kept in the delivery log
+const webhookResponseExcerptLength = 1024
+
+var webhookHttpClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// WebhookSubscription is a row of the webhook table. EventTypes and TableFilter are comma separated lists,
+// an empty list or * matches everything
+type WebhookSubscription struct {
+	ReferenceId string
+	Name        string
+	Url         string
+	Secret      string
+	EventTypes  []string
+	TableFilter []string
+}
+
+func (ws WebhookSubscription) Matches(eventType string, tableName string) bool {
+	return matchesWebhookFilter(ws.EventTypes, eventType) && matchesWebhookFilter(ws.TableFilter, tableName)
+}
+
+func matchesWebhookFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, item := range filter {
+		if item == "*" || item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func splitWebhookFilter(value interface{}) []string {
+	items := make([]string, 0)
+	if value == nil {
+		return items
+	}
+	for _, item := range strings.Split(fmt.Sprintf("%v", value), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// WebhookEventType names the event for the http method of the request which changed the row
+func WebhookEventType(method string) string {
+	switch strings.ToLower(method) {
+	case "post":
+		return "create"
+	case "put", "patch":
+		return "update"
+	case "delete":
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+// SignWebhookPayload is the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret, sent
+// as "sha256=<signature>" in the X-Daptin-Signature header
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDeliveryAttempt is the outcome of one http call to a webhook, saved in webhook_delivery
+type WebhookDeliveryAttempt struct {
+	StatusCode      int
+	LatencyMs       int64
+	ResponseExcerpt string
+	Err             error
+}
+
+// WebhookExternalExchange delivers the changed rows to every enabled webhook subscribed to the event.
+// Deliveries run in the background, each one is retried as per the retry policy of the exchange
+type WebhookExternalExchange struct {
+	exchangeContract ExchangeContract
+	cruds            map[string]*DbResource
+	eventType        string
+}
+
+func (wh *WebhookExternalExchange) ExecuteTarget(row map[string]interface{}) (map[string]interface{}, error) {
+
+	tableName, _ := row["__type"].(string)
+
+	subscriptions, err := wh.GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryIds := make([]string, 0)
+	for _, subscription := range subscriptions {
+		if !subscription.Matches(wh.eventType, tableName) {
+			continue
+		}
+
+		deliveryId, body, err := wh.payload(row)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveryIds = append(deliveryIds, deliveryId)
+		go wh.deliver(subscription, deliveryId, row, body)
+	}
+
+	return map[string]interface{}{
+		"deliveries": deliveryIds,
+	}, nil
+}
+
+// Redeliver sends a row of a failed delivery to the webhook it was meant for, once. The exchange_failure row
+// of the delivery is updated by the replay, so no new one is saved when this fails
+func (wh *WebhookExternalExchange) Redeliver(webhookReferenceId string, row map[string]interface{}) error {
+
+	subscriptions, err := wh.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if subscription.ReferenceId != webhookReferenceId {
+			continue
+		}
+
+		deliveryId, body, err := wh.payload(row)
+		if err != nil {
+			return err
+		}
+		tableName, _ := row["__type"].(string)
+		result := AttemptWebhookDelivery(webhookHttpClient, subscription, deliveryId, wh.eventType, body)
+		err = wh.SaveDeliveryAttempt(subscription, deliveryId, tableName, 1, result)
+		CheckErr(err, "Failed to save delivery [%v] to webhook [%v]", deliveryId, subscription.Name)
+		return result.Err
+	}
+
+	return fmt.Errorf("webhook [%v] is deleted or disabled", webhookReferenceId)
+}
+
+// payload is the body sent to the webhooks for the row, with a new delivery id. Columns the user the exchange
+// runs as cannot read are left out
+func (wh *WebhookExternalExchange) payload(row map[string]interface{}) (string, []byte, error) {
+
+	tableName, _ := row["__type"].(string)
+
+	crud, ok := wh.cruds[tableName]
+	if !ok {
+		return "", nil, fmt.Errorf("no table [%v] to send to webhooks", tableName)
+	}
+	sessionUser, err := crud.ExchangeSessionUser(wh.exchangeContract.AsUserId)
+	if err != nil {
+		return "", nil, err
+	}
+	readableRow := crud.StripUnreadableColumns(sessionUser, []map[string]interface{}{row})[0]
+
+	data := make(map[string]interface{}, len(readableRow))
+	for key, value := range readableRow {
+		if key == "id" {
+			continue
+		}
+		data[key] = value
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", nil, err
+	}
+	deliveryId := u.String()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"delivery_id": deliveryId,
+		"event":       wh.eventType,
+		"table":       tableName,
+		"created_at":  time.Now().UTC().Format(time.RFC3339),
+		"data":        data,
+	})
+	return deliveryId, body, err
+}
+
+// GetSubscriptions reads the enabled rows of the webhook table with their secrets decrypted
+func (wh *WebhookExternalExchange) GetSubscriptions() ([]WebhookSubscription, error) {
+
+	crud, ok := wh.cruds[WEBHOOK_TABLE_NAME]
+	if !ok {
+		return nil, fmt.Errorf("no %v table to read subscriptions from", WEBHOOK_TABLE_NAME)
+	}
+
+	encryptionSecret, err := crud.configStore.GetConfigValueFor("encryption.secret", "backend")
+	CheckErr(err, "Failed to get encryption secret for webhooks")
+
+	query, args, err := statementbuilder.Squirrel.
+		Select("reference_id", "name", "url", "secret", "event_types", "table_filter", "enabled").
+		From(WEBHOOK_TABLE_NAME).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := crud.connection.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = rows.Close()
+		CheckErr(err, "Failed to close webhook rows")
+	}()
+
+	subscriptions := make([]WebhookSubscription, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return nil, err
+		}
+		if !isTruthy(row["enabled"]) {
+			continue
+		}
+
+		secret := ""
+		if row["secret"] != nil && fmt.Sprintf("%s", row["secret"]) != "" {
+			secret, err = Decrypt([]byte(encryptionSecret), fmt.Sprintf("%s", row["secret"]))
+			if err != nil {
+				log.Errorf("Failed to decrypt secret of webhook [%s], skipping it: %v", row["name"], err)
+				continue
+			}
+		}
+
+		subscriptions = append(subscriptions, WebhookSubscription{
+			ReferenceId: fmt.Sprintf("%s", row["reference_id"]),
+			Name:        fmt.Sprintf("%s", row["name"]),
+			Url:         fmt.Sprintf("%s", row["url"]),
+			Secret:      secret,
+			EventTypes:  splitWebhookFilter(row["event_types"]),
+			TableFilter: splitWebhookFilter(row["table_filter"]),
+		})
+	}
+
+	return subscriptions, nil
+}
+
+// deliver posts the payload to the webhook until it is accepted or the retry policy gives up. Every attempt
+// keeps the same delivery id so the receiver can drop duplicates. The row is saved in exchange_failure when
+// the policy gives up, to be replayed later
+func (wh *WebhookExternalExchange) deliver(subscription WebhookSubscription, deliveryId string, row map[string]interface{}, body []byte) {
+
+	tableName, _ := row["__type"].(string)
+	policy := wh.exchangeContract.RetryPolicy
+	for attempt := 1; ; attempt++ {
+		result := AttemptWebhookDelivery(webhookHttpClient, subscription, deliveryId, wh.eventType, body)
+
+		err := wh.SaveDeliveryAttempt(subscription, deliveryId, tableName, attempt, result)
+		CheckErr(err, "Failed to save delivery [%v] to webhook [%v]", deliveryId, subscription.Name)
+
+		if result.Err == nil || attempt >= policy.Attempts() || !policy.IsRetryable(result.Err) {
+			if result.Err != nil {
+				log.Errorf("Failed to deliver [%v] to webhook [%v] after %d attempts: %v", deliveryId, subscription.Name, attempt, result.Err)
+				SaveExchangeFailure(wh.cruds, wh.exchangeContract, wh.eventType, subscription.ReferenceId, row, attempt, result.Err)
+			}
+			return
+		}
+
+		backoff := policy.Backoff(attempt)
+		log.Warnf("Delivery [%v] to webhook [%v] attempt %d failed, retrying in %v: %v", deliveryId, subscription.Name, attempt, backoff, result.Err)
+		time.Sleep(backoff)
+	}
+}
+
+// AttemptWebhookDelivery makes one signed POST of the payload to the webhook url
+func AttemptWebhookDelivery(client *http.Client, subscription WebhookSubscription, deliveryId string, eventType string, body []byte) WebhookDeliveryAttempt {
+
+	result := WebhookDeliveryAttempt{}
+
+	request, err := http.NewRequest("POST", subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookDeliveryHeader, deliveryId)
+	request.Header.Set(WebhookEventHeader, eventType)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(subscription.Secret, timestamp, body))
+
+	start := time.Now()
+	response, err := client.Do(request)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer response.Body.Close()
+
+	excerpt, _ := ioutil.ReadAll(io.LimitReader(response.Body, webhookResponseExcerptLength))
+	result.StatusCode = response.StatusCode
+	result.ResponseExcerpt = string(excerpt)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		result.Err = &ExchangeStatusError{
+			StatusCode: response.StatusCode,
+			Body:       result.ResponseExcerpt,
+		}
+	}
+
+	return result
+}
+
+// SaveDeliveryAttempt adds a row for the attempt in webhook_delivery, owned by the admin like the webhooks
+func (wh *WebhookExternalExchange) SaveDeliveryAttempt(subscription WebhookSubscription, deliveryId string,
+	tableName string, attempt int, result WebhookDeliveryAttempt) error {
+
+	crud, ok := wh.cruds[WEBHOOK_DELIVERY_TABLE_NAME]
+	if !ok {
+		return fmt.Errorf("no %v table to save the delivery in", WEBHOOK_DELIVERY_TABLE_NAME)
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(crud.db)
+
+	record := goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"webhook_reference_id": subscription.ReferenceId,
+		"webhook_name":         subscription.Name,
+		"delivery_id":          deliveryId,
+		"event_type":           wh.eventType,
+		"table_name":           tableName,
+		"attempt":              attempt,
+		"status":               WebhookDeliveryStatusDelivered,
+		"status_code":          result.StatusCode,
+		"latency_ms":           result.LatencyMs,
+		"response_body":        result.ResponseExcerpt,
+		"created_at":           time.Now(),
+	}
+	if result.Err != nil {
+		record["status"] = WebhookDeliveryStatusFailed
+		record["error"] = result.Err.Error()
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(WEBHOOK_DELIVERY_TABLE_NAME).Rows(record).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	_, err = crud.db.Exec(query, args...)
+	return err
+}
+
+func NewWebhookExchangeHandler(exchangeContext ExchangeContract, cruds map[string]*DbResource, method string) ExternalExchange {
+
+	return &WebhookExternalExchange{
+		exchangeContract: exchangeContext,
+		cruds:            cruds,
+		eventType:        WebhookEventType(method),
+	}
+}
@@ -0,0 +1,238 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSubscriptionMatches(t *testing.T) {
+
+	subscription := WebhookSubscription{
+		EventTypes:  splitWebhookFilter("create, delete"),
+		TableFilter: splitWebhookFilter("order,invoice"),
+	}
+
+	if !subscription.Matches("create", "order") || !subscription.Matches("delete", "invoice") {
+		t.Errorf("expected subscribed events on filtered tables to match")
+	}
+	if subscription.Matches("update", "order") || subscription.Matches("create", "user_account") {
+		t.Errorf("expected other events and tables to not match")
+	}
+
+	all := WebhookSubscription{
+		EventTypes:  splitWebhookFilter("*"),
+		TableFilter: splitWebhookFilter(nil),
+	}
+	if !all.Matches(WebhookEventType("PATCH"), "anything") {
+		t.Errorf("expected * and an empty filter to match everything")
+	}
+}
+
+func TestAttemptWebhookDelivery(t *testing.T) {
+
+	body := []byte(`{"event":"create","table":"order"}`)
+	subscription := WebhookSubscription{Name: "partner", Secret: "shared-secret"}
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received, _ := ioutil.ReadAll(request.Body)
+		expected := "sha256=" + SignWebhookPayload("shared-secret", request.Header.Get(WebhookTimestampHeader), received)
+		if request.Header.Get(WebhookSignatureHeader) != expected {
+			t.Errorf("signature [%v] does not verify", request.Header.Get(WebhookSignatureHeader))
+		}
+		if request.Header.Get(WebhookDeliveryHeader) != "delivery-1" || request.Header.Get(WebhookEventHeader) != "create" {
+			t.Errorf("unexpected delivery headers: %v", request.Header)
+		}
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte("received"))
+	}))
+	defer server.Close()
+	subscription.Url = server.URL
+
+	result := AttemptWebhookDelivery(server.Client(), subscription, "delivery-1", "create", body)
+	if result.Err != nil || result.StatusCode != 200 || result.ResponseExcerpt != "received" {
+		t.Errorf("unexpected delivery result: %+v", result)
+	}
+
+	status = http.StatusServiceUnavailable
+	result = AttemptWebhookDelivery(server.Client(), subscription, "delivery-1", "create", body)
+	statusErr, ok := result.Err.(*ExchangeStatusError)
+	if !ok || statusErr.StatusCode != 503 {
+		t.Errorf("expected a status error for 503, got: %v", result.Err)
+	}
+}
+
+// newWebhookTestResources creates the webhook tables and an order table with a cost column the exchange user
+// cannot read
+func newWebhookTestResources(t *testing.T, webhookUrl string) map[string]*DbResource {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table user_account (id integer primary key, reference_id varchar(40), email varchar(100))",
+		"create table usergroup (id integer primary key, reference_id varchar(40))",
+		"create table user_account_user_account_id_has_usergroup_usergroup_id (id integer primary key, reference_id varchar(40), " +
+			"user_account_id integer, usergroup_id integer, permission integer)",
+		"create table \"order\" (id integer primary key, reference_id varchar(40), permission int, user_account_id int, title varchar(100), cost int)",
+		"insert into user_account (id, reference_id, email) values (1, 'admin', 'admin@example.com'), (2, 'exchange', 'exchange@example.com')",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+	configStore, err := NewConfigStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create config store: %v", err)
+	}
+
+	cruds := make(map[string]*DbResource)
+	config := &CmsConfig{Tables: make([]TableInfo, len(StandardTables))}
+	copy(config.Tables, StandardTables)
+	CheckRelations(config)
+	for i := range config.Tables {
+		table := config.Tables[i]
+		if table.TableName != EXCHANGE_FAILURE_TABLE_NAME && table.TableName != WEBHOOK_TABLE_NAME &&
+			table.TableName != WEBHOOK_DELIVERY_TABLE_NAME {
+			continue
+		}
+		CreateAMapOfColumnsWeWantInTheFinalTable(&table)
+		_, err = db.Exec(MakeCreateTableQuery(&table, "sqlite3"))
+		if err != nil {
+			t.Fatalf("Failed to create %v table: %v", table.TableName, err)
+		}
+		cruds[table.TableName] = &DbResource{
+			db:           db,
+			connection:   db,
+			Cruds:        cruds,
+			configStore:  configStore,
+			model:        api2go.NewApi2GoModel(table.TableName, table.Columns, 0, nil),
+			tableInfo:    &table,
+			contextCache: make(map[string]interface{}),
+			contextLock:  &sync.RWMutex{},
+		}
+	}
+	for _, tableName := range []string{USER_ACCOUNT_TABLE_NAME, "order"} {
+		cruds[tableName] = &DbResource{
+			db:         db,
+			connection: db,
+			Cruds:      cruds,
+			model:      api2go.NewApi2GoModel(tableName, nil, 0, nil),
+			tableInfo: &TableInfo{
+				TableName: tableName,
+				Columns: []api2go.ColumnInfo{
+					{ColumnName: "title"},
+					{ColumnName: "cost", Permission: uint64(auth.GroupRead)},
+				},
+			},
+			contextCache: make(map[string]interface{}),
+			contextLock:  &sync.RWMutex{},
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf("insert into webhook (reference_id, permission, name, url, secret, event_types, table_filter, enabled) "+
+		"values ('w1', %d, 'partner', '%s', '', 'update', 'order', 1)", auth.DEFAULT_PERMISSION, webhookUrl))
+	if err != nil {
+		t.Fatalf("Failed to add webhook: %v", err)
+	}
+	return cruds
+}
+
+func TestWebhookFailedDeliveryIsReplayed(t *testing.T) {
+
+	var lock sync.Mutex
+	status := http.StatusServiceUnavailable
+	received := make([]map[string]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		payload := make(map[string]interface{})
+		body, _ := ioutil.ReadAll(request.Body)
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			t.Errorf("Failed to read payload: %v", err)
+		}
+		if request.Header.Get(WebhookEventHeader) != "update" {
+			t.Errorf("expected an update event, got [%v]", request.Header.Get(WebhookEventHeader))
+		}
+		received = append(received, payload)
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cruds := newWebhookTestResources(t, server.URL)
+	contract := ExchangeContract{
+		Name:       "order webhooks",
+		TargetType: "webhook",
+		AsUserId:   2,
+		RetryPolicy: ExchangeRetryPolicy{
+			MaxAttempts:    2,
+			BackoffSeconds: 0.001,
+		},
+	}
+	exchange := NewWebhookExchangeHandler(contract, cruds, "PATCH")
+	_, err := exchange.ExecuteTarget(map[string]interface{}{
+		"__type": "order", "id": int64(1), "reference_id": "o1", "permission": int64(auth.DEFAULT_PERMISSION), "user_account_id": "exchange",
+		"title": "first", "cost": 10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute webhook exchange: %v", err)
+	}
+
+	// the delivery runs in the background and saves the failure once both attempts failed
+	failure := map[string]interface{}{}
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		row, err := cruds[EXCHANGE_FAILURE_TABLE_NAME].connection.QueryRowx(
+			"select reference_id, event_type, webhook_reference_id, attempt_count from exchange_failure").SliceScan()
+		if err == nil {
+			failure["reference_id"], failure["event_type"], failure["webhook_reference_id"], failure["attempt_count"] = row[0], row[1], row[2], row[3]
+			break
+		}
+	}
+	if fmt.Sprintf("%s", failure["event_type"]) != "update" || fmt.Sprintf("%s", failure["webhook_reference_id"]) != "w1" ||
+		fmt.Sprintf("%v", failure["attempt_count"]) != "2" {
+		t.Fatalf("expected the failed delivery to be saved with its event and webhook, got %v", failure)
+	}
+
+	lock.Lock()
+	status = http.StatusOK
+	lock.Unlock()
+
+	replay := &exchangeFailureReplayActionPerformer{
+		cruds:     cruds,
+		cmsConfig: &CmsConfig{ExchangeContracts: []ExchangeContract{contract}},
+	}
+	_, _, errs := replay.DoAction(Outcome{}, map[string]interface{}{
+		"exchange_failure_id": fmt.Sprintf("%s", failure["reference_id"]),
+	})
+	if len(errs) > 0 {
+		t.Fatalf("Failed to replay the delivery: %v", errs)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 3 {
+		t.Fatalf("expected 2 failed attempts and the replay, got %d requests", len(received))
+	}
+	for _, payload := range received {
+		data := payload["data"].(map[string]interface{})
+		if data["title"] != "first" || data["cost"] != nil || data["id"] != nil {
+			t.Errorf("expected only the readable columns to be sent, got %v", data)
+		}
+	}
+	var status1 string
+	err = cruds[EXCHANGE_FAILURE_TABLE_NAME].connection.QueryRowx("select status from exchange_failure").Scan(&status1)
+	if err != nil || status1 != ExchangeFailureStatusReplayed {
+		t.Errorf("expected the failure to be marked replayed, got [%v] %v", status1, err)
+	}
+}
@@ -0,0 +1,93 @@
+package resource
+
+import (
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"time"
+)
+
+const FTP_CHANGE_LOG_TABLE_NAME = "ftp_change_log"
+
+const (
+	FtpOperationUpload = "upload"
+	FtpOperationMkdir  = "mkdir"
+	FtpOperationDelete = "delete"
+	FtpOperationRename = "rename"
+	FtpOperationChmod  = "chmod"
+	FtpOperationMtime  = "mtime"
+)
+
+// FtpChangeLogEntry is one change made to a site folder or a cloud store over ftp, sftp or webdav
+type FtpChangeLogEntry struct {
+	SiteId          string
+	CloudStoreId    string
+	Protocol        string
+	UserReferenceId string
+	Operation       string
+	Path            string
+	NewPath         string
+	Size            int64
+	ClientIp        string
+}
+
+// InsertFtpChangeLog stores an ftp change log entry. The rows are owned by the administrator so the users in the
+// log cannot change them
+func (dr *DbResource) InsertFtpChangeLog(entry FtpChangeLogEntry) error {
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	u, _ := uuid.NewV4()
+	row := goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"user_reference_id":    entry.UserReferenceId,
+		"operation":            entry.Operation,
+		"path":                 entry.Path,
+		"size":                 entry.Size,
+		"client_ip":            entry.ClientIp,
+		"created_at":           time.Now(),
+	}
+	if entry.SiteId != "" {
+		row["site_id"] = entry.SiteId
+	}
+	if entry.CloudStoreId != "" {
+		row["cloud_store_id"] = entry.CloudStoreId
+	}
+	if entry.Protocol != "" {
+		row["protocol"] = entry.Protocol
+	}
+	if entry.NewPath != "" {
+		row["new_path"] = entry.NewPath
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(FTP_CHANGE_LOG_TABLE_NAME).Rows(row).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// GetFtpUploadedBytes is the number of bytes the user uploaded over ftp, sftp and webdav since the given time,
+// used for the upload quota
+func (dr *DbResource) GetFtpUploadedBytes(userReferenceId string, since time.Time) (int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select(goqu.COALESCE(goqu.SUM("size"), 0)).
+		From(FTP_CHANGE_LOG_TABLE_NAME).
+		Where(
+			goqu.Ex{
+				"user_reference_id": userReferenceId,
+				"operation":         FtpOperationUpload,
+			},
+			goqu.C("created_at").Gte(since),
+		).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	var uploadedBytes int64
+	err = dr.queryRow(query, args, &uploadedBytes)
+	return uploadedBytes, err
+}
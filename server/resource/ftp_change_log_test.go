@@ -0,0 +1,79 @@
+package resource
+
+import (
+	"github.com/jmoiron/sqlx"
+	"testing"
+	"time"
+)
+
+func TestFtpChangeLogUploadedBytes(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table user_account (id integer primary key, email varchar(100))",
+		"create table usergroup (id integer primary key)",
+		"insert into user_account (email) values ('admin@example.com')",
+		"insert into usergroup (id) values (1)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	// create the table as the server does, with the columns added for its relations
+	config := &CmsConfig{Tables: make([]TableInfo, len(StandardTables))}
+	copy(config.Tables, StandardTables)
+	CheckRelations(config)
+	for _, table := range config.Tables {
+		if table.TableName != FTP_CHANGE_LOG_TABLE_NAME {
+			continue
+		}
+		CreateAMapOfColumnsWeWantInTheFinalTable(&table)
+		_, err = db.Exec(MakeCreateTableQuery(&table, "sqlite3"))
+		if err != nil {
+			t.Fatalf("Failed to create %v table: %v", FTP_CHANGE_LOG_TABLE_NAME, err)
+		}
+	}
+	dr := &DbResource{db: db, connection: db}
+
+	for _, entry := range []FtpChangeLogEntry{
+		{SiteId: "s1", UserReferenceId: "u1", Operation: FtpOperationUpload, Path: "a.txt", Size: 10},
+		{CloudStoreId: "c1", Protocol: "sftp", UserReferenceId: "u1", Operation: FtpOperationUpload, Path: "b.txt", Size: 5},
+		{SiteId: "s1", UserReferenceId: "u1", Operation: FtpOperationRename, Path: "a.txt", NewPath: "c.txt"},
+		{SiteId: "s1", UserReferenceId: "u2", Operation: FtpOperationUpload, Path: "d.txt", Size: 100},
+	} {
+		err = dr.InsertFtpChangeLog(entry)
+		if err != nil {
+			t.Fatalf("Failed to insert change log: %v", err)
+		}
+	}
+
+	uploaded, err := dr.GetFtpUploadedBytes("u1", time.Now().Add(-time.Hour))
+	if err != nil || uploaded != 15 {
+		t.Errorf("expected 15 bytes uploaded by u1, found %v %v", uploaded, err)
+	}
+	uploaded, err = dr.GetFtpUploadedBytes("u3", time.Now().Add(-time.Hour))
+	if err != nil || uploaded != 0 {
+		t.Errorf("expected no bytes uploaded by u3, found %v %v", uploaded, err)
+	}
+	uploaded, err = dr.GetFtpUploadedBytes("u1", time.Now().Add(time.Hour))
+	if err != nil || uploaded != 0 {
+		t.Errorf("expected older uploads to not be counted, found %v %v", uploaded, err)
+	}
+
+	var newPath, protocol string
+	var owner int64
+	err = db.QueryRowx("select new_path, user_account_id, protocol from ftp_change_log where operation = 'rename'").
+		Scan(&newPath, &owner, &protocol)
+	if err != nil || newPath != "c.txt" || owner != 1 || protocol != "ftp" {
+		t.Errorf("unexpected rename change %v %v %v %v", newPath, owner, protocol, err)
+	}
+	err = db.QueryRowx("select protocol from ftp_change_log where cloud_store_id = 'c1'").Scan(&protocol)
+	if err != nil || protocol != "sftp" {
+		t.Errorf("unexpected cloud store change %v %v", protocol, err)
+	}
+}
@@ -200,6 +200,11 @@ func (db *DbResource) HandleActionRequest(actionRequest ActionRequest, req api2g
 		return nil, api2go.NewHTTPError(err, "no such action", 400)
 	}
 
+	if !sessionUser.AllowsAction(actionRequest.Action) {
+		log.Warnf("api key scopes do not allow action: %v - %v", actionRequest.Action, actionRequest.Type)
+		return nil, api2go.NewHTTPError(errors.New("forbidden"), "forbidden", 403)
+	}
+
 	isAdmin := db.IsAdmin(sessionUser.UserReferenceId)
 
 	subjectInstanceReferenceId, ok := actionRequest.Attributes[actionRequest.Type+"_id"]
@@ -279,6 +284,11 @@ func (db *DbResource) HandleActionRequest(actionRequest ActionRequest, req api2g
 
 	inFieldMap, err := GetValidatedInFields(actionRequest, action)
 	inFieldMap["attributes"] = actionRequest.Attributes
+	inFieldMap["client_ip"] = auth.RequestClientIp(req.PlainRequest)
+	inFieldMap["language_preference"] = make([]string, 0)
+	if prefs, ok := req.PlainRequest.Context().Value("language_preference").([]string); ok {
+		inFieldMap["language_preference"] = prefs
+	}
 
 	if err != nil {
 		return nil, api2go.NewHTTPError(err, "failed to validate fields", 400)
@@ -299,8 +309,22 @@ func (db *DbResource) HandleActionRequest(actionRequest ActionRequest, req api2g
 
 	responses := make([]ActionResponse, 0)
 
+	cruds := db.Cruds
+	var transaction *ResourceTransaction
+	if action.Transactional {
+		transaction, err = db.NewTransaction()
+		if err != nil {
+			log.Errorf("Failed to begin transaction for action [%v]: %v", actionRequest.Action, err)
+			return nil, api2go.NewHTTPError(err, "failed to begin transaction", 500)
+		}
+		// no-op once the transaction is committed
+		defer transaction.Rollback()
+		cruds = transaction.Cruds
+	}
+	outcomeFailed := false
+
 OutFields:
-	for _, outcome := range action.OutFields {
+	for outcomeIndex, outcome := range action.OutFields {
 		var responseObjects interface{}
 		responseObjects = nil
 		var responses1 []ActionResponse
@@ -355,6 +379,15 @@ OutFields:
 			}
 		}
 
+		savepoint := fmt.Sprintf("outcome_%d", outcomeIndex)
+		if transaction != nil && outcome.ContinueOnError {
+			err = transaction.Savepoint(savepoint)
+			if err != nil {
+				log.Errorf("Failed to create savepoint for outcome [%v][%v]: %v", outcome.Type, outcome.Method, err)
+				return nil, api2go.NewHTTPError(err, "failed to create savepoint", 500)
+			}
+		}
+
 		requestContext := req.PlainRequest.Context()
 		adminUserReferenceId := db.GetAdminReferenceId()
 		if len(adminUserReferenceId) > 0 {
@@ -363,7 +396,7 @@ OutFields:
 			})
 		}
 		request.PlainRequest = request.PlainRequest.WithContext(requestContext)
-		dbResource, _ := db.Cruds[outcome.Type]
+		dbResource, _ := cruds[outcome.Type]
 
 		actionResponses := make([]ActionResponse, 0)
 		//log.Printf("Next outcome method: [%v][%v]", outcome.Method, outcome.Type)
@@ -375,7 +408,8 @@ OutFields:
 
 				actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to create "+model.GetName()+". "+err.Error(), "Failed"))
 				responses = append(responses, actionResponse)
-				break OutFields
+				outcomeFailed = true
+				break
 			} else {
 				createdRow := responseObjects.(api2go.Response).Result().(*api2go.Api2GoModel).Data
 				actionResponse = NewActionResponse(createdRow["__type"].(string), createdRow)
@@ -399,7 +433,8 @@ OutFields:
 				actionResponse = NewActionResponse("client.notify",
 					NewClientNotification("error", "Failed to get "+model.GetName()+". "+err.Error(), "Failed"))
 				responses = append(responses, actionResponse)
-				break OutFields
+				outcomeFailed = true
+				break
 			} else {
 				actionResponse = NewActionResponse(actionRequest.Type, responseObjects)
 			}
@@ -430,7 +465,8 @@ OutFields:
 				actionResponse = NewActionResponse("client.notify",
 					NewClientNotification("error", "Failed to create "+model.GetName()+". "+err.Error(), "Failed"))
 				responses = append(responses, actionResponse)
-				break OutFields
+				outcomeFailed = true
+				break
 			} else {
 				actionResponse = NewActionResponse(actionRequest.Type, responseObjects)
 			}
@@ -441,7 +477,8 @@ OutFields:
 			if err != nil {
 				actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to update "+model.GetName()+". "+err.Error(), "Failed"))
 				responses = append(responses, actionResponse)
-				break OutFields
+				outcomeFailed = true
+				break
 			} else {
 				createdRow := responseObjects.(api2go.Response).Result().(*api2go.Api2GoModel).Data
 				actionResponse = NewActionResponse(createdRow["__type"].(string), createdRow)
@@ -453,7 +490,8 @@ OutFields:
 			if err != nil {
 				actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to delete "+model.GetName(), "Failed"))
 				responses = append(responses, actionResponse)
-				break OutFields
+				outcomeFailed = true
+				break
 			} else {
 				actionResponse = NewActionResponse("client.notify", NewClientNotification("success", "Deleted "+model.GetName(), "Success"))
 			}
@@ -502,6 +540,19 @@ OutFields:
 
 		}
 
+		if outcomeFailed {
+			if transaction != nil && outcome.ContinueOnError {
+				err = transaction.RollbackToSavepoint(savepoint)
+				if err != nil {
+					log.Errorf("Failed to rollback to savepoint for outcome [%v][%v]: %v", outcome.Type, outcome.Method, err)
+					break OutFields
+				}
+				outcomeFailed = false
+				continue
+			}
+			break OutFields
+		}
+
 		if !outcome.SkipInResponse {
 			responses = append(responses, actionResponses...)
 		}
@@ -544,6 +595,26 @@ OutFields:
 		if err != nil {
 			return responses, err
 		}
+
+		if transaction != nil && outcome.ContinueOnError {
+			err = transaction.ReleaseSavepoint(savepoint)
+			CheckErr(err, "Failed to release savepoint for outcome [%v][%v]", outcome.Type, outcome.Method)
+		}
+	}
+
+	if transaction != nil {
+		if outcomeFailed {
+			log.Warnf("Action [%v] failed, rolling back transaction", actionRequest.Action)
+			transaction.Rollback()
+			responses = append(responses, NewActionResponse("client.notify",
+				NewClientNotification("error", "No changes were saved", "Rolled back")))
+			return responses, api2go.NewHTTPError(errors.New("action rolled back"), "action rolled back", 400)
+		}
+		err = transaction.Commit()
+		if err != nil {
+			log.Errorf("Failed to commit transaction for action [%v]: %v", actionRequest.Action, err)
+			return nil, api2go.NewHTTPError(err, "failed to commit transaction", 500)
+		}
 	}
 
 	return responses, nil
@@ -5,10 +5,17 @@ import (
 	"github.com/artpar/go-imap"
 	"github.com/artpar/go-imap/backend"
 	"github.com/daptin/daptin/server/auth"
+	"net"
 )
 
 type DaptinImapBackend struct {
-	cruds map[string]*DbResource
+	cruds    map[string]*DbResource
+	notifier *ImapMailBoxNotifier
+}
+
+// Updates makes the backend a backend.BackendUpdater, the server writes these updates to the clients
+func (be *DaptinImapBackend) Updates() <-chan backend.Update {
+	return be.notifier.Updates()
 }
 
 func (be *DaptinImapBackend) LoginMd5(conn *imap.ConnInfo, username, challenge string, response string) (backend.User, error) {
@@ -45,8 +52,18 @@ func (be *DaptinImapBackend) LoginMd5(conn *imap.ConnInfo, username, challenge s
 
 func (be *DaptinImapBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
 
+	clientIp := ""
+	if conn != nil && conn.RemoteAddr != nil {
+		clientIp, _, _ = net.SplitHostPort(conn.RemoteAddr.String())
+	}
+	err := auth.LoginAttempts.Check(username, clientIp)
+	if err != nil {
+		return nil, err
+	}
+
 	userMailAccount, err := be.cruds[USER_ACCOUNT_TABLE_NAME].GetUserMailAccountRowByEmail(username)
 	if err != nil {
+		auth.LoginAttempts.Failure("imap", username, clientIp)
 		return nil, err
 	}
 
@@ -62,20 +79,29 @@ func (be *DaptinImapBackend) Login(conn *imap.ConnInfo, username, password strin
 
 	if BcryptCheckStringHash(password, userMailAccount["password"].(string)) {
 
+		auth.LoginAttempts.Success(username)
 		return &DaptinImapUser{
 			username:               username,
 			mailAccountId:          userMailAccount["id"].(int64),
 			mailAccountReferenceId: userMailAccount["reference_id"].(string),
 			dbResource:             be.cruds,
 			sessionUser:            sessionUser,
+			notifier:               be.notifier,
 		}, nil
 	}
 
+	auth.LoginAttempts.Failure("imap", username, clientIp)
 	return nil, errors.New("bad username or password")
 }
 
 func NewImapServer(cruds map[string]*DbResource) *DaptinImapBackend {
 	return &DaptinImapBackend{
-		cruds: cruds,
+		cruds:    cruds,
+		notifier: NewImapMailBoxNotifier(cruds),
 	}
 }
+
+// Close removes the listener of the backend on the mail topic, called when the imap server shuts down
+func (be *DaptinImapBackend) Close() {
+	be.notifier.Close()
+}
@@ -0,0 +1,533 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go-imap/responses"
+	"github.com/artpar/go-imap/server"
+	"strconv"
+	"strings"
+)
+
+// FetchModSequence is the MODSEQ fetch item of CONDSTORE
+const FetchModSequence imap.FetchItem = "MODSEQ"
+
+const (
+	codeHighestModSequence imap.StatusRespCode = "HIGHESTMODSEQ"
+	codeModified           imap.StatusRespCode = "MODIFIED"
+)
+
+// ImapMailBoxMover is a mailbox supporting the MOVE extension
+type ImapMailBoxMover interface {
+	MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error
+}
+
+// ImapModSequenceMailBox is a mailbox keeping mod-sequences for CONDSTORE and QRESYNC
+type ImapModSequenceMailBox interface {
+	HighestModSequence() (int64, error)
+	ModSequences() ([]MailModSequence, error)
+	ExpungedModSequences() ([]MailModSequence, error)
+}
+
+// HighestModSequence is the mod-sequence of the last change in the mailbox
+func (dimb *DaptinImapMailBox) HighestModSequence() (int64, error) {
+	return dimb.dbResource["mail_box"].GetMailBoxHighestModSequence(dimb.mailBoxId)
+}
+
+// ModSequences are the mails with their mod-sequence in sequence order
+func (dimb *DaptinImapMailBox) ModSequences() ([]MailModSequence, error) {
+	return dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, false)
+}
+
+// ExpungedModSequences are all the mails which were not expunged yet, including the ones marked as deleted
+func (dimb *DaptinImapMailBox) ExpungedModSequences() ([]MailModSequence, error) {
+	return dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, true)
+}
+
+type imapMoveExtension struct{}
+
+// NewImapMoveExtension adds the MOVE and UID MOVE commands of RFC 6851
+func NewImapMoveExtension() server.Extension {
+	return &imapMoveExtension{}
+}
+
+func (ext *imapMoveExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"MOVE"}
+	}
+	return nil
+}
+
+func (ext *imapMoveExtension) Command(name string) server.HandlerFactory {
+	if name != "MOVE" {
+		return nil
+	}
+	return func() server.Handler {
+		return &imapMove{}
+	}
+}
+
+type imapMove struct {
+	server.Copy
+}
+
+func (cmd *imapMove) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if ctx.MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+
+	mover, ok := ctx.Mailbox.(ImapMailBoxMover)
+	if !ok {
+		return errors.New("MOVE is not supported by this mailbox")
+	}
+	return mover.MoveMessages(uid, cmd.SeqSet, cmd.Mailbox)
+}
+
+func (cmd *imapMove) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapMove) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+type imapCondStoreExtension struct{}
+
+// NewImapCondStoreExtension adds CONDSTORE and QRESYNC of RFC 7162 with the ENABLE command they need. SELECT
+// reports HIGHESTMODSEQ, FETCH takes MODSEQ and CHANGEDSINCE and STORE takes UNCHANGEDSINCE. QRESYNC
+// parameters of SELECT are answered with VANISHED (EARLIER) and the flags of the changed mails
+func NewImapCondStoreExtension() server.Extension {
+	return &imapCondStoreExtension{}
+}
+
+func (ext *imapCondStoreExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"ENABLE", "CONDSTORE", "QRESYNC"}
+	}
+	return nil
+}
+
+func (ext *imapCondStoreExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "ENABLE":
+		return func() server.Handler {
+			return &imapEnable{}
+		}
+	case "SELECT":
+		return func() server.Handler {
+			return &imapCondStoreSelect{}
+		}
+	case "EXAMINE":
+		return func() server.Handler {
+			handler := &imapCondStoreSelect{}
+			handler.ReadOnly = true
+			return handler
+		}
+	case "FETCH":
+		return func() server.Handler {
+			return &imapCondStoreFetch{}
+		}
+	case "STORE":
+		return func() server.Handler {
+			return &imapCondStoreStore{}
+		}
+	}
+	return nil
+}
+
+type imapEnable struct {
+	capabilities []string
+}
+
+func (cmd *imapEnable) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	for _, field := range fields {
+		capability, ok := field.(string)
+		if !ok {
+			return errors.New("Capability must be an atom")
+		}
+		capability = strings.ToUpper(capability)
+		if capability == "CONDSTORE" || capability == "QRESYNC" {
+			cmd.capabilities = append(cmd.capabilities, capability)
+		}
+	}
+	return nil
+}
+
+func (cmd *imapEnable) Handle(conn server.Conn) error {
+	if conn.Context().User == nil {
+		return server.ErrNotAuthenticated
+	}
+	enabled := []interface{}{imap.RawString("ENABLED")}
+	for _, capability := range cmd.capabilities {
+		enabled = append(enabled, imap.RawString(capability))
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(enabled))
+}
+
+// ImapQresyncParameters are the QRESYNC parameters of SELECT, the state of the mailbox the client knows
+type ImapQresyncParameters struct {
+	UidValidity uint32
+	ModSequence int64
+	KnownUids   *imap.SeqSet
+}
+
+type imapCondStoreSelect struct {
+	server.Select
+	condStore bool
+	qresync   *ImapQresyncParameters
+}
+
+func (cmd *imapCondStoreSelect) Parse(fields []interface{}) error {
+	err := cmd.Select.Parse(fields)
+	if err != nil || len(fields) < 2 {
+		return err
+	}
+	cmd.condStore, cmd.qresync, err = ParseImapSelectParameters(fields[1])
+	return err
+}
+
+// ParseImapSelectParameters reads the (CONDSTORE) and (QRESYNC (uidvalidity modseq [known-uids])) parameters
+func ParseImapSelectParameters(field interface{}) (bool, *ImapQresyncParameters, error) {
+
+	parameters, ok := field.([]interface{})
+	if !ok {
+		return false, nil, errors.New("Select parameters must be a list")
+	}
+
+	condStore := false
+	var qresync *ImapQresyncParameters
+	for i := 0; i < len(parameters); i++ {
+		name, _ := parameters[i].(string)
+		switch strings.ToUpper(name) {
+		case "CONDSTORE":
+			condStore = true
+		case "QRESYNC":
+			if i+1 >= len(parameters) {
+				return false, nil, errors.New("Missing QRESYNC parameters")
+			}
+			i++
+			values, ok := parameters[i].([]interface{})
+			if !ok || len(values) < 2 {
+				return false, nil, errors.New("Invalid QRESYNC parameters")
+			}
+			uidValidity, err := imap.ParseNumber(values[0])
+			if err != nil {
+				return false, nil, err
+			}
+			modSequence, err := parseModSequence(values[1])
+			if err != nil {
+				return false, nil, err
+			}
+			qresync = &ImapQresyncParameters{
+				UidValidity: uidValidity,
+				ModSequence: modSequence,
+			}
+			if len(values) > 2 {
+				if knownUids, ok := values[2].(string); ok {
+					qresync.KnownUids, err = imap.ParseSeqSet(knownUids)
+					if err != nil {
+						return false, nil, err
+					}
+				}
+			}
+			condStore = true
+		default:
+			return false, nil, fmt.Errorf("Unknown select parameter [%v]", parameters[i])
+		}
+	}
+	return condStore, qresync, nil
+}
+
+func parseModSequence(field interface{}) (int64, error) {
+	value, ok := field.(string)
+	if !ok {
+		return 0, errors.New("Mod-sequence must be a number")
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func (cmd *imapCondStoreSelect) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	mbox, err := ctx.User.GetMailbox(cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	items := []imap.StatusItem{
+		imap.StatusMessages, imap.StatusRecent, imap.StatusUnseen,
+		imap.StatusUidNext, imap.StatusUidValidity,
+	}
+	status, err := mbox.Status(items)
+	if err != nil {
+		return err
+	}
+
+	ctx.Mailbox = mbox
+	ctx.MailboxReadOnly = cmd.ReadOnly || status.ReadOnly
+
+	err = conn.WriteResp(&responses.Select{Mailbox: status})
+	if err != nil {
+		return err
+	}
+
+	if modSequenceMailBox, ok := mbox.(ImapModSequenceMailBox); ok {
+		highestModSequence, err := modSequenceMailBox.HighestModSequence()
+		if err != nil {
+			return err
+		}
+		err = conn.WriteResp(&imap.StatusResp{
+			Type:      imap.StatusRespOk,
+			Code:      codeHighestModSequence,
+			Arguments: []interface{}{imap.RawString(strconv.FormatInt(highestModSequence, 10))},
+			Info:      "Highest",
+		})
+		if err != nil {
+			return err
+		}
+
+		if cmd.qresync != nil && cmd.qresync.UidValidity == status.UidValidity {
+			err = cmd.resync(conn, modSequenceMailBox, status.UidNext)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var code imap.StatusRespCode = imap.CodeReadWrite
+	if ctx.MailboxReadOnly {
+		code = imap.CodeReadOnly
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type: imap.StatusRespOk,
+		Code: code,
+	})
+}
+
+// resync sends the uids expunged and the flags of the mails changed since the state the client knows
+func (cmd *imapCondStoreSelect) resync(conn server.Conn, mailBox ImapModSequenceMailBox, uidNext uint32) error {
+
+	mails, err := mailBox.ExpungedModSequences()
+	if err != nil {
+		return err
+	}
+
+	known := cmd.qresync.KnownUids
+	if known == nil {
+		known, _ = imap.ParseSeqSet("1:*")
+	}
+	lastUid := uint32(0)
+	if uidNext > 0 {
+		lastUid = uidNext - 1
+	}
+	vanished := VanishedUids(known, mails, lastUid)
+	if !vanished.Empty() {
+		err = conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+			imap.RawString("VANISHED"), []interface{}{imap.RawString("EARLIER")}, vanished,
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := mailBox.ModSequences()
+	if err != nil {
+		return err
+	}
+	changed, _ := FilterModSequences(current, true, nil, cmd.qresync.ModSequence)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return writeFetch(conn, true, numbersToSeqSet(changed),
+		[]imap.FetchItem{imap.FetchUid, imap.FetchFlags, FetchModSequence})
+}
+
+func writeFetch(conn server.Conn, uid bool, seqset *imap.SeqSet, items []imap.FetchItem) error {
+	ch := make(chan *imap.Message)
+	res := &responses.Fetch{Messages: ch}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.WriteResp(res)
+		for range ch {
+		}
+	}()
+
+	err := conn.Context().Mailbox.ListMessages(uid, seqset, items, ch)
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+type imapCondStoreFetch struct {
+	server.Fetch
+	changedSince int64
+}
+
+func (cmd *imapCondStoreFetch) Parse(fields []interface{}) error {
+	err := cmd.Fetch.Parse(fields)
+	if err != nil || len(fields) < 3 {
+		return err
+	}
+
+	modifiers, ok := fields[2].([]interface{})
+	if !ok {
+		return errors.New("Fetch modifiers must be a list")
+	}
+	for i := 0; i < len(modifiers); i++ {
+		name, _ := modifiers[i].(string)
+		switch strings.ToUpper(name) {
+		case "CHANGEDSINCE":
+			if i+1 >= len(modifiers) {
+				return errors.New("Missing CHANGEDSINCE mod-sequence")
+			}
+			i++
+			cmd.changedSince, err = parseModSequence(modifiers[i])
+			if err != nil {
+				return err
+			}
+		case "VANISHED":
+			// expunged mails are not kept, the client learns about them from the sequence numbers
+		default:
+			return fmt.Errorf("Unknown fetch modifier [%v]", modifiers[i])
+		}
+	}
+	return nil
+}
+
+func (cmd *imapCondStoreFetch) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if cmd.changedSince == 0 {
+		return writeFetch(conn, uid, cmd.SeqSet, cmd.Items)
+	}
+
+	mailBox, ok := ctx.Mailbox.(ImapModSequenceMailBox)
+	if !ok {
+		return errors.New("CHANGEDSINCE is not supported by this mailbox")
+	}
+	mails, err := mailBox.ModSequences()
+	if err != nil {
+		return err
+	}
+	changed, _ := FilterModSequences(mails, uid, cmd.SeqSet, cmd.changedSince)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	items := cmd.Items
+	if !hasFetchItem(items, FetchModSequence) {
+		items = append(items, FetchModSequence)
+	}
+	return writeFetch(conn, uid, numbersToSeqSet(changed), items)
+}
+
+func hasFetchItem(items []imap.FetchItem, item imap.FetchItem) bool {
+	for _, existing := range items {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *imapCondStoreFetch) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapCondStoreFetch) UidHandle(conn server.Conn) error {
+	if !hasFetchItem(cmd.Items, imap.FetchUid) {
+		cmd.Items = append(cmd.Items, imap.FetchUid)
+	}
+	return cmd.handle(true, conn)
+}
+
+type imapCondStoreStore struct {
+	server.Store
+	unchangedSince int64
+}
+
+func (cmd *imapCondStoreStore) Parse(fields []interface{}) error {
+	if len(fields) > 1 {
+		if modifiers, ok := fields[1].([]interface{}); ok {
+			if len(modifiers) != 2 || !strings.EqualFold(fmt.Sprint(modifiers[0]), "UNCHANGEDSINCE") {
+				return errors.New("Unknown store modifier")
+			}
+			unchangedSince, err := parseModSequence(modifiers[1])
+			if err != nil {
+				return err
+			}
+			cmd.unchangedSince = unchangedSince
+			fields = append([]interface{}{fields[0]}, fields[2:]...)
+		}
+	}
+	return cmd.Store.Parse(fields)
+}
+
+func (cmd *imapCondStoreStore) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if cmd.unchangedSince == 0 {
+		return cmd.storeAll(uid, conn)
+	}
+
+	mailBox, ok := ctx.Mailbox.(ImapModSequenceMailBox)
+	if !ok {
+		return errors.New("UNCHANGEDSINCE is not supported by this mailbox")
+	}
+	mails, err := mailBox.ModSequences()
+	if err != nil {
+		return err
+	}
+
+	modified, unchanged := FilterModSequences(mails, uid, cmd.SeqSet, cmd.unchangedSince)
+	if len(unchanged) > 0 {
+		cmd.SeqSet = numbersToSeqSet(unchanged)
+		err = cmd.storeAll(uid, conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(modified) == 0 {
+		return nil
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      codeModified,
+		Arguments: []interface{}{numbersToSeqSet(modified)},
+		Info:      "Conditional STORE failed",
+	})
+}
+
+func (cmd *imapCondStoreStore) storeAll(uid bool, conn server.Conn) error {
+	if uid {
+		return cmd.Store.UidHandle(conn)
+	}
+	return cmd.Store.Handle(conn)
+}
+
+func (cmd *imapCondStoreStore) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapCondStoreStore) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
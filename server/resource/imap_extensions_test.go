@@ -0,0 +1,49 @@
+package resource
+
+import (
+	"testing"
+)
+
+func TestParseImapSelectParameters(t *testing.T) {
+
+	condStore, qresync, err := ParseImapSelectParameters([]interface{}{"CONDSTORE"})
+	if err != nil || !condStore || qresync != nil {
+		t.Errorf("unexpected CONDSTORE parameters %v %v %v", condStore, qresync, err)
+	}
+
+	condStore, qresync, err = ParseImapSelectParameters([]interface{}{
+		"QRESYNC", []interface{}{"67890007", "20050715194045000", "41,43:211"},
+	})
+	if err != nil || !condStore || qresync == nil {
+		t.Fatalf("unexpected QRESYNC parameters %v %v %v", condStore, qresync, err)
+	}
+	if qresync.UidValidity != 67890007 || qresync.ModSequence != 20050715194045000 || qresync.KnownUids.String() != "41,43:211" {
+		t.Errorf("unexpected QRESYNC parameters %+v", qresync)
+	}
+
+	_, _, err = ParseImapSelectParameters([]interface{}{"QRESYNC", []interface{}{"1"}})
+	if err == nil {
+		t.Errorf("QRESYNC without a mod-sequence should fail")
+	}
+}
+
+func TestImapCondStoreModifiers(t *testing.T) {
+
+	fetch := &imapCondStoreFetch{}
+	err := fetch.Parse([]interface{}{"1:*", []interface{}{"UID", "FLAGS"}, []interface{}{"CHANGEDSINCE", "12345"}})
+	if err != nil || fetch.changedSince != 12345 || len(fetch.Items) != 2 {
+		t.Errorf("unexpected fetch %+v %v", fetch, err)
+	}
+
+	store := &imapCondStoreStore{}
+	err = store.Parse([]interface{}{"1:3", []interface{}{"UNCHANGEDSINCE", "320162338"}, "+FLAGS.SILENT", []interface{}{"\\Deleted"}})
+	if err != nil || store.unchangedSince != 320162338 || store.Item != "+FLAGS.SILENT" || store.SeqSet.String() != "1:3" {
+		t.Errorf("unexpected store %+v %v", store, err)
+	}
+
+	store = &imapCondStoreStore{}
+	err = store.Parse([]interface{}{"1", "FLAGS", []interface{}{"\\Seen"}})
+	if err != nil || store.unchangedSince != 0 {
+		t.Errorf("plain store should still parse %v", err)
+	}
+}
@@ -20,7 +20,7 @@ import (
 	"golang.org/x/net/context"
 	"io/ioutil"
 	"net/http"
-
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +29,8 @@ import (
 
 type DaptinImapMailBox struct {
 	name               string
+	username           string
+	notifier           *ImapMailBoxNotifier
 	sessionUser        *auth.SessionUser
 	dbResource         map[string]*DbResource
 	mailAccountId      int64
@@ -239,6 +241,12 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 					case imap.FetchUid:
 						uid := mailContent["id"].(int64)
 						returnMail.Uid = uint32(uid)
+					case FetchModSequence:
+						modSequence, ok := mailContent["mod_sequence"].(int64)
+						if !ok {
+							modSequence = 1
+						}
+						returnMail.Items[FetchModSequence] = []interface{}{imap.RawString(strconv.FormatInt(modSequence, 10))}
 					default:
 						log.Printf("Fetch default [%v] update flags: %v", subItems, flagList)
 
@@ -305,99 +313,30 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 // uid is set to true, or sequence numbers otherwise.
 func (dimb *DaptinImapMailBox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
 
-	httpRequest := http.Request{}
-
-	//filterParams := make(map[string][]string)
-
-	queries := make([]Query, 0)
-
-	if criteria.Uid != nil && len(criteria.Uid.Set) > 0 {
-		setRange := criteria.Uid.Set[0]
-		queries = append(queries, Query{
-			ColumnName: "id",
-			Operator:   "after",
-			Value:      setRange.Start - 1,
-		}, Query{
-			ColumnName: "id",
-			Operator:   "before",
-			Value:      setRange.Stop + 1,
-		})
-	}
-
-	if len(criteria.WithFlags) > 0 {
-		for _, flag := range criteria.WithFlags {
-			switch strings.ToLower(flag) {
-			case "\\deleted":
-				queries = append(queries, Query{
-					ColumnName: "deleted",
-					Operator:   "is",
-					Value:      true,
-				})
-			}
-		}
-	}
-
-	if len(criteria.WithoutFlags) > 0 {
-		for _, flag := range criteria.WithFlags {
-			switch strings.ToLower(flag) {
-			case "\\deleted":
-				queries = append(queries, Query{
-					ColumnName: "deleted",
-					Operator:   "is",
-					Value:      false,
-				})
-			}
-		}
-	}
-
-	if len(criteria.Header) > 0 {
-		for headerName, flag := range criteria.Header {
-			switch strings.ToLower(headerName) {
-			case "Message-ID":
-				queries = append(queries, Query{
-					ColumnName: "message_id",
-					Operator:   "is",
-					Value:      flag,
-				})
-			}
-		}
-	}
-
-	queryJson, _ := json.Marshal(queries)
-
-	searchRequest := api2go.Request{
-		PlainRequest: &httpRequest,
-		QueryParams: map[string][]string{
-			"fields": {
-				"id",
-			},
-			"query": {
-				string(queryJson),
-			},
-		},
+	sequenceIds, err := dimb.dbResource["mail"].GetMailBoxMailIds(dimb.mailBoxId)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("Search query for mail: %v", searchRequest.QueryParams)
-	results, _, _, _, err := dimb.dbResource["mail"].PaginatedFindAllWithoutFilters(searchRequest)
-
+	matchedIds, err := dimb.dbResource["mail"].SearchMailBox(dimb.mailBoxId, criteria, sequenceIds)
 	if err != nil {
 		return nil, err
 	}
 
-	ids := make([]uint32, 0)
-	log.Printf("Mail search results: %v", results)
-	for i, res := range results {
+	sequenceNumbers := make(map[int64]uint32, len(sequenceIds))
+	for i, id := range sequenceIds {
+		sequenceNumbers[id] = uint32(i + 1)
+	}
+
+	ids := make([]uint32, 0, len(matchedIds))
+	for _, id := range matchedIds {
 		if uid {
-			id, err := dimb.dbResource["mail"].GetReferenceIdToId("mail", res["reference_id"].(string))
-			if err != nil {
-				CheckErr(err, "Failed to get id from reference id")
-				continue
-			}
 			ids = append(ids, uint32(id))
-		} else {
-			ids = append(ids, uint32(i+1))
+		} else if sequenceNumber, ok := sequenceNumbers[id]; ok {
+			ids = append(ids, sequenceNumber)
 		}
 	}
+	log.Printf("Mail search in [%v] matched %d mails", dimb.name, len(ids))
 
 	return ids, nil
 }
@@ -543,6 +482,8 @@ func (dimb *DaptinImapMailBox) CreateMessage(flags []string, date time.Time, bod
 	if err != nil {
 		log.Println(utf8.ValidString(parsedmail.TextBody))
 		log.Printf("Failed to insert: %v", parsedmail.TextBody)
+	} else {
+		dimb.notifier.MailBoxCreated(dimb.mailBoxReferenceId)
 	}
 
 	return err
@@ -585,6 +526,16 @@ func (dimb *DaptinImapMailBox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet
 	log.Printf("Update messages flags: [%v] :[%v]: %v", seqset, operation, flags)
 	var mails []map[string]interface{}
 	var err error
+
+	sequence, err := dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, false)
+	if err != nil {
+		return err
+	}
+	sequenceNumbers := make(map[int64]uint32, len(sequence))
+	for i, mail := range sequence {
+		sequenceNumbers[mail.Uid] = uint32(i + 1)
+	}
+
 	for _, seq := range seqset.Set {
 		if uid {
 			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, seq.Start, seq.Stop)
@@ -617,6 +568,8 @@ func (dimb *DaptinImapMailBox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet
 			if err != nil {
 				return err
 			}
+			dimb.notifier.FlagsChanged(dimb.username, dimb.name, dimb.mailBoxReferenceId,
+				sequenceNumbers[mailRow["id"].(int64)], mailRow["id"].(int64), newFlags)
 		}
 	}
 
@@ -679,9 +632,49 @@ func (dimb *DaptinImapMailBox) CopyMessages(uid bool, seqset *imap.SeqSet, dest
 		}
 
 	}
+
+	dimb.notifier.MailBoxCreated(destinationMailBoxId["reference_id"].(string))
 	return err
 }
 
+// MoveMessages moves the specified message(s) to the end of the destination mailbox, they are copied and then
+// expunged from this mailbox, like a COPY followed by an EXPUNGE of only these messages
+func (dimb *DaptinImapMailBox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+
+	before, err := dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, true)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]interface{}, 0)
+	for _, set := range seqset.Set {
+		var mails []map[string]interface{}
+		if uid {
+			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, set.Start, set.Stop)
+		} else {
+			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByOffset(dimb.mailBoxId, set.Start, set.Stop)
+		}
+		if err != nil {
+			return err
+		}
+		for _, mail := range mails {
+			ids = append(ids, mail["id"])
+		}
+	}
+
+	err = dimb.CopyMessages(uid, seqset, dest)
+	if err != nil {
+		return err
+	}
+
+	_, err = dimb.dbResource["mail_box"].DeleteMails(ids)
+	if err != nil {
+		return err
+	}
+
+	return dimb.expunged(before)
+}
+
 // Expunge permanently removes all messages that have the \Deleted flag set
 // from the currently selected mailbox.
 //
@@ -689,11 +682,41 @@ func (dimb *DaptinImapMailBox) CopyMessages(uid bool, seqset *imap.SeqSet, dest
 // via an expunge update.
 func (dimb *DaptinImapMailBox) Expunge() error {
 
+	before, err := dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, true)
+	if err != nil {
+		return err
+	}
+
 	deleteCount, err := dimb.dbResource["mail_box"].ExpungeMailBox(dimb.mailBoxId)
 	log.Printf("%v messages were deleted", deleteCount)
 
 	if err != nil {
 		log.Printf("Failed to expunge mails: %v", err)
+		return err
 	}
-	return err
+	if deleteCount == 0 {
+		return nil
+	}
+
+	return dimb.expunged(before)
+}
+
+// expunged sends the expunge updates for the mails removed since before, an expunge is a change of the
+// mailbox for CONDSTORE clients
+func (dimb *DaptinImapMailBox) expunged(before []MailModSequence) error {
+
+	after, err := dimb.dbResource["mail"].GetMailBoxModSequences(dimb.mailBoxId, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = dimb.dbResource["mail_box"].NextMailBoxModSequence(dimb.mailBoxId)
+	if err != nil {
+		return err
+	}
+
+	// sequence numbers of the remaining mails changed
+	dimb.sequenceToMail = make(map[uint32]*imap.Message)
+	dimb.notifier.Expunged(dimb.username, dimb.name, dimb.mailBoxReferenceId, before, after)
+	return nil
 }
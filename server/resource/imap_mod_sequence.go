@@ -0,0 +1,235 @@
+package resource
+
+import (
+	"github.com/artpar/go-imap"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// MailModSequence is the uid of a mail with the mod-sequence of its last change, in the order of the mailbox
+type MailModSequence struct {
+	Uid         int64 `db:"id"`
+	ModSequence int64 `db:"mod_sequence"`
+}
+
+// NextMailBoxModSequence increments the highest mod-sequence of the mailbox and returns it, every change of a
+// mail or expunge in the mailbox takes a new value
+func (dr *DbResource) NextMailBoxModSequence(mailBoxId int64) (int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Update("mail_box").Set(goqu.Record{
+		"highest_mod_sequence": goqu.L("COALESCE(highest_mod_sequence, 1) + 1"),
+	}).Where(goqu.Ex{"id": mailBoxId}).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return dr.GetMailBoxHighestModSequence(mailBoxId)
+}
+
+// GetMailBoxHighestModSequence is the HIGHESTMODSEQ of the mailbox
+func (dr *DbResource) GetMailBoxHighestModSequence(mailBoxId int64) (int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select(goqu.L("COALESCE(highest_mod_sequence, 1)")).
+		From("mail_box").Where(goqu.Ex{"id": mailBoxId}).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return 0, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	var modSequence int64
+	err = stmt1.QueryRowx(args...).Scan(&modSequence)
+	return modSequence, err
+}
+
+// TouchMailModSequence gives a new or changed mail the next mod-sequence of its mailbox
+func (dr *DbResource) TouchMailModSequence(mailId int64) error {
+
+	query, args, err := statementbuilder.Squirrel.Select("mail_box_id").From("mail").
+		Where(goqu.Ex{"id": mailId}).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return err
+	}
+	var mailBoxId int64
+	err = stmt1.QueryRowx(args...).Scan(&mailBoxId)
+	closeErr := stmt1.Close()
+	if closeErr != nil {
+		log.Errorf("failed to close prepared statement: %v", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	modSequence, err := dr.NextMailBoxModSequence(mailBoxId)
+	if err != nil {
+		return err
+	}
+
+	query, args, err = statementbuilder.Squirrel.Update("mail").
+		Set(goqu.Record{"mod_sequence": modSequence}).
+		Where(goqu.Ex{"id": mailId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// GetMailBoxModSequences lists the mails of the mailbox in sequence order. Mails marked as deleted are only
+// included when they still count for the sequence numbers of the client, as before an expunge
+func (dr *DbResource) GetMailBoxModSequences(mailBoxId int64, includeDeleted bool) ([]MailModSequence, error) {
+
+	where := goqu.Ex{
+		"mail_box_id": mailBoxId,
+	}
+	if !includeDeleted {
+		where["deleted"] = false
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select("id", goqu.L("COALESCE(mod_sequence, 1)").As("mod_sequence")).
+		From("mail").Where(where).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mails := make([]MailModSequence, 0)
+	for rows.Next() {
+		var mail MailModSequence
+		err = rows.StructScan(&mail)
+		if err != nil {
+			return nil, err
+		}
+		mails = append(mails, mail)
+	}
+	return mails, nil
+}
+
+// FilterModSequences splits the mails in the set by their mod-sequence into the ones changed after
+// modSequence and the others. The returned numbers are uids when uid is set and sequence numbers otherwise
+func FilterModSequences(mails []MailModSequence, uid bool, seqset *imap.SeqSet, modSequence int64) ([]uint32, []uint32) {
+
+	changed := make([]uint32, 0)
+	unchanged := make([]uint32, 0)
+
+	lastUid := uint32(0)
+	if len(mails) > 0 {
+		lastUid = uint32(mails[len(mails)-1].Uid)
+	}
+
+	for i, mail := range mails {
+		number := uint32(i + 1)
+		last := uint32(len(mails))
+		if uid {
+			number = uint32(mail.Uid)
+			last = lastUid
+		}
+		if !seqSetContains(seqset, number, last) {
+			continue
+		}
+		if mail.ModSequence > modSequence {
+			changed = append(changed, number)
+		} else {
+			unchanged = append(unchanged, number)
+		}
+	}
+	return changed, unchanged
+}
+
+// VanishedUids are the uids of the known set missing from the mailbox, reported to QRESYNC clients as
+// VANISHED (EARLIER). Uids are shared by all mailboxes so the set may include uids the client never saw
+func VanishedUids(known *imap.SeqSet, mails []MailModSequence, lastUid uint32) *imap.SeqSet {
+
+	present := make([]uint32, len(mails))
+	for i, mail := range mails {
+		present[i] = uint32(mail.Uid)
+	}
+
+	vanished := new(imap.SeqSet)
+	for _, seq := range known.Set {
+		if lastUid == 0 && (seq.Start == 0 || seq.Stop == 0) {
+			continue
+		}
+		start, stop := resolveSeq(seq, lastUid)
+		if start == 0 {
+			start = 1
+		}
+		if stop < start {
+			continue
+		}
+		next := start
+		i := sort.Search(len(present), func(i int) bool {
+			return present[i] >= start
+		})
+		for ; i < len(present) && present[i] <= stop; i++ {
+			if present[i] > next {
+				vanished.AddRange(next, present[i]-1)
+			}
+			next = present[i] + 1
+		}
+		if next <= stop {
+			vanished.AddRange(next, stop)
+		}
+	}
+	return vanished
+}
+
+func seqSetContains(seqset *imap.SeqSet, number uint32, last uint32) bool {
+	if seqset == nil {
+		return true
+	}
+	for _, seq := range seqset.Set {
+		start, stop := resolveSeq(seq, last)
+		if number >= start && number <= stop {
+			return true
+		}
+	}
+	return false
+}
+
+// numbersToSeqSet builds a set from sequence numbers or uids
+func numbersToSeqSet(numbers []uint32) *imap.SeqSet {
+	seqset := new(imap.SeqSet)
+	for _, number := range numbers {
+		seqset.AddNum(number)
+	}
+	return seqset
+}
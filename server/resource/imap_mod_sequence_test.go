@@ -0,0 +1,122 @@
+package resource
+
+import (
+	"github.com/artpar/go-imap"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"sync"
+	"testing"
+)
+
+func TestFilterModSequences(t *testing.T) {
+
+	mails := []MailModSequence{
+		{Uid: 10, ModSequence: 3},
+		{Uid: 12, ModSequence: 8},
+		{Uid: 15, ModSequence: 5},
+		{Uid: 20, ModSequence: 9},
+	}
+
+	all, _ := imap.ParseSeqSet("1:*")
+	changed, unchanged := FilterModSequences(mails, false, all, 5)
+	if imapNumbers(changed) != "2,4" || imapNumbers(unchanged) != "1,3" {
+		t.Errorf("unexpected split by sequence number %v %v", changed, unchanged)
+	}
+
+	uids, _ := imap.ParseSeqSet("11:*")
+	changed, unchanged = FilterModSequences(mails, true, uids, 5)
+	if imapNumbers(changed) != "12,20" || imapNumbers(unchanged) != "15" {
+		t.Errorf("unexpected split by uid %v %v", changed, unchanged)
+	}
+
+	changed, _ = FilterModSequences(mails, true, nil, 0)
+	if len(changed) != 4 {
+		t.Errorf("every mail changed after 0: %v", changed)
+	}
+}
+
+func TestVanishedUids(t *testing.T) {
+
+	mails := []MailModSequence{{Uid: 3}, {Uid: 4}, {Uid: 9}}
+
+	known, _ := imap.ParseSeqSet("1:*")
+	if vanished := VanishedUids(known, mails, 10); vanished.String() != "1:2,5:8,10" {
+		t.Errorf("unexpected vanished uids [%v]", vanished)
+	}
+
+	known, _ = imap.ParseSeqSet("3:5,9")
+	if vanished := VanishedUids(known, mails, 10); vanished.String() != "5" {
+		t.Errorf("unexpected vanished uids [%v]", vanished)
+	}
+
+	if vanished := VanishedUids(known, nil, 0); vanished.String() != "3:5,9" {
+		t.Errorf("known uids of an empty mailbox vanished [%v]", vanished)
+	}
+}
+
+func TestExpungedSequenceNumbers(t *testing.T) {
+
+	before := []MailModSequence{{Uid: 3}, {Uid: 4}, {Uid: 9}, {Uid: 11}}
+	after := []MailModSequence{{Uid: 4}, {Uid: 11}}
+
+	seqNums := ExpungedSequenceNumbers(before, after)
+	if len(seqNums) != 2 || seqNums[0] != 3 || seqNums[1] != 1 {
+		t.Errorf("expunges should be sent from the last mail, got %v", seqNums)
+	}
+}
+
+func TestMailBoxModSequence(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table mail_box (id integer primary key, highest_mod_sequence int)",
+		"create table mail (id integer primary key, mail_box_id int, deleted bool, mod_sequence int)",
+		"insert into mail_box (id, highest_mod_sequence) values (1, null)",
+		"insert into mail (id, mail_box_id, deleted) values (5, 1, 0), (6, 1, 1), (7, 1, 0)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+	dr := &DbResource{
+		db:           db,
+		connection:   db,
+		contextCache: make(map[string]interface{}),
+		contextLock:  &sync.RWMutex{},
+	}
+
+	err = dr.TouchMailModSequence(7)
+	if err != nil {
+		t.Fatalf("failed to touch mail: %v", err)
+	}
+	highest, err := dr.GetMailBoxHighestModSequence(1)
+	if err != nil || highest != 2 {
+		t.Errorf("expected highest mod-sequence 2, got %v %v", highest, err)
+	}
+
+	mails, err := dr.GetMailBoxModSequences(1, false)
+	if err != nil || len(mails) != 2 {
+		t.Fatalf("unexpected mails %v %v", mails, err)
+	}
+	if mails[0].ModSequence != 1 || mails[1].Uid != 7 || mails[1].ModSequence != 2 {
+		t.Errorf("unexpected mod-sequences %v", mails)
+	}
+
+	mails, _ = dr.GetMailBoxModSequences(1, true)
+	if len(mails) != 3 {
+		t.Errorf("deleted mails should be included before an expunge: %v", mails)
+	}
+}
+
+func imapNumbers(numbers []uint32) string {
+	set := new(imap.SeqSet)
+	for _, number := range numbers {
+		set.AddNum(number)
+	}
+	return set.String()
+}
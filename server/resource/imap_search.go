@@ -0,0 +1,402 @@
+package resource
+
+import (
+	"encoding/base64"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const MAIL_SEARCH_TERM_TABLE_NAME = "mail_search_term"
+
+// mailSearchBodyField is the field of terms from the text and html parts, header terms use the lower case
+// header name as the field
+const mailSearchBodyField = "body"
+
+const maxMailSearchTermLength = 100
+const maxMailSearchTerms = 5000
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// MailSearchTokens splits text into lower case words, the same way for indexed mails and searched strings
+func MailSearchTokens(text string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, token := range tokens {
+		if len(token) > maxMailSearchTermLength {
+			tokens[i] = token[:maxMailSearchTermLength]
+		}
+	}
+	return tokens
+}
+
+// MailSearchTerms are the distinct terms of a mail by field, from its headers and its text and html bodies
+func MailSearchTerms(inbound InboundMail) map[string][]string {
+
+	terms := make(map[string][]string)
+	seen := make(map[string]bool)
+	count := 0
+
+	add := func(field string, text string) {
+		for _, token := range MailSearchTokens(text) {
+			key := field + ":" + token
+			if seen[key] || count >= maxMailSearchTerms {
+				continue
+			}
+			seen[key] = true
+			count++
+			terms[field] = append(terms[field], token)
+		}
+	}
+
+	for name, values := range inbound.Header {
+		for _, value := range values {
+			add(strings.ToLower(name), value)
+		}
+	}
+	add(mailSearchBodyField, inbound.Text)
+	add(mailSearchBodyField, htmlTagPattern.ReplaceAllString(inbound.Html, " "))
+
+	return terms
+}
+
+// IndexMailSearchTerms stores the search terms of a new mail and the date from its Date header, so SEARCH
+// can look up words in the body and headers without reading every mail
+func (dr *DbResource) IndexMailSearchTerms(mailId int64, encodedMail string) error {
+
+	raw, err := base64.StdEncoding.DecodeString(encodedMail)
+	if err != nil {
+		return err
+	}
+	inbound, err := ParseInboundMail(raw, "", "")
+	if err != nil {
+		log.Printf("Indexing only the readable parts of mail [%v]: %v", mailId, err)
+	}
+
+	if dateHeader := inbound.HeaderValue("Date"); dateHeader != "" {
+		sentAt, err := mail.ParseDate(dateHeader)
+		if err == nil {
+			query, args, err := statementbuilder.Squirrel.Update("mail").
+				Set(goqu.Record{"sent_at": sentAt}).
+				Where(goqu.Ex{"id": mailId}).ToSQL()
+			if err == nil {
+				_, err = dr.db.Exec(query, args...)
+			}
+			CheckErr(err, "Failed to set sent date of mail [%v]", mailId)
+		}
+	}
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	now := time.Now()
+	rows := make([]interface{}, 0)
+	for field, terms := range MailSearchTerms(inbound) {
+		for _, term := range terms {
+			u, _ := uuid.NewV4()
+			rows = append(rows, goqu.Record{
+				"reference_id":         u.String(),
+				"permission":           auth.DEFAULT_PERMISSION,
+				USER_ACCOUNT_ID_COLUMN: adminUserId,
+				"mail_id":              mailId,
+				"field":                field,
+				"term":                 term,
+				"created_at":           now,
+			})
+		}
+	}
+
+	for start := 0; start < len(rows); start += 200 {
+		end := start + 200
+		if end > len(rows) {
+			end = len(rows)
+		}
+		query, args, err := statementbuilder.Squirrel.Insert(MAIL_SEARCH_TERM_TABLE_NAME).Rows(rows[start:end]...).ToSQL()
+		if err != nil {
+			return err
+		}
+		_, err = dr.db.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMailSearchTerms removes the index of mails which are deleted
+func (dr *DbResource) DeleteMailSearchTerms(mailIds interface{}) error {
+	query, args, err := statementbuilder.Squirrel.Delete(MAIL_SEARCH_TERM_TABLE_NAME).Where(goqu.Ex{
+		"mail_id": mailIds,
+	}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// GetMailBoxMailIds are the ids of the mails visible in the mailbox, the position in the list is the sequence
+// number of the mail
+func (dr *DbResource) GetMailBoxMailIds(mailBoxId int64) ([]int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("id").From("mail").Where(goqu.Ex{
+		"mail_box_id": mailBoxId,
+		"deleted":     false,
+	}).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SearchMailBox returns the ids of the mails in the mailbox matching the criteria, in order
+func (dr *DbResource) SearchMailBox(mailBoxId int64, criteria *imap.SearchCriteria, sequenceIds []int64) ([]int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("id").From("mail").Where(
+		goqu.Ex{
+			"mail_box_id": mailBoxId,
+			"deleted":     false,
+		},
+		MailSearchExpression(criteria, sequenceIds),
+	).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// MailSearchExpression compiles the search criteria into a condition on the mail table. sequenceIds are the
+// mail ids in sequence order, used for sequence number criteria
+func MailSearchExpression(criteria *imap.SearchCriteria, sequenceIds []int64) exp.Expression {
+
+	conditions := make([]exp.Expression, 0)
+	if criteria == nil {
+		return allOf(conditions)
+	}
+
+	if criteria.SeqNum != nil {
+		conditions = append(conditions, sequenceSetExpression(criteria.SeqNum, sequenceIds))
+	}
+	if criteria.Uid != nil {
+		lastUid := int64(0)
+		if len(sequenceIds) > 0 {
+			lastUid = sequenceIds[len(sequenceIds)-1]
+		}
+		conditions = append(conditions, uidSetExpression(criteria.Uid, uint32(lastUid)))
+	}
+
+	if !criteria.Since.IsZero() {
+		conditions = append(conditions, goqu.C("internal_date").Gte(startOfDay(criteria.Since)))
+	}
+	if !criteria.Before.IsZero() {
+		conditions = append(conditions, goqu.C("internal_date").Lt(startOfDay(criteria.Before)))
+	}
+	if !criteria.SentSince.IsZero() {
+		conditions = append(conditions, goqu.C("sent_at").Gte(startOfDay(criteria.SentSince)))
+	}
+	if !criteria.SentBefore.IsZero() {
+		conditions = append(conditions, goqu.C("sent_at").Lt(startOfDay(criteria.SentBefore)))
+	}
+
+	for name, values := range criteria.Header {
+		field := strings.ToLower(name)
+		for _, value := range values {
+			conditions = append(conditions, searchTermsExpression(field, value))
+		}
+	}
+	for _, value := range criteria.Body {
+		conditions = append(conditions, searchTermsExpression(mailSearchBodyField, value))
+	}
+	for _, value := range criteria.Text {
+		conditions = append(conditions, searchTermsExpression("", value))
+	}
+
+	for _, flag := range criteria.WithFlags {
+		conditions = append(conditions, flagExpression(flag))
+	}
+	for _, flag := range criteria.WithoutFlags {
+		conditions = append(conditions, goqu.Func("NOT", flagExpression(flag)))
+	}
+
+	if criteria.Larger > 0 {
+		conditions = append(conditions, goqu.C("size").Gt(criteria.Larger))
+	}
+	if criteria.Smaller > 0 {
+		conditions = append(conditions, goqu.C("size").Lt(criteria.Smaller))
+	}
+
+	for _, not := range criteria.Not {
+		conditions = append(conditions, goqu.Func("NOT", MailSearchExpression(not, sequenceIds)))
+	}
+	for _, or := range criteria.Or {
+		conditions = append(conditions, goqu.Or(
+			MailSearchExpression(or[0], sequenceIds),
+			MailSearchExpression(or[1], sequenceIds),
+		))
+	}
+
+	return allOf(conditions)
+}
+
+// allOf is true without conditions, an empty AND is not valid inside NOT
+func allOf(conditions []exp.Expression) exp.Expression {
+	if len(conditions) == 0 {
+		return goqu.L("1 = 1")
+	}
+	return goqu.And(conditions...)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// resolveSeq replaces * with the last number, "5:*" includes the last mail even when it is below 5
+func resolveSeq(seq imap.Seq, last uint32) (uint32, uint32) {
+	start, stop := seq.Start, seq.Stop
+	if start == 0 {
+		start = last
+	}
+	if stop == 0 {
+		stop = last
+	}
+	if start > stop {
+		start, stop = stop, start
+	}
+	return start, stop
+}
+
+func uidSetExpression(set *imap.SeqSet, lastUid uint32) exp.Expression {
+	ranges := make([]exp.Expression, 0, len(set.Set))
+	for _, seq := range set.Set {
+		start, stop := resolveSeq(seq, lastUid)
+		ranges = append(ranges, goqu.C("id").Between(exp.NewRangeVal(start, stop)))
+	}
+	if len(ranges) == 0 {
+		return goqu.L("1 = 0")
+	}
+	return goqu.Or(ranges...)
+}
+
+func sequenceSetExpression(set *imap.SeqSet, sequenceIds []int64) exp.Expression {
+	ids := make([]int64, 0)
+	for _, seq := range set.Set {
+		start, stop := resolveSeq(seq, uint32(len(sequenceIds)))
+		for number := start; number >= 1 && number <= stop && int(number) <= len(sequenceIds); number++ {
+			ids = append(ids, sequenceIds[number-1])
+		}
+	}
+	if len(ids) == 0 {
+		return goqu.L("1 = 0")
+	}
+	return goqu.C("id").In(ids)
+}
+
+// flagExpression uses the seen, recent and deleted columns for their flags and the flags list for the others
+func flagExpression(flag string) exp.Expression {
+	switch strings.ToLower(flag) {
+	case "\\seen":
+		return goqu.C("seen").IsTrue()
+	case "\\recent":
+		return goqu.C("recent").IsTrue()
+	case "\\deleted":
+		return goqu.C("deleted").IsTrue()
+	}
+
+	// backslashes are an escape character in LIKE on some databases, they are matched by a single character
+	// wildcard instead, as are the wildcards
+	pattern := strings.NewReplacer("\\", "_", "%", "_", "_", "_").Replace(flag)
+	return goqu.Or(
+		goqu.C("flags").Like(pattern),
+		goqu.C("flags").Like(pattern+",%"),
+		goqu.C("flags").Like("%,"+pattern),
+		goqu.C("flags").Like("%,"+pattern+",%"),
+	)
+}
+
+// searchTermsExpression matches mails with a term starting with each word of the value, in the field or in any
+// field when field is empty. An empty header value matches mails which have the header
+func searchTermsExpression(field string, value string) exp.Expression {
+
+	tokens := MailSearchTokens(value)
+	if len(tokens) == 0 {
+		if field == "" || field == mailSearchBodyField {
+			return allOf(nil)
+		}
+		return goqu.Ex{"id": statementbuilder.Squirrel.From(MAIL_SEARCH_TERM_TABLE_NAME).
+			Select("mail_id").Where(goqu.Ex{"field": field})}
+	}
+
+	conditions := make([]exp.Expression, 0, len(tokens))
+	for _, token := range tokens {
+		where := []exp.Expression{goqu.C("term").Like(token + "%")}
+		if field != "" {
+			where = append(where, goqu.C("field").Eq(field))
+		}
+		conditions = append(conditions, goqu.Ex{"id": statementbuilder.Squirrel.From(MAIL_SEARCH_TERM_TABLE_NAME).
+			Select("mail_id").Where(where...)})
+	}
+	return allOf(conditions)
+}
@@ -0,0 +1,143 @@
+package resource
+
+import (
+	"encoding/base64"
+	"github.com/artpar/go-imap"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMailSearchTestResource(t *testing.T) *DbResource {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table mail (id integer primary key, mail_box_id int, deleted bool, seen bool, recent bool, " +
+			"flags varchar(500), size int, internal_date timestamp, sent_at timestamp)",
+		"create table mail_search_term (id integer primary key, reference_id varchar(40), permission int, " +
+			"user_account_id int, mail_id int, field varchar(100), term varchar(100), created_at timestamp)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+
+	cruds := make(map[string]*DbResource)
+	cruds["mail"] = &DbResource{
+		db:           db,
+		connection:   db,
+		Cruds:        cruds,
+		contextCache: make(map[string]interface{}),
+		contextLock:  &sync.RWMutex{},
+	}
+	return cruds["mail"]
+}
+
+func addSearchTestMail(t *testing.T, dr *DbResource, id int64, flags string, size int, internalDate string, mail string) {
+	_, err := dr.db.Exec("insert into mail (id, mail_box_id, deleted, seen, recent, flags, size, internal_date) values (?, 1, 0, ?, 0, ?, ?, ?)",
+		id, flags == "\\Seen", flags, size, internalDate)
+	if err != nil {
+		t.Fatalf("Failed to insert mail: %v", err)
+	}
+	err = dr.IndexMailSearchTerms(id, base64.StdEncoding.EncodeToString([]byte(mail)))
+	if err != nil {
+		t.Fatalf("Failed to index mail: %v", err)
+	}
+}
+
+func TestMailSearchTokens(t *testing.T) {
+	tokens := MailSearchTokens("Invoice #1001 from Bob <bob@example.org>")
+	expected := []string{"invoice", "1001", "from", "bob", "bob", "example", "org"}
+	if len(tokens) != len(expected) {
+		t.Fatalf("unexpected tokens %v", tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("unexpected tokens %v", tokens)
+		}
+	}
+}
+
+func TestSearchMailBox(t *testing.T) {
+
+	dr := newMailSearchTestResource(t)
+
+	addSearchTestMail(t, dr, 1, "\\Seen", 100, "2020-01-10 10:00:00",
+		"From: alice@example.com\r\nTo: bob@example.org\r\nSubject: Invoice 1001\r\nDate: Fri, 10 Jan 2020 10:00:00 +0000\r\n\r\nPlease pay the invoice\r\n")
+	addSearchTestMail(t, dr, 2, "\\Flagged", 5000, "2020-02-10 10:00:00",
+		"From: carol@example.net\r\nTo: bob@example.org\r\nSubject: Lunch\r\nX-Priority: 1\r\nContent-Type: text/html\r\n\r\n<p>Lunch on <b>Friday</b>?</p>\r\n")
+	addSearchTestMail(t, dr, 3, "", 300, "2020-03-10 10:00:00",
+		"From: alice@example.com\r\nTo: dave@example.org\r\nSubject: Re: Lunch\r\n\r\nSure, see you friday\r\n")
+
+	sequenceIds, err := dr.GetMailBoxMailIds(1)
+	if err != nil || len(sequenceIds) != 3 {
+		t.Fatalf("failed to get mailbox ids: %v %v", sequenceIds, err)
+	}
+
+	since, _ := time.Parse("2006-01-02", "2020-02-01")
+	uidSet, _ := imap.ParseSeqSet("2:*")
+	seqSet, _ := imap.ParseSeqSet("1,3")
+
+	cases := []struct {
+		name     string
+		criteria *imap.SearchCriteria
+		expected []int64
+	}{
+		{"all", &imap.SearchCriteria{}, []int64{1, 2, 3}},
+		{"from", &imap.SearchCriteria{Header: textproto.MIMEHeader{"From": {"alice"}}}, []int64{1, 3}},
+		{"subject", &imap.SearchCriteria{Header: textproto.MIMEHeader{"Subject": {"lunch"}}}, []int64{2, 3}},
+		{"header exists", &imap.SearchCriteria{Header: textproto.MIMEHeader{"X-Priority": {""}}}, []int64{2}},
+		{"body", &imap.SearchCriteria{Body: []string{"friday"}}, []int64{2, 3}},
+		{"body prefix", &imap.SearchCriteria{Body: []string{"invo"}}, []int64{1}},
+		{"text", &imap.SearchCriteria{Text: []string{"dave"}}, []int64{3}},
+		{"since", &imap.SearchCriteria{Since: since}, []int64{2, 3}},
+		{"before", &imap.SearchCriteria{Before: since}, []int64{1}},
+		{"sent before", &imap.SearchCriteria{SentBefore: since}, []int64{1}},
+		{"seen", &imap.SearchCriteria{WithFlags: []string{"\\Seen"}}, []int64{1}},
+		{"flagged", &imap.SearchCriteria{WithFlags: []string{"\\Flagged"}}, []int64{2}},
+		{"unflagged", &imap.SearchCriteria{WithoutFlags: []string{"\\Flagged"}}, []int64{1, 3}},
+		{"larger", &imap.SearchCriteria{Larger: 200}, []int64{2, 3}},
+		{"smaller", &imap.SearchCriteria{Smaller: 200}, []int64{1}},
+		{"uid", &imap.SearchCriteria{Uid: uidSet}, []int64{2, 3}},
+		{"sequence", &imap.SearchCriteria{SeqNum: seqSet}, []int64{1, 3}},
+		{"not", &imap.SearchCriteria{Not: []*imap.SearchCriteria{{Body: []string{"friday"}}}}, []int64{1}},
+		{"or", &imap.SearchCriteria{Or: [][2]*imap.SearchCriteria{{
+			{Header: textproto.MIMEHeader{"To": {"dave"}}},
+			{Larger: 1000},
+		}}}, []int64{2, 3}},
+	}
+
+	for _, c := range cases {
+		ids, err := dr.SearchMailBox(1, c.criteria, sequenceIds)
+		if err != nil {
+			t.Errorf("[%v] search failed: %v", c.name, err)
+			continue
+		}
+		if len(ids) != len(c.expected) {
+			t.Errorf("[%v] expected %v, got %v", c.name, c.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.expected[i] {
+				t.Errorf("[%v] expected %v, got %v", c.name, c.expected, ids)
+				break
+			}
+		}
+	}
+
+	err = dr.DeleteMailSearchTerms([]interface{}{int64(1)})
+	if err != nil {
+		t.Fatalf("failed to delete terms: %v", err)
+	}
+	ids, _ := dr.SearchMailBox(1, &imap.SearchCriteria{Body: []string{"invoice"}}, sequenceIds)
+	if len(ids) != 0 {
+		t.Errorf("deleted terms should not match: %v", ids)
+	}
+}
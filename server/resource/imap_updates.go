@@ -0,0 +1,297 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go-imap/backend"
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// mailTopicName is the olric topic of the mail table, the event generator publishes api changes of mails on it
+const mailTopicName = "mail"
+
+// imapUpdateTimeout is how long a command waits for its updates to be written to the connected clients
+const imapUpdateTimeout = 5 * time.Second
+
+// ImapMailBoxNotifier pushes changes of mailboxes to the connected imap clients as unilateral updates, which
+// IDLE clients receive right away. Changes made by this node are pushed directly and published on the mail
+// topic for the other nodes of the cluster, mails delivered by the smtp server arrive through the topic
+type ImapMailBoxNotifier struct {
+	cruds   map[string]*DbResource
+	updates chan backend.Update
+	topic   *olric.DTopic
+	nodeId  string
+	// listenerId is the listener added on topic when listening is set, removed by Close
+	listenerId uint64
+	listening  bool
+	closeOnce  sync.Once
+}
+
+// NewImapMailBoxNotifier listens on the mail topic when olric is available
+func NewImapMailBoxNotifier(cruds map[string]*DbResource) *ImapMailBoxNotifier {
+
+	notifier := &ImapMailBoxNotifier{
+		cruds:   cruds,
+		updates: make(chan backend.Update, 100),
+		nodeId:  NewEventId(),
+	}
+
+	olricDb := cruds["mail"].OlricDb
+	if olricDb == nil {
+		return notifier
+	}
+
+	topic, err := olricDb.NewDTopic(mailTopicName, 4, 1)
+	if CheckErr(err, "Failed to open mail topic for imap updates") {
+		return notifier
+	}
+	notifier.topic = topic
+
+	notifier.listenerId, err = topic.AddListener(notifier.onMailEvent)
+	notifier.listening = !CheckErr(err, "Failed to listen for mail updates")
+	return notifier
+}
+
+// Close stops listening on the mail topic, the notifier of the imap server started after a restart listens
+// instead
+func (n *ImapMailBoxNotifier) Close() {
+	if n == nil || !n.listening {
+		return
+	}
+	n.closeOnce.Do(func() {
+		err := n.topic.RemoveListener(n.listenerId)
+		CheckErr(err, "Failed to remove imap mail update listener")
+	})
+}
+
+// Updates is read by the imap server, the backend is a backend.BackendUpdater through it
+func (n *ImapMailBoxNotifier) Updates() <-chan backend.Update {
+	return n.updates
+}
+
+// PublishMailBoxChange publishes an event about a mailbox of any mail account on the mail topic, the smtp
+// server uses it for delivered mails
+func (dr *DbResource) PublishMailBoxChange(eventType string, eventData map[string]interface{}) error {
+
+	if dr.OlricDb == nil {
+		return nil
+	}
+	topic, err := dr.OlricDb.NewDTopic(mailTopicName, 4, 1)
+	if err != nil {
+		return err
+	}
+	return topic.Publish(EventMessage{
+		EventId:       NewEventId(),
+		MessageSource: "imap",
+		EventType:     eventType,
+		ObjectType:    "mail",
+		EventData:     eventData,
+	})
+}
+
+// MailBoxCreated tells the clients of the mailbox about new mails
+func (n *ImapMailBoxNotifier) MailBoxCreated(mailBoxReferenceId string) {
+	if n == nil {
+		return
+	}
+	err := n.cruds["mail"].PublishMailBoxChange("create", map[string]interface{}{
+		"mail_box_id": mailBoxReferenceId,
+	})
+	CheckErr(err, "Failed to publish new mails of mailbox [%v]", mailBoxReferenceId)
+}
+
+// FlagsChanged sends the new flags of a mail to the clients of the mailbox, including the current one unless
+// it asked for a silent store
+func (n *ImapMailBoxNotifier) FlagsChanged(username string, mailBox string, mailBoxReferenceId string, seqNum uint32, uid int64, flags []string) {
+	if n == nil {
+		return
+	}
+
+	n.push(n.flagsUpdate(username, mailBox, seqNum, uid, flags), true)
+	n.publish("update", map[string]interface{}{
+		"mail_box_id":     mailBoxReferenceId,
+		"id":              uid,
+		"sequence_number": seqNum,
+		"flags":           flags,
+	})
+}
+
+// Expunged sends an EXPUNGE for every mail of before which is not in after, from the last one to the first
+// so the sequence numbers stay valid while the client applies them
+func (n *ImapMailBoxNotifier) Expunged(username string, mailBox string, mailBoxReferenceId string, before []MailModSequence, after []MailModSequence) {
+	if n == nil {
+		return
+	}
+
+	for _, seqNum := range ExpungedSequenceNumbers(before, after) {
+		n.push(&backend.ExpungeUpdate{
+			Update: backend.NewUpdate(username, mailBox),
+			SeqNum: seqNum,
+		}, true)
+		n.publish("delete", map[string]interface{}{
+			"mail_box_id":     mailBoxReferenceId,
+			"sequence_number": seqNum,
+		})
+	}
+}
+
+// ExpungedSequenceNumbers are the sequence numbers of the removed mails in decreasing order
+func ExpungedSequenceNumbers(before []MailModSequence, after []MailModSequence) []uint32 {
+
+	remaining := make(map[int64]bool, len(after))
+	for _, mail := range after {
+		remaining[mail.Uid] = true
+	}
+
+	seqNums := make([]uint32, 0)
+	for i := len(before) - 1; i >= 0; i-- {
+		if !remaining[before[i].Uid] {
+			seqNums = append(seqNums, uint32(i+1))
+		}
+	}
+	return seqNums
+}
+
+func (n *ImapMailBoxNotifier) flagsUpdate(username string, mailBox string, seqNum uint32, uid int64, flags []string) backend.Update {
+	message := imap.NewMessage(seqNum, []imap.FetchItem{imap.FetchFlags, imap.FetchUid})
+	message.Flags = flags
+	message.Uid = uint32(uid)
+	return &backend.MessageUpdate{
+		Update:  backend.NewUpdate(username, mailBox),
+		Message: message,
+	}
+}
+
+// push hands an update to the imap server, waiting until the clients got it when a command of this node
+// caused it so the updates are written before the command completes
+func (n *ImapMailBoxNotifier) push(update backend.Update, wait bool) {
+	done := update.Done()
+	select {
+	case n.updates <- update:
+	case <-time.After(imapUpdateTimeout):
+		log.Warnf("Dropped imap update for [%v] [%v]", update.Username(), update.Mailbox())
+		return
+	}
+	if !wait {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(imapUpdateTimeout):
+	}
+}
+
+func (n *ImapMailBoxNotifier) publish(eventType string, eventData map[string]interface{}) {
+	if n.topic == nil {
+		return
+	}
+	eventData["imap_node"] = n.nodeId
+	err := n.topic.Publish(EventMessage{
+		EventId:       NewEventId(),
+		MessageSource: "imap",
+		EventType:     eventType,
+		ObjectType:    "mail",
+		EventData:     eventData,
+	})
+	CheckErr(err, "Failed to publish imap %v", eventType)
+}
+
+func (n *ImapMailBoxNotifier) onMailEvent(message olric.DTopicMessage) {
+
+	eventMessage, ok := message.Message.(EventMessage)
+	if !ok || eventMessage.ObjectType != "mail" {
+		return
+	}
+	if eventMessage.EventData["imap_node"] == n.nodeId {
+		// already pushed when the change was made
+		return
+	}
+	if eventMessage.EventType != "create" && eventMessage.EventData["sequence_number"] == nil {
+		// changes made through the api are picked up by clients on their next command
+		return
+	}
+
+	mailBoxId, mailBoxName, username, err := n.cruds["mail_box"].GetMailBoxOwner(eventMessage.EventData["mail_box_id"])
+	if err != nil {
+		log.Printf("Skipping imap update for mail event [%v]: %v", eventMessage.EventType, err)
+		return
+	}
+
+	switch eventMessage.EventType {
+	case "create":
+		status, err := n.cruds["mail_box"].GetMailBoxStatus(0, mailBoxId)
+		if CheckErr(err, "Failed to get status of mailbox [%v]", mailBoxName) {
+			return
+		}
+		update := imap.NewMailboxStatus(mailBoxName, []imap.StatusItem{imap.StatusMessages, imap.StatusRecent})
+		update.Messages = status.Messages
+		update.Recent = status.Recent
+		n.push(&backend.MailboxUpdate{
+			Update:        backend.NewUpdate(username, mailBoxName),
+			MailboxStatus: update,
+		}, false)
+	case "update":
+		seqNum, _ := eventMessage.EventData["sequence_number"].(uint32)
+		uid, _ := eventMessage.EventData["id"].(int64)
+		flags, ok := eventMessage.EventData["flags"].([]string)
+		if seqNum == 0 || !ok {
+			return
+		}
+		n.push(n.flagsUpdate(username, mailBoxName, seqNum, uid, flags), false)
+	case "delete":
+		seqNum, _ := eventMessage.EventData["sequence_number"].(uint32)
+		if seqNum == 0 {
+			return
+		}
+		n.push(&backend.ExpungeUpdate{
+			Update: backend.NewUpdate(username, mailBoxName),
+			SeqNum: seqNum,
+		}, false)
+	}
+}
+
+// GetMailBoxOwner finds the mailbox by id or reference id with the username of its mail account, which is the
+// username of the imap clients using it
+func (dr *DbResource) GetMailBoxOwner(mailBox interface{}) (int64, string, string, error) {
+
+	where := goqu.Ex{}
+	switch mailBoxValue := mailBox.(type) {
+	case string:
+		where["mb.reference_id"] = mailBoxValue
+	case int64:
+		where["mb.id"] = mailBoxValue
+	default:
+		return 0, "", "", fmt.Errorf("invalid mailbox [%v]", mailBox)
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select("mb.id", "mb.name", "ma.username").
+		From(goqu.T("mail_box").As("mb")).
+		InnerJoin(goqu.T("mail_account").As("ma"), goqu.On(goqu.Ex{"ma.id": goqu.I("mb.mail_account_id")})).
+		Where(where).ToSQL()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	var mailBoxId int64
+	var mailBoxName string
+	var username string
+	err = stmt1.QueryRowx(args...).Scan(&mailBoxId, &mailBoxName, &username)
+	return mailBoxId, mailBoxName, username, err
+}
@@ -19,6 +19,7 @@ type DaptinImapUser struct {
 	mailAccountId          int64
 	mailAccountReferenceId string
 	sessionUser            *auth.SessionUser
+	notifier               *ImapMailBoxNotifier
 }
 
 // User represents a user in the mail storage system. A user operation always
@@ -52,6 +53,8 @@ func (diu *DaptinImapUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, er
 		mb := DaptinImapMailBox{
 			dbResource:         diu.dbResource,
 			name:               box["name"].(string),
+			username:           diu.username,
+			notifier:           diu.notifier,
 			sessionUser:        diu.sessionUser,
 			mailBoxReferenceId: box["reference_id"].(string),
 			sequenceToMail:     make(map[uint32]*imap.Message),
@@ -193,6 +196,8 @@ func (diu *DaptinImapUser) GetMailbox(name string) (backend.Mailbox, error) {
 	mb := DaptinImapMailBox{
 		dbResource:         diu.dbResource,
 		name:               box[0]["name"].(string),
+		username:           diu.username,
+		notifier:           diu.notifier,
 		sessionUser:        diu.sessionUser,
 		mailBoxId:          box[0]["id"].(int64),
 		mailAccountId:      diu.mailAccountId,
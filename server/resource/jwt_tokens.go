@@ -0,0 +1,416 @@
+package resource
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/doug-martin/goqu/v9"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const REFRESH_TOKEN_TABLE_NAME = "refresh_token"
+const REVOKED_TOKEN_TABLE_NAME = "revoked_token"
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// JwtTokenGenerator issues the access tokens and refresh tokens given out on sign in
+type JwtTokenGenerator struct {
+	Keys                 *auth.JwtSigningKeys
+	Issuer               string
+	TokenLifeTime        time.Duration
+	RefreshTokenLifeTime time.Duration
+}
+
+// LoadJwtSigningKeys creates the signing keys for the algorithm set in jwt.signing.algorithm. For RS256 and ES256
+// a private key is generated on first use and kept encrypted in jwt.signing.private_key. An unsupported algorithm or
+// a key which cannot be loaded is an error, falling back to the secret would break every external verifier
+func LoadJwtSigningKeys(configStore *ConfigStore) (*auth.JwtSigningKeys, error) {
+
+	secret, err := configStore.GetConfigValueFor("jwt.secret", "backend")
+	if err != nil {
+		return nil, fmt.Errorf("no jwt.secret set: %v", err)
+	}
+
+	algorithm, err := configStore.GetConfigValueFor("jwt.signing.algorithm", "backend")
+	if err != nil {
+		algorithm = auth.JwtAlgorithmHS256
+		err = configStore.SetConfigValueFor("jwt.signing.algorithm", algorithm, "backend")
+		CheckErr(err, "Failed to store default jwt signing algorithm")
+	}
+	algorithm = strings.ToUpper(strings.TrimSpace(algorithm))
+
+	if algorithm == auth.JwtAlgorithmHS256 {
+		return auth.NewJwtSigningKeys(algorithm, []byte(secret), "")
+	}
+
+	keys, err := loadJwtPrivateKeys(configStore, algorithm, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %v jwt signing key: %v", algorithm, err)
+	}
+	return keys, nil
+}
+
+// loadJwtPrivateKeys creates the keys for an asymmetric algorithm from the stored private key, generating and
+// storing a new private key when there is none
+func loadJwtPrivateKeys(configStore *ConfigStore, algorithm string, secret string) (*auth.JwtSigningKeys, error) {
+
+	if algorithm != auth.JwtAlgorithmRS256 && algorithm != auth.JwtAlgorithmES256 {
+		return nil, fmt.Errorf("unsupported jwt signing algorithm [%v], expected %v, %v or %v", algorithm,
+			auth.JwtAlgorithmHS256, auth.JwtAlgorithmRS256, auth.JwtAlgorithmES256)
+	}
+
+	encryptionSecret, err := configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return nil, fmt.Errorf("no encryption.secret to store the private key: %v", err)
+	}
+
+	privateKeyPem := ""
+	encryptedPrivateKey, err := configStore.GetConfigValueFor("jwt.signing.private_key", "backend")
+	if err == nil {
+		privateKeyPem, err = Decrypt([]byte(encryptionSecret), encryptedPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt jwt signing key: %v", err)
+		}
+	}
+
+	keys, err := auth.NewJwtSigningKeys(algorithm, []byte(secret), privateKeyPem)
+	if err == nil || privateKeyPem != "" {
+		return keys, err
+	}
+
+	log.Printf("Generating a new %v key for signing jwt tokens", algorithm)
+	privateKeyPem, err = auth.GenerateJwtPrivateKeyPem(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	encryptedPrivateKey, err = Encrypt([]byte(encryptionSecret), privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	err = configStore.SetConfigValueFor("jwt.signing.private_key", encryptedPrivateKey, "backend")
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewJwtSigningKeys(algorithm, []byte(secret), privateKeyPem)
+}
+
+// NewJwtTokenGenerator reads the signing keys, the issuer and the token life times from the config
+func NewJwtTokenGenerator(configStore *ConfigStore) (*JwtTokenGenerator, error) {
+
+	keys, err := LoadJwtSigningKeys(configStore)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenLifeTimeHours, err := configStore.GetConfigIntValueFor("jwt.token.life.hours", "backend")
+	CheckErr(err, "No default jwt token life time set in configuration")
+	if err != nil {
+		err = configStore.SetConfigIntValueFor("jwt.token.life.hours", 24*3, "backend")
+		CheckErr(err, "Failed to store default jwt token life time")
+		tokenLifeTimeHours = 24 * 3 // 3 days
+	}
+
+	refreshTokenLifeTimeHours, err := configStore.GetConfigIntValueFor("jwt.refresh_token.life.hours", "backend")
+	if err != nil {
+		err = configStore.SetConfigIntValueFor("jwt.refresh_token.life.hours", 24*30, "backend")
+		CheckErr(err, "Failed to store default refresh token life time")
+		refreshTokenLifeTimeHours = 24 * 30 // 30 days
+	}
+
+	jwtTokenIssuer, err := configStore.GetConfigValueFor("jwt.token.issuer", "backend")
+	CheckErr(err, "No default jwt token issuer set")
+	if err != nil {
+		uid, _ := uuid.NewV4()
+		jwtTokenIssuer = "daptin-" + uid.String()[0:6]
+		err = configStore.SetConfigValueFor("jwt.token.issuer", jwtTokenIssuer, "backend")
+		CheckErr(err, "Failed to store jwt token issuer")
+	}
+
+	return &JwtTokenGenerator{
+		Keys:                 keys,
+		Issuer:               jwtTokenIssuer,
+		TokenLifeTime:        time.Duration(tokenLifeTimeHours) * time.Hour,
+		RefreshTokenLifeTime: time.Duration(refreshTokenLifeTimeHours) * time.Hour,
+	}, nil
+}
+
+// AccessToken signs a new token for the user account row, returns the token with its jti and expiry
+func (g *JwtTokenGenerator) AccessToken(userAccount map[string]interface{}) (string, string, time.Time, error) {
+
+	u, _ := uuid.NewV4()
+	timeNow := time.Now()
+	expiresAt := timeNow.Add(g.TokenLifeTime)
+
+	tokenString, err := g.Keys.SignClaims(jwt.MapClaims{
+		"email": userAccount["email"],
+		"sub":   userAccount["reference_id"],
+		"name":  userAccount["name"],
+		"nbf":   timeNow.Unix(),
+		"exp":   expiresAt.Unix(),
+		"iss":   g.Issuer,
+		"iat":   timeNow.Unix(),
+		"jti":   u.String(),
+	})
+
+	return tokenString, u.String(), expiresAt, err
+}
+
+// HashRefreshToken is the value kept in the refresh_token table, the token itself is never stored
+func HashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func newRandomTokenValue() (string, error) {
+	value := make([]byte, 32)
+	_, err := rand.Read(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// IssueRefreshToken creates a refresh token for the user, tied to the access token it was issued with. Tokens
+// rotated from the same sign in share the familyId, an empty familyId starts a new family
+func (dr *DbResource) IssueRefreshToken(userId int64, device string, familyId string, accessTokenJti string,
+	accessTokenExpiresAt time.Time, lifeTime time.Duration) (string, string, error) {
+
+	token, err := newRandomTokenValue()
+	if err != nil {
+		return "", "", err
+	}
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", "", err
+	}
+	if familyId == "" {
+		familyId = u.String()
+	}
+	now := time.Now()
+
+	record := goqu.Record{
+		"reference_id":            u.String(),
+		"permission":              auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN:    userId,
+		"token_hash":              HashRefreshToken(token),
+		"family_id":               familyId,
+		"device":                  device,
+		"access_token_jti":        accessTokenJti,
+		"access_token_expires_at": accessTokenExpiresAt,
+		"expires_at":              now.Add(lifeTime),
+		"created_at":              now,
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(REFRESH_TOKEN_TABLE_NAME).Rows(record).ToSQL()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, u.String(), nil
+}
+
+// UseRefreshToken revokes the refresh token and the access token issued with it, and returns the row so a new
+// pair can be issued in the same family. A token which was already rotated is a sign of theft, every token of
+// its family is revoked then
+func (dr *DbResource) UseRefreshToken(token string) (map[string]interface{}, error) {
+
+	query, args, err := statementbuilder.Squirrel.
+		Select("reference_id", USER_ACCOUNT_ID_COLUMN, "family_id", "device", "access_token_jti",
+			"access_token_expires_at", "expires_at", "revoked_at").
+		From(REFRESH_TOKEN_TABLE_NAME).
+		Where(goqu.Ex{"token_hash": HashRefreshToken(token)}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{})
+	err = dr.db.QueryRowx(query, args...).MapScan(row)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	for key, value := range row {
+		if bytesValue, ok := value.([]byte); ok {
+			row[key] = string(bytesValue)
+		}
+	}
+
+	if row["revoked_at"] != nil {
+		log.Warnf("Revoked refresh token [%v] was used again, revoking its family", row["reference_id"])
+		_, err = dr.RevokeRefreshTokens(goqu.Ex{"family_id": row["family_id"]})
+		CheckErr(err, "Failed to revoke refresh token family [%v]", row["family_id"])
+		return nil, ErrInvalidRefreshToken
+	}
+
+	expiresAt, ok := auth.ParseTimeValue(row["expires_at"])
+	if !ok || time.Now().After(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	revoked, err := dr.RevokeRefreshTokens(goqu.Ex{"reference_id": row["reference_id"]})
+	if err != nil {
+		return nil, err
+	}
+	if revoked == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return row, nil
+}
+
+// RevokeRefreshTokens revokes the active refresh tokens matching the where clause along with the access tokens
+// they were issued with. Returns the number of refresh tokens revoked
+func (dr *DbResource) RevokeRefreshTokens(where goqu.Ex) (int, error) {
+
+	where["revoked_at"] = nil
+	query, args, err := statementbuilder.Squirrel.
+		Select("reference_id", "access_token_jti", "access_token_expires_at").
+		From(REFRESH_TOKEN_TABLE_NAME).Where(where).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := dr.db.Queryx(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	tokens := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			break
+		}
+		tokens = append(tokens, row)
+	}
+	closeErr := rows.Close()
+	CheckErr(closeErr, "Failed to close refresh token rows")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	revoked := 0
+	for _, token := range tokens {
+
+		// only the request which flips revoked_at gets to count the token, concurrent uses of it do not
+		query, args, err = statementbuilder.Squirrel.Update(REFRESH_TOKEN_TABLE_NAME).
+			Set(goqu.Record{"revoked_at": now, "updated_at": now}).
+			Where(goqu.Ex{
+				"reference_id": fmt.Sprintf("%s", token["reference_id"]),
+				"revoked_at":   nil,
+			}).ToSQL()
+		if err != nil {
+			return revoked, err
+		}
+		result, err := dr.db.Exec(query, args...)
+		if err != nil {
+			return revoked, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil || affected == 0 {
+			continue
+		}
+		revoked += 1
+
+		jti := fmt.Sprintf("%s", token["access_token_jti"])
+		expiresAt, ok := auth.ParseTimeValue(token["access_token_expires_at"])
+		if ok && jti != "" {
+			err = dr.RevokeTokenId(jti, expiresAt)
+			CheckErr(err, "Failed to revoke access token [%v]", jti)
+		}
+	}
+
+	return revoked, nil
+}
+
+// MarkRefreshTokenReplaced records which token a rotated refresh token was replaced with
+func (dr *DbResource) MarkRefreshTokenReplaced(referenceId string, replacedBy string) error {
+	query, args, err := statementbuilder.Squirrel.Update(REFRESH_TOKEN_TABLE_NAME).
+		Set(goqu.Record{"replaced_by": replacedBy}).
+		Where(goqu.Ex{"reference_id": referenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// RevokeTokenId denies the access token with the jti until it expires, the denial is kept in revoked_token so
+// it outlives a restart
+func (dr *DbResource) RevokeTokenId(jti string, expiresAt time.Time) error {
+
+	if time.Now().After(expiresAt) {
+		return nil
+	}
+
+	u, _ := uuid.NewV4()
+	query, args, err := statementbuilder.Squirrel.Insert(REVOKED_TOKEN_TABLE_NAME).Rows(goqu.Record{
+		"reference_id": u.String(),
+		"permission":   auth.DEFAULT_PERMISSION,
+		"jti":          jti,
+		"expires_at":   expiresAt,
+		"created_at":   time.Now(),
+	}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	return auth.RevokeTokenId(jti, expiresAt)
+}
+
+// LoadRevokedTokens puts the revoked tokens which have not expired yet in the cache checked by the jwt middleware
+func LoadRevokedTokens(db database.DatabaseConnection) error {
+
+	query, args, err := statementbuilder.Squirrel.Select("jti", "expires_at").
+		From(REVOKED_TOKEN_TABLE_NAME).
+		Where(goqu.C("expires_at").Gt(time.Now())).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = rows.Close()
+		CheckErr(err, "Failed to close revoked token rows")
+	}()
+
+	count := 0
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		err = rows.Scan(&jti, &expiresAt)
+		if err != nil {
+			return err
+		}
+		err = auth.RevokeTokenId(jti, expiresAt)
+		if err != nil {
+			return err
+		}
+		count += 1
+	}
+	log.Printf("Loaded %d revoked tokens", count)
+
+	return nil
+}
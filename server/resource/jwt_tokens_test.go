@@ -0,0 +1,275 @@
+package resource
+
+import (
+	"context"
+	"github.com/buraksezer/olric"
+	olricConfig "github.com/buraksezer/olric/config"
+	"github.com/daptin/daptin/server/auth"
+	jwtmiddleware "github.com/daptin/daptin/server/jwt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoadJwtSigningKeysInvalidAlgorithm(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	configStore, err := NewConfigStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create config store: %v", err)
+	}
+
+	_, err = LoadJwtSigningKeys(configStore)
+	if err == nil {
+		t.Errorf("expected an error when jwt.secret is not set")
+	}
+
+	err = configStore.SetConfigValueFor("jwt.secret", "secret", "backend")
+	if err != nil {
+		t.Fatalf("Failed to set jwt.secret: %v", err)
+	}
+
+	for _, algorithm := range []string{"PS512", "none"} {
+		err = configStore.SetConfigValueFor("jwt.signing.algorithm", algorithm, "backend")
+		if err != nil {
+			t.Fatalf("Failed to set jwt.signing.algorithm: %v", err)
+		}
+		keys, err := LoadJwtSigningKeys(configStore)
+		if err == nil {
+			t.Errorf("expected [%v] to be rejected, got %v", algorithm, keys)
+		}
+	}
+
+	// the algorithm is matched regardless of case, an RS256 key needs the encryption secret to be stored
+	err = configStore.SetConfigValueFor("jwt.signing.algorithm", "rs256", "backend")
+	if err != nil {
+		t.Fatalf("Failed to set jwt.signing.algorithm: %v", err)
+	}
+	keys, err := LoadJwtSigningKeys(configStore)
+	if err == nil {
+		t.Errorf("expected rs256 without an encryption secret to fail, got %v", keys)
+	}
+	err = configStore.SetConfigValueFor("encryption.secret", "0123456789abcdef0123456789abcdef", "backend")
+	if err != nil {
+		t.Fatalf("Failed to set encryption.secret: %v", err)
+	}
+	keys, err = LoadJwtSigningKeys(configStore)
+	if err != nil || keys.Method.Alg() != auth.JwtAlgorithmRS256 {
+		t.Errorf("expected rs256 to sign tokens with %v, got %v, %v", auth.JwtAlgorithmRS256, keys, err)
+	}
+}
+
+func freeTestPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startTestRevokedTokens starts a local olric node to hold the revoked token ids the jwt middleware checks
+func startTestRevokedTokens(t *testing.T) func() {
+	config := olricConfig.New("local")
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = freeTestPort(t)
+	config.MemberlistConfig.BindAddr = "127.0.0.1"
+	config.MemberlistConfig.BindPort = freeTestPort(t)
+	config.MemberlistConfig.AdvertisePort = config.MemberlistConfig.BindPort
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	started := make(chan struct{})
+	config.Started = func() {
+		close(started)
+	}
+	olricDb, err := olric.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create olric: %v", err)
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- olricDb.Start()
+	}()
+	select {
+	case <-started:
+	case err = <-failed:
+		t.Fatalf("Failed to start olric: %v", err)
+	case <-time.After(30 * time.Second):
+		t.Fatalf("olric did not start")
+	}
+
+	revokedTokens, err := olricDb.NewDMap("revoked-tokens")
+	if err != nil {
+		t.Fatalf("Failed to create revoked tokens map: %v", err)
+	}
+	previous := jwtmiddleware.RevokedTokens
+	jwtmiddleware.RevokedTokens = revokedTokens
+
+	return func() {
+		jwtmiddleware.RevokedTokens = previous
+		err := olricDb.Shutdown(context.Background())
+		CheckErr(err, "Failed to stop olric")
+	}
+}
+
+func newTestRefreshTokenResource(t *testing.T) *DbResource {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table user_account (id integer primary key, email varchar(100))",
+		"create table usergroup (id integer primary key)",
+		"insert into user_account (email) values ('user@example.com')",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	config := &CmsConfig{Tables: make([]TableInfo, len(StandardTables))}
+	copy(config.Tables, StandardTables)
+	CheckRelations(config)
+	for _, table := range config.Tables {
+		if table.TableName != REFRESH_TOKEN_TABLE_NAME && table.TableName != REVOKED_TOKEN_TABLE_NAME {
+			continue
+		}
+		CreateAMapOfColumnsWeWantInTheFinalTable(&table)
+		_, err = db.Exec(MakeCreateTableQuery(&table, "sqlite3"))
+		if err != nil {
+			t.Fatalf("Failed to create %v table: %v", table.TableName, err)
+		}
+	}
+	return &DbResource{db: db, connection: db}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	stop := startTestRevokedTokens(t)
+	defer stop()
+	dr := newTestRefreshTokenResource(t)
+
+	keys, err := auth.NewJwtSigningKeys(auth.JwtAlgorithmHS256, []byte("secret"), "")
+	if err != nil {
+		t.Fatalf("Failed to create signing keys: %v", err)
+	}
+	generator := &JwtTokenGenerator{
+		Keys:                 keys,
+		Issuer:               "daptin-test",
+		TokenLifeTime:        time.Hour,
+		RefreshTokenLifeTime: time.Hour,
+	}
+	middleware := jwtmiddleware.New(jwtmiddleware.Options{
+		ValidationKeyGetter: keys.ValidationKey,
+		Issuer:              "daptin-test",
+		SigningMethod:       keys.Method,
+	})
+	userAccount := map[string]interface{}{"email": "user@example.com", "reference_id": "u1", "name": "user"}
+
+	// sign in, the access token works until the refresh token it was issued with is used
+	accessToken, jti, accessExpiresAt, err := generator.AccessToken(userAccount)
+	if err != nil {
+		t.Fatalf("Failed to sign access token: %v", err)
+	}
+	refreshToken, _, err := dr.IssueRefreshToken(1, "laptop", "", jti, accessExpiresAt, generator.RefreshTokenLifeTime)
+	if err != nil {
+		t.Fatalf("Failed to issue refresh token: %v", err)
+	}
+	_, err = middleware.CheckExtractedJWT(nil, accessToken)
+	if err != nil {
+		t.Fatalf("expected the access token to be accepted, got %v", err)
+	}
+
+	row, err := dr.UseRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatalf("Failed to use refresh token: %v", err)
+	}
+	familyId := row["family_id"].(string)
+	_, err = middleware.CheckExtractedJWT(nil, accessToken)
+	if err == nil {
+		t.Errorf("expected the access token of a used refresh token to be rejected")
+	}
+	_, err = dr.UseRefreshToken(refreshToken)
+	if err != ErrInvalidRefreshToken {
+		t.Errorf("expected a used refresh token to be rejected, got %v", err)
+	}
+
+	// rotate in the same family, reusing the first token revokes the rotated one too
+	accessToken, jti, accessExpiresAt, err = generator.AccessToken(userAccount)
+	if err != nil {
+		t.Fatalf("Failed to sign access token: %v", err)
+	}
+	rotatedToken, _, err := dr.IssueRefreshToken(1, "laptop", familyId, jti, accessExpiresAt, generator.RefreshTokenLifeTime)
+	if err != nil {
+		t.Fatalf("Failed to issue rotated refresh token: %v", err)
+	}
+	_, err = dr.UseRefreshToken(refreshToken)
+	if err != ErrInvalidRefreshToken {
+		t.Errorf("expected the reused refresh token to be rejected, got %v", err)
+	}
+	_, err = dr.UseRefreshToken(rotatedToken)
+	if err != ErrInvalidRefreshToken {
+		t.Errorf("expected reuse to revoke the whole family, got %v", err)
+	}
+	_, err = middleware.CheckExtractedJWT(nil, accessToken)
+	if err == nil {
+		t.Errorf("expected the access token of the revoked family to be rejected")
+	}
+	if !jwtmiddleware.IsRevoked(&jwt.Token{Claims: jwt.MapClaims{"jti": jti}}) {
+		t.Errorf("expected the jti of the revoked family to be revoked")
+	}
+
+	var revokedTokens int
+	err = dr.db.QueryRowx("select count(*) from revoked_token").Scan(&revokedTokens)
+	if err != nil || revokedTokens != 2 {
+		t.Errorf("expected 2 revoked access tokens to be stored, found %v %v", revokedTokens, err)
+	}
+}
+
+func TestRevokeRefreshTokens(t *testing.T) {
+	stop := startTestRevokedTokens(t)
+	defer stop()
+	dr := newTestRefreshTokenResource(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	laptopToken, _, err := dr.IssueRefreshToken(1, "laptop", "", "jti-laptop", expiresAt, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to issue refresh token: %v", err)
+	}
+	phoneToken, _, err := dr.IssueRefreshToken(1, "phone", "", "jti-phone", expiresAt, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to issue refresh token: %v", err)
+	}
+
+	revoked, err := dr.RevokeRefreshTokens(goqu.Ex{"device": "laptop"})
+	if err != nil || revoked != 1 {
+		t.Fatalf("expected 1 refresh token to be revoked, got %v %v", revoked, err)
+	}
+	revoked, err = dr.RevokeRefreshTokens(goqu.Ex{"device": "laptop"})
+	if err != nil || revoked != 0 {
+		t.Errorf("expected an already revoked token to not be counted again, got %v %v", revoked, err)
+	}
+
+	_, err = dr.UseRefreshToken(laptopToken)
+	if err != ErrInvalidRefreshToken {
+		t.Errorf("expected the revoked refresh token to be rejected, got %v", err)
+	}
+	if !jwtmiddleware.IsRevoked(&jwt.Token{Claims: jwt.MapClaims{"jti": "jti-laptop"}}) {
+		t.Errorf("expected the access token of the revoked refresh token to be revoked")
+	}
+	if jwtmiddleware.IsRevoked(&jwt.Token{Claims: jwt.MapClaims{"jti": "jti-phone"}}) {
+		t.Errorf("expected the access token of the other device to still work")
+	}
+	_, err = dr.UseRefreshToken(phoneToken)
+	if err != nil {
+		t.Errorf("expected the other device to still refresh, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/go.uuid"
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"time"
+)
+
+const LOGIN_LOCKOUT_TABLE_NAME = "login_lockout"
+
+const (
+	LoginLockoutEventLocked   = "locked"
+	LoginLockoutEventUnlocked = "unlocked"
+)
+
+// LoadLoginAttemptPolicy reads the lockout settings from the config, storing the defaults on first use
+func LoadLoginAttemptPolicy(configStore *ConfigStore) auth.LoginAttemptPolicy {
+
+	policy := auth.DefaultLoginAttemptPolicy
+
+	configInt := func(key string, defaultValue int) int {
+		value, err := configStore.GetConfigIntValueFor(key, "backend")
+		if err != nil {
+			err = configStore.SetConfigIntValueFor(key, defaultValue, "backend")
+			CheckErr(err, "Failed to store default value for [%v]", key)
+			return defaultValue
+		}
+		return value
+	}
+
+	policy.MaxFailures = configInt("login.lockout.max_failures", policy.MaxFailures)
+	policy.MaxFailuresPerIp = configInt("login.lockout.max_failures_per_ip", policy.MaxFailuresPerIp)
+	policy.FailureWindow = time.Duration(configInt("login.lockout.window_minutes", int(policy.FailureWindow/time.Minute))) * time.Minute
+	policy.LockoutDuration = time.Duration(configInt("login.lockout.minutes", int(policy.LockoutDuration/time.Minute))) * time.Minute
+
+	return policy
+}
+
+// InitLoginAttemptTracker starts counting failed logins across the cluster, lockouts are saved in login_lockout
+func InitLoginAttemptTracker(configStore *ConfigStore, cruds map[string]*DbResource, olricDb *olric.Olric) error {
+
+	policy := LoadLoginAttemptPolicy(configStore)
+
+	return auth.InitLoginAttemptTracker(olricDb, policy, func(event auth.LockoutEvent) {
+		err := cruds[LOGIN_LOCKOUT_TABLE_NAME].SaveLoginLockoutEvent(LoginLockoutEventLocked, event)
+		CheckErr(err, "Failed to save lockout of [%v][%v]", event.Account, event.IpAddress)
+	})
+}
+
+// SaveLoginLockoutEvent adds a row to login_lockout when an account or ip address is locked or unlocked
+func (dr *DbResource) SaveLoginLockoutEvent(eventType string, event auth.LockoutEvent) error {
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+
+	record := goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"event":                eventType,
+		"service":              event.Service,
+		"account":              event.Account,
+		"ip_address":           event.IpAddress,
+		"failures":             event.Failures,
+		"created_at":           time.Now(),
+	}
+	if !event.LockedUntil.IsZero() {
+		record["locked_until"] = event.LockedUntil
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(LOGIN_LOCKOUT_TABLE_NAME).Rows(record).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to save login lockout: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/artpar/go.uuid"
+	mailpacket "github.com/emersion/go-message/mail"
+	"github.com/emersion/go-msgauth/dkim"
+	"io"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MailAttachment is a file attached to a mail sent by mail.send
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Contents    []byte
+}
+
+// ComposedMail is a mail built by mail.send before it is signed and queued in the outbox. Bcc recipients are
+// only used for the envelope and never written in the headers
+type ComposedMail struct {
+	From        *mail.Address
+	To          []*mail.Address
+	Cc          []*mail.Address
+	Bcc         []*mail.Address
+	Subject     string
+	Text        string
+	Html        string
+	Attachments []MailAttachment
+}
+
+// ParseMailAddressList reads a comma separated string or a list of addresses
+func ParseMailAddressList(value interface{}) ([]*mail.Address, error) {
+
+	addresses := make([]*mail.Address, 0)
+	switch list := value.(type) {
+	case nil:
+		return addresses, nil
+	case string:
+		if strings.TrimSpace(list) == "" {
+			return addresses, nil
+		}
+		return mail.ParseAddressList(list)
+	case []string:
+		for _, item := range list {
+			parsed, err := ParseMailAddressList(item)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, parsed...)
+		}
+	case []interface{}:
+		for _, item := range list {
+			parsed, err := ParseMailAddressList(item)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, parsed...)
+		}
+	default:
+		return nil, fmt.Errorf("invalid mail address list [%v]", value)
+	}
+	return addresses, nil
+}
+
+// Recipients are the envelope recipients, every address of to, cc and bcc once
+func (m ComposedMail) Recipients() []string {
+	seen := make(map[string]bool)
+	recipients := make([]string, 0)
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, address := range list {
+			key := strings.ToLower(address.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			recipients = append(recipients, address.Address)
+		}
+	}
+	return recipients
+}
+
+func toPacketAddresses(list []*mail.Address) []*mailpacket.Address {
+	addresses := make([]*mailpacket.Address, len(list))
+	for i, address := range list {
+		addresses[i] = (*mailpacket.Address)(address)
+	}
+	return addresses
+}
+
+// Build writes the mail as a MIME message, the text and html bodies are alternatives of each other and are
+// followed by the attachments
+func (m ComposedMail) Build() ([]byte, error) {
+
+	if m.From == nil {
+		return nil, errors.New("mail from address is missing")
+	}
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return nil, errors.New("mail has no recipients")
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	_, fromDomain, err := splitMailAddress(m.From.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var header mailpacket.Header
+	header.SetAddressList("From", []*mailpacket.Address{(*mailpacket.Address)(m.From)})
+	if len(m.To) > 0 {
+		header.SetAddressList("To", toPacketAddresses(m.To))
+	}
+	if len(m.Cc) > 0 {
+		header.SetAddressList("Cc", toPacketAddresses(m.Cc))
+	}
+	header.SetSubject(m.Subject)
+	header.SetDate(time.Now())
+	header.Set("Message-Id", fmt.Sprintf("<%s@%s>", u.String(), fromDomain))
+
+	var buffer bytes.Buffer
+	writer, err := mailpacket.CreateWriter(&buffer, header)
+	if err != nil {
+		return nil, err
+	}
+
+	text := m.Text
+	if text == "" && m.Html == "" {
+		text = " "
+	}
+
+	inline, err := writer.CreateInline()
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		err = writeMailPart(inline, "text/plain", text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.Html != "" {
+		err = writeMailPart(inline, "text/html", m.Html)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = inline.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range m.Attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(attachment.Name))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		var attachmentHeader mailpacket.AttachmentHeader
+		attachmentHeader.Set("Content-Type", contentType)
+		attachmentHeader.SetFilename(attachment.Name)
+		part, err := writer.CreateAttachment(attachmentHeader)
+		if err != nil {
+			return nil, err
+		}
+		_, err = part.Write(attachment.Contents)
+		if err != nil {
+			return nil, err
+		}
+		err = part.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeMailPart(inline *mailpacket.InlineWriter, contentType string, body string) error {
+	var partHeader mailpacket.InlineHeader
+	partHeader.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+	part, err := inline.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, body)
+	if err != nil {
+		return err
+	}
+	return part.Close()
+}
+
+// SignMailWithDkim adds a DKIM-Signature header using the private key of the sender domain, with the same
+// options as mails relayed by the smtp server
+func SignMailWithDkim(message []byte, domain string, privateKeyPem []byte) ([]byte, error) {
+
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, fmt.Errorf("no private key for [%v]", domain)
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &dkim.SignOptions{
+		Selector:               "d1",
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		Domain:                 domain,
+		Signer:                 privateKey,
+	}
+
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, bytes.NewReader(message), options)
+	if err != nil {
+		return nil, err
+	}
+	return signed.Bytes(), nil
+}
@@ -0,0 +1,121 @@
+package resource
+
+import (
+	"bytes"
+	mailpacket "github.com/emersion/go-message/mail"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestParseMailAddressList(t *testing.T) {
+
+	addresses, err := ParseMailAddressList("alice@example.com, Bob <bob@example.org>")
+	if err != nil || len(addresses) != 2 || addresses[1].Address != "bob@example.org" {
+		t.Errorf("failed to parse comma separated addresses: %v %v", addresses, err)
+	}
+
+	addresses, err = ParseMailAddressList([]interface{}{"alice@example.com", "carol@example.net"})
+	if err != nil || len(addresses) != 2 {
+		t.Errorf("failed to parse address list: %v %v", addresses, err)
+	}
+
+	addresses, err = ParseMailAddressList(nil)
+	if err != nil || len(addresses) != 0 {
+		t.Errorf("missing addresses should be empty: %v %v", addresses, err)
+	}
+
+	_, err = ParseMailAddressList("not an address")
+	if err == nil {
+		t.Errorf("invalid addresses should fail")
+	}
+}
+
+func TestComposedMailBuild(t *testing.T) {
+
+	composedMail := ComposedMail{
+		From:    &mail.Address{Name: "Shop", Address: "shop@example.com"},
+		To:      []*mail.Address{{Address: "alice@example.com"}},
+		Cc:      []*mail.Address{{Address: "bob@example.org"}},
+		Bcc:     []*mail.Address{{Address: "audit@example.com"}, {Address: "ALICE@example.com"}},
+		Subject: "Your receipt",
+		Text:    "Thanks for your order",
+		Html:    "<p>Thanks for your order</p>",
+		Attachments: []MailAttachment{
+			{Name: "receipt.csv", Contents: []byte("item,price\nbook,10\n")},
+		},
+	}
+
+	recipients := composedMail.Recipients()
+	if strings.Join(recipients, ",") != "alice@example.com,bob@example.org,audit@example.com" {
+		t.Errorf("unexpected recipients: %v", recipients)
+	}
+
+	mailBytes, err := composedMail.Build()
+	if err != nil {
+		t.Fatalf("failed to build mail: %v", err)
+	}
+	if bytes.Contains(mailBytes, []byte("audit@example.com")) {
+		t.Errorf("bcc recipients should not be in the headers")
+	}
+
+	reader, err := mailpacket.CreateReader(bytes.NewReader(mailBytes))
+	if err != nil {
+		t.Fatalf("failed to read mail: %v", err)
+	}
+	subject, _ := reader.Header.Subject()
+	if subject != "Your receipt" {
+		t.Errorf("unexpected subject [%v]", subject)
+	}
+
+	parts := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part.Body)
+		switch header := part.Header.(type) {
+		case *mailpacket.InlineHeader:
+			contentType, _, _ := header.ContentType()
+			parts[contentType] = string(body)
+		case *mailpacket.AttachmentHeader:
+			fileName, _ := header.Filename()
+			parts[fileName] = string(body)
+		}
+	}
+
+	if parts["text/plain"] != "Thanks for your order" || parts["text/html"] != "<p>Thanks for your order</p>" {
+		t.Errorf("missing alternative bodies: %v", parts)
+	}
+	if parts["receipt.csv"] != "item,price\nbook,10\n" {
+		t.Errorf("missing attachment: %v", parts)
+	}
+}
+
+func TestFileToMailAttachments(t *testing.T) {
+
+	files := []map[string]interface{}{
+		{"name": "a.txt", "contents": "aGVsbG8="},
+		{"name": "b.png", "contents": "data:image/png;base64,aGVsbG8="},
+	}
+
+	attachments, err := fileToMailAttachments(files, "")
+	if err != nil || len(attachments) != 2 {
+		t.Fatalf("failed to convert files: %v %v", attachments, err)
+	}
+	if string(attachments[0].Contents) != "hello" || attachments[1].ContentType != "image/png" {
+		t.Errorf("unexpected attachments: %v", attachments)
+	}
+
+	attachments, err = fileToMailAttachments(files, "b.png")
+	if err != nil || len(attachments) != 1 || attachments[0].Name != "b.png" {
+		t.Errorf("failed to pick the named file: %v %v", attachments, err)
+	}
+
+	_, err = fileToMailAttachments(files, "c.pdf")
+	if err == nil {
+		t.Errorf("missing named file should fail")
+	}
+}
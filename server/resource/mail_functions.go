@@ -80,6 +80,11 @@ func (d *DbResource) DeleteMailAccountBox(mailAccountId int64, mailBoxName strin
 		return errors.New("mailbox does not exist")
 	}
 
+	err = d.DeleteMailSearchTerms(statementbuilder.Squirrel.From("mail").Select("id").Where(goqu.Ex{"mail_box_id": box[0]["id"]}))
+	if err != nil {
+		return err
+	}
+
 	query, args, err := statementbuilder.Squirrel.Delete("mail").Where(goqu.Ex{"mail_box_id": box[0]["id"]}).ToSQL()
 	if err != nil {
 		return err
@@ -0,0 +1,397 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/emersion/go-message"
+	mailpacket "github.com/emersion/go-message/mail"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const MAIL_RULE_TABLE_NAME = "mail_rule"
+
+// InboundMail is a mail accepted by the smtp server for a local account, parsed for the mail rules
+type InboundMail struct {
+	Recipient   string
+	Sender      string
+	Subject     string
+	MessageId   string
+	Header      map[string][]string
+	Text        string
+	Html        string
+	Attachments []map[string]interface{}
+	Raw         []byte
+}
+
+// ParseInboundMail reads the headers, the text and html bodies and the attachments of a mail. Attachments are
+// file entries with base64 contents, as expected by asset columns
+func ParseInboundMail(raw []byte, sender string, recipient string) (InboundMail, error) {
+
+	inbound := InboundMail{
+		Recipient:   recipient,
+		Sender:      sender,
+		Header:      make(map[string][]string),
+		Attachments: make([]map[string]interface{}, 0),
+		Raw:         raw,
+	}
+
+	reader, err := mailpacket.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return inbound, err
+	}
+
+	fields := reader.Header.Fields()
+	for fields.Next() {
+		key := textproto.CanonicalMIMEHeaderKey(fields.Key())
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+		inbound.Header[key] = append(inbound.Header[key], value)
+	}
+	inbound.Subject, _ = reader.Header.Subject()
+	inbound.MessageId = strings.Trim(reader.Header.Get("Message-Id"), "<> ")
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil && !message.IsUnknownCharset(err) {
+			return inbound, err
+		}
+
+		contents, err := ioutil.ReadAll(part.Body)
+		if err != nil {
+			return inbound, err
+		}
+
+		switch header := part.Header.(type) {
+		case *mailpacket.InlineHeader:
+			contentType, _, _ := header.ContentType()
+			_, params, _ := header.ContentDisposition()
+			if contentType == "text/plain" && inbound.Text == "" && params["filename"] == "" {
+				inbound.Text = string(contents)
+			} else if contentType == "text/html" && inbound.Html == "" && params["filename"] == "" {
+				inbound.Html = string(contents)
+			} else if params["filename"] != "" {
+				inbound.Attachments = append(inbound.Attachments, mailAttachmentFile(params["filename"], contentType, contents))
+			}
+		case *mailpacket.AttachmentHeader:
+			contentType, _, _ := header.ContentType()
+			fileName, _ := header.Filename()
+			if fileName == "" {
+				fileName = fmt.Sprintf("attachment-%d", len(inbound.Attachments)+1)
+			}
+			inbound.Attachments = append(inbound.Attachments, mailAttachmentFile(fileName, contentType, contents))
+		}
+	}
+
+	return inbound, nil
+}
+
+func mailAttachmentFile(name string, contentType string, contents []byte) map[string]interface{} {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return map[string]interface{}{
+		"name": name,
+		"type": contentType,
+		"file": "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(contents),
+	}
+}
+
+// HeaderValue is the first value of the header, names are not case sensitive
+func (m InboundMail) HeaderValue(name string) string {
+	values := m.Header[textproto.CanonicalMIMEHeaderKey(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// InFields are the attributes of the actions run by mail rules
+func (m InboundMail) InFields() map[string]interface{} {
+
+	headers := make(map[string]interface{})
+	for key, values := range m.Header {
+		headers[key] = strings.Join(values, ", ")
+	}
+	attachments := make([]interface{}, len(m.Attachments))
+	for i, attachment := range m.Attachments {
+		attachments[i] = attachment
+	}
+
+	return map[string]interface{}{
+		"recipient":   m.Recipient,
+		"sender":      m.Sender,
+		"from":        m.HeaderValue("From"),
+		"to":          m.HeaderValue("To"),
+		"cc":          m.HeaderValue("Cc"),
+		"reply_to":    m.HeaderValue("Reply-To"),
+		"subject":     m.Subject,
+		"message_id":  m.MessageId,
+		"text":        m.Text,
+		"html":        m.Html,
+		"headers":     headers,
+		"attachments": attachments,
+	}
+}
+
+// MailRule matches inbound mail on recipient, sender, subject and headers. Empty patterns match everything
+type MailRule struct {
+	ReferenceId      string
+	Name             string
+	Priority         int
+	RecipientPattern *regexp.Regexp
+	SenderPattern    *regexp.Regexp
+	SubjectPattern   *regexp.Regexp
+	HeaderConditions map[string]*regexp.Regexp
+	MoveToMailbox    string
+	SetFlags         []string
+	ForwardTo        []string
+	ActionName       string
+	EntityName       string
+	Attributes       map[string]interface{}
+	StopProcessing   bool
+	// OwnerReferenceId is the user the action of the rule runs as
+	OwnerReferenceId string
+}
+
+// Matches is true when every condition of the rule holds for the mail
+func (r MailRule) Matches(m InboundMail) bool {
+
+	if r.RecipientPattern != nil && !r.RecipientPattern.MatchString(m.Recipient) {
+		return false
+	}
+	if r.SenderPattern != nil && !r.SenderPattern.MatchString(m.Sender) {
+		return false
+	}
+	if r.SubjectPattern != nil && !r.SubjectPattern.MatchString(m.Subject) {
+		return false
+	}
+	for headerName, pattern := range r.HeaderConditions {
+		matched := false
+		for _, value := range m.Header[textproto.CanonicalMIMEHeaderKey(headerName)] {
+			if pattern.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchMailRules returns the rules matching the mail in order, until a matching rule stops the processing
+func MatchMailRules(rules []MailRule, m InboundMail) []MailRule {
+	matched := make([]MailRule, 0)
+	for _, rule := range rules {
+		if !rule.Matches(m) {
+			continue
+		}
+		matched = append(matched, rule)
+		if rule.StopProcessing {
+			break
+		}
+	}
+	return matched
+}
+
+// MailRuleDisposition is the mailbox and the extra flags for the mail, the first matched rule with a mailbox
+// decides the mailbox
+func MailRuleDisposition(matched []MailRule, mailbox string, flags []string) (string, []string) {
+	mailboxSet := false
+	for _, rule := range matched {
+		if rule.MoveToMailbox != "" && !mailboxSet {
+			mailbox = rule.MoveToMailbox
+			mailboxSet = true
+		}
+		for _, flag := range rule.SetFlags {
+			exists := false
+			for _, existing := range flags {
+				if strings.EqualFold(existing, flag) {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return mailbox, flags
+}
+
+func compileMailPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+func splitMailRuleList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// NewMailRule reads a row of the mail_rule table
+func NewMailRule(row map[string]interface{}) (MailRule, error) {
+
+	rule := MailRule{
+		HeaderConditions: make(map[string]*regexp.Regexp),
+		Attributes:       make(map[string]interface{}),
+	}
+	rule.ReferenceId, _ = row["reference_id"].(string)
+	rule.Name, _ = row["name"].(string)
+	priority, _ := row["priority"].(int64)
+	rule.Priority = int(priority)
+	rule.StopProcessing = fmt.Sprintf("%v", row["stop_processing"]) == "1" || row["stop_processing"] == true
+	rule.MoveToMailbox, _ = row["move_to_mailbox"].(string)
+	flags, _ := row["set_flags"].(string)
+	rule.SetFlags = splitMailRuleList(flags)
+	forwardTo, _ := row["forward_to"].(string)
+	rule.ForwardTo = splitMailRuleList(forwardTo)
+	rule.ActionName, _ = row["action_name"].(string)
+	rule.EntityName, _ = row["entity_name"].(string)
+	rule.OwnerReferenceId, _ = row[USER_ACCOUNT_ID_COLUMN].(string)
+
+	var err error
+	for column, pattern := range map[string]**regexp.Regexp{
+		"recipient_pattern": &rule.RecipientPattern,
+		"sender_pattern":    &rule.SenderPattern,
+		"subject_pattern":   &rule.SubjectPattern,
+	} {
+		value, _ := row[column].(string)
+		*pattern, err = compileMailPattern(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid %v of mail rule [%v]: %v", column, rule.Name, err)
+		}
+	}
+
+	headerConditions := make(map[string]string)
+	if value, ok := row["header_conditions"].(string); ok && strings.TrimSpace(value) != "" {
+		err = json.Unmarshal([]byte(value), &headerConditions)
+		if err != nil {
+			return rule, fmt.Errorf("invalid header_conditions of mail rule [%v]: %v", rule.Name, err)
+		}
+	}
+	for headerName, value := range headerConditions {
+		pattern, err := compileMailPattern(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid pattern for header [%v] of mail rule [%v]: %v", headerName, rule.Name, err)
+		}
+		if pattern == nil {
+			pattern = regexp.MustCompile(".")
+		}
+		rule.HeaderConditions[headerName] = pattern
+	}
+
+	if value, ok := row["attributes"].(string); ok && strings.TrimSpace(value) != "" {
+		err = json.Unmarshal([]byte(value), &rule.Attributes)
+		if err != nil {
+			return rule, fmt.Errorf("invalid attributes of mail rule [%v]: %v", rule.Name, err)
+		}
+	}
+
+	return rule, nil
+}
+
+// GetMailRules loads the enabled mail rules ordered by priority, rules which cannot be read are skipped
+func (dr *DbResource) GetMailRules() ([]MailRule, error) {
+
+	rows, _, err := dr.Cruds[MAIL_RULE_TABLE_NAME].GetRowsByWhereClause(MAIL_RULE_TABLE_NAME, nil, goqu.Ex{"enabled": true})
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]MailRule, 0, len(rows))
+	for _, row := range rows {
+		rule, err := NewMailRule(row)
+		if err != nil {
+			log.Errorf("Skipping mail rule: %v", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+	return rules, nil
+}
+
+// RunMailRuleActions forwards the mail and runs the actions of the matched rules, after the mail is stored.
+// Actions run as the owner of the rule with the parsed mail as attributes
+func (dr *DbResource) RunMailRuleActions(matched []MailRule, m InboundMail, outboxWorker *OutboxDeliveryWorker) {
+
+	for _, rule := range matched {
+
+		if len(rule.ForwardTo) > 0 {
+			if outboxWorker == nil {
+				log.Errorf("Cannot forward mail for rule [%v], the outbox is not running", rule.Name)
+			} else {
+				_, err := outboxWorker.Enqueue(m.Recipient, rule.ForwardTo, m.Raw)
+				CheckErr(err, "Failed to forward mail to [%v] for rule [%v]", rule.ForwardTo, rule.Name)
+			}
+		}
+
+		if rule.ActionName == "" || rule.EntityName == "" {
+			continue
+		}
+		if _, ok := dr.Cruds[rule.EntityName]; !ok {
+			log.Errorf("Mail rule [%v] refers to unknown entity [%v]", rule.Name, rule.EntityName)
+			continue
+		}
+
+		sessionUser := &auth.SessionUser{}
+		if rule.OwnerReferenceId != "" {
+			owner, err := dr.GetReferenceIdToObject(USER_ACCOUNT_TABLE_NAME, rule.OwnerReferenceId)
+			if err != nil {
+				log.Errorf("Failed to load owner of mail rule [%v]: %v", rule.Name, err)
+				continue
+			}
+			sessionUser.UserId, _ = owner["id"].(int64)
+			sessionUser.UserReferenceId = rule.OwnerReferenceId
+			sessionUser.Groups = dr.GetObjectUserGroupsByWhere(USER_ACCOUNT_TABLE_NAME, "reference_id", rule.OwnerReferenceId)
+		}
+
+		attributes := m.InFields()
+		for key, value := range rule.Attributes {
+			attributes[key] = value
+		}
+
+		pr := &http.Request{
+			Method: "EXECUTE",
+		}
+		pr = pr.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+		req := api2go.Request{
+			PlainRequest: pr,
+		}
+
+		_, err := dr.Cruds[rule.EntityName].HandleActionRequest(ActionRequest{
+			Type:       rule.EntityName,
+			Action:     rule.ActionName,
+			Attributes: attributes,
+		}, req)
+		CheckErr(err, "Failed to run action [%v][%v] of mail rule [%v]", rule.EntityName, rule.ActionName, rule.Name)
+	}
+}
@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestParseInboundMail(t *testing.T) {
+
+	raw, err := ComposedMail{
+		From:    &mail.Address{Address: "billing@vendor.com"},
+		To:      []*mail.Address{{Address: "invoices@example.com"}},
+		Subject: "Invoice 1001",
+		Text:    "Please find the invoice attached",
+		Html:    "<p>Please find the invoice attached</p>",
+		Attachments: []MailAttachment{
+			{Name: "invoice.pdf", ContentType: "application/pdf", Contents: []byte("%PDF-1.4")},
+		},
+	}.Build()
+	if err != nil {
+		t.Fatalf("failed to build mail: %v", err)
+	}
+
+	inbound, err := ParseInboundMail(raw, "billing@vendor.com", "invoices@example.com")
+	if err != nil {
+		t.Fatalf("failed to parse mail: %v", err)
+	}
+	if inbound.Subject != "Invoice 1001" || inbound.Text != "Please find the invoice attached" {
+		t.Errorf("unexpected subject [%v] or text [%v]", inbound.Subject, inbound.Text)
+	}
+	if !strings.Contains(inbound.Html, "<p>") {
+		t.Errorf("missing html body: %v", inbound.Html)
+	}
+	if inbound.HeaderValue("to") != "<invoices@example.com>" {
+		t.Errorf("unexpected to header [%v]", inbound.HeaderValue("to"))
+	}
+	if len(inbound.Attachments) != 1 || inbound.Attachments[0]["name"] != "invoice.pdf" ||
+		inbound.Attachments[0]["file"] != "data:application/pdf;base64,JVBERi0xLjQ=" {
+		t.Errorf("unexpected attachments: %v", inbound.Attachments)
+	}
+
+	inFields := inbound.InFields()
+	if inFields["recipient"] != "invoices@example.com" || len(inFields["attachments"].([]interface{})) != 1 {
+		t.Errorf("unexpected in fields: %v", inFields)
+	}
+}
+
+func TestMailRules(t *testing.T) {
+
+	inbound := InboundMail{
+		Recipient: "invoices@example.com",
+		Sender:    "billing@vendor.com",
+		Subject:   "Invoice 1001",
+		Header: map[string][]string{
+			"X-Priority": {"1 (Highest)"},
+		},
+	}
+
+	invoices, err := NewMailRule(map[string]interface{}{
+		"name":              "invoices",
+		"priority":          int64(10),
+		"recipient_pattern": "^invoices@",
+		"subject_pattern":   "invoice \\d+",
+		"header_conditions": `{"x-priority": "^1"}`,
+		"move_to_mailbox":   "Invoices",
+		"set_flags":         "\\Flagged, $Invoice",
+		"stop_processing":   int64(1),
+	})
+	if err != nil {
+		t.Fatalf("failed to read rule: %v", err)
+	}
+	if !invoices.Matches(inbound) {
+		t.Errorf("invoice rule should match")
+	}
+
+	other, _ := NewMailRule(map[string]interface{}{
+		"name":            "everything",
+		"move_to_mailbox": "Archive",
+	})
+	support, _ := NewMailRule(map[string]interface{}{
+		"name":              "support",
+		"recipient_pattern": "^support@",
+	})
+	if support.Matches(inbound) {
+		t.Errorf("support rule should not match")
+	}
+
+	matched := MatchMailRules([]MailRule{support, invoices, other}, inbound)
+	if len(matched) != 1 || matched[0].Name != "invoices" {
+		t.Errorf("processing should stop after the invoice rule: %v", matched)
+	}
+
+	mailbox, flags := MailRuleDisposition([]MailRule{invoices, other}, "INBOX", []string{"\\Recent"})
+	if mailbox != "Invoices" || strings.Join(flags, ",") != "\\Recent,\\Flagged,$Invoice" {
+		t.Errorf("unexpected disposition [%v] %v", mailbox, flags)
+	}
+
+	_, err = NewMailRule(map[string]interface{}{"name": "broken", "subject_pattern": "("})
+	if err == nil {
+		t.Errorf("invalid patterns should fail")
+	}
+}
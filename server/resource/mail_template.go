@@ -0,0 +1,129 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/doug-martin/goqu/v9"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+const MAIL_TEMPLATE_TABLE_NAME = "mail_template"
+
+// MailTemplate is one language variant of a named template in the mail_template table, an empty Language is
+// the fallback variant
+type MailTemplate struct {
+	Name     string
+	Language string
+	Subject  string
+	Text     string
+	Html     string
+}
+
+// SelectMailTemplate picks the variant matching the first preferred language, "pt-BR" also matches a "pt"
+// variant. Without a match the fallback variant is used, and then the first one
+func SelectMailTemplate(variants []MailTemplate, languages []string) (MailTemplate, bool) {
+
+	if len(variants) == 0 {
+		return MailTemplate{}, false
+	}
+
+	for _, language := range languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if language == "" {
+			continue
+		}
+		base := strings.Split(strings.Split(language, "-")[0], "_")[0]
+		for _, variant := range variants {
+			if strings.ToLower(variant.Language) == language {
+				return variant, true
+			}
+		}
+		for _, variant := range variants {
+			if strings.ToLower(variant.Language) == base {
+				return variant, true
+			}
+		}
+	}
+
+	for _, variant := range variants {
+		if variant.Language == "" {
+			return variant, true
+		}
+	}
+	return variants[0], true
+}
+
+// GetMailTemplate loads the variant of the named template for the preferred languages
+func (dr *DbResource) GetMailTemplate(name string, languages []string) (MailTemplate, error) {
+
+	rows, _, err := dr.Cruds[MAIL_TEMPLATE_TABLE_NAME].GetRowsByWhereClause(MAIL_TEMPLATE_TABLE_NAME, nil, goqu.Ex{"name": name})
+	if err != nil {
+		return MailTemplate{}, err
+	}
+
+	variants := make([]MailTemplate, 0, len(rows))
+	for _, row := range rows {
+		variant := MailTemplate{
+			Name: name,
+		}
+		variant.Language, _ = row["language"].(string)
+		variant.Subject, _ = row["subject"].(string)
+		variant.Text, _ = row["text_body"].(string)
+		variant.Html, _ = row["html_body"].(string)
+		variants = append(variants, variant)
+	}
+
+	mailTemplate, ok := SelectMailTemplate(variants, languages)
+	if !ok {
+		return MailTemplate{}, fmt.Errorf("no mail template named [%v]", name)
+	}
+	return mailTemplate, nil
+}
+
+// Render executes the subject and the text body as text templates and the html body as an html template, so
+// values are escaped in the html
+func (t MailTemplate) Render(data map[string]interface{}) (string, string, string, error) {
+
+	subject, err := renderTextTemplate(t.Name+".subject", t.Subject, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	text, err := renderTextTemplate(t.Name+".text", t.Text, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	html := ""
+	if t.Html != "" {
+		htmlTemplate, err := htmltemplate.New(t.Name + ".html").Option("missingkey=zero").Parse(t.Html)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to parse html of mail template [%v]: %v", t.Name, err)
+		}
+		var buffer bytes.Buffer
+		err = htmlTemplate.Execute(&buffer, data)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to render html of mail template [%v]: %v", t.Name, err)
+		}
+		html = buffer.String()
+	}
+
+	return strings.TrimSpace(subject), text, html, nil
+}
+
+func renderTextTemplate(name string, source string, data map[string]interface{}) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+	textTemplate, err := template.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse mail template [%v]: %v", name, err)
+	}
+	var buffer bytes.Buffer
+	err = textTemplate.Execute(&buffer, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render mail template [%v]: %v", name, err)
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,64 @@
+package resource
+
+import "testing"
+
+func TestSelectMailTemplate(t *testing.T) {
+
+	variants := []MailTemplate{
+		{Name: "welcome", Language: "", Subject: "Welcome"},
+		{Name: "welcome", Language: "de", Subject: "Willkommen"},
+		{Name: "welcome", Language: "pt-br", Subject: "Bem-vindo"},
+	}
+
+	cases := []struct {
+		languages []string
+		subject   string
+	}{
+		{[]string{"de"}, "Willkommen"},
+		{[]string{"pt-BR"}, "Bem-vindo"},
+		{[]string{"de-AT"}, "Willkommen"},
+		{[]string{"fr", "de"}, "Willkommen"},
+		{[]string{"fr"}, "Welcome"},
+		{nil, "Welcome"},
+	}
+
+	for _, c := range cases {
+		variant, ok := SelectMailTemplate(variants, c.languages)
+		if !ok || variant.Subject != c.subject {
+			t.Errorf("expected [%v] for %v, got [%v]", c.subject, c.languages, variant.Subject)
+		}
+	}
+
+	if _, ok := SelectMailTemplate(nil, []string{"en"}); ok {
+		t.Errorf("no variants should not match")
+	}
+}
+
+func TestMailTemplateRender(t *testing.T) {
+
+	mailTemplate := MailTemplate{
+		Name:    "receipt",
+		Subject: "Order {{.order.number}}",
+		Text:    "Hello {{.user.name}}",
+		Html:    "<p>Hello {{.user.name}}</p>",
+	}
+
+	subject, text, html, err := mailTemplate.Render(map[string]interface{}{
+		"order": map[string]interface{}{"number": "42"},
+		"user":  map[string]interface{}{"name": "<Alice>"},
+	})
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+	if subject != "Order 42" || text != "Hello <Alice>" {
+		t.Errorf("unexpected subject [%v] or text [%v]", subject, text)
+	}
+	if html != "<p>Hello &lt;Alice&gt;</p>" {
+		t.Errorf("html values should be escaped: %v", html)
+	}
+
+	_, _, _, err = MailTemplate{Name: "broken", Text: "{{.user"}.Render(nil)
+	if err == nil {
+		t.Errorf("invalid templates should fail")
+	}
+}
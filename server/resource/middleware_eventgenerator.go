@@ -2,6 +2,7 @@ package resource
 
 import (
 	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
 	"github.com/buraksezer/olric"
 	log "github.com/sirupsen/logrus"
 	"strings"
@@ -17,12 +18,19 @@ func (pc eventHandlerMiddleware) String() string {
 }
 
 type EventMessage struct {
+	// EventId identifies the message across the cluster, websocket clients use it to resume a subscription
+	EventId       string
 	MessageSource string
 	EventType     string
 	ObjectType    string
 	EventData     map[string]interface{}
 }
 
+func NewEventId() string {
+	u, _ := uuid.NewV4()
+	return u.String()
+}
+
 func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
 
 	topic := (*pc.dtopicMap)[dr.model.GetTableName()]
@@ -30,51 +38,60 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 		return results, nil
 	}
 
+	var eventType string
 	switch strings.ToLower(req.PlainRequest.Method) {
 	case "get":
-		break
+		return results, nil
 	case "post":
-		go func() {
-			err := topic.Publish(EventMessage{
-				MessageSource: "database",
-				EventType:     "create",
-				ObjectType:    dr.model.GetTableName(),
-				EventData:     results[0],
-			})
-			CheckErr(err, "Failed to publish create message")
-		}()
-		break
+		eventType = "create"
 	case "delete":
-		go func() {
-			err := topic.Publish(EventMessage{
-				MessageSource: "database",
-				EventType:     "delete",
-				ObjectType:    dr.model.GetTableName(),
-				EventData:     results[0],
-			})
-			CheckErr(err, "Failed to delete create message")
-
-		}()
-		break
+		eventType = "delete"
 	case "patch":
+		eventType = "update"
+	default:
+		log.Errorf("Invalid method: %v", req.PlainRequest.Method)
+		return results, nil
+	}
+
+	eventData := results[0]
+	// events for writes made inside a transaction are only published once it commits
+	dr.AfterCommit(func() {
 		go func() {
 			err := topic.Publish(EventMessage{
+				EventId:       NewEventId(),
 				MessageSource: "database",
-				EventType:     "update",
+				EventType:     eventType,
 				ObjectType:    dr.model.GetTableName(),
-				EventData:     results[0],
+				EventData:     eventData,
 			})
-			CheckErr(err, "Failed to update create message")
+			CheckErr(err, "Failed to publish %v message", eventType)
 		}()
-		break
-	default:
-		log.Errorf("Invalid method: %v", req.PlainRequest.Method)
-	}
+	})
 
 	return results, nil
 
 }
 
+// PublishTableEvent publishes an event on the topic of the table, for rows changed without going through the
+// api middlewares
+func (dr *DbResource) PublishTableEvent(tableName string, eventType string, eventData map[string]interface{}) error {
+
+	if dr.OlricDb == nil {
+		return nil
+	}
+	topic, err := dr.OlricDb.NewDTopic(tableName, 4, 1)
+	if err != nil {
+		return err
+	}
+	return topic.Publish(EventMessage{
+		EventId:       NewEventId(),
+		MessageSource: "database",
+		EventType:     eventType,
+		ObjectType:    tableName,
+		EventData:     eventData,
+	})
+}
+
 func (pc *eventHandlerMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
 
 	reqmethod := req.PlainRequest.Method
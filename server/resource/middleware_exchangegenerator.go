@@ -110,6 +110,7 @@ func (em *exchangeMiddleware) InterceptBefore(dr *DbResource, req *api2go.Reques
 
 			log.Printf("executing exchange in routine: %v -> %v", exchange.SourceType, exchange.TargetType)
 			exchangeExecution := NewExchangeExecution(exchange, em.cruds)
+			exchangeExecution.Method = reqmethod
 
 			exchangeResult, err := exchangeExecution.Execute([]map[string]interface{}{resultRow})
 			if err != nil {
@@ -138,12 +139,22 @@ func (em *exchangeMiddleware) InterceptBefore(dr *DbResource, req *api2go.Reques
 // Called after the data changes are complete, resposible for calling the external api.
 func (em *exchangeMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
 
-	//errors := []error{}
-
 	reqmethod := req.PlainRequest.Method
 	reqmethod = strings.ToLower(reqmethod)
 	//log.Printf("Request to intercept in middleware exchange: %v", reqmethod)
 
+	// exchanges are calls to the outside world, for writes made inside a transaction they are
+	// held back until the transaction commits. They run in the background so that retries do not
+	// hold up the request
+	dr.AfterCommit(func() {
+		go em.executeAfterExchanges(reqmethod, results)
+	})
+
+	return results, nil
+}
+
+func (em *exchangeMiddleware) executeAfterExchanges(reqmethod string, results []map[string]interface{}) {
+
 	for _, resultRow := range results {
 
 		typ, ok := resultRow["__type"]
@@ -183,6 +194,7 @@ func (em *exchangeMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request
 
 			log.Printf("executing exchange in routine: %v -> %v", exchange.SourceType, exchange.TargetType)
 			exchangeExecution := NewExchangeExecution(exchange, em.cruds)
+			exchangeExecution.Method = reqmethod
 
 			exchangeResult, err := exchangeExecution.Execute([]map[string]interface{}{resultRow})
 			if err != nil {
@@ -203,6 +215,4 @@ func (em *exchangeMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request
 			}
 		}
 	}
-
-	return results, nil
 }
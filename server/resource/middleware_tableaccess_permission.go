@@ -80,6 +80,10 @@ func (pc *TableAccessPermissionChecker) InterceptBefore(dr *DbResource, req *api
 		sessionUser = user.(*auth.SessionUser)
 	}
 
+	if !sessionUser.AllowsTable(dr.tableInfo.TableName, req.PlainRequest.Method) {
+		return nil, api2go.NewHTTPError(fmt.Errorf(errorMsgFormat, "api key scope", dr.tableInfo.TableName, req.PlainRequest.Method, sessionUser.UserReferenceId), pc.String(), 403)
+	}
+
 	if dr.IsAdmin(sessionUser.UserReferenceId) {
 		return results, nil
 	}
@@ -0,0 +1,576 @@
+package resource
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const OUTBOX_TABLE_NAME = "outbox"
+
+const (
+	OutboxStatusQueued   = "queued"
+	OutboxStatusRetrying = "retrying"
+	OutboxStatusSent     = "sent"
+	OutboxStatusBounced  = "bounced"
+	OutboxStatusExpired  = "expired"
+)
+
+// outboxClaimDuration is how long a worker holds a mail it picked up. A mail held by a worker which died is
+// picked up again by any worker after this
+const outboxClaimDuration = 10 * time.Minute
+
+// EnqueueOutboxMail adds one outbox row per recipient and returns their reference ids. The mail is delivered
+// by the OutboxDeliveryWorker, it is given up after expiresIn
+func (dr *DbResource) EnqueueOutboxMail(from string, to []string, mail []byte, expiresIn time.Duration) ([]string, error) {
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	now := time.Now()
+	referenceIds := make([]string, 0, len(to))
+
+	for _, recipient := range to {
+		_, host, err := splitMailAddress(recipient)
+		if err != nil {
+			return referenceIds, err
+		}
+
+		u, err := uuid.NewV4()
+		if err != nil {
+			return referenceIds, err
+		}
+
+		query, args, err := statementbuilder.Squirrel.Insert(OUTBOX_TABLE_NAME).Rows(goqu.Record{
+			"reference_id":         u.String(),
+			"permission":           auth.DEFAULT_PERMISSION,
+			USER_ACCOUNT_ID_COLUMN: adminUserId,
+			"from_address":         from,
+			"to_address":           recipient,
+			"to_host":              host,
+			"mail":                 base64.StdEncoding.EncodeToString(mail),
+			"sent":                 false,
+			"status":               OutboxStatusQueued,
+			"attempts":             0,
+			"next_attempt_at":      now,
+			"expires_at":           now.Add(expiresIn),
+			"created_at":           now,
+		}).ToSQL()
+		if err != nil {
+			return referenceIds, err
+		}
+
+		_, err = dr.db.Exec(query, args...)
+		if err != nil {
+			return referenceIds, fmt.Errorf("failed to queue mail to [%v]: %v", recipient, err)
+		}
+		referenceIds = append(referenceIds, u.String())
+	}
+
+	return referenceIds, nil
+}
+
+func splitMailAddress(address string) (string, string, error) {
+	parts := strings.SplitN(strings.Trim(strings.TrimSpace(address), "<>"), "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid mail address [%v]", address)
+	}
+	return parts[0], parts[1], nil
+}
+
+// OutboxMail is a queued mail picked up for delivery
+type OutboxMail struct {
+	Id          int64
+	ReferenceId string
+	From        string
+	To          string
+	Mail        []byte
+	Attempts    int
+	ExpiresAt   time.Time
+}
+
+// OutboxDeliveryWorker drains the outbox table. Every mail is delivered to the MX hosts of its recipient,
+// temporary failures are retried as per RetryPolicy until the mail expires. Mails which are rejected or
+// expire are bounced to the mail box of the sender when the sender has a mail account here
+type OutboxDeliveryWorker struct {
+	cruds        map[string]*DbResource
+	RetryPolicy  ExchangeRetryPolicy
+	ExpiresIn    time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+	deliver      func(mail OutboxMail) (string, error)
+	wake         chan struct{}
+	// stop ends the loop started by Start, stopped is closed once it has returned
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOutboxDeliveryWorker reads the retry settings from mail.outbox.retry.seconds (60), the wait after the first
+// failed attempt, doubled after every attempt up to an hour, and mail.outbox.expiry.hours (48)
+func NewOutboxDeliveryWorker(cruds map[string]*DbResource, configStore *ConfigStore) *OutboxDeliveryWorker {
+
+	retrySeconds, err := configStore.GetConfigIntValueFor("mail.outbox.retry.seconds", "backend")
+	if err != nil {
+		retrySeconds = 60
+		err = configStore.SetConfigIntValueFor("mail.outbox.retry.seconds", retrySeconds, "backend")
+		CheckErr(err, "Failed to store default outbox retry seconds")
+	}
+
+	expiryHours, err := configStore.GetConfigIntValueFor("mail.outbox.expiry.hours", "backend")
+	if err != nil {
+		expiryHours = 48
+		err = configStore.SetConfigIntValueFor("mail.outbox.expiry.hours", expiryHours, "backend")
+		CheckErr(err, "Failed to store default outbox expiry hours")
+	}
+
+	return &OutboxDeliveryWorker{
+		cruds: cruds,
+		RetryPolicy: ExchangeRetryPolicy{
+			BackoffSeconds:    float64(retrySeconds),
+			BackoffMultiplier: 2,
+			MaxBackoffSeconds: 3600,
+		},
+		ExpiresIn:    time.Duration(expiryHours) * time.Hour,
+		PollInterval: 30 * time.Second,
+		BatchSize:    20,
+		deliver:      DeliverOutboxMail,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Start polls the outbox in the background until Stop is called
+func (w *OutboxDeliveryWorker) Start() {
+	w.stop = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(w.PollInterval)
+		defer ticker.Stop()
+		for {
+			w.ProcessDue()
+			select {
+			case <-ticker.C:
+			case <-w.wake:
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the polling started by Start, after the mails being delivered are done. Mails still due are
+// picked up by the worker of the next run
+func (w *OutboxDeliveryWorker) Stop() {
+	if w.stop == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.stopped
+	})
+}
+
+// Wake makes the worker look at the outbox now instead of at the next poll
+func (w *OutboxDeliveryWorker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue queues the mail with the expiry of the worker and wakes the worker up
+func (w *OutboxDeliveryWorker) Enqueue(from string, to []string, mail []byte) ([]string, error) {
+	referenceIds, err := w.cruds[OUTBOX_TABLE_NAME].EnqueueOutboxMail(from, to, mail, w.ExpiresIn)
+	if len(referenceIds) > 0 {
+		w.Wake()
+	}
+	return referenceIds, err
+}
+
+// ProcessDue delivers the mails whose next attempt is due, until none are left or the worker is stopped
+func (w *OutboxDeliveryWorker) ProcessDue() {
+	for !w.stopping() {
+		mails, err := w.ClaimDue()
+		if err != nil {
+			log.Errorf("Failed to read outbox: %v", err)
+			return
+		}
+		if len(mails) == 0 {
+			return
+		}
+		for _, mail := range mails {
+			w.process(mail)
+		}
+	}
+}
+
+// stopping is true once Stop was called, so a long backlog does not hold up a restart. Claimed mails are
+// always delivered, the check is made between batches
+func (w *OutboxDeliveryWorker) stopping() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClaimDue picks up to BatchSize due mails. A mail is claimed by pushing its next attempt ahead, only the
+// worker whose update changed the row delivers it
+func (w *OutboxDeliveryWorker) ClaimDue() ([]OutboxMail, error) {
+
+	dr := w.cruds[OUTBOX_TABLE_NAME]
+	now := time.Now()
+
+	query, args, err := statementbuilder.Squirrel.
+		Select("id", "reference_id", "from_address", "to_address", "mail", "attempts", "expires_at", "next_attempt_at").
+		From(OUTBOX_TABLE_NAME).
+		Where(goqu.Ex{
+			"status":          []string{OutboxStatusQueued, OutboxStatusRetrying},
+			"next_attempt_at": goqu.Op{"lte": now},
+		}).
+		Order(goqu.C("next_attempt_at").Asc()).
+		Limit(uint(w.BatchSize)).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dr.connection.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	due := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			break
+		}
+		due = append(due, row)
+	}
+	closeErr := rows.Close()
+	CheckErr(closeErr, "Failed to close outbox rows")
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]OutboxMail, 0, len(due))
+	for _, row := range due {
+		query, args, err = statementbuilder.Squirrel.Update(OUTBOX_TABLE_NAME).
+			Set(goqu.Record{"next_attempt_at": now.Add(outboxClaimDuration)}).
+			Where(goqu.Ex{
+				"id":              row["id"],
+				"next_attempt_at": row["next_attempt_at"],
+			}).ToSQL()
+		if err != nil {
+			return claimed, err
+		}
+		result, err := dr.db.Exec(query, args...)
+		if err != nil {
+			return claimed, err
+		}
+		if affected, _ := result.RowsAffected(); affected != 1 {
+			continue
+		}
+
+		mail, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%s", row["mail"]))
+		if err != nil {
+			log.Errorf("Failed to decode outbox mail [%v]: %v", row["reference_id"], err)
+			continue
+		}
+		id, _ := row["id"].(int64)
+		attempts, _ := row["attempts"].(int64)
+		expiresAt, _ := auth.ParseTimeValue(row["expires_at"])
+		claimed = append(claimed, OutboxMail{
+			Id:          id,
+			ReferenceId: fmt.Sprintf("%s", row["reference_id"]),
+			From:        fmt.Sprintf("%s", row["from_address"]),
+			To:          fmt.Sprintf("%s", row["to_address"]),
+			Mail:        mail,
+			Attempts:    int(attempts),
+			ExpiresAt:   expiresAt,
+		})
+	}
+
+	return claimed, nil
+}
+
+func (w *OutboxDeliveryWorker) process(mail OutboxMail) {
+
+	now := time.Now()
+	if !mail.ExpiresAt.IsZero() && now.After(mail.ExpiresAt) {
+		w.finish(mail, OutboxStatusExpired, mail.Attempts, "", "expired before it could be delivered")
+		w.bounce(mail, "the mail could not be delivered before it expired")
+		return
+	}
+
+	attempts := mail.Attempts + 1
+	mxHost, err := w.deliver(mail)
+	if err == nil {
+		log.Printf("Delivered outbox mail [%v] to [%v] via [%v]", mail.ReferenceId, mail.To, mxHost)
+		w.finish(mail, OutboxStatusSent, attempts, mxHost, "")
+		return
+	}
+
+	if IsPermanentMailError(err) {
+		log.Errorf("Outbox mail [%v] to [%v] was rejected: %v", mail.ReferenceId, mail.To, err)
+		w.finish(mail, OutboxStatusBounced, attempts, mxHost, err.Error())
+		w.bounce(mail, err.Error())
+		return
+	}
+
+	backoff := w.RetryPolicy.Backoff(attempts)
+	log.Warnf("Outbox mail [%v] to [%v] attempt %d failed, retrying in %v: %v", mail.ReferenceId, mail.To, attempts, backoff, err)
+	record := goqu.Record{
+		"status":          OutboxStatusRetrying,
+		"attempts":        attempts,
+		"last_error":      err.Error(),
+		"last_attempt_at": now,
+		"next_attempt_at": now.Add(backoff),
+		"updated_at":      now,
+	}
+	w.update(mail, record)
+}
+
+func (w *OutboxDeliveryWorker) finish(mail OutboxMail, status string, attempts int, mxHost string, lastError string) {
+	now := time.Now()
+	record := goqu.Record{
+		"status":          status,
+		"sent":            status == OutboxStatusSent,
+		"attempts":        attempts,
+		"last_attempt_at": now,
+		"updated_at":      now,
+	}
+	if mxHost != "" {
+		record["mx_host"] = mxHost
+	}
+	if lastError != "" {
+		record["last_error"] = lastError
+	}
+	if status == OutboxStatusSent {
+		record["sent_at"] = now
+	}
+	w.update(mail, record)
+}
+
+func (w *OutboxDeliveryWorker) update(mail OutboxMail, record goqu.Record) {
+	query, args, err := statementbuilder.Squirrel.Update(OUTBOX_TABLE_NAME).Set(record).
+		Where(goqu.Ex{"id": mail.Id}).ToSQL()
+	if err == nil {
+		_, err = w.cruds[OUTBOX_TABLE_NAME].db.Exec(query, args...)
+	}
+	CheckErr(err, "Failed to update outbox mail [%v]", mail.ReferenceId)
+}
+
+// bounce puts a delivery failure notice in the INBOX of the sender, if the sender has a mail account here
+func (w *OutboxDeliveryWorker) bounce(mail OutboxMail, reason string) {
+
+	if mail.From == "" || strings.HasPrefix(strings.ToUpper(mail.From), "MAILER-DAEMON@") {
+		return
+	}
+
+	dr := w.cruds[OUTBOX_TABLE_NAME]
+	mailAccount, err := dr.GetUserMailAccountRowByEmail(mail.From)
+	if err != nil {
+		log.Printf("Not bouncing outbox mail [%v], sender [%v] has no mail account", mail.ReferenceId, mail.From)
+		return
+	}
+
+	user, _, err := dr.GetSingleRowByReferenceId(USER_ACCOUNT_TABLE_NAME, mailAccount["user_account_id"].(string), nil)
+	if err != nil {
+		log.Errorf("Failed to get owner of mail account [%v] for bounce: %v", mail.From, err)
+		return
+	}
+	sessionUser := &auth.SessionUser{
+		UserId:          user["id"].(int64),
+		UserReferenceId: user["reference_id"].(string),
+		Groups:          dr.GetObjectUserGroupsByWhere(USER_ACCOUNT_TABLE_NAME, "id", user["id"].(int64)),
+	}
+
+	mailBox, err := dr.GetMailAccountBox(mailAccount["id"].(int64), "INBOX")
+	if err != nil {
+		mailBox, err = dr.CreateMailAccountBox(mailAccount["reference_id"].(string), sessionUser, "INBOX")
+		if err != nil {
+			log.Errorf("Failed to create INBOX of [%v] for bounce: %v", mail.From, err)
+			return
+		}
+	}
+
+	_, host, _ := splitMailAddress(mail.From)
+	daemon := "MAILER-DAEMON@" + host
+	u, _ := uuid.NewV4()
+	messageId := fmt.Sprintf("%s@%s", u.String(), host)
+	bounceMail := BuildBounceMail(daemon, mail, reason, messageId)
+
+	model := api2go.Api2GoModel{
+		Data: map[string]interface{}{
+			"message_id":       messageId,
+			"mail_id":          u.String(),
+			"from_address":     daemon,
+			"to_address":       mail.From,
+			"sender_address":   daemon,
+			"subject":          bounceSubject,
+			"body":             reason,
+			"mail":             base64.StdEncoding.EncodeToString(bounceMail),
+			"spam_score":       0,
+			"spam":             false,
+			"hash":             u.String(),
+			"content_type":     "text/plain; charset=UTF-8",
+			"reply_to_address": "",
+			"internal_date":    time.Now(),
+			"recipient":        mail.From,
+			"has_attachment":   false,
+			"ip_addr":          "",
+			"return_path":      "",
+			"is_tls":           false,
+			"mail_box_id":      mailBox["reference_id"],
+			"user_account_id":  mailAccount["user_account_id"],
+			"seen":             false,
+			"recent":           true,
+			"flags":            "\\Recent",
+			"size":             len(bounceMail),
+		},
+	}
+
+	pr := (&http.Request{Method: "POST"}).WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	_, err = dr.Cruds["mail"].Create(&model, api2go.Request{PlainRequest: pr})
+	CheckErr(err, "Failed to save bounce of outbox mail [%v]", mail.ReferenceId)
+}
+
+const bounceSubject = "Undelivered Mail Returned to Sender"
+
+// BuildBounceMail is the delivery failure notice for the mail, with the headers of the original mail
+func BuildBounceMail(from string, mail OutboxMail, reason string, messageId string) []byte {
+
+	originalHeaders := string(mail.Mail)
+	if end := strings.Index(originalHeaders, "\r\n\r\n"); end > -1 {
+		originalHeaders = originalHeaders[:end]
+	} else if end := strings.Index(originalHeaders, "\n\n"); end > -1 {
+		originalHeaders = originalHeaders[:end]
+	}
+
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMessage-Id: <%s>\r\n"+
+		"Auto-Submitted: auto-replied\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n"+
+		"Your mail to %s could not be delivered after %d attempts.\r\n\r\nReason: %s\r\n\r\n"+
+		"--- Headers of the original mail ---\r\n%s\r\n",
+		from, mail.From, bounceSubject, time.Now().Format(time.RFC1123Z), messageId,
+		mail.To, mail.Attempts+1, reason, originalHeaders))
+}
+
+// IsPermanentMailError is true for 5xx smtp replies and domains which do not exist, the mail is bounced
+// without retrying then
+func IsPermanentMailError(err error) bool {
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 500
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return false
+}
+
+// DeliverOutboxMail sends the mail to the MX hosts of the recipient domain in order of preference, the domain
+// itself is used when it has no MX records. It returns the host which accepted the mail
+func DeliverOutboxMail(mail OutboxMail) (string, error) {
+
+	_, domain, err := splitMailAddress(mail.To)
+	if err != nil {
+		return "", &textproto.Error{Code: 553, Msg: err.Error()}
+	}
+
+	hosts := make([]string, 0)
+	mxs, err := net.LookupMX(domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return "", err
+		}
+	}
+	sort.Slice(mxs, func(i, j int) bool {
+		return mxs[i].Pref < mxs[j].Pref
+	})
+	for _, mx := range mxs {
+		hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+	}
+	if len(hosts) == 0 {
+		hosts = append(hosts, domain)
+	}
+
+	_, heloHost, err := splitMailAddress(mail.From)
+	if err != nil {
+		heloHost = "localhost"
+	}
+
+	var lastErr error
+	for _, host := range hosts {
+		lastErr = sendToMailHost(host, heloHost, mail)
+		if lastErr == nil || IsPermanentMailError(lastErr) {
+			return host, lastErr
+		}
+		log.Warnf("Failed to deliver outbox mail [%v] via [%v]: %v", mail.ReferenceId, host, lastErr)
+	}
+	return "", lastErr
+}
+
+func sendToMailHost(host string, heloHost string, mail OutboxMail) error {
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "25"), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Hello(heloHost); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(mail.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(mail.To); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(mail.Mail); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,329 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsPermanentMailError(t *testing.T) {
+
+	if !IsPermanentMailError(&textproto.Error{Code: 550, Msg: "no such user"}) {
+		t.Errorf("5xx replies should bounce")
+	}
+	if IsPermanentMailError(&textproto.Error{Code: 451, Msg: "try again later"}) {
+		t.Errorf("4xx replies should be retried")
+	}
+	if !IsPermanentMailError(fmt.Errorf("lookup: %w", &net.DNSError{Err: "no such host", IsNotFound: true})) {
+		t.Errorf("unknown domains should bounce")
+	}
+	if IsPermanentMailError(errors.New("connection refused")) {
+		t.Errorf("connection errors should be retried")
+	}
+}
+
+func TestBuildBounceMail(t *testing.T) {
+
+	mail := OutboxMail{
+		From:     "alice@example.com",
+		To:       "bob@example.org",
+		Mail:     []byte("From: alice@example.com\r\nSubject: hello\r\n\r\nsecret body"),
+		Attempts: 2,
+	}
+
+	bounce := string(BuildBounceMail("MAILER-DAEMON@example.com", mail, "550 no such user", "id@example.com"))
+
+	for _, expected := range []string{"To: alice@example.com", "bob@example.org", "after 3 attempts", "550 no such user", "Subject: hello"} {
+		if !strings.Contains(bounce, expected) {
+			t.Errorf("bounce does not contain [%v]:\n%v", expected, bounce)
+		}
+	}
+	if strings.Contains(bounce, "secret body") {
+		t.Errorf("bounce should only carry the headers of the original mail")
+	}
+}
+
+func TestSplitMailAddress(t *testing.T) {
+
+	_, host, err := splitMailAddress("<bob@example.org>")
+	if err != nil || host != "example.org" {
+		t.Errorf("unexpected host [%v]: %v", host, err)
+	}
+	if _, _, err = splitMailAddress("bob"); err == nil {
+		t.Errorf("expected an error for an address without a domain")
+	}
+}
+
+// newOutboxTestWorker creates the standard tables with a mail account for sender@example.com and a worker which
+// delivers through deliver
+// newStandardTestCruds creates the standard tables in a new sqlite database and returns their resources
+func newStandardTestCruds(t *testing.T) (*sqlx.DB, map[string]*DbResource) {
+	databaseDirectory, err := ioutil.TempDir("", "outbox-db")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	db, err := sqlx.Open("sqlite3", "file:"+filepath.Join(databaseDirectory, "daptin.db")+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(databaseDirectory)
+	})
+	db.SetMaxOpenConns(4)
+
+	config := &CmsConfig{
+		Tables:    make([]TableInfo, len(StandardTables)),
+		Relations: make([]api2go.TableRelation, len(StandardRelations)),
+	}
+	copy(config.Tables, StandardTables)
+	copy(config.Relations, StandardRelations)
+	CheckRelations(config)
+	CheckAllTableStatus(config, db)
+	CreateRelations(config, db)
+
+	cruds := make(map[string]*DbResource)
+	for i := range config.Tables {
+		table := config.Tables[i]
+		cruds[table.TableName] = &DbResource{
+			db:               db,
+			connection:       db,
+			Cruds:            cruds,
+			ms:               &MiddlewareSet{},
+			model:            api2go.NewApi2GoModel(table.TableName, table.Columns, int64(auth.DEFAULT_PERMISSION), table.Relations),
+			tableInfo:        &table,
+			contextCache:     make(map[string]interface{}),
+			contextLock:      &sync.RWMutex{},
+			AssetFolderCache: make(map[string]map[string]*AssetFolderCache),
+		}
+	}
+	return db, cruds
+}
+
+// addTestAdministrator adds the user admin@example.com with reference id admin to the administrators group
+func addTestAdministrator(t *testing.T, db *sqlx.DB) {
+	for _, statement := range []string{
+		fmt.Sprintf("insert into user_account (id, reference_id, name, email, permission) values "+
+			"(1, 'admin', 'admin', 'admin@example.com', %d)", auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into usergroup (id, reference_id, name, permission) values (1, 'g1', 'administrators', %d)",
+			auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into user_account_user_account_id_has_usergroup_usergroup_id "+
+			"(user_account_id, usergroup_id, reference_id, permission) values (1, 1, 'ug1', %d)", auth.DEFAULT_PERMISSION),
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+}
+
+func newOutboxTestWorker(t *testing.T, deliver func(mail OutboxMail) (string, error)) *OutboxDeliveryWorker {
+	db, cruds := newStandardTestCruds(t)
+
+	for _, statement := range []string{
+		fmt.Sprintf("insert into user_account (id, reference_id, name, email, permission) values "+
+			"(1, 'admin', 'admin', 'admin@example.com', %d), (2, 'sender', 'sender', 'sender@example.com', %d)",
+			auth.DEFAULT_PERMISSION, auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into mail_server (id, reference_id, hostname, permission) values (1, 'ms1', 'example.com', %d)",
+			auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into mail_account (id, reference_id, username, password, password_md5, mail_server_id, "+
+			"user_account_id, permission) values (1, 'ma1', 'sender@example.com', '', '', 1, 2, %d)", auth.DEFAULT_PERMISSION),
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	return &OutboxDeliveryWorker{
+		cruds: cruds,
+		RetryPolicy: ExchangeRetryPolicy{
+			BackoffSeconds:    60,
+			BackoffMultiplier: 2,
+			MaxBackoffSeconds: 3600,
+		},
+		ExpiresIn:    time.Hour,
+		PollInterval: time.Hour,
+		BatchSize:    10,
+		deliver:      deliver,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// outboxTestMail is the status, attempts and seconds until the next attempt of the outbox row
+func outboxTestMail(t *testing.T, w *OutboxDeliveryWorker, referenceId string) (string, int, float64) {
+	var status string
+	var attempts int
+	var nextAttemptAt time.Time
+	err := w.cruds[OUTBOX_TABLE_NAME].db.QueryRowx("select status, attempts, next_attempt_at from outbox where reference_id = ?",
+		referenceId).Scan(&status, &attempts, &nextAttemptAt)
+	if err != nil {
+		t.Fatalf("Failed to read outbox mail: %v", err)
+	}
+	return status, attempts, time.Until(nextAttemptAt).Seconds()
+}
+
+// makeOutboxDue moves the next attempt of every queued mail to now
+func makeOutboxDue(t *testing.T, w *OutboxDeliveryWorker) {
+	query, args, err := statementbuilder.Squirrel.Update(OUTBOX_TABLE_NAME).
+		Set(goqu.Record{"next_attempt_at": time.Now().Add(-time.Second)}).ToSQL()
+	if err == nil {
+		_, err = w.cruds[OUTBOX_TABLE_NAME].db.Exec(query, args...)
+	}
+	if err != nil {
+		t.Fatalf("Failed to update outbox: %v", err)
+	}
+}
+
+func TestOutboxClaimDueAndRetry(t *testing.T) {
+
+	var lock sync.Mutex
+	deliveries := 0
+	deliverErr := error(&textproto.Error{Code: 451, Msg: "try again later"})
+	w := newOutboxTestWorker(t, func(mail OutboxMail) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		deliveries += 1
+		return "mx.example.org", deliverErr
+	})
+
+	referenceIds, err := w.Enqueue("sender@example.com", []string{"bob@example.org", "carol@example.net"}, []byte("Subject: hi\r\n\r\nhello"))
+	if err != nil || len(referenceIds) != 2 {
+		t.Fatalf("Failed to enqueue mail: %v %v", referenceIds, err)
+	}
+
+	mails, err := w.ClaimDue()
+	if err != nil || len(mails) != 2 || mails[0].To != "bob@example.org" || string(mails[0].Mail) != "Subject: hi\r\n\r\nhello" {
+		t.Fatalf("expected both mails to be claimed, got %v %v", mails, err)
+	}
+	mails, err = w.ClaimDue()
+	if err != nil || len(mails) != 0 {
+		t.Errorf("expected claimed mails to not be picked up again, got %v %v", mails, err)
+	}
+
+	// temporary failures are retried after the backoff of the retry policy
+	makeOutboxDue(t, w)
+	w.ProcessDue()
+	status, attempts, nextAttemptIn := outboxTestMail(t, w, referenceIds[0])
+	if status != OutboxStatusRetrying || attempts != 1 || nextAttemptIn < 50 || nextAttemptIn > 60 {
+		t.Errorf("expected a retry in 60s, got %v %v %v", status, attempts, nextAttemptIn)
+	}
+	w.ProcessDue()
+	if deliveries != 2 {
+		t.Errorf("expected mails to wait for their next attempt, got %d deliveries", deliveries)
+	}
+
+	makeOutboxDue(t, w)
+	w.ProcessDue()
+	status, attempts, nextAttemptIn = outboxTestMail(t, w, referenceIds[0])
+	if status != OutboxStatusRetrying || attempts != 2 || nextAttemptIn < 110 || nextAttemptIn > 120 {
+		t.Errorf("expected the backoff to double, got %v %v %v", status, attempts, nextAttemptIn)
+	}
+
+	lock.Lock()
+	deliverErr = nil
+	lock.Unlock()
+	makeOutboxDue(t, w)
+	w.ProcessDue()
+	status, attempts, _ = outboxTestMail(t, w, referenceIds[1])
+	if status != OutboxStatusSent || attempts != 3 {
+		t.Errorf("expected the mail to be sent on the third attempt, got %v %v", status, attempts)
+	}
+}
+
+func TestOutboxExpiryAndBounce(t *testing.T) {
+
+	deliveries := 0
+	w := newOutboxTestWorker(t, func(mail OutboxMail) (string, error) {
+		deliveries += 1
+		return "mx.example.org", &textproto.Error{Code: 550, Msg: "no such user"}
+	})
+
+	expired, err := w.cruds[OUTBOX_TABLE_NAME].EnqueueOutboxMail("sender@example.com", []string{"bob@example.org"},
+		[]byte("Subject: late\r\n\r\nhello"), -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to enqueue mail: %v", err)
+	}
+	w.ProcessDue()
+	status, _, _ := outboxTestMail(t, w, expired[0])
+	if status != OutboxStatusExpired || deliveries != 0 {
+		t.Errorf("expected the mail to expire without a delivery, got %v after %d deliveries", status, deliveries)
+	}
+
+	rejected, err := w.Enqueue("sender@example.com", []string{"nobody@example.org"}, []byte("Subject: lost\r\n\r\nhello"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue mail: %v", err)
+	}
+	// no bounce for a sender without a mail account here
+	_, err = w.Enqueue("stranger@example.net", []string{"nobody@example.org"}, []byte("Subject: lost\r\n\r\nhello"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue mail: %v", err)
+	}
+	w.ProcessDue()
+	status, attempts, _ := outboxTestMail(t, w, rejected[0])
+	if status != OutboxStatusBounced || attempts != 1 {
+		t.Errorf("expected the rejected mail to bounce, got %v %v", status, attempts)
+	}
+
+	rows, err := w.cruds["mail"].db.Queryx("select m.subject, m.to_address, m.from_address from mail m " +
+		"join mail_box b on b.id = m.mail_box_id where b.name = 'INBOX' and b.mail_account_id = 1")
+	if err != nil {
+		t.Fatalf("Failed to read bounces: %v", err)
+	}
+	defer rows.Close()
+	bounces := 0
+	for rows.Next() {
+		var subject, to, from string
+		err = rows.Scan(&subject, &to, &from)
+		if err != nil || subject != bounceSubject || to != "sender@example.com" || from != "MAILER-DAEMON@example.com" {
+			t.Errorf("unexpected bounce %v %v %v %v", subject, to, from, err)
+		}
+		bounces += 1
+	}
+	if bounces != 2 {
+		t.Errorf("expected a bounce for the expired and the rejected mail, got %d", bounces)
+	}
+}
+
+func TestOutboxDeliveryWorkerStop(t *testing.T) {
+
+	w := newOutboxTestWorker(t, func(mail OutboxMail) (string, error) {
+		return "mx.example.org", nil
+	})
+	w.Stop()
+
+	w.Start()
+	referenceIds, err := w.Enqueue("sender@example.com", []string{"bob@example.org"}, []byte("Subject: hi\r\n\r\nhello"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue mail: %v", err)
+	}
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if status, _, _ := outboxTestMail(t, w, referenceIds[0]); status == OutboxStatusSent {
+			break
+		}
+	}
+	w.Stop()
+	w.Stop()
+
+	select {
+	case <-w.stopped:
+	default:
+		t.Errorf("expected the polling to have returned")
+	}
+	if status, _, _ := outboxTestMail(t, w, referenceIds[0]); status != OutboxStatusSent {
+		t.Errorf("expected the woken worker to send the mail, got %v", status)
+	}
+}
@@ -0,0 +1,172 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchQueries checks a row which is already in memory (like the EventData of an EventMessage)
+// against queries in the same format as the query param of the find all api.
+// All the queries need to match, a query on a column not present in data does not match
+func MatchQueries(data map[string]interface{}, queries []Query) (bool, error) {
+	for _, query := range queries {
+		matched, err := MatchQuery(data, query)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func MatchQuery(data map[string]interface{}, query Query) (bool, error) {
+
+	value, present := data[query.ColumnName]
+
+	switch query.Operator {
+	case "contains":
+		return strings.Contains(toMatchString(value), toMatchString(query.Value)), nil
+	case "not contains":
+		return !strings.Contains(toMatchString(value), toMatchString(query.Value)), nil
+	case "begins with":
+		return strings.HasPrefix(toMatchString(value), toMatchString(query.Value)), nil
+	case "ends with":
+		return strings.HasSuffix(toMatchString(value), toMatchString(query.Value)), nil
+	}
+
+	opValue, ok := OperatorMap[query.Operator]
+	if !ok {
+		opValue = query.Operator
+	}
+
+	switch opValue {
+	case "is nil", "is null", "is empty":
+		return value == nil || toMatchString(value) == "", nil
+	case "not nil", "not null", "not empty":
+		return value != nil && toMatchString(value) != "", nil
+	case "is true":
+		return isTruthy(value), nil
+	case "is false":
+		return present && !isTruthy(value), nil
+	}
+
+	if !present {
+		return false, nil
+	}
+
+	switch opValue {
+	case "is", "eq", "=":
+		return compareValues(value, query.Value) == 0, nil
+	case "isNot", "neq", "not", "!=":
+		return compareValues(value, query.Value) != 0, nil
+	case "lt":
+		return compareValues(value, query.Value) < 0, nil
+	case "lte":
+		return compareValues(value, query.Value) <= 0, nil
+	case "gt":
+		return compareValues(value, query.Value) > 0, nil
+	case "gte":
+		return compareValues(value, query.Value) >= 0, nil
+	case "in", "any of":
+		return inValues(value, query.Value), nil
+	case "notIn", "none of":
+		return !inValues(value, query.Value), nil
+	case "like", "iLike":
+		return likeMatch(toMatchString(value), toMatchString(query.Value), opValue == "iLike")
+	case "notLike", "notILike":
+		matched, err := likeMatch(toMatchString(value), toMatchString(query.Value), opValue == "notILike")
+		return !matched, err
+	}
+
+	return false, fmt.Errorf("unsupported operator [%v] on column [%v]", query.Operator, query.ColumnName)
+}
+
+func toMatchString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case nil:
+		return false
+	}
+	s := strings.ToLower(toMatchString(value))
+	return s == "true" || s == "1"
+}
+
+// compareValues compares the values as numbers when both of them are numbers, and as strings otherwise
+func compareValues(left, right interface{}) int {
+	leftString := toMatchString(left)
+	rightString := toMatchString(right)
+
+	leftNumber, leftErr := strconv.ParseFloat(leftString, 64)
+	rightNumber, rightErr := strconv.ParseFloat(rightString, 64)
+	if leftErr == nil && rightErr == nil {
+		switch {
+		case leftNumber < rightNumber:
+			return -1
+		case leftNumber > rightNumber:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(leftString, rightString)
+}
+
+// inValues accepts a list or a comma separated string of values, as the find all api does
+func inValues(value interface{}, values interface{}) bool {
+	var candidates []interface{}
+	switch v := values.(type) {
+	case []interface{}:
+		candidates = v
+	case []string:
+		candidates = ToInterfaceArray(v)
+	default:
+		candidates = ToInterfaceArray(strings.Split(toMatchString(values), ","))
+	}
+
+	for _, candidate := range candidates {
+		if compareValues(value, candidate) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// likeMatch evaluates a sql like pattern, % matches any run of characters and _ matches one
+func likeMatch(value string, pattern string, caseInsensitive bool) (bool, error) {
+	var expression strings.Builder
+	if caseInsensitive {
+		expression.WriteString("(?is)")
+	} else {
+		expression.WriteString("(?s)")
+	}
+	expression.WriteString("^")
+	for _, char := range pattern {
+		switch char {
+		case '%':
+			expression.WriteString(".*")
+		case '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expression.WriteString("$")
+
+	compiled, err := regexp.Compile(expression.String())
+	if err != nil {
+		return false, err
+	}
+	return compiled.MatchString(value), nil
+}
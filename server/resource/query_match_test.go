@@ -0,0 +1,59 @@
+package resource
+
+import "testing"
+
+func TestMatchQueries(t *testing.T) {
+	data := map[string]interface{}{
+		"title":    "Quarterly report",
+		"status":   "open",
+		"priority": int64(3),
+		"archived": false,
+		"owner":    nil,
+	}
+
+	cases := []struct {
+		query    Query
+		expected bool
+	}{
+		{Query{ColumnName: "status", Operator: "is", Value: "open"}, true},
+		{Query{ColumnName: "status", Operator: "is not", Value: "open"}, false},
+		{Query{ColumnName: "status", Operator: "in", Value: "closed,open"}, true},
+		{Query{ColumnName: "status", Operator: "none of", Value: []interface{}{"closed", "open"}}, false},
+		{Query{ColumnName: "priority", Operator: "more then", Value: "2"}, true},
+		{Query{ColumnName: "priority", Operator: "less then", Value: 3}, false},
+		{Query{ColumnName: "priority", Operator: "lte", Value: 3}, true},
+		{Query{ColumnName: "title", Operator: "contains", Value: "report"}, true},
+		{Query{ColumnName: "title", Operator: "begins with", Value: "Annual"}, false},
+		{Query{ColumnName: "title", Operator: "like", Value: "Quarter%"}, true},
+		{Query{ColumnName: "title", Operator: "ilike", Value: "%REPORT"}, true},
+		{Query{ColumnName: "title", Operator: "not like", Value: "%report"}, false},
+		{Query{ColumnName: "archived", Operator: "is false"}, true},
+		{Query{ColumnName: "archived", Operator: "is true"}, false},
+		{Query{ColumnName: "owner", Operator: "is empty"}, true},
+		{Query{ColumnName: "missing", Operator: "is", Value: "open"}, false},
+	}
+
+	for _, c := range cases {
+		matched, err := MatchQuery(data, c.query)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", c.query, err)
+			continue
+		}
+		if matched != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.query, c.expected, matched)
+		}
+	}
+
+	matched, err := MatchQueries(data, []Query{
+		{ColumnName: "status", Operator: "is", Value: "open"},
+		{ColumnName: "priority", Operator: "gt", Value: 5},
+	})
+	if err != nil || matched {
+		t.Errorf("expected all queries to be required to match")
+	}
+
+	_, err = MatchQuery(data, Query{ColumnName: "status", Operator: "sounds like", Value: "open"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown operator")
+	}
+}
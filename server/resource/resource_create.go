@@ -18,6 +18,7 @@ import (
 
 	"github.com/araddon/dateparse"
 	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/statementbuilder"
 	"github.com/pkg/errors"
 
@@ -31,16 +32,21 @@ const DEFAULT_LANGUAGE = "en"
 func NewFromDbResourceWithTransaction(resources *DbResource, tx *sqlx.Tx) *DbResource {
 
 	return &DbResource{
-		Cruds:            resources.Cruds,
-		configStore:      resources.configStore,
-		model:            resources.model,
-		db:               tx,
-		connection:       resources.connection,
-		ActionHandlerMap: resources.ActionHandlerMap,
-		contextCache:     resources.contextCache,
-		defaultGroups:    resources.defaultGroups,
-		ms:               resources.ms,
-		tableInfo:        resources.tableInfo,
+		Cruds:              resources.Cruds,
+		configStore:        resources.configStore,
+		model:              resources.model,
+		db:                 tx,
+		connection:         database.NewTransactionConnection(tx, resources.connection),
+		ActionHandlerMap:   resources.ActionHandlerMap,
+		contextCache:       resources.contextCache,
+		contextLock:        resources.contextLock,
+		defaultGroups:      resources.defaultGroups,
+		ms:                 resources.ms,
+		tableInfo:          resources.tableInfo,
+		OlricDb:            resources.OlricDb,
+		AssetFolderCache:   resources.AssetFolderCache,
+		SubsiteFolderCache: resources.SubsiteFolderCache,
+		MailSender:         resources.MailSender,
 	}
 
 }
@@ -66,6 +72,13 @@ func (dr *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Request) (
 
 	attrs := data.GetAllAsAttributes()
 
+	if !isAdmin {
+		err := dr.CheckWritableColumns(sessionUser, attrs, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	allColumns := dr.model.GetColumns()
 
 	dataToInsert := make(map[string]interface{})
@@ -517,6 +530,15 @@ func (dr *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Request) (
 		//	log.Errorf("Failed to insert add user relation for usergroup [%v]: %v", dr.model.GetName(), err)
 		//}
 
+	} else if dr.model.GetName() == "mail" {
+
+		if encodedMail, ok := attrs["mail"].(string); ok {
+			err = dr.IndexMailSearchTerms(createdResource["id"].(int64), encodedMail)
+			CheckErr(err, "Failed to index mail [%v] for search", createdResource["reference_id"])
+		}
+		err = dr.TouchMailModSequence(createdResource["id"].(int64))
+		CheckErr(err, "Failed to set mod-sequence of mail [%v]", createdResource["reference_id"])
+
 	} else if dr.model.GetName() == USER_ACCOUNT_TABLE_NAME {
 
 		adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
@@ -606,6 +628,11 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 		}
 	}
 
+	if createdResource != nil {
+		sessionUser, _ := req.PlainRequest.Context().Value("user").(*auth.SessionUser)
+		createdResource = dr.StripUnreadableColumns(sessionUser, []map[string]interface{}{createdResource})[0]
+	}
+
 	n1 := dr.model.GetName()
 	c1 := dr.model.GetColumns()
 	p1 := dr.model.GetDefaultPermission()
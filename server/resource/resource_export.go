@@ -0,0 +1,350 @@
+package resource
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gocarina/gocsv"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExportBatchSize is the number of rows read from the database at a time while streaming an export
+const ExportBatchSize = 1000
+
+// Formats accepted by StreamExport
+const (
+	ExportFormatNdjson = "ndjson"
+	ExportFormatCsv    = "csv"
+	ExportFormatSql    = "sql"
+)
+
+func IsValidExportFormat(format string) bool {
+	switch format {
+	case ExportFormatNdjson, ExportFormatCsv, ExportFormatSql:
+		return true
+	}
+	return false
+}
+
+func ExportContentType(format string) string {
+	switch format {
+	case ExportFormatCsv:
+		return "text/csv"
+	case ExportFormatSql:
+		return "application/sql"
+	}
+	return "application/x-ndjson"
+}
+
+// exportRowWriter writes the rows of an export one at a time in one of the export formats
+type exportRowWriter interface {
+	WriteRow(row map[string]interface{}) error
+	Close() error
+}
+
+// StreamExport writes all the rows of the table matching the query, filter and fields params of the
+// request (same as the find all api) to writer. Rows are read in batches of ExportBatchSize ordered by id
+// and go through the same permission checks as the find all api, so users only export rows they can read.
+// The sql format keeps the ids of the rows and of the rows their foreign keys refer to, so the statements can
+// be imported again. writer is flushed after every batch if it is a http.Flusher
+func (dr *DbResource) StreamExport(req api2go.Request, format string, writer io.Writer) error {
+
+	if !IsValidExportFormat(format) {
+		return fmt.Errorf("invalid export format [%v], expected one of ndjson, csv or sql", format)
+	}
+
+	for _, bf := range dr.ms.BeforeFindAll {
+		_, err := bf.InterceptBefore(dr, &req, []map[string]interface{}{})
+		if err != nil {
+			log.Printf("Error from BeforeFindAll middleware [%v] on export: %v", bf.String(), err)
+			return err
+		}
+	}
+
+	fields := exportFieldNames(req.QueryParams)
+	rowWriter := newExportRowWriter(format, dr.tableInfo.TableName, fields, writer)
+	flusher, canFlush := writer.(http.Flusher)
+
+	queryParams := url.Values{}
+	for key, values := range req.QueryParams {
+		switch key {
+		case "page[number]", "page[size]", "page[after]", "page[before]", "sort", "included_relations":
+			continue
+		}
+		queryParams[key] = values
+	}
+	queryParams["sort"] = []string{"id"}
+	queryParams["page[size]"] = []string{fmt.Sprintf("%d", ExportBatchSize)}
+
+	exported := 0
+	for {
+		batchRequest := api2go.Request{
+			PlainRequest: req.PlainRequest,
+			QueryParams:  copyQueryParams(queryParams),
+			Pagination:   req.Pagination,
+			Header:       req.Header,
+		}
+
+		results, _, _, _, err := dr.PaginatedFindAllWithoutFilters(batchRequest)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			break
+		}
+		// the next batch starts after the last row of this batch, before any of them are filtered out
+		lastReferenceId := fmt.Sprintf("%v", results[len(results)-1]["reference_id"])
+		batchCount := len(results)
+
+		for _, bf := range dr.ms.AfterFindAll {
+			results, err = bf.InterceptAfter(dr, &batchRequest, results)
+			if err != nil {
+				log.Errorf("Error from AfterFindAll[%v] middleware on export: %v", bf.String(), err)
+			}
+		}
+
+		if format == ExportFormatSql {
+			err = dr.rawForeignKeys(results)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, row := range results {
+			if format != ExportFormatSql {
+				delete(row, "id")
+			}
+			delete(row, "__type")
+			if len(fields) > 0 {
+				for column := range row {
+					if column != "reference_id" && column != "id" && !InStringArray(fields, column) {
+						delete(row, column)
+					}
+				}
+			}
+			err = rowWriter.WriteRow(row)
+			if err != nil {
+				return err
+			}
+			exported += 1
+		}
+
+		if canFlush {
+			flusher.Flush()
+		}
+
+		if batchCount < ExportBatchSize {
+			break
+		}
+		queryParams["page[after]"] = []string{lastReferenceId}
+	}
+
+	log.Printf("Exported [%d] rows of [%v] as %v", exported, dr.tableInfo.TableName, format)
+	return rowWriter.Close()
+}
+
+// rawForeignKeys replaces the reference ids in the foreign key columns of the rows with the ids of the rows they
+// refer to, looked up once for every column of the batch
+func (dr *DbResource) rawForeignKeys(rows []map[string]interface{}) error {
+	for _, col := range dr.tableInfo.Columns {
+		if !col.IsForeignKey || col.ForeignKeyData.DataSource != "self" {
+			continue
+		}
+
+		referenceIds := make([]string, 0, len(rows))
+		for _, row := range rows {
+			if referenceId, ok := row[col.ColumnName].(string); ok && referenceId != "" {
+				referenceIds = append(referenceIds, referenceId)
+			}
+		}
+		if len(referenceIds) == 0 {
+			continue
+		}
+
+		ids, err := dr.GetReferenceIdListToIdList(col.ForeignKeyData.Namespace, referenceIds)
+		if err != nil {
+			return fmt.Errorf("failed to get ids of [%v] for [%v]: %v", col.ForeignKeyData.Namespace, col.ColumnName, err)
+		}
+		for _, row := range rows {
+			referenceId, ok := row[col.ColumnName].(string)
+			if !ok || referenceId == "" {
+				continue
+			}
+			id, ok := ids[referenceId]
+			if !ok {
+				log.Printf("No [%v] with reference id [%v] for [%v] of exported row, exported as null", col.ForeignKeyData.Namespace, referenceId, col.ColumnName)
+				row[col.ColumnName] = nil
+				continue
+			}
+			row[col.ColumnName] = id
+		}
+	}
+	return nil
+}
+
+// exportFieldNames returns the columns asked for in the fields param, reference_id is always exported
+func exportFieldNames(queryParams map[string][]string) []string {
+	fields := make([]string, 0)
+	for _, f := range queryParams["fields"] {
+		for _, name := range strings.Split(f, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || name == "reference_id" || InStringArray(fields, name) {
+				continue
+			}
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return fields
+	}
+	return append([]string{"reference_id"}, fields...)
+}
+
+func copyQueryParams(queryParams map[string][]string) map[string][]string {
+	copied := make(map[string][]string, len(queryParams))
+	for key, values := range queryParams {
+		copied[key] = append([]string{}, values...)
+	}
+	return copied
+}
+
+// ExportColumnNames is the list of columns written for rows in csv exports, in alphabetical order
+func ExportColumnNames(row map[string]interface{}) []string {
+	columnKeys := make([]string, 0, len(row))
+	for colName := range row {
+		columnKeys = append(columnKeys, colName)
+	}
+	sort.Strings(columnKeys)
+	return columnKeys
+}
+
+func newExportRowWriter(format string, tableName string, columns []string, writer io.Writer) exportRowWriter {
+	switch format {
+	case ExportFormatCsv:
+		csvFileWriter := csv.NewWriter(writer)
+		return &csvExportWriter{
+			fileWriter: csvFileWriter,
+			csvWriter:  gocsv.NewSafeCSVWriter(csvFileWriter),
+			columns:    columns,
+		}
+	case ExportFormatSql:
+		return &sqlExportWriter{
+			tableName: tableName,
+			writer:    writer,
+		}
+	}
+	return &ndjsonExportWriter{
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+type ndjsonExportWriter struct {
+	encoder interface{ Encode(v interface{}) error }
+}
+
+func (w *ndjsonExportWriter) WriteRow(row map[string]interface{}) error {
+	return w.encoder.Encode(row)
+}
+
+func (w *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	fileWriter *csv.Writer
+	csvWriter  *gocsv.SafeCSVWriter
+	// header of the file, taken from the first row unless the columns were asked for in fields
+	columns       []string
+	headerWritten bool
+}
+
+func (w *csvExportWriter) WriteRow(row map[string]interface{}) error {
+	if !w.headerWritten {
+		if len(w.columns) == 0 {
+			w.columns = ExportColumnNames(row)
+		}
+		err := w.csvWriter.Write(w.columns)
+		if err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	dataRow := make([]string, 0, len(w.columns))
+	for _, colName := range w.columns {
+		dataRow = append(dataRow, exportCsvValue(row[colName]))
+	}
+	err := w.csvWriter.Write(dataRow)
+	if err != nil {
+		return err
+	}
+	// flush the csv buffer with every row so that a flush of the underlying writer sends complete batches
+	w.csvWriter.Flush()
+	return w.fileWriter.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	if !w.headerWritten && len(w.columns) > 0 {
+		err := w.csvWriter.Write(w.columns)
+		if err != nil {
+			return err
+		}
+	}
+	w.csvWriter.Flush()
+	return w.fileWriter.Error()
+}
+
+func exportCsvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	case map[string]interface{}, []interface{}, []map[string]interface{}:
+		return toJson(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// sqlExportWriter writes every row as an INSERT statement in the dialect of the database
+type sqlExportWriter struct {
+	tableName string
+	writer    io.Writer
+}
+
+func (w *sqlExportWriter) WriteRow(row map[string]interface{}) error {
+	record := goqu.Record{}
+	for column, value := range row {
+		switch v := value.(type) {
+		case map[string]interface{}, []interface{}, []map[string]interface{}:
+			record[column] = toJson(v)
+		default:
+			record[column] = value
+		}
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(w.tableName).Rows(record).ToSQL()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("failed to inline values in insert statement for [%v]", w.tableName)
+	}
+
+	_, err = io.WriteString(w.writer, query+";\n")
+	return err
+}
+
+func (w *sqlExportWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+package resource
+
+import (
+	"bytes"
+	"github.com/artpar/api2go"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"testing"
+)
+
+func TestExportFieldNames(t *testing.T) {
+	fields := exportFieldNames(map[string][]string{
+		"fields": {"title,status", "status"},
+	})
+	if strings.Join(fields, ",") != "reference_id,title,status" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if len(exportFieldNames(map[string][]string{})) != 0 {
+		t.Errorf("expected no fields when none are asked for")
+	}
+}
+
+func TestExportRowWriters(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"reference_id": "a1", "title": "first, with comma", "count": int64(2)},
+		{"reference_id": "b2", "title": "it's second", "count": nil},
+	}
+
+	cases := map[string]string{
+		ExportFormatCsv: "count,reference_id,title\n" +
+			"2,a1,\"first, with comma\"\n" +
+			",b2,it's second\n",
+		ExportFormatSql: "INSERT INTO \"ticket\" (\"count\", \"reference_id\", \"title\") VALUES (2, 'a1', 'first, with comma');\n" +
+			"INSERT INTO \"ticket\" (\"count\", \"reference_id\", \"title\") VALUES (NULL, 'b2', 'it''s second');\n",
+	}
+
+	for format, expected := range cases {
+		var buffer bytes.Buffer
+		writer := newExportRowWriter(format, "ticket", nil, &buffer)
+		for _, row := range rows {
+			err := writer.WriteRow(row)
+			if err != nil {
+				t.Fatalf("failed to write %v row: %v", format, err)
+			}
+		}
+		err := writer.Close()
+		if err != nil {
+			t.Fatalf("failed to close %v writer: %v", format, err)
+		}
+		if buffer.String() != expected {
+			t.Errorf("unexpected %v export:\n%v\nexpected:\n%v", format, buffer.String(), expected)
+		}
+	}
+}
+
+func TestCsvExportHeaderFromFields(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := newExportRowWriter(ExportFormatCsv, "ticket", []string{"reference_id", "title"}, &buffer)
+	err := writer.Close()
+	if err != nil {
+		t.Fatalf("failed to close csv writer: %v", err)
+	}
+	if buffer.String() != "reference_id,title\n" {
+		t.Errorf("expected only the header for an empty export, got [%v]", buffer.String())
+	}
+}
+
+func TestSqlExportImportsForeignKeys(t *testing.T) {
+	schema := []string{
+		"create table customer (id integer primary key, reference_id varchar(40), name varchar(40))",
+		"create table purchase (id integer primary key, reference_id varchar(40), amount integer, customer_id integer references customer(id))",
+	}
+	source, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	source.SetMaxOpenConns(1)
+	for _, statement := range append(schema, "insert into customer (id, reference_id, name) values (7, 'c7', 'ann')") {
+		_, err = source.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	purchase := &DbResource{
+		connection: source,
+		tableInfo: &TableInfo{
+			TableName: "purchase",
+			Columns: []api2go.ColumnInfo{
+				{ColumnName: "amount"},
+				{ColumnName: "customer_id", IsForeignKey: true, ForeignKeyData: api2go.ForeignKeyData{DataSource: "self", Namespace: "customer", KeyName: "id"}},
+			},
+		},
+	}
+
+	// rows as the find all api returns them, with the reference id of the customer
+	rows := []map[string]interface{}{
+		{"id": int64(3), "reference_id": "p3", "amount": int64(10), "customer_id": "c7"},
+		{"id": int64(4), "reference_id": "p4", "amount": int64(20), "customer_id": nil},
+	}
+	err = purchase.rawForeignKeys(rows)
+	if err != nil {
+		t.Fatalf("Failed to get raw foreign keys: %v", err)
+	}
+	var buffer bytes.Buffer
+	writer := newExportRowWriter(ExportFormatSql, "purchase", nil, &buffer)
+	for _, row := range rows {
+		err = writer.WriteRow(row)
+		if err != nil {
+			t.Fatalf("Failed to write row: %v", err)
+		}
+	}
+
+	target, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	target.SetMaxOpenConns(1)
+	for _, statement := range append(schema, "insert into customer (id, reference_id, name) values (7, 'c7', 'ann')") {
+		_, err = target.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+	_, err = target.Exec(buffer.String())
+	if err != nil {
+		t.Fatalf("Failed to import export:\n%v\n%v", buffer.String(), err)
+	}
+
+	var name string
+	err = target.QueryRow("select c.name from purchase p join customer c on c.id = p.customer_id where p.reference_id = 'p3'").Scan(&name)
+	if err != nil || name != "ann" {
+		t.Errorf("expected the imported purchase to refer to the customer, got [%v] %v", name, err)
+	}
+	var count int
+	err = target.QueryRow("select count(*) from purchase where id in (3, 4)").Scan(&count)
+	if err != nil || count != 2 {
+		t.Errorf("expected the rows to keep their ids, got %v %v", count, err)
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table ticket (id integer primary key, reference_id varchar(40))",
+		"insert into ticket (id, reference_id) values (5, 't5')",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+	ticket := &DbResource{db: db, connection: db, tableInfo: &TableInfo{TableName: "ticket"}}
+
+	cursor, err := ticket.pageCursor(map[string][]string{"page[number]": {"2"}})
+	if err != nil || cursor != nil {
+		t.Errorf("expected no cursor without page[after] or page[before], got %v %v", cursor, err)
+	}
+	cursor, err = ticket.pageCursor(map[string][]string{"page[after]": {"t5"}})
+	if err != nil || cursor["ticket.id"].(goqu.Op)["gt"] != int64(5) {
+		t.Errorf("expected the rows after id 5, got %v %v", cursor, err)
+	}
+	cursor, err = ticket.pageCursor(map[string][]string{"page[before]": {"t5"}})
+	if err != nil || cursor["ticket.id"].(goqu.Op)["lt"] != int64(5) {
+		t.Errorf("expected the rows before id 5, got %v %v", cursor, err)
+	}
+
+	for _, param := range []string{"page[after]", "page[before]"} {
+		_, err = ticket.pageCursor(map[string][]string{param: {"missing"}})
+		httpError, ok := err.(api2go.HTTPError)
+		if !ok || httpError.Status() != 400 {
+			t.Errorf("expected a missing %v row to be rejected, got %v", param, err)
+		}
+	}
+}
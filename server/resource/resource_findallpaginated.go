@@ -235,6 +235,22 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		//}
 	}
 
+	if !isAdmin {
+		// values of a column the user cannot read would show in which rows match or how they are ordered
+		queriedColumns := make([]string, 0, len(queries)+len(groupings))
+		for _, q := range queries {
+			queriedColumns = append(queriedColumns, q.ColumnName)
+		}
+		for _, g := range groupings {
+			queriedColumns = append(queriedColumns, g.ColumnName)
+		}
+		queriedColumns = append(queriedColumns, req.QueryParams["sort"]...)
+		err = dr.CheckQueryableColumns(sessionUser, []string{dr.tableInfo.TableName}, queriedColumns)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+	}
+
 	//filters := []string{}
 
 	//if len(req.QueryParams["filter"]) > 0 {
@@ -376,20 +392,12 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 
 	}
 
-	if req.QueryParams["page[after]"] != nil && len(req.QueryParams["page[after]"]) > 0 {
-		id, err := dr.GetReferenceIdToId(dr.TableInfo().TableName, req.QueryParams["page[after]"][0])
-		if err != nil {
-			queryBuilder = queryBuilder.Where(goqu.Ex{
-				dr.TableInfo().TableName + ".id": goqu.Op{"gt": id},
-			}).Limit(uint(pageSize))
-		}
-	} else if req.QueryParams["page[before]"] != nil && len(req.QueryParams["page[before]"]) > 0 {
-		id, err := dr.GetReferenceIdToId(dr.TableInfo().TableName, req.QueryParams["page[before]"][0])
-		if err != nil {
-			queryBuilder = queryBuilder.Where(goqu.Ex{
-				dr.TableInfo().TableName + ".id": goqu.Op{"lt": id},
-			}).Limit(uint(pageSize))
-		}
+	cursor, err := dr.pageCursor(req.QueryParams)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if cursor != nil {
+		queryBuilder = queryBuilder.Where(cursor).Limit(uint(pageSize))
 	} else {
 		queryBuilder = queryBuilder.Offset(uint(pageNumber)).Limit(uint(pageSize))
 	}
@@ -404,7 +412,8 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		colsToAdd := make([]string, 0)
 
 		for _, col := range infos {
-			if col.IsIndexed && (col.ColumnType == "name" || col.ColumnType == "label" || col.ColumnType == "email") {
+			if col.IsIndexed && (col.ColumnType == "name" || col.ColumnType == "label" || col.ColumnType == "email") &&
+				(isAdmin || dr.tableInfo.QueryableColumn(col.ColumnName)) {
 				colsToAdd = append(colsToAdd, col.ColumnName)
 			}
 		}
@@ -1007,7 +1016,10 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		results, includes, err = dr.ResultToArrayOfMap(rows, dr.model.GetColumnMap(), includedRelations)
 
 	}
-	total1 = dr.GetTotalCountBySelectBuilder(countQueryBuilder)
+	// a cursor page is asked for by clients walking the whole table, which do not need the count of every page
+	if cursor == nil {
+		total1 = dr.GetTotalCountBySelectBuilder(countQueryBuilder)
+	}
 
 	//log.Printf("Found: %d results", len(results))
 	//log.Printf("Results: %v", results)
@@ -1016,6 +1028,13 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		pageSize = 10
 	}
 
+	if !isAdmin {
+		results = dr.StripUnreadableColumns(sessionUser, results)
+		for i := range includes {
+			includes[i] = dr.StripUnreadableColumns(sessionUser, includes[i])
+		}
+	}
+
 	paginationData := &PaginationData{
 		PageNumber: pageNumber,
 		PageSize:   pageSize,
@@ -1026,6 +1045,28 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 
 }
 
+// pageCursor is the where clause of a page[after] or page[before] request, nil when the request has neither. The
+// row the cursor refers to has to exist, otherwise the page would silently start from the first row
+func (dr *DbResource) pageCursor(queryParams map[string][]string) (goqu.Ex, error) {
+	operator := "gt"
+	cursor := queryParams["page[after]"]
+	if len(cursor) == 0 {
+		operator = "lt"
+		cursor = queryParams["page[before]"]
+	}
+	if len(cursor) == 0 {
+		return nil, nil
+	}
+
+	id, err := dr.GetReferenceIdToId(dr.TableInfo().TableName, cursor[0])
+	if err != nil {
+		return nil, api2go.NewHTTPError(err, fmt.Sprintf("page cursor [%v] not found", cursor[0]), 400)
+	}
+	return goqu.Ex{
+		dr.TableInfo().TableName + ".id": goqu.Op{operator: id},
+	}, nil
+}
+
 func ValuesOf(mapItem map[string]int64) []int64 {
 	ret := make([]int64, 0)
 	for _, item := range mapItem {
@@ -1315,6 +1356,9 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 	//log.Printf("Request [%v]: %v", dr.model.GetName(), req.QueryParams)
 
 	results, includes, pagination, finalResponseIsSingleObject, err := dr.PaginatedFindAllWithoutFilters(req)
+	if httpErr, ok := err.(api2go.HTTPError); ok {
+		return 0, NewResponse(nil, httpErr, httpErr.Status(), nil), httpErr
+	}
 
 	for _, bf := range dr.ms.AfterFindAll {
 		//log.Printf("Invoke AfterFindAll [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
@@ -115,6 +115,12 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 		}
 	}
 
+	sessionUser, _ := req.PlainRequest.Context().Value("user").(*auth.SessionUser)
+	if data != nil {
+		data = dr.StripUnreadableColumns(sessionUser, []map[string]interface{}{data})[0]
+	}
+	include = dr.StripUnreadableColumns(sessionUser, include)
+
 	delete(data, "id")
 
 	infos := dr.model.GetColumns()
@@ -10,7 +10,9 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/statementbuilder"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/pkg/errors"
@@ -30,6 +32,50 @@ type AggregationRequest struct {
 	TimeSample    TimeStamp
 	TimeFrom      string
 	TimeTo        string
+	// TimeColumn is the datetime column bucketed by TimeSample, created_at by default
+	TimeColumn string
+}
+
+// Tables are the root table and the joined tables the aggregation reads
+func (req AggregationRequest) Tables() []string {
+	tables := []string{req.RootEntity}
+	for _, join := range req.Join {
+		tables = append(tables, strings.Split(join, "@")[0])
+	}
+	return tables
+}
+
+// Expressions are the filters, groupings, projections, orders and join conditions of the aggregation, which name
+// the columns it reads
+func (req AggregationRequest) Expressions() []string {
+	expressions := make([]string, 0, len(req.Filter)+len(req.GroupBy)+len(req.ProjectColumn)+len(req.Order)+len(req.Having)+len(req.Join)+1)
+	expressions = append(expressions, req.Filter...)
+	expressions = append(expressions, req.GroupBy...)
+	expressions = append(expressions, req.ProjectColumn...)
+	expressions = append(expressions, req.Order...)
+	expressions = append(expressions, req.Having...)
+	for _, query := range req.Query {
+		expressions = append(expressions, query.ColumnName)
+	}
+	for _, join := range req.Join {
+		if i := strings.Index(join, "@"); i > -1 {
+			expressions = append(expressions, join[i+1:])
+		}
+	}
+	if req.TimeColumn != "" {
+		expressions = append(expressions, req.TimeColumn)
+	}
+	return expressions
+}
+
+// AllowedBy is false when the session is from an api key whose scopes do not allow reading one of the tables
+func (req AggregationRequest) AllowedBy(sessionUser *auth.SessionUser) bool {
+	for _, tableName := range req.Tables() {
+		if !sessionUser.AllowsTable(tableName, "GET") {
+			return false
+		}
+	}
+	return true
 }
 
 type AggregateRow struct {
@@ -145,12 +191,64 @@ func (dr *DbResource) DataStats(req AggregationRequest) (*AggregateData, error)
 		projectionsAdded = append(projectionsAdded, goqu.L("count(*)").As("count"))
 	}
 
+	groupByExpressions := ToInterfaceArray(req.GroupBy)
+	orderExpressions := ToOrderedExpressionArray(req.Order)
+	timeRangeExpressions := make([]goqu.Expression, 0)
+	var timeFrom, timeTo *time.Time
+
+	if req.TimeSample != "" {
+		if !req.TimeSample.IsValid() {
+			return nil, fmt.Errorf("invalid time sample [%v], expected one of minute, hour, day, week, month or year", req.TimeSample)
+		}
+
+		timeColumn := req.TimeColumn
+		if timeColumn == "" {
+			timeColumn = DefaultTimeColumn
+		}
+		columnName := timeColumn
+		if strings.Index(columnName, ".") > -1 {
+			columnName = strings.Split(columnName, ".")[1]
+		}
+		if _, ok := dr.TableInfo().GetColumnByName(columnName); !ok {
+			return nil, fmt.Errorf("no such time column [%v] in [%v]", timeColumn, req.RootEntity)
+		}
+
+		bucketExpression, err := TimeBucketExpression(dr.connection.DriverName(), timeColumn, req.TimeSample)
+		if err != nil {
+			return nil, err
+		}
+		projectionsAdded = append(projectionsAdded, bucketExpression.As(TimeBucketColumn))
+		groupByExpressions = append(groupByExpressions, bucketExpression)
+		if len(orderExpressions) == 0 {
+			orderExpressions = append(orderExpressions, bucketExpression.Asc())
+		}
+
+		// bounds are compared as "2006-01-02 15:04:05" strings, which sqlite needs to match its stored
+		// timestamps and mysql/postgres cast to their datetime types
+		if req.TimeFrom != "" {
+			from, err := ParseTimeRangeValue(req.TimeFrom)
+			if err != nil {
+				return nil, err
+			}
+			timeFrom = &from
+			timeRangeExpressions = append(timeRangeExpressions, goqu.I(timeColumn).Gte(from.Format(timeBucketLayout)))
+		}
+		if req.TimeTo != "" {
+			to, err := ParseTimeRangeValue(req.TimeTo)
+			if err != nil {
+				return nil, err
+			}
+			timeTo = &to
+			timeRangeExpressions = append(timeRangeExpressions, goqu.I(timeColumn).Lt(to.Format(timeBucketLayout)))
+		}
+	}
+
 	selectBuilder := statementbuilder.Squirrel.Select(projectionsAdded...)
 	builder := selectBuilder.From(req.RootEntity)
 
-	builder = builder.GroupBy(ToInterfaceArray(req.GroupBy)...)
+	builder = builder.GroupBy(groupByExpressions...)
 
-	builder = builder.Order(ToOrderedExpressionArray(req.Order)...)
+	builder = builder.Order(orderExpressions...)
 
 	// functionName(param1, param2)
 	querySyntax, err := regexp.Compile("([a-zA-Z0-9=<>]+)\\(([^,]+?),(.+)\\)")
@@ -190,6 +288,7 @@ func (dr *DbResource) DataStats(req AggregationRequest) (*AggregateData, error)
 
 		}
 	}
+	whereExpressions = append(whereExpressions, timeRangeExpressions...)
 	builder = builder.Where(whereExpressions...)
 
 	havingExpressions := make([]goqu.Expression, 0)
@@ -362,6 +461,20 @@ func (dr *DbResource) DataStats(req AggregationRequest) (*AggregateData, error)
 		}
 	}
 
+	if req.TimeSample != "" {
+		groupColumns := make([]string, 0)
+		for _, groupedColumn := range req.GroupBy {
+			if strings.Index(groupedColumn, ".") > -1 {
+				groupedColumn = strings.Split(groupedColumn, ".")[1]
+			}
+			groupColumns = append(groupColumns, groupedColumn)
+		}
+		rows, err = FillTimeBuckets(rows, req.TimeSample, timeFrom, timeTo, groupColumns, req.Order, returnModelName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	returnRows := make([]AggregateRow, 0)
 	for _, row := range rows {
 		newId, _ := uuid.NewV4()
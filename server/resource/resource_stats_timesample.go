@@ -0,0 +1,302 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample sizes accepted in AggregationRequest.TimeSample
+const (
+	TimeStampMinute TimeStamp = "minute"
+	TimeStampHour   TimeStamp = "hour"
+	TimeStampDay    TimeStamp = "day"
+	TimeStampWeek   TimeStamp = "week"
+	TimeStampMonth  TimeStamp = "month"
+	TimeStampYear   TimeStamp = "year"
+)
+
+// TimeBucketColumn is the name of the column holding the start of the time bucket in aggregate rows
+const TimeBucketColumn = "time_bucket"
+
+// DefaultTimeColumn is used to bucket rows when AggregationRequest.TimeColumn is not set
+const DefaultTimeColumn = "created_at"
+
+// time buckets are returned as strings in this format for all databases
+const timeBucketLayout = "2006-01-02 15:04:05"
+
+// upper limit on the number of buckets generated to fill a time range with zeros
+const maxTimeBuckets = 10000
+
+var timeRangeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	timeBucketLayout,
+	"2006-01-02",
+}
+
+func (ts TimeStamp) IsValid() bool {
+	switch ts {
+	case TimeStampMinute, TimeStampHour, TimeStampDay, TimeStampWeek, TimeStampMonth, TimeStampYear:
+		return true
+	}
+	return false
+}
+
+// Truncate returns the start of the bucket t falls in, weeks start on monday
+func (ts TimeStamp) Truncate(t time.Time) time.Time {
+	switch ts {
+	case TimeStampMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case TimeStampHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case TimeStampDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case TimeStampWeek:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, t.Location())
+	case TimeStampMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case TimeStampYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+// Next returns the start of the bucket following the bucket starting at t
+func (ts TimeStamp) Next(t time.Time) time.Time {
+	switch ts {
+	case TimeStampMinute:
+		return t.Add(time.Minute)
+	case TimeStampHour:
+		return t.Add(time.Hour)
+	case TimeStampDay:
+		return t.AddDate(0, 0, 1)
+	case TimeStampWeek:
+		return t.AddDate(0, 0, 7)
+	case TimeStampMonth:
+		return t.AddDate(0, 1, 0)
+	case TimeStampYear:
+		return t.AddDate(1, 0, 0)
+	}
+	return t
+}
+
+// TimeBucketExpression truncates the column to the sample size in the sql dialect of the database,
+// the result is a string in the "2006-01-02 15:04:05" format
+func TimeBucketExpression(driverName string, column string, sample TimeStamp) (exp.LiteralExpression, error) {
+
+	col := goqu.I(column)
+
+	switch driverName {
+	case "sqlite3":
+		formats := map[TimeStamp]string{
+			TimeStampMinute: "%Y-%m-%d %H:%M:00",
+			TimeStampHour:   "%Y-%m-%d %H:00:00",
+			TimeStampDay:    "%Y-%m-%d 00:00:00",
+			TimeStampMonth:  "%Y-%m-01 00:00:00",
+			TimeStampYear:   "%Y-01-01 00:00:00",
+		}
+		if sample == TimeStampWeek {
+			// move to the coming sunday (or stay on it) and then back to monday
+			return goqu.L("strftime('%Y-%m-%d 00:00:00', ?, 'weekday 0', '-6 days')", col), nil
+		}
+		return goqu.L(fmt.Sprintf("strftime('%s', ?)", formats[sample]), col), nil
+	case "mysql":
+		formats := map[TimeStamp]string{
+			TimeStampMinute: "%Y-%m-%d %H:%i:00",
+			TimeStampHour:   "%Y-%m-%d %H:00:00",
+			TimeStampDay:    "%Y-%m-%d 00:00:00",
+			TimeStampMonth:  "%Y-%m-01 00:00:00",
+			TimeStampYear:   "%Y-01-01 00:00:00",
+		}
+		if sample == TimeStampWeek {
+			return goqu.L("DATE_FORMAT(DATE_SUB(?, INTERVAL WEEKDAY(?) DAY), '%Y-%m-%d 00:00:00')", col, col), nil
+		}
+		return goqu.L(fmt.Sprintf("DATE_FORMAT(?, '%s')", formats[sample]), col), nil
+	case "postgres":
+		return goqu.L(fmt.Sprintf("to_char(date_trunc('%s', ?), 'YYYY-MM-DD HH24:MI:SS')", string(sample)), col), nil
+	}
+
+	return nil, fmt.Errorf("time sample is not supported for database [%v]", driverName)
+}
+
+// ParseTimeRangeValue parses the timefrom/timeto values of an aggregate request
+func ParseTimeRangeValue(value string) (time.Time, error) {
+	for _, layout := range timeRangeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time [%v], expected a date like 2006-01-02 or 2006-01-02T15:04:05Z", value)
+}
+
+// FillTimeBuckets adds a row with zero values for every bucket between from and to (both optional)
+// which is missing in rows, separately for every combination of the other group by columns.
+// rows are in the order of the aggregation ("column" or "-column"), by time bucket when order is empty. They keep
+// their order and the added rows are placed between them by the same order
+func FillTimeBuckets(rows []map[string]interface{}, sample TimeStamp, from, to *time.Time, groupColumns []string, order []string, typeName string) ([]map[string]interface{}, error) {
+
+	valueColumns := make([]string, 0)
+	if len(rows) > 0 {
+		for column := range rows[0] {
+			if column == TimeBucketColumn || column == "__type" || InStringArray(groupColumns, column) {
+				continue
+			}
+			valueColumns = append(valueColumns, column)
+		}
+	} else {
+		valueColumns = append(valueColumns, "count")
+	}
+
+	var start, end time.Time
+	if from != nil {
+		start = sample.Truncate(*from)
+	}
+	if to != nil {
+		end = *to
+	}
+
+	groups := make(map[string]map[string]interface{})
+	groupOrder := make([]string, 0)
+	existing := make(map[string]bool)
+
+	for _, row := range rows {
+		bucket := fmt.Sprintf("%v", row[TimeBucketColumn])
+		bucketTime, err := time.Parse(timeBucketLayout, bucket)
+		if err == nil {
+			if from == nil && (start.IsZero() || bucketTime.Before(start)) {
+				start = bucketTime
+			}
+			if to == nil && (end.IsZero() || !bucketTime.Before(end)) {
+				end = sample.Next(bucketTime)
+			}
+		}
+
+		groupValues := make(map[string]interface{})
+		groupKeyParts := make([]string, 0)
+		for _, column := range groupColumns {
+			groupValues[column] = row[column]
+			groupKeyParts = append(groupKeyParts, fmt.Sprintf("%v", row[column]))
+		}
+		groupKey := strings.Join(groupKeyParts, "\x00")
+		if _, ok := groups[groupKey]; !ok {
+			groups[groupKey] = groupValues
+			groupOrder = append(groupOrder, groupKey)
+		}
+		existing[groupKey+"\x00"+bucket] = true
+	}
+
+	if len(groupColumns) == 0 && len(groupOrder) == 0 {
+		groups[""] = map[string]interface{}{}
+		groupOrder = append(groupOrder, "")
+	}
+
+	if start.IsZero() || end.IsZero() {
+		return rows, nil
+	}
+
+	buckets := make([]string, 0)
+	for bucket := start; bucket.Before(end); bucket = sample.Next(bucket) {
+		if len(buckets) >= maxTimeBuckets {
+			return nil, fmt.Errorf("time range has more than %d buckets of [%v]", maxTimeBuckets, sample)
+		}
+		buckets = append(buckets, bucket.Format(timeBucketLayout))
+	}
+
+	filled := make([]map[string]interface{}, 0)
+	for _, groupKey := range groupOrder {
+		for _, bucket := range buckets {
+			if existing[groupKey+"\x00"+bucket] {
+				continue
+			}
+			row := map[string]interface{}{
+				"__type":         typeName,
+				TimeBucketColumn: bucket,
+			}
+			for column, value := range groups[groupKey] {
+				row[column] = value
+			}
+			for _, column := range valueColumns {
+				row[column] = 0
+			}
+			filled = append(filled, row)
+		}
+	}
+
+	if len(order) == 0 {
+		order = []string{TimeBucketColumn}
+	}
+	sort.SliceStable(filled, func(i, j int) bool {
+		return compareRowsByOrder(filled[i], filled[j], order) < 0
+	})
+
+	merged := make([]map[string]interface{}, 0, len(rows)+len(filled))
+	next := 0
+	for _, row := range rows {
+		for next < len(filled) && compareRowsByOrder(filled[next], row, order) < 0 {
+			merged = append(merged, filled[next])
+			next++
+		}
+		merged = append(merged, row)
+	}
+	merged = append(merged, filled[next:]...)
+	return merged, nil
+}
+
+// compareRowsByOrder compares two rows by the order columns, "-column" for descending
+func compareRowsByOrder(a, b map[string]interface{}, order []string) int {
+	for _, column := range order {
+		descending := strings.HasPrefix(column, "-")
+		column = strings.TrimPrefix(column, "-")
+		if i := strings.LastIndex(column, "."); i > -1 {
+			column = column[i+1:]
+		}
+		result := compareAggregateValues(a[column], b[column])
+		if descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// compareAggregateValues compares numbers by value and other values by their text, nil before any value
+func compareAggregateValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	aText, bText := aggregateValueText(a), aggregateValueText(b)
+	aNumber, aErr := strconv.ParseFloat(aText, 64)
+	bNumber, bErr := strconv.ParseFloat(bText, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case aNumber < bNumber:
+			return -1
+		case aNumber > bNumber:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(aText, bText)
+}
+
+func aggregateValueText(value interface{}) string {
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,185 @@
+package resource
+
+import (
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+)
+
+func TestTimeStampTruncate(t *testing.T) {
+	instant := time.Date(2021, 3, 17, 13, 45, 30, 0, time.UTC) // a wednesday
+
+	expected := map[TimeStamp]string{
+		TimeStampMinute: "2021-03-17 13:45:00",
+		TimeStampHour:   "2021-03-17 13:00:00",
+		TimeStampDay:    "2021-03-17 00:00:00",
+		TimeStampWeek:   "2021-03-15 00:00:00",
+		TimeStampMonth:  "2021-03-01 00:00:00",
+		TimeStampYear:   "2021-01-01 00:00:00",
+	}
+
+	for sample, bucket := range expected {
+		if got := sample.Truncate(instant).Format(timeBucketLayout); got != bucket {
+			t.Errorf("[%v] expected bucket %v, got %v", sample, bucket, got)
+		}
+	}
+}
+
+func TestFillTimeBuckets(t *testing.T) {
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	rows := []map[string]interface{}{
+		{TimeBucketColumn: "2021-03-02 00:00:00", "count": int64(4), "status": "open"},
+		{TimeBucketColumn: "2021-03-03 00:00:00", "count": int64(2), "status": "closed"},
+	}
+
+	filled, err := FillTimeBuckets(rows, TimeStampDay, &from, &to, []string{"status"}, nil, "aggregate_ticket")
+	if err != nil {
+		t.Fatalf("Failed to fill buckets: %v", err)
+	}
+	if len(filled) != 6 {
+		t.Fatalf("expected 3 days for 2 statuses, got %d rows", len(filled))
+	}
+	if filled[0][TimeBucketColumn] != "2021-03-01 00:00:00" || filled[0]["count"] != 0 {
+		t.Errorf("expected first bucket to be filled with zero, got %v", filled[0])
+	}
+
+	_, err = FillTimeBuckets(nil, TimeStampMinute, &from, &to, nil, nil, "aggregate_ticket")
+	if err != nil {
+		t.Fatalf("Failed to fill buckets: %v", err)
+	}
+	// rows ordered by the newest bucket first keep that order, with the missing buckets in between
+	ordered := []map[string]interface{}{
+		{TimeBucketColumn: "2021-03-03 00:00:00", "count": int64(2)},
+		{TimeBucketColumn: "2021-03-01 00:00:00", "count": int64(5)},
+	}
+	filled, err = FillTimeBuckets(ordered, TimeStampDay, &from, &to, nil, []string{"-" + TimeBucketColumn}, "aggregate_ticket")
+	if err != nil {
+		t.Fatalf("Failed to fill buckets: %v", err)
+	}
+	buckets := make([]string, 0)
+	for _, row := range filled {
+		buckets = append(buckets, row[TimeBucketColumn].(string))
+	}
+	if strings.Join(buckets, ",") != "2021-03-03 00:00:00,2021-03-02 00:00:00,2021-03-01 00:00:00" {
+		t.Errorf("expected the buckets newest first, got %v", buckets)
+	}
+
+	// rows ordered by count keep that order, the empty buckets come first
+	ordered = []map[string]interface{}{
+		{TimeBucketColumn: "2021-03-03 00:00:00", "count": int64(2)},
+		{TimeBucketColumn: "2021-03-01 00:00:00", "count": int64(5)},
+	}
+	filled, err = FillTimeBuckets(ordered, TimeStampDay, &from, &to, nil, []string{"count"}, "aggregate_ticket")
+	if err != nil {
+		t.Fatalf("Failed to fill buckets: %v", err)
+	}
+	if len(filled) != 3 || filled[0]["count"] != 0 || filled[1]["count"] != int64(2) || filled[2]["count"] != int64(5) {
+		t.Errorf("expected the rows by count, got %v", filled)
+	}
+
+	far := time.Date(2031, 3, 4, 0, 0, 0, 0, time.UTC)
+	_, err = FillTimeBuckets(nil, TimeStampMinute, &from, &far, nil, nil, "aggregate_ticket")
+	if err == nil {
+		t.Errorf("expected an error for too many buckets")
+	}
+}
+
+func TestDataStatsTimeSample(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("create table ticket (status varchar(20), created_at timestamp)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for _, createdAt := range []time.Time{
+		time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 1, 18, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 9, 9, 0, 0, 0, time.UTC),
+	} {
+		_, err = db.Exec("insert into ticket (status, created_at) values ('open', ?)", createdAt)
+		if err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	cruds := make(map[string]*DbResource)
+	cruds["ticket"] = &DbResource{
+		db:         db,
+		connection: db,
+		Cruds:      cruds,
+		tableInfo: &TableInfo{
+			TableName: "ticket",
+			Columns: []api2go.ColumnInfo{
+				{ColumnName: "status"},
+				{ColumnName: "created_at"},
+			},
+		},
+	}
+
+	result, err := cruds["ticket"].DataStats(AggregationRequest{
+		RootEntity: "ticket",
+		TimeSample: TimeStampDay,
+		TimeFrom:   "2021-03-01",
+		TimeTo:     "2021-03-05",
+	})
+	if err != nil {
+		t.Fatalf("Failed to aggregate: %v", err)
+	}
+
+	expected := []int64{2, 0, 1, 0}
+	if len(result.Data) != len(expected) {
+		t.Fatalf("expected %d buckets, got %d: %v", len(expected), len(result.Data), result.Data)
+	}
+	for i, count := range expected {
+		if got := toInt64(result.Data[i].Attributes["count"]); got != count {
+			t.Errorf("bucket %v: expected %d, got %d", result.Data[i].Attributes[TimeBucketColumn], count, got)
+		}
+	}
+
+	_, err = cruds["ticket"].DataStats(AggregationRequest{
+		RootEntity: "ticket",
+		TimeSample: "fortnight",
+	})
+	if err == nil {
+		t.Errorf("expected an error for an invalid time sample")
+	}
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return -1
+}
+
+func TestAggregationRequestAllowedBy(t *testing.T) {
+	req := AggregationRequest{
+		RootEntity: "order",
+		Join:       []string{"customer@eq(customer.id,order.customer_id)"},
+	}
+
+	scoped := &auth.SessionUser{ApiKeyScopes: auth.ParseApiKeyScopes("read:order")}
+	if req.AllowedBy(scoped) {
+		t.Errorf("expected the joined customer table to need a read scope")
+	}
+	scoped.ApiKeyScopes = auth.ParseApiKeyScopes("read:order read:customer")
+	if !req.AllowedBy(scoped) {
+		t.Errorf("expected reads on order and customer to allow the aggregate")
+	}
+	if !req.AllowedBy(&auth.SessionUser{}) {
+		t.Errorf("expected sessions without an api key to not be narrowed")
+	}
+}
@@ -0,0 +1,113 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// ResourceTransaction holds a copy of every DbResource bound to one database transaction.
+// Writes made through Cruds are committed or rolled back together, and side effects registered
+// with AfterCommit (events, exchanges) are only fired once the transaction is committed
+type ResourceTransaction struct {
+	Cruds       map[string]*DbResource
+	tx          *sqlx.Tx
+	afterCommit []func()
+	done        bool
+	lock        sync.Mutex
+}
+
+// NewTransaction begins a transaction on the underlying connection and binds a copy of all the
+// cruds to it
+func (dr *DbResource) NewTransaction() (*ResourceTransaction, error) {
+
+	tx, err := dr.connection.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := &ResourceTransaction{
+		Cruds:       make(map[string]*DbResource),
+		tx:          tx,
+		afterCommit: make([]func(), 0),
+	}
+
+	for name, crud := range dr.Cruds {
+		txResource := NewFromDbResourceWithTransaction(crud, tx)
+		txResource.Cruds = transaction.Cruds
+		txResource.transaction = transaction
+		transaction.Cruds[name] = txResource
+	}
+
+	return transaction, nil
+}
+
+// AfterCommit runs fn right away when dr is not bound to a transaction, otherwise fn is
+// queued and called only after the transaction commits. Queued functions are dropped on rollback
+func (dr *DbResource) AfterCommit(fn func()) {
+	if dr.transaction == nil {
+		fn()
+		return
+	}
+	dr.transaction.lock.Lock()
+	defer dr.transaction.lock.Unlock()
+	dr.transaction.afterCommit = append(dr.transaction.afterCommit, fn)
+}
+
+// Savepoint marks a point in the transaction which can be returned to using RollbackToSavepoint
+// without discarding the whole transaction
+func (rt *ResourceTransaction) Savepoint(name string) error {
+	_, err := rt.tx.Exec(fmt.Sprintf("SAVEPOINT %s", name))
+	return err
+}
+
+func (rt *ResourceTransaction) RollbackToSavepoint(name string) error {
+	_, err := rt.tx.Exec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name))
+	return err
+}
+
+func (rt *ResourceTransaction) ReleaseSavepoint(name string) error {
+	_, err := rt.tx.Exec(fmt.Sprintf("RELEASE SAVEPOINT %s", name))
+	return err
+}
+
+// Commit commits the transaction and then calls the functions queued by AfterCommit in order
+func (rt *ResourceTransaction) Commit() error {
+	rt.lock.Lock()
+	if rt.done {
+		rt.lock.Unlock()
+		return nil
+	}
+	rt.done = true
+	err := rt.tx.Commit()
+	afterCommit := rt.afterCommit
+	rt.afterCommit = nil
+	rt.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range afterCommit {
+		fn()
+	}
+	return nil
+}
+
+// Rollback discards the transaction and the queued after commit functions. It is a no-op if
+// the transaction was already committed or rolled back, so it is safe to defer
+func (rt *ResourceTransaction) Rollback() error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.done {
+		return nil
+	}
+	rt.done = true
+	rt.afterCommit = nil
+	err := rt.tx.Rollback()
+	if err != nil {
+		log.Errorf("Failed to rollback transaction: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func newTransactionTestResource(t *testing.T) *DbResource {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("create table item (name varchar(100))")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	cruds := make(map[string]*DbResource)
+	cruds["item"] = &DbResource{
+		db:           db,
+		connection:   db,
+		Cruds:        cruds,
+		contextCache: make(map[string]interface{}),
+		contextLock:  &sync.RWMutex{},
+	}
+	return cruds["item"]
+}
+
+func countItems(t *testing.T, dr *DbResource) int {
+	var count int
+	err := dr.connection.Get(&count, "select count(*) from item")
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	return count
+}
+
+func TestResourceTransactionCommit(t *testing.T) {
+	dr := newTransactionTestResource(t)
+
+	transaction, err := dr.NewTransaction()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+
+	txResource := transaction.Cruds["item"]
+	_, err = txResource.db.Exec("insert into item (name) values ('one')")
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	fired := false
+	txResource.AfterCommit(func() {
+		fired = true
+	})
+	if fired {
+		t.Errorf("after commit function called before commit")
+	}
+	if countItems(t, txResource) != 1 {
+		t.Errorf("insert not visible inside the transaction")
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if !fired {
+		t.Errorf("after commit function not called on commit")
+	}
+	if countItems(t, dr) != 1 {
+		t.Errorf("expected 1 item after commit")
+	}
+	if transaction.Rollback() != nil {
+		t.Errorf("rollback after commit should be a no-op")
+	}
+}
+
+func TestResourceTransactionRollback(t *testing.T) {
+	dr := newTransactionTestResource(t)
+
+	transaction, err := dr.NewTransaction()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+
+	txResource := transaction.Cruds["item"]
+	fired := false
+	txResource.AfterCommit(func() {
+		fired = true
+	})
+
+	_, err = txResource.db.Exec("insert into item (name) values ('one')")
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	err = transaction.Savepoint("second")
+	if err != nil {
+		t.Fatalf("Failed to create savepoint: %v", err)
+	}
+	_, err = txResource.db.Exec("insert into item (name) values ('two')")
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	err = transaction.RollbackToSavepoint("second")
+	if err != nil {
+		t.Fatalf("Failed to rollback to savepoint: %v", err)
+	}
+	if countItems(t, txResource) != 1 {
+		t.Errorf("expected only the insert before the savepoint to remain")
+	}
+
+	err = transaction.Rollback()
+	if err != nil {
+		t.Fatalf("Failed to rollback: %v", err)
+	}
+	if fired {
+		t.Errorf("after commit function called on rollback")
+	}
+	if countItems(t, dr) != 0 {
+		t.Errorf("expected no items after rollback")
+	}
+
+	// resources without a transaction run the function immediately
+	dr.AfterCommit(func() {
+		fired = true
+	})
+	if !fired {
+		t.Errorf("after commit function not called without a transaction")
+	}
+}
+
+func TestTransactionalActionRollsBack(t *testing.T) {
+	db, cruds := newStandardTestCruds(t)
+	addTestAdministrator(t, db)
+
+	createGroup := `{"Type": "usergroup", "Method": "POST", "Attributes": {"name": "created by action"}}`
+	updateMissingGroup := `{"Type": "usergroup", "Method": "PATCH", "Attributes": {"reference_id": "missing", "name": "updated"}}`
+	for _, statement := range []string{
+		fmt.Sprintf("insert into world (id, reference_id, table_name, world_schema_json, default_permission, permission) "+
+			"values (1, 'w1', 'usergroup', '{}', %d, %d)", auth.DEFAULT_PERMISSION, auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into action (id, reference_id, action_name, label, world_id, action_schema, permission) values "+
+			"(1, 'a1', 'create_group', 'create', 1, '{\"InstanceOptional\": true, \"Transactional\": true, \"OutFields\": [%s]}', %d), "+
+			"(2, 'a2', 'create_and_fail', 'fail', 1, '{\"InstanceOptional\": true, \"Transactional\": true, \"OutFields\": [%s, %s]}', %d)",
+			createGroup, auth.DEFAULT_PERMISSION, createGroup, updateMissingGroup, auth.DEFAULT_PERMISSION),
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	transaction, err := cruds["usergroup"].NewTransaction()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if transaction.Cruds["usergroup"].contextLock != cruds["usergroup"].contextLock {
+		t.Errorf("expected the transaction to share the lock of the context cache")
+	}
+	transaction.Rollback()
+
+	createdGroups := func() int {
+		var count int
+		err := db.Get(&count, "select count(*) from usergroup where name = 'created by action'")
+		if err != nil {
+			t.Fatalf("Failed to count usergroups: %v", err)
+		}
+		return count
+	}
+
+	for _, actionName := range []string{"create_and_fail", "create_group"} {
+		httpRequest := &http.Request{Method: "EXECUTE"}
+		httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), "user", &auth.SessionUser{
+			UserId:          1,
+			UserReferenceId: "admin",
+		}))
+		_, err = cruds["usergroup"].HandleActionRequest(ActionRequest{
+			Type:   "usergroup",
+			Action: actionName,
+		}, api2go.Request{PlainRequest: httpRequest})
+
+		if actionName == "create_and_fail" {
+			if err == nil {
+				t.Errorf("expected the failing update to fail the action")
+			}
+			if createdGroups() != 0 {
+				t.Errorf("expected the usergroup created before the failing update to be rolled back")
+			}
+		} else {
+			if err != nil {
+				t.Errorf("expected the action to succeed, got %v", err)
+			}
+			if createdGroups() != 1 {
+				t.Errorf("expected the usergroup created by the action to be committed")
+			}
+		}
+	}
+}
@@ -61,6 +61,20 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 
 	allChanges := data.GetChanges()
 	allColumns := dr.model.GetColumns()
+
+	if !isAdmin {
+		changedAttributes := make(map[string]interface{}, len(allChanges))
+		for columnName, change := range allChanges {
+			changedAttributes[columnName] = change.NewValue
+		}
+		err = dr.CheckWritableColumns(sessionUser, changedAttributes, map[string]interface{}{
+			"__type":       dr.model.GetName(),
+			"reference_id": id,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 	//log.Printf("Update object request with changes: %v", allChanges)
 
 	//dataToInsert := make(map[string]interface{})
@@ -984,6 +998,10 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 			log.Errorf("Error from AfterUpdate middleware: %v", err)
 		}
 	}
+	if updatedResource != nil {
+		sessionUser, _ := req.PlainRequest.Context().Value("user").(*auth.SessionUser)
+		updatedResource = dr.StripUnreadableColumns(sessionUser, []map[string]interface{}{updatedResource})[0]
+	}
 	delete(updatedResource, "id")
 
 	return NewResponse(nil, api2go.NewApi2GoModelWithData(dr.model.GetName(), dr.model.GetColumns(), dr.model.GetDefaultPermission(), dr.model.GetRelations(), updatedResource), 200, nil), nil
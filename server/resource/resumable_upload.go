@@ -0,0 +1,646 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FILE_UPLOAD_TABLE_NAME = "file_upload"
+
+const (
+	FileUploadStatusUploading = "uploading"
+	FileUploadStatusComplete  = "complete"
+)
+
+// ErrUploadChecksumMismatch is returned when the data does not match the checksum sent with it
+var ErrUploadChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrUploadOffsetMismatch is returned when a chunk does not start at the current offset of the upload
+var ErrUploadOffsetMismatch = errors.New("offset mismatch")
+
+// UploadChecksumAlgorithms are the algorithms accepted for checksums, as "<algorithm> <base64 digest>"
+var UploadChecksumAlgorithms = []string{"md5", "sha1", "sha256"}
+
+// FileUpload is a resumable upload, the received bytes are appended to the file at LocalPath until Offset
+// reaches Size and the file is moved to the Target
+type FileUpload struct {
+	Id            int64          `db:"id"`
+	ReferenceId   string         `db:"reference_id"`
+	FileName      string         `db:"file_name"`
+	Size          int64          `db:"size"`
+	Offset        int64          `db:"upload_offset"`
+	Checksum      sql.NullString `db:"checksum"`
+	Target        string         `db:"target"`
+	Path          sql.NullString `db:"path"`
+	LocalPath     string         `db:"local_path"`
+	Status        string         `db:"status"`
+	UserAccountId int64          `db:"user_account_id"`
+}
+
+// UploadTarget is where a completed upload goes, either a file column of a row, "<table>/<reference id>/<column>",
+// or a path in a cloud store, "cloud_store/<reference id>/<path>"
+type UploadTarget struct {
+	TypeName    string
+	ReferenceId string
+	ColumnName  string
+	// Path is the folder in the cloud store, uploads to a column use the path of the upload
+	Path string
+}
+
+func (t UploadTarget) IsCloudStore() bool {
+	return t.TypeName == "cloud_store" && t.ColumnName == ""
+}
+
+// ParseUploadTarget reads the target of an upload
+func ParseUploadTarget(target string) (UploadTarget, error) {
+	parts := strings.SplitN(strings.Trim(target, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return UploadTarget{}, fmt.Errorf("invalid upload target [%v]", target)
+	}
+
+	if parts[0] == "cloud_store" {
+		path := ""
+		if len(parts) == 3 {
+			path = parts[2]
+		}
+		cleanPath, err := CleanUploadPath(path)
+		if err != nil {
+			return UploadTarget{}, err
+		}
+		return UploadTarget{TypeName: parts[0], ReferenceId: parts[1], Path: cleanPath}, nil
+	}
+
+	if len(parts) != 3 || parts[2] == "" || strings.Contains(parts[2], "/") {
+		return UploadTarget{}, fmt.Errorf("invalid upload target [%v]", target)
+	}
+	return UploadTarget{TypeName: parts[0], ReferenceId: parts[1], ColumnName: parts[2]}, nil
+}
+
+// CleanUploadPath is a relative folder path without parent references
+func CleanUploadPath(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	cleanPath := filepath.ToSlash(filepath.Clean("/" + path))
+	if cleanPath != "/"+strings.Trim(path, "/") {
+		return "", fmt.Errorf("invalid path [%v]", path)
+	}
+	return strings.TrimPrefix(cleanPath, "/"), nil
+}
+
+// CleanUploadFileName is the name of the uploaded file without any folders
+func CleanUploadFileName(fileName string) (string, error) {
+	name := filepath.Base(filepath.Clean("/" + fileName))
+	if name != fileName || name == "/" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name [%v]", fileName)
+	}
+	return name, nil
+}
+
+// ParseUploadMetadata reads the Upload-Metadata header, comma separated keys with base64 encoded values
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of metadata [%v]", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// NewUploadChecksum parses a checksum, "<algorithm> <base64 digest>", into the hash to compute and the
+// expected digest
+func NewUploadChecksum(checksum string) (hash.Hash, []byte, error) {
+	parts := strings.SplitN(strings.TrimSpace(checksum), " ", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid checksum [%v]", checksum)
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid checksum digest [%v]", parts[1])
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported checksum algorithm [%v]", parts[0])
+}
+
+// WriteUploadChunk appends the body to the upload file from offset, up to the size of the upload. A chunk not
+// matching its checksum is discarded. Returns the new offset
+func WriteUploadChunk(upload FileUpload, offset int64, body io.Reader, checksum string) (int64, error) {
+
+	if offset != upload.Offset {
+		return upload.Offset, ErrUploadOffsetMismatch
+	}
+
+	var checksumHash hash.Hash
+	var expected []byte
+	if checksum != "" {
+		var err error
+		checksumHash, expected, err = NewUploadChecksum(checksum)
+		if err != nil {
+			return upload.Offset, err
+		}
+	}
+
+	file, err := os.OpenFile(upload.LocalPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return upload.Offset, err
+	}
+	defer file.Close()
+
+	_, err = file.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		return upload.Offset, err
+	}
+
+	var writer io.Writer = file
+	if checksumHash != nil {
+		writer = io.MultiWriter(file, checksumHash)
+	}
+	written, err := io.Copy(writer, io.LimitReader(body, upload.Size-upload.Offset))
+
+	if checksumHash != nil && (err != nil || !bytes.Equal(checksumHash.Sum(nil), expected)) {
+		// a chunk with a checksum is kept only when it arrived complete and matches
+		truncateErr := file.Truncate(upload.Offset)
+		CheckErr(truncateErr, "Failed to discard chunk of upload [%v]", upload.ReferenceId)
+		if err == nil {
+			err = ErrUploadChecksumMismatch
+		}
+		return upload.Offset, err
+	}
+	// bytes received before a network error are kept, the client resumes from the new offset
+	return upload.Offset + written, err
+}
+
+// UploadFileDigests reads the uploaded file once for its md5, which is stored with the file entry of a column,
+// and checks it against the checksum of the whole file when the client sent one
+func UploadFileDigests(upload FileUpload) (string, error) {
+
+	file, err := os.Open(upload.LocalPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	md5Hash := md5.New()
+	var writer io.Writer = md5Hash
+	var checksumHash hash.Hash
+	var expected []byte
+	if upload.Checksum.Valid && upload.Checksum.String != "" {
+		checksumHash, expected, err = NewUploadChecksum(upload.Checksum.String)
+		if err != nil {
+			return "", err
+		}
+		writer = io.MultiWriter(md5Hash, checksumHash)
+	}
+
+	_, err = io.Copy(writer, file)
+	if err != nil {
+		return "", err
+	}
+	if checksumHash != nil && !bytes.Equal(checksumHash.Sum(nil), expected) {
+		return "", ErrUploadChecksumMismatch
+	}
+	return hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// CreateFileUpload stores a new upload for the user, LocalPath is set from the upload directory
+func (dr *DbResource) CreateFileUpload(upload *FileUpload, uploadDirectory string) error {
+
+	err := os.MkdirAll(uploadDirectory, 0700)
+	if err != nil {
+		return err
+	}
+
+	u, _ := uuid.NewV4()
+	upload.ReferenceId = u.String()
+	upload.LocalPath = filepath.Join(uploadDirectory, upload.ReferenceId)
+	upload.Status = FileUploadStatusUploading
+
+	file, err := os.OpenFile(upload.LocalPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	CheckErr(file.Close(), "Failed to close upload file [%v]", upload.LocalPath)
+
+	now := time.Now()
+	query, args, err := statementbuilder.Squirrel.Insert(FILE_UPLOAD_TABLE_NAME).Rows(goqu.Record{
+		"reference_id":         upload.ReferenceId,
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: upload.UserAccountId,
+		"file_name":            upload.FileName,
+		"size":                 upload.Size,
+		"upload_offset":        0,
+		"checksum":             upload.Checksum,
+		"target":               upload.Target,
+		"path":                 upload.Path,
+		"local_path":           upload.LocalPath,
+		"status":               upload.Status,
+		"created_at":           now,
+		"updated_at":           now,
+	}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		CheckErr(os.Remove(upload.LocalPath), "Failed to remove upload file [%v]", upload.LocalPath)
+	}
+	return err
+}
+
+// GetFileUpload finds an upload by its reference id
+func (dr *DbResource) GetFileUpload(referenceId string) (FileUpload, error) {
+
+	var upload FileUpload
+	query, args, err := statementbuilder.Squirrel.Select("id", "reference_id", "file_name", "size", "upload_offset",
+		"checksum", "target", "path", "local_path", "status", USER_ACCOUNT_ID_COLUMN).
+		From(FILE_UPLOAD_TABLE_NAME).Where(goqu.Ex{"reference_id": referenceId}).ToSQL()
+	if err != nil {
+		return upload, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return upload, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	err = stmt1.QueryRowx(args...).StructScan(&upload)
+	return upload, err
+}
+
+// UpdateFileUploadOffset records the bytes received so far, which also keeps the upload from expiring
+func (dr *DbResource) UpdateFileUploadOffset(upload FileUpload, offset int64) error {
+	query, args, err := statementbuilder.Squirrel.Update(FILE_UPLOAD_TABLE_NAME).Set(goqu.Record{
+		"upload_offset": offset,
+		"updated_at":    time.Now(),
+	}).Where(goqu.Ex{"reference_id": upload.ReferenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// DeleteFileUpload removes the upload and its partial file
+func (dr *DbResource) DeleteFileUpload(upload FileUpload) error {
+	err := os.Remove(upload.LocalPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	query, args, err := statementbuilder.Squirrel.Delete(FILE_UPLOAD_TABLE_NAME).
+		Where(goqu.Ex{"reference_id": upload.ReferenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// CompleteFileUpload verifies the uploaded file and moves it to its target. Uploads to a column are added to
+// the files of the column by the user, replacing a file with the same name and path
+func (dr *DbResource) CompleteFileUpload(upload FileUpload, sessionUser *auth.SessionUser) error {
+
+	fileMd5, err := UploadFileDigests(upload)
+	if err != nil {
+		return err
+	}
+
+	target, err := ParseUploadTarget(upload.Target)
+	if err != nil {
+		return err
+	}
+
+	if target.IsCloudStore() {
+		err = dr.completeCloudStoreUpload(upload, target)
+	} else {
+		err = dr.completeColumnUpload(upload, target, fileMd5, sessionUser)
+	}
+	if err != nil {
+		return err
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update(FILE_UPLOAD_TABLE_NAME).Set(goqu.Record{
+		"upload_offset": upload.Size,
+		"status":        FileUploadStatusComplete,
+		"updated_at":    time.Now(),
+	}).Where(goqu.Ex{"reference_id": upload.ReferenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+func (dr *DbResource) completeCloudStoreUpload(upload FileUpload, target UploadTarget) error {
+
+	cloudStoreRow, _, err := dr.Cruds["cloud_store"].GetSingleRowByReferenceId("cloud_store", target.ReferenceId, nil)
+	if err != nil {
+		return err
+	}
+	cloudStore, err := dr.Cruds["cloud_store"].GetCloudStoreByName(fmt.Sprintf("%v", cloudStoreRow["name"]))
+	if err != nil {
+		return err
+	}
+
+	tempDirectoryPath, err := ioutil.TempDir(os.Getenv("DAPTIN_CACHE_FOLDER"), "upload-"+upload.ReferenceId[0:8])
+	if err != nil {
+		return err
+	}
+	err = moveUploadFile(upload.LocalPath, filepath.Join(tempDirectoryPath, upload.FileName))
+	if err != nil {
+		return err
+	}
+
+	rootPath := strings.TrimSuffix(cloudStore.RootPath, "/")
+	if target.Path != "" {
+		rootPath = rootPath + "/" + target.Path
+	}
+	UploadLocalDirectoryToStore(dr.Cruds, tempDirectoryPath, rootPath, cloudStore.StoreProvider, cloudStore.OAutoTokenId)
+	return nil
+}
+
+func (dr *DbResource) completeColumnUpload(upload FileUpload, target UploadTarget, fileMd5 string, sessionUser *auth.SessionUser) error {
+
+	assetFolder, ok := dr.Cruds[target.TypeName].AssetFolderCache[target.TypeName][target.ColumnName]
+	if !ok {
+		return fmt.Errorf("column [%v][%v] has no file storage", target.TypeName, target.ColumnName)
+	}
+
+	path := upload.Path.String
+	localPath := filepath.Join(assetFolder.LocalSyncPath, filepath.FromSlash(path))
+	err := os.MkdirAll(localPath, 0755)
+	if err != nil {
+		return err
+	}
+	err = moveUploadFile(upload.LocalPath, filepath.Join(localPath, upload.FileName))
+	if err != nil {
+		return err
+	}
+
+	if assetFolder.CloudStore.StoreProvider != "local" {
+		tempDirectoryPath, err := ioutil.TempDir(os.Getenv("DAPTIN_CACHE_FOLDER"), "upload-"+upload.ReferenceId[0:8])
+		if err != nil {
+			return err
+		}
+		err = copyUploadFile(filepath.Join(localPath, upload.FileName), filepath.Join(tempDirectoryPath, upload.FileName))
+		if err != nil {
+			return err
+		}
+		rootPath := assetFolder.CloudStore.RootPath + "/" + assetFolder.Keyname
+		if path != "" {
+			rootPath = rootPath + "/" + path
+		}
+		UploadLocalDirectoryToStore(dr.Cruds, tempDirectoryPath, rootPath, assetFolder.CloudStore.StoreProvider, assetFolder.CloudStore.OAutoTokenId)
+	}
+
+	fileType := mime.TypeByExtension(filepath.Ext(upload.FileName))
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+	return dr.Cruds[target.TypeName].AddColumnFile(sessionUser, target, map[string]interface{}{
+		"name": upload.FileName,
+		"path": path,
+		"type": fileType,
+		"size": upload.Size,
+		"md5":  fileMd5,
+	})
+}
+
+// columnFileLocks keeps uploads completing on the same row from adding their files to the same list of files
+var columnFileLocks sync.Map
+
+// AddColumnFile adds the entry of a file stored in the cloud store of a column to the files of the column. The user
+// has to be able to write the column, the change is audited and published like an update from the api
+func (dr *DbResource) AddColumnFile(sessionUser *auth.SessionUser, target UploadTarget, file map[string]interface{}) error {
+
+	if !dr.IsAdmin(sessionUser.UserReferenceId) {
+		err := dr.CheckWritableColumns(sessionUser, map[string]interface{}{target.ColumnName: file}, map[string]interface{}{
+			"__type":       target.TypeName,
+			"reference_id": target.ReferenceId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	lock, _ := columnFileLocks.LoadOrStore(target.TypeName+"/"+target.ReferenceId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	originalRow, err := dr.GetReferenceIdToObject(target.TypeName, target.ReferenceId)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select(target.ColumnName).From(target.TypeName).
+		Where(goqu.Ex{"reference_id": target.ReferenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	var columnValue interface{}
+	err = dr.queryRow(query, args, &columnValue)
+	if err != nil {
+		return err
+	}
+
+	files := make([]map[string]interface{}, 0)
+	var existing []byte
+	switch value := columnValue.(type) {
+	case string:
+		existing = []byte(value)
+	case []byte:
+		existing = value
+	}
+	if len(existing) > 0 {
+		err = json.Unmarshal(existing, &files)
+		if err != nil {
+			return err
+		}
+	}
+
+	updated := make([]map[string]interface{}, 0, len(files)+1)
+	for _, existingFile := range files {
+		if existingFile["name"] == file["name"] && fmt.Sprintf("%v", existingFile["path"]) == file["path"] {
+			continue
+		}
+		updated = append(updated, existingFile)
+	}
+	updated = append(updated, file)
+
+	filesJson, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	query, args, err = statementbuilder.Squirrel.Update(target.TypeName).Set(goqu.Record{
+		target.ColumnName: string(filesJson),
+		"updated_at":      now,
+	}).Where(goqu.Ex{"reference_id": target.ReferenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	data := api2go.NewApi2GoModelWithData(target.TypeName, nil, 0, nil, originalRow)
+	data.SetAttributes(map[string]interface{}{
+		target.ColumnName: updated,
+		"updated_at":      now,
+	})
+
+	if dr.tableInfo.IsAuditEnabled {
+		auditModel := data.GetAuditModel()
+		creator, ok := dr.Cruds[auditModel.GetTableName()]
+		if !ok {
+			log.Errorf("No creator for audit type: %v", auditModel.GetTableName())
+		} else {
+			pr := &http.Request{
+				Method: "POST",
+			}
+			pr = pr.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+			_, err = creator.Create(auditModel, api2go.Request{
+				PlainRequest: pr,
+			})
+			CheckErr(err, "Failed to create audit entry for [%v][%v]", target.TypeName, target.ReferenceId)
+		}
+	}
+
+	err = dr.PublishTableEvent(target.TypeName, "update", data.GetAllAsAttributes())
+	CheckErr(err, "Failed to publish update of [%v][%v]", target.TypeName, target.ReferenceId)
+	return nil
+}
+
+// CleanupAbandonedUploads removes the uploads which received nothing for longer than expiry, with their
+// partial files
+func (dr *DbResource) CleanupAbandonedUploads(expiry time.Duration) (int, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("id", "reference_id", "local_path").
+		From(FILE_UPLOAD_TABLE_NAME).Where(
+		goqu.Ex{"status": FileUploadStatusUploading},
+		goqu.L("COALESCE(updated_at, created_at)").Lt(time.Now().Add(-expiry)),
+	).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		CheckErr(stmt1.Close(), "failed to close prepared statement")
+		return 0, err
+	}
+	uploads := make([]FileUpload, 0)
+	for rows.Next() {
+		var upload FileUpload
+		err = rows.Scan(&upload.Id, &upload.ReferenceId, &upload.LocalPath)
+		if err != nil {
+			break
+		}
+		uploads = append(uploads, upload)
+	}
+	CheckErr(rows.Close(), "failed to close rows")
+	CheckErr(stmt1.Close(), "failed to close prepared statement")
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, upload := range uploads {
+		err = dr.DeleteFileUpload(upload)
+		if CheckErr(err, "Failed to remove abandoned upload [%v]", upload.ReferenceId) {
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// moveUploadFile renames the file, or copies it when the target is on another file system
+func moveUploadFile(source string, target string) error {
+	err := os.Rename(source, target)
+	if err == nil {
+		return nil
+	}
+	err = copyUploadFile(source, target)
+	if err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+func copyUploadFile(source string, target string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(targetFile, sourceFile)
+	closeErr := targetFile.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,343 @@
+package resource
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFileUploadTestResource opens a database file so uploads completing at the same time use their own connections
+func newFileUploadTestResource(t *testing.T) (*DbResource, *sqlx.DB) {
+	databaseDirectory, err := ioutil.TempDir("", "upload-db")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	db, err := sqlx.Open("sqlite3", "file:"+filepath.Join(databaseDirectory, "daptin.db")+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(databaseDirectory)
+	})
+	db.SetMaxOpenConns(8)
+	for _, statement := range []string{
+		"create table file_upload (id integer primary key, reference_id varchar(40), permission int, " +
+			"user_account_id int, file_name varchar(500), size bigint, upload_offset bigint default 0, " +
+			"checksum varchar(200), target varchar(1000), path varchar(1000), local_path varchar(1000), " +
+			"status varchar(20), created_at timestamp, updated_at timestamp)",
+		"create table document (id integer primary key, reference_id varchar(40), permission int, attachment text, " +
+			"updated_at timestamp)",
+		fmt.Sprintf("insert into document (reference_id, permission, attachment) values "+
+			"('d1', %d, '[{\"name\":\"a.txt\",\"path\":\"\",\"size\":1}]')", int64(auth.DEFAULT_PERMISSION)),
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	cruds := make(map[string]*DbResource)
+	for _, tableName := range []string{FILE_UPLOAD_TABLE_NAME, "document"} {
+		columns := []api2go.ColumnInfo{
+			{ColumnName: "reference_id", ColumnType: "alias"},
+			{ColumnName: "attachment", ColumnType: "json"},
+			{ColumnName: "updated_at", ColumnType: "datetime"},
+		}
+		cruds[tableName] = &DbResource{
+			db:               db,
+			connection:       db,
+			Cruds:            cruds,
+			model:            api2go.NewApi2GoModel(tableName, columns, 0, nil),
+			tableInfo:        &TableInfo{TableName: tableName, Columns: columns},
+			AssetFolderCache: make(map[string]map[string]*AssetFolderCache),
+			contextCache:     make(map[string]interface{}),
+			contextLock:      &sync.RWMutex{},
+		}
+	}
+	return cruds[FILE_UPLOAD_TABLE_NAME], db
+}
+
+// newColumnUploadTarget gives the attachment column of document a local storage folder
+func newColumnUploadTarget(t *testing.T, dr *DbResource) string {
+	syncDirectory, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	dr.Cruds["document"].AssetFolderCache["document"] = map[string]*AssetFolderCache{
+		"attachment": {LocalSyncPath: syncDirectory, CloudStore: CloudStore{StoreProvider: "local"}},
+	}
+	return syncDirectory
+}
+
+// writeTestUpload creates an upload of contents to the attachment column of document d1 and writes all of it
+func writeTestUpload(t *testing.T, dr *DbResource, uploadDirectory string, fileName string, contents string) FileUpload {
+	upload := FileUpload{FileName: fileName, Size: int64(len(contents)), Target: "document/d1/attachment", UserAccountId: 2}
+	upload.Path.String, upload.Path.Valid = "", true
+	err := dr.CreateFileUpload(&upload, uploadDirectory)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	offset, err := WriteUploadChunk(upload, 0, strings.NewReader(contents), "")
+	if err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	upload.Offset = offset
+	return upload
+}
+
+func sha1Checksum(data string) string {
+	digest := sha1.Sum([]byte(data))
+	return "sha1 " + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+func TestParseUploadTarget(t *testing.T) {
+	target, err := ParseUploadTarget("document/d1/attachment")
+	if err != nil || target.IsCloudStore() || target.TypeName != "document" || target.ColumnName != "attachment" {
+		t.Errorf("unexpected target %v %v", target, err)
+	}
+	target, err = ParseUploadTarget("cloud_store/c1/backups/2020")
+	if err != nil || !target.IsCloudStore() || target.ReferenceId != "c1" || target.Path != "backups/2020" {
+		t.Errorf("unexpected target %v %v", target, err)
+	}
+
+	for _, invalid := range []string{"", "document", "document/d1", "document//attachment", "document/d1/a/b", "cloud_store/c1/../etc"} {
+		_, err = ParseUploadTarget(invalid)
+		if err == nil {
+			t.Errorf("expected target [%v] to be invalid", invalid)
+		}
+	}
+	for _, invalid := range []string{"", "..", "a/b.txt", "../b.txt"} {
+		_, err = CleanUploadFileName(invalid)
+		if err == nil {
+			t.Errorf("expected file name [%v] to be invalid", invalid)
+		}
+	}
+
+	metadata, err := ParseUploadMetadata("filename " + base64.StdEncoding.EncodeToString([]byte("a b.txt")) + ",is_private")
+	if err != nil || metadata["filename"] != "a b.txt" || len(metadata) != 2 {
+		t.Errorf("unexpected metadata %v %v", metadata, err)
+	}
+}
+
+func TestWriteUploadChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	upload := FileUpload{Size: 6, LocalPath: filepath.Join(dir, "upload")}
+
+	offset, err := WriteUploadChunk(upload, 0, strings.NewReader("abc"), sha1Checksum("abc"))
+	if err != nil || offset != 3 {
+		t.Fatalf("unexpected offset %v %v", offset, err)
+	}
+	upload.Offset = offset
+
+	_, err = WriteUploadChunk(upload, 0, strings.NewReader("abc"), "")
+	if err != ErrUploadOffsetMismatch {
+		t.Errorf("expected an offset mismatch, got %v", err)
+	}
+
+	offset, err = WriteUploadChunk(upload, 3, strings.NewReader("xyz"), sha1Checksum("def"))
+	if err != ErrUploadChecksumMismatch || offset != 3 {
+		t.Errorf("expected a checksum mismatch, got %v %v", offset, err)
+	}
+
+	// bytes beyond the size of the upload are not written
+	offset, err = WriteUploadChunk(upload, 3, strings.NewReader("defghi"), "")
+	if err != nil || offset != 6 {
+		t.Fatalf("unexpected offset %v %v", offset, err)
+	}
+	contents, _ := ioutil.ReadFile(upload.LocalPath)
+	if string(contents) != "abcdef" {
+		t.Errorf("unexpected upload contents [%s]", contents)
+	}
+
+	upload.Offset = offset
+	upload.Checksum.String, upload.Checksum.Valid = sha1Checksum("abcdef"), true
+	fileMd5, err := UploadFileDigests(upload)
+	if err != nil || fileMd5 != "e80b5017098950fc58aad83c8c14978e" {
+		t.Errorf("unexpected digest %v %v", fileMd5, err)
+	}
+	upload.Checksum.String = sha1Checksum("abcdeg")
+	_, err = UploadFileDigests(upload)
+	if err != ErrUploadChecksumMismatch {
+		t.Errorf("expected the file to not match the checksum, got %v", err)
+	}
+}
+
+func TestCompleteFileUploadToColumn(t *testing.T) {
+	dr, db := newFileUploadTestResource(t)
+	uploadDirectory, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(uploadDirectory)
+	syncDirectory := newColumnUploadTarget(t, dr)
+	defer os.RemoveAll(syncDirectory)
+
+	upload := FileUpload{FileName: "b.txt", Size: 3, Target: "document/d1/attachment", UserAccountId: 2}
+	upload.Path.String, upload.Path.Valid = "notes", true
+	err = dr.CreateFileUpload(&upload, uploadDirectory)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+
+	offset, err := WriteUploadChunk(upload, 0, strings.NewReader("xyz"), "")
+	if err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	err = dr.UpdateFileUploadOffset(upload, offset)
+	if err != nil {
+		t.Fatalf("Failed to update offset: %v", err)
+	}
+	upload, err = dr.GetFileUpload(upload.ReferenceId)
+	if err != nil || upload.Offset != 3 || upload.UserAccountId != 2 || upload.Status != FileUploadStatusUploading {
+		t.Fatalf("unexpected upload %v %v", upload, err)
+	}
+
+	err = dr.CompleteFileUpload(upload, &auth.SessionUser{UserReferenceId: "u2"})
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(syncDirectory, "notes", "b.txt"))
+	if string(contents) != "xyz" {
+		t.Errorf("unexpected file contents [%s]", contents)
+	}
+	if _, err = os.Stat(upload.LocalPath); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file to be moved")
+	}
+
+	var attachment string
+	err = db.QueryRowx("select attachment from document where reference_id = 'd1'").Scan(&attachment)
+	if err != nil || !strings.Contains(attachment, `"name":"a.txt"`) || !strings.Contains(attachment, `"name":"b.txt"`) ||
+		!strings.Contains(attachment, `"path":"notes"`) {
+		t.Errorf("unexpected column value %v %v", attachment, err)
+	}
+	upload, _ = dr.GetFileUpload(upload.ReferenceId)
+	if upload.Status != FileUploadStatusComplete || upload.Offset != 3 {
+		t.Errorf("expected the upload to be complete %v", upload)
+	}
+}
+
+func TestCompleteFileUploadsToSameColumn(t *testing.T) {
+	dr, db := newFileUploadTestResource(t)
+	uploadDirectory, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(uploadDirectory)
+	syncDirectory := newColumnUploadTarget(t, dr)
+	defer os.RemoveAll(syncDirectory)
+
+	uploads := make([]FileUpload, 0)
+	for i := 0; i < 32; i++ {
+		uploads = append(uploads, writeTestUpload(t, dr, uploadDirectory, fmt.Sprintf("file-%d.txt", i), "xyz"))
+	}
+
+	// every upload completing at the same time keeps its file in the column
+	var wait sync.WaitGroup
+	errs := make(chan error, len(uploads))
+	for _, upload := range uploads {
+		wait.Add(1)
+		go func(upload FileUpload) {
+			defer wait.Done()
+			errs <- dr.CompleteFileUpload(upload, &auth.SessionUser{UserReferenceId: "u2"})
+		}(upload)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Failed to complete upload: %v", err)
+		}
+	}
+
+	var attachment string
+	err = db.QueryRowx("select attachment from document where reference_id = 'd1'").Scan(&attachment)
+	if err != nil {
+		t.Fatalf("Failed to read column: %v", err)
+	}
+	for i := 0; i < len(uploads); i++ {
+		if !strings.Contains(attachment, fmt.Sprintf(`"name":"file-%d.txt"`, i)) {
+			t.Errorf("expected file-%d.txt in the column, found %v", i, attachment)
+		}
+	}
+	if !strings.Contains(attachment, `"name":"a.txt"`) {
+		t.Errorf("expected the existing file to be kept, found %v", attachment)
+	}
+}
+
+func TestCompleteFileUploadToReadOnlyColumn(t *testing.T) {
+	dr, db := newFileUploadTestResource(t)
+	uploadDirectory, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(uploadDirectory)
+	syncDirectory := newColumnUploadTarget(t, dr)
+	defer os.RemoveAll(syncDirectory)
+
+	// only the members of a group may write the column
+	dr.Cruds["document"].tableInfo.Columns[1].Permission = uint64(auth.GroupRead | auth.GroupUpdate)
+
+	upload := writeTestUpload(t, dr, uploadDirectory, "b.txt", "xyz")
+	err = dr.CompleteFileUpload(upload, &auth.SessionUser{UserReferenceId: "u2"})
+	httpErr, ok := err.(api2go.HTTPError)
+	if !ok || httpErr.Status() != 403 {
+		t.Fatalf("expected a 403 for an unwritable column, got %v", err)
+	}
+
+	var attachment string
+	err = db.QueryRowx("select attachment from document where reference_id = 'd1'").Scan(&attachment)
+	if err != nil || strings.Contains(attachment, `"name":"b.txt"`) {
+		t.Errorf("expected the column to be unchanged, found %v %v", attachment, err)
+	}
+}
+
+func TestCleanupAbandonedUploads(t *testing.T) {
+	dr, db := newFileUploadTestResource(t)
+	uploadDirectory, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(uploadDirectory)
+
+	abandoned := FileUpload{FileName: "a.txt", Size: 10, Target: "document/d1/attachment"}
+	active := FileUpload{FileName: "b.txt", Size: 10, Target: "document/d1/attachment"}
+	for _, upload := range []*FileUpload{&abandoned, &active} {
+		err = dr.CreateFileUpload(upload, uploadDirectory)
+		if err != nil {
+			t.Fatalf("Failed to create upload: %v", err)
+		}
+	}
+	_, err = db.Exec("update file_upload set created_at = ?, updated_at = ? where reference_id = ?",
+		time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour), abandoned.ReferenceId)
+	if err != nil {
+		t.Fatalf("Failed to age upload: %v", err)
+	}
+
+	removed, err := dr.CleanupAbandonedUploads(time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("unexpected cleanup %v %v", removed, err)
+	}
+	if _, err = os.Stat(abandoned.LocalPath); !os.IsNotExist(err) {
+		t.Errorf("expected the abandoned file to be removed")
+	}
+	if _, err = dr.GetFileUpload(active.ReferenceId); err != nil {
+		t.Errorf("expected the active upload to be kept: %v", err)
+	}
+}
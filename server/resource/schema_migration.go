@@ -0,0 +1,711 @@
+package resource
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const SCHEMA_MIGRATION_TABLE_NAME = "schema_migration"
+
+// Kinds of SchemaChange
+const (
+	SchemaChangeAddColumn          = "add_column"
+	SchemaChangeColumnType         = "change_column_type"
+	SchemaChangeColumnNullable     = "change_column_nullable"
+	SchemaChangeDropColumn         = "drop_column"
+	SchemaChangeAddIndex           = "add_index"
+	SchemaChangeDropIndex          = "drop_index"
+	schemaMigrationRebuildSuffix   = "_migration_rebuild"
+	schemaMigrationAppliedByServer = "server"
+)
+
+// Values of DAPTIN_SCHEMA_MIGRATION, which decides what the server does with the plan on startup. The plan is
+// only printed unless a mode which applies it is set
+const (
+	SchemaMigrationModeSafe   = "safe"
+	SchemaMigrationModeAll    = "all"
+	SchemaMigrationModeDryRun = "dry-run"
+	SchemaMigrationModeOff    = "off"
+)
+
+// indexes created by daptin are named with a letter followed by a md5 hash, other indexes are left alone
+var managedIndexNamePattern = regexp.MustCompile("^[iu][0-9a-f]{32}$")
+
+// SchemaChange is one difference between a table in the config and the same table in the database.
+// Destructive changes can lose data or fail on existing data, and are applied only when asked for
+type SchemaChange struct {
+	Kind        string   `json:"kind"`
+	TableName   string   `json:"table_name"`
+	ColumnName  string   `json:"column_name,omitempty"`
+	IndexName   string   `json:"index_name,omitempty"`
+	Columns     []string `json:"columns,omitempty"`
+	Unique      bool     `json:"unique,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          string   `json:"to,omitempty"`
+	Destructive bool     `json:"destructive"`
+}
+
+func (sc SchemaChange) String() string {
+	destructive := ""
+	if sc.Destructive {
+		destructive = " [destructive]"
+	}
+	switch sc.Kind {
+	case SchemaChangeAddColumn:
+		return fmt.Sprintf("%v: add column %v %v%v", sc.TableName, sc.ColumnName, sc.To, destructive)
+	case SchemaChangeColumnType:
+		return fmt.Sprintf("%v: change type of %v from %v to %v%v", sc.TableName, sc.ColumnName, sc.From, sc.To, destructive)
+	case SchemaChangeColumnNullable:
+		return fmt.Sprintf("%v: change %v from %v to %v%v", sc.TableName, sc.ColumnName, sc.From, sc.To, destructive)
+	case SchemaChangeDropColumn:
+		return fmt.Sprintf("%v: drop column %v%v", sc.TableName, sc.ColumnName, destructive)
+	case SchemaChangeAddIndex:
+		return fmt.Sprintf("%v: add index %v on (%v)%v", sc.TableName, sc.IndexName, strings.Join(sc.Columns, ", "), destructive)
+	case SchemaChangeDropIndex:
+		return fmt.Sprintf("%v: drop index %v%v", sc.TableName, sc.IndexName, destructive)
+	}
+	return fmt.Sprintf("%v: %v%v", sc.TableName, sc.Kind, destructive)
+}
+
+// SchemaMigrationPlan is the list of changes needed to bring the database to the tables in the config
+type SchemaMigrationPlan struct {
+	DriverName string
+	Changes    []SchemaChange
+	// desired state of the tables in the plan, used to rebuild sqlite tables
+	tables map[string]TableInfo
+}
+
+func (plan SchemaMigrationPlan) IsEmpty() bool {
+	return len(plan.Changes) == 0
+}
+
+func (plan SchemaMigrationPlan) HasDestructiveChanges() bool {
+	for _, change := range plan.Changes {
+		if change.Destructive {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectChanges returns the changes which are applied, all of them or only the ones which are not destructive
+func (plan SchemaMigrationPlan) SelectChanges(includeDestructive bool) []SchemaChange {
+	changes := make([]SchemaChange, 0)
+	for _, change := range plan.Changes {
+		if change.Destructive && !includeDestructive {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (plan SchemaMigrationPlan) String() string {
+	if plan.IsEmpty() {
+		return "schema is up to date"
+	}
+	lines := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Statements returns the sql to apply the selected changes, in order of tables.
+// On sqlite, column type and nullability changes and dropped columns need the table to be rebuilt:
+// a new table is created from the config, rows are copied over, and it replaces the old table
+func (plan SchemaMigrationPlan) Statements(includeDestructive bool) []string {
+
+	statements := make([]string, 0)
+	tableOrder := make([]string, 0)
+	changesByTable := make(map[string][]SchemaChange)
+	for _, change := range plan.SelectChanges(includeDestructive) {
+		if _, ok := changesByTable[change.TableName]; !ok {
+			tableOrder = append(tableOrder, change.TableName)
+		}
+		changesByTable[change.TableName] = append(changesByTable[change.TableName], change)
+	}
+
+	for _, tableName := range tableOrder {
+		changes := changesByTable[tableName]
+		table := plan.tables[tableName]
+
+		if plan.DriverName == "sqlite3" && needsTableRebuild(changes) {
+			statements = append(statements, rebuildTableStatements(table, changes)...)
+			continue
+		}
+
+		modifiedColumns := make(map[string]bool)
+		for _, change := range changes {
+			column, _ := findDesiredColumn(table, change.ColumnName)
+			switch change.Kind {
+			case SchemaChangeAddColumn:
+				statements = append(statements, alterTableAddColumn(tableName, &column, plan.DriverName))
+			case SchemaChangeColumnType, SchemaChangeColumnNullable:
+				if plan.DriverName == "mysql" {
+					// modify column sets both the type and the nullability
+					if !modifiedColumns[change.ColumnName] {
+						statements = append(statements, fmt.Sprintf("alter table %v modify column %v", tableName, getColumnLine(&column, plan.DriverName)))
+						modifiedColumns[change.ColumnName] = true
+					}
+				} else if change.Kind == SchemaChangeColumnType {
+					statements = append(statements, fmt.Sprintf("alter table %v alter column %v type %v using %v::%v",
+						tableName, change.ColumnName, change.To, change.ColumnName, change.To))
+				} else if change.To == "null" {
+					statements = append(statements, fmt.Sprintf("alter table %v alter column %v drop not null", tableName, change.ColumnName))
+				} else {
+					statements = append(statements, fmt.Sprintf("alter table %v alter column %v set not null", tableName, change.ColumnName))
+				}
+			case SchemaChangeDropColumn:
+				statements = append(statements, fmt.Sprintf("alter table %v drop column %v", tableName, change.ColumnName))
+			case SchemaChangeAddIndex:
+				statements = append(statements, createIndexStatement(tableName, change.IndexName, change.Columns, change.Unique, false))
+			case SchemaChangeDropIndex:
+				if plan.DriverName == "mysql" {
+					statements = append(statements, fmt.Sprintf("drop index %v on %v", change.IndexName, tableName))
+				} else {
+					statements = append(statements, fmt.Sprintf("drop index %v", change.IndexName))
+				}
+			}
+		}
+	}
+
+	return statements
+}
+
+func needsTableRebuild(changes []SchemaChange) bool {
+	for _, change := range changes {
+		switch change.Kind {
+		case SchemaChangeColumnType, SchemaChangeColumnNullable, SchemaChangeDropColumn:
+			return true
+		}
+	}
+	return false
+}
+
+// rebuildTableStatements recreates the table as it is in the config, keeping the rows and the values of the
+// columns which are in both. Indexes are dropped along with the old table, so all indexes from the config are
+// created again
+func rebuildTableStatements(table TableInfo, changes []SchemaChange) []string {
+
+	dropped := make(map[string]bool)
+	added := make(map[string]bool)
+	for _, change := range changes {
+		switch change.Kind {
+		case SchemaChangeDropColumn:
+			dropped[change.ColumnName] = true
+		case SchemaChangeAddColumn:
+			added[change.ColumnName] = true
+		}
+	}
+
+	copiedColumns := make([]string, 0)
+	for _, column := range desiredColumns(table) {
+		if !added[column.ColumnName] && !dropped[column.ColumnName] {
+			copiedColumns = append(copiedColumns, column.ColumnName)
+		}
+	}
+
+	newTable := table
+	newTable.TableName = table.TableName + schemaMigrationRebuildSuffix
+	newTable.Columns = desiredColumns(table)
+
+	columnList := strings.Join(copiedColumns, ", ")
+	statements := []string{
+		MakeCreateTableQuery(&newTable, "sqlite3"),
+		fmt.Sprintf("insert into %v (%v) select %v from %v", newTable.TableName, columnList, columnList, table.TableName),
+		fmt.Sprintf("drop table %v", table.TableName),
+		fmt.Sprintf("alter table %v rename to %v", newTable.TableName, table.TableName),
+	}
+	for _, index := range desiredIndexes(table) {
+		// composite key indexes can already exist on another table with the same name
+		statements = append(statements, createIndexStatement(table.TableName, index.Name, index.Columns, index.Unique, true))
+	}
+	return statements
+}
+
+func createIndexStatement(tableName string, indexName string, columns []string, unique bool, ifNotExists bool) string {
+	indexType := "index"
+	if unique {
+		indexType = "unique index"
+	}
+	if ifNotExists {
+		indexType += " if not exists"
+	}
+	return fmt.Sprintf("create %v %v on %v (%v)", indexType, indexName, tableName, strings.Join(columns, ", "))
+}
+
+// LiveColumn is a column as it exists in the database
+type LiveColumn struct {
+	ColumnName string
+	DataType   string
+	IsNullable bool
+}
+
+type schemaIndex struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// desiredColumns is the list of columns the table is created with, without duplicates
+func desiredColumns(table TableInfo) []api2go.ColumnInfo {
+	columns := make([]api2go.ColumnInfo, 0)
+	done := make(map[string]bool)
+	for _, column := range table.Columns {
+		if column.ColumnName == "" {
+			column.ColumnName = column.Name
+		}
+		if strings.TrimSpace(column.ColumnName) == "" || done[column.ColumnName] {
+			continue
+		}
+		done[column.ColumnName] = true
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func findDesiredColumn(table TableInfo, columnName string) (api2go.ColumnInfo, bool) {
+	for _, column := range desiredColumns(table) {
+		if column.ColumnName == columnName {
+			return column, true
+		}
+	}
+	return api2go.ColumnInfo{}, false
+}
+
+// desiredIndexes are the indexes created for the table by CreateIndexes and CreateUniqueConstraints
+func desiredIndexes(table TableInfo) []schemaIndex {
+	indexes := make([]schemaIndex, 0)
+	for _, column := range desiredColumns(table) {
+		if column.IsUnique {
+			indexes = append(indexes, schemaIndex{
+				Name:    UniqueColumnIndexName(table.TableName, column.ColumnName),
+				Columns: []string{column.ColumnName},
+				Unique:  true,
+			})
+		} else if column.IsIndexed {
+			indexes = append(indexes, schemaIndex{
+				Name:    ColumnIndexName(table.TableName, column.ColumnName),
+				Columns: []string{column.ColumnName},
+			})
+		}
+	}
+
+	for _, compositeKeyCols := range table.CompositeKeys {
+		indexes = append(indexes, schemaIndex{
+			Name:    CompositeKeyIndexName(compositeKeyCols),
+			Columns: compositeKeyCols,
+			Unique:  true,
+		})
+	}
+
+	if strings.Index(table.TableName, "_has_") > -1 {
+		var cols []string
+		for _, col := range table.Columns {
+			if col.IsForeignKey {
+				cols = append(cols, col.ColumnName)
+			}
+		}
+		if len(cols) > 0 {
+			indexes = append(indexes, schemaIndex{
+				Name:    JoinTableIndexName(table.TableName),
+				Columns: cols,
+				Unique:  true,
+			})
+		}
+	}
+	return indexes
+}
+
+// normalizeDataType maps the names different databases report for the same type to one name,
+// so that the type in the config can be compared with the type in the database
+func normalizeDataType(dataType string, driverName string) string {
+	dataType = strings.ToLower(strings.TrimSpace(dataType))
+	dataType = strings.Join(strings.Fields(dataType), " ")
+	dataType = strings.ReplaceAll(dataType, " (", "(")
+
+	switch {
+	case dataType == "integer" || dataType == "int" || strings.HasPrefix(dataType, "int("):
+		return "int"
+	case dataType == "bigint" || strings.HasPrefix(dataType, "bigint("):
+		return "bigint"
+	case dataType == "boolean" || dataType == "bool" || dataType == "tinyint(1)":
+		return "bool"
+	case strings.HasPrefix(dataType, "character varying"):
+		return "varchar" + strings.TrimPrefix(dataType, "character varying")
+	case strings.HasPrefix(dataType, "timestamp"):
+		return "timestamp"
+	case dataType == "double precision" || dataType == "double" || dataType == "float" || dataType == "real":
+		return "float"
+	case driverName == "postgres" && (strings.HasPrefix(dataType, "float") || strings.HasPrefix(dataType, "double") ||
+		strings.HasPrefix(dataType, "real")):
+		// postgres reports float(p) as real or double precision, without the precision
+		return "float"
+	case driverName == "postgres" && (strings.HasPrefix(dataType, "numeric") || strings.HasPrefix(dataType, "decimal")):
+		return "numeric"
+	case dataType == "bytea" || dataType == "blob":
+		return "blob"
+	}
+	return dataType
+}
+
+// PlanTableMigration compares the table in the config with the columns and indexes of the table in the database
+func PlanTableMigration(table TableInfo, driverName string, liveColumns []LiveColumn, liveIndexes []string) []SchemaChange {
+
+	changes := make([]SchemaChange, 0)
+
+	liveColumnMap := make(map[string]LiveColumn)
+	for _, column := range liveColumns {
+		liveColumnMap[column.ColumnName] = column
+	}
+
+	wanted := make(map[string]bool)
+	for _, column := range desiredColumns(table) {
+		wanted[column.ColumnName] = true
+		dataType := getColumnDataType(&column, driverName)
+		nullable := isColumnNullable(&column, driverName)
+
+		liveColumn, ok := liveColumnMap[column.ColumnName]
+		if !ok {
+			changes = append(changes, SchemaChange{
+				Kind:       SchemaChangeAddColumn,
+				TableName:  table.TableName,
+				ColumnName: column.ColumnName,
+				To:         dataType,
+			})
+			continue
+		}
+
+		// the primary key is created differently by every database
+		if column.IsAutoIncrement || column.IsPrimaryKey {
+			continue
+		}
+
+		if normalizeDataType(dataType, driverName) != normalizeDataType(liveColumn.DataType, driverName) {
+			changes = append(changes, SchemaChange{
+				Kind:        SchemaChangeColumnType,
+				TableName:   table.TableName,
+				ColumnName:  column.ColumnName,
+				From:        liveColumn.DataType,
+				To:          dataType,
+				Destructive: true,
+			})
+		}
+
+		if nullable != liveColumn.IsNullable {
+			changes = append(changes, SchemaChange{
+				Kind:        SchemaChangeColumnNullable,
+				TableName:   table.TableName,
+				ColumnName:  column.ColumnName,
+				From:        nullabilityName(liveColumn.IsNullable),
+				To:          nullabilityName(nullable),
+				Destructive: true,
+			})
+		}
+	}
+
+	for _, column := range liveColumns {
+		if !wanted[column.ColumnName] {
+			changes = append(changes, SchemaChange{
+				Kind:        SchemaChangeDropColumn,
+				TableName:   table.TableName,
+				ColumnName:  column.ColumnName,
+				From:        column.DataType,
+				Destructive: true,
+			})
+		}
+	}
+
+	liveIndexMap := make(map[string]bool)
+	for _, indexName := range liveIndexes {
+		liveIndexMap[indexName] = true
+	}
+
+	wantedIndexes := make(map[string]bool)
+	for _, index := range desiredIndexes(table) {
+		wantedIndexes[index.Name] = true
+		if liveIndexMap[index.Name] {
+			continue
+		}
+		changes = append(changes, SchemaChange{
+			Kind:      SchemaChangeAddIndex,
+			TableName: table.TableName,
+			IndexName: index.Name,
+			Columns:   index.Columns,
+			Unique:    index.Unique,
+		})
+	}
+
+	for _, indexName := range liveIndexes {
+		if wantedIndexes[indexName] || !managedIndexNamePattern.MatchString(indexName) {
+			continue
+		}
+		// a dropped unique index no longer keeps duplicates out
+		changes = append(changes, SchemaChange{
+			Kind:        SchemaChangeDropIndex,
+			TableName:   table.TableName,
+			IndexName:   indexName,
+			Destructive: true,
+		})
+	}
+
+	return changes
+}
+
+func nullabilityName(nullable bool) string {
+	if nullable {
+		return "null"
+	}
+	return "not null"
+}
+
+// PlanSchemaMigration diffs the tables in the config against the database. Tables which are not yet
+// in the database are left out, they are created by CheckAllTableStatus
+func PlanSchemaMigration(tables []TableInfo, db database.DatabaseConnection) (SchemaMigrationPlan, error) {
+
+	plan := SchemaMigrationPlan{
+		DriverName: db.DriverName(),
+		Changes:    make([]SchemaChange, 0),
+		tables:     make(map[string]TableInfo),
+	}
+	indexTables := make(map[string]string)
+
+	for _, table := range tables {
+		if len(table.TableName) < 2 {
+			continue
+		}
+		if _, ok := plan.tables[table.TableName]; ok {
+			continue
+		}
+
+		liveColumns, err := GetLiveColumns(db, table.TableName)
+		if err != nil {
+			return plan, err
+		}
+		if len(liveColumns) == 0 {
+			continue
+		}
+
+		liveIndexes, err := GetLiveIndexes(db, table.TableName)
+		if err != nil {
+			return plan, err
+		}
+
+		for _, indexName := range liveIndexes {
+			indexTables[indexName] = table.TableName
+		}
+
+		plan.tables[table.TableName] = table
+		plan.Changes = append(plan.Changes, PlanTableMigration(table, plan.DriverName, liveColumns, liveIndexes)...)
+	}
+
+	// composite key indexes are named after their columns only, an index with the same name on
+	// another table cannot be created again
+	changes := make([]SchemaChange, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		if change.Kind == SchemaChangeAddIndex {
+			if otherTable, ok := indexTables[change.IndexName]; ok && otherTable != change.TableName {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	plan.Changes = changes
+
+	return plan, nil
+}
+
+// GetLiveColumns reads the columns of the table from the database, the list is empty if there is no such table
+func GetLiveColumns(db database.DatabaseConnection, tableName string) ([]LiveColumn, error) {
+
+	columns := make([]LiveColumn, 0)
+
+	var query string
+	args := []interface{}{tableName}
+	switch db.DriverName() {
+	case "sqlite3":
+		query = "select name, type, \"notnull\" = 0 from pragma_table_info(?)"
+	case "mysql":
+		query = "select COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE = 'YES' from INFORMATION_SCHEMA.COLUMNS " +
+			"where TABLE_SCHEMA = database() and TABLE_NAME = ? order by ORDINAL_POSITION"
+	case "postgres":
+		query = "select column_name, case when character_maximum_length is null then data_type " +
+			"else data_type || '(' || character_maximum_length || ')' end, is_nullable = 'YES' " +
+			"from information_schema.columns where table_schema = current_schema() and table_name = ? order by ordinal_position"
+	default:
+		return columns, fmt.Errorf("schema migration is not supported for database [%v]", db.DriverName())
+	}
+
+	rows, err := db.Queryx(db.Rebind(query), args...)
+	if err != nil {
+		return columns, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column LiveColumn
+		var dataType sql.NullString
+		err = rows.Scan(&column.ColumnName, &dataType, &column.IsNullable)
+		if err != nil {
+			return columns, err
+		}
+		column.DataType = dataType.String
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// GetLiveIndexes reads the names of the indexes on the table from the database
+func GetLiveIndexes(db database.DatabaseConnection, tableName string) ([]string, error) {
+
+	indexes := make([]string, 0)
+
+	var query string
+	switch db.DriverName() {
+	case "sqlite3":
+		query = "select name from sqlite_master where type = 'index' and tbl_name = ?"
+	case "mysql":
+		query = "select distinct INDEX_NAME from INFORMATION_SCHEMA.STATISTICS where TABLE_SCHEMA = database() and TABLE_NAME = ?"
+	case "postgres":
+		query = "select indexname from pg_indexes where schemaname = current_schema() and tablename = ?"
+	default:
+		return indexes, fmt.Errorf("schema migration is not supported for database [%v]", db.DriverName())
+	}
+
+	rows, err := db.Queryx(db.Rebind(query), tableName)
+	if err != nil {
+		return indexes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var indexName string
+		err = rows.Scan(&indexName)
+		if err != nil {
+			return indexes, err
+		}
+		indexes = append(indexes, indexName)
+	}
+
+	return indexes, rows.Err()
+}
+
+// ApplySchemaMigration runs the statements of the plan in a transaction and records them in schema_migration
+// along with the user who applied them. userId is the id of the user account, or 0 when the server applies
+// the migration on startup, in which case the migration is owned by the admin
+func ApplySchemaMigration(db database.DatabaseConnection, plan SchemaMigrationPlan, includeDestructive bool,
+	appliedBy string, userId int64) ([]string, error) {
+
+	changes := plan.SelectChanges(includeDestructive)
+	statements := plan.Statements(includeDestructive)
+	if len(statements) == 0 {
+		return statements, nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return statements, err
+	}
+
+	for _, statement := range statements {
+		log.Printf("Schema migration: %v", statement)
+		_, err = tx.Exec(statement)
+		if err != nil {
+			rollbackErr := tx.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback schema migration")
+			return statements, fmt.Errorf("failed to apply schema migration [%v]: %v", statement, err)
+		}
+	}
+
+	if userId == 0 {
+		userId, _ = GetAdminUserIdAndUserGroupId(tx)
+	}
+	if appliedBy == "" {
+		appliedBy = schemaMigrationAppliedByServer
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback schema migration")
+		return statements, err
+	}
+	now := time.Now()
+	record := goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		"created_at":           now,
+		"changes":              toJson(changes),
+		"statements":           strings.Join(statements, ";\n"),
+		"applied_by":           appliedBy,
+		"includes_destructive": includeDestructive && plan.HasDestructiveChanges(),
+	}
+	if userId != 0 {
+		record[USER_ACCOUNT_ID_COLUMN] = userId
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(SCHEMA_MIGRATION_TABLE_NAME).Rows(record).ToSQL()
+	if err == nil {
+		_, err = tx.Exec(query, args...)
+	}
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback schema migration")
+		return statements, fmt.Errorf("failed to record schema migration: %v", err)
+	}
+
+	return statements, tx.Commit()
+}
+
+// RunSchemaMigration prints the migration plan for the tables in the config and applies it as
+// configured by mode, destructive changes are only applied in SchemaMigrationModeAll
+func RunSchemaMigration(initConfig *CmsConfig, db database.DatabaseConnection, mode string) {
+
+	if mode == SchemaMigrationModeOff {
+		return
+	}
+
+	plan, err := PlanSchemaMigration(initConfig.Tables, db)
+	if err != nil {
+		log.Errorf("Failed to plan schema migration: %v", err)
+		return
+	}
+	if plan.IsEmpty() {
+		log.Printf("Schema migration: schema is up to date")
+		return
+	}
+
+	log.Printf("Schema migration plan:\n%v", plan.String())
+
+	switch mode {
+	case SchemaMigrationModeDryRun:
+		log.Printf("Schema migration: dry run, no changes applied. Set DAPTIN_SCHEMA_MIGRATION=safe or all, or use " +
+			"the migrate_schema action to apply them")
+		return
+	case SchemaMigrationModeAll:
+	default:
+		if plan.HasDestructiveChanges() {
+			log.Warnf("Schema migration: destructive changes are not applied, set DAPTIN_SCHEMA_MIGRATION=all or " +
+				"use the migrate_schema action to apply them")
+		}
+	}
+
+	statements, err := ApplySchemaMigration(db, plan, mode == SchemaMigrationModeAll, schemaMigrationAppliedByServer, 0)
+	if err != nil {
+		log.Errorf("Schema migration failed: %v", err)
+		return
+	}
+	log.Printf("Schema migration: applied %d statements", len(statements))
+}
@@ -0,0 +1,148 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+	"testing"
+)
+
+func newSchemaMigrationTestDatabase(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		"create table ticket (id INTEGER PRIMARY KEY, title varchar(50) not null, status varchar(20) not null, " +
+			"priority int(11) not null, legacy text null)",
+		"insert into ticket (title, status, priority, legacy) values ('first', 'open', 1, 'x')",
+		"insert into ticket (title, status, priority, legacy) values ('second', 'closed', 2, 'y')",
+		"create table schema_migration (id INTEGER PRIMARY KEY, reference_id varchar(64), permission int(11), " +
+			"user_account_id int(11), created_at timestamp, changes text, statements text, applied_by varchar(100), " +
+			"includes_destructive bool)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database [%v]: %v", statement, err)
+		}
+	}
+	return db
+}
+
+var schemaMigrationTestTable = TableInfo{
+	TableName: "ticket",
+	Columns: []api2go.ColumnInfo{
+		{ColumnName: "id", DataType: "INTEGER", IsAutoIncrement: true, IsPrimaryKey: true},
+		{ColumnName: "title", DataType: "varchar(100)"},
+		{ColumnName: "status", DataType: "varchar(20)", IsIndexed: true},
+		{ColumnName: "priority", DataType: "int(11)", IsNullable: true},
+	},
+}
+
+func TestPlanSchemaMigration(t *testing.T) {
+	db := newSchemaMigrationTestDatabase(t)
+
+	plan, err := PlanSchemaMigration([]TableInfo{schemaMigrationTestTable, {TableName: "not_created_yet"}}, db)
+	if err != nil {
+		t.Fatalf("Failed to plan migration: %v", err)
+	}
+
+	kinds := make([]string, 0)
+	for _, change := range plan.Changes {
+		kinds = append(kinds, change.Kind+":"+change.ColumnName)
+	}
+	expected := "change_column_type:title,change_column_nullable:priority,drop_column:legacy,add_index:"
+	if strings.Join(kinds, ",") != expected {
+		t.Errorf("unexpected plan [%v], expected [%v]", strings.Join(kinds, ","), expected)
+	}
+
+	safeStatements := plan.Statements(false)
+	if len(safeStatements) != 1 || !strings.HasPrefix(safeStatements[0], "create index i") {
+		t.Errorf("expected only the index to be created without destructive changes: %v", safeStatements)
+	}
+}
+
+func TestApplySchemaMigrationRebuildsSqliteTable(t *testing.T) {
+	db := newSchemaMigrationTestDatabase(t)
+
+	plan, err := PlanSchemaMigration([]TableInfo{schemaMigrationTestTable}, db)
+	if err != nil {
+		t.Fatalf("Failed to plan migration: %v", err)
+	}
+
+	_, err = ApplySchemaMigration(db, plan, true, "admin@example.com", 1)
+	if err != nil {
+		t.Fatalf("Failed to apply migration: %v", err)
+	}
+
+	liveColumns, err := GetLiveColumns(db, "ticket")
+	if err != nil {
+		t.Fatalf("Failed to read columns: %v", err)
+	}
+	columnTypes := make([]string, 0)
+	for _, column := range liveColumns {
+		columnTypes = append(columnTypes, column.ColumnName+" "+column.DataType)
+	}
+	if strings.Join(columnTypes, ",") != "id INTEGER,title varchar(100),status varchar(20),priority int(11)" {
+		t.Errorf("unexpected columns after migration: %v", columnTypes)
+	}
+
+	var titles []string
+	err = db.Select(&titles, "select title from ticket order by id")
+	if err != nil || strings.Join(titles, ",") != "first,second" {
+		t.Errorf("rows were not kept by the rebuild: %v %v", titles, err)
+	}
+
+	plan, err = PlanSchemaMigration([]TableInfo{schemaMigrationTestTable}, db)
+	if err != nil {
+		t.Fatalf("Failed to plan migration: %v", err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("expected no changes after migration, got: %v", plan.String())
+	}
+
+	var appliedBy string
+	var includesDestructive bool
+	err = db.QueryRowx("select applied_by, includes_destructive from schema_migration").Scan(&appliedBy, &includesDestructive)
+	if err != nil {
+		t.Fatalf("Failed to read recorded migration: %v", err)
+	}
+	if appliedBy != "admin@example.com" || !includesDestructive {
+		t.Errorf("unexpected migration record: %v %v", appliedBy, includesDestructive)
+	}
+}
+
+func TestNormalizeDataType(t *testing.T) {
+	same := [][]string{
+		{"int(11)", "integer"},
+		{"varchar(100)", "character varying(100)"},
+		{"bool", "tinyint(1)"},
+		{"timestamp", "timestamp without time zone"},
+		{"float(7,4)", "real"},
+		{"float(11)", "real"},
+		{"float", "double precision"},
+		{"decimal(10,2)", "numeric"},
+	}
+	for _, pair := range same {
+		if normalizeDataType(pair[0], "postgres") != normalizeDataType(pair[1], "postgres") {
+			t.Errorf("expected [%v] and [%v] to be the same type", pair[0], pair[1])
+		}
+	}
+	if normalizeDataType("varchar(50)", "postgres") == normalizeDataType("varchar(100)", "postgres") {
+		t.Errorf("expected varchar lengths to be compared")
+	}
+	if normalizeDataType("float(7,4)", "mysql") == normalizeDataType("float(8,2)", "mysql") {
+		t.Errorf("expected float precision to be compared when the database reports it")
+	}
+}
+
+func TestPlanSchemaMigrationDropIndexIsDestructive(t *testing.T) {
+	changes := PlanTableMigration(TableInfo{TableName: "ticket"}, "sqlite3", nil,
+		[]string{"u0123456789abcdef0123456789abcdef", "custom_index"})
+	if len(changes) != 1 || changes[0].Kind != SchemaChangeDropIndex || !changes[0].Destructive {
+		t.Errorf("expected dropping the managed index to be a destructive change: %v", changes)
+	}
+}
@@ -0,0 +1,46 @@
+package resource
+
+import "sync"
+
+// SiteFolderCache holds the local folders of the hosted sites by the site reference id. Sites are added, replaced
+// and removed while the server is running, so the folders are only accessed through the lock
+type SiteFolderCache struct {
+	lock    sync.RWMutex
+	folders map[string]*AssetFolderCache
+}
+
+func NewSiteFolderCache() *SiteFolderCache {
+	return &SiteFolderCache{
+		folders: make(map[string]*AssetFolderCache),
+	}
+}
+
+// Get returns the folder of the site, nil if the site is not hosted here
+func (sfc *SiteFolderCache) Get(siteReferenceId string) *AssetFolderCache {
+	sfc.lock.RLock()
+	defer sfc.lock.RUnlock()
+	return sfc.folders[siteReferenceId]
+}
+
+func (sfc *SiteFolderCache) Set(siteReferenceId string, folder *AssetFolderCache) {
+	sfc.lock.Lock()
+	defer sfc.lock.Unlock()
+	sfc.folders[siteReferenceId] = folder
+}
+
+func (sfc *SiteFolderCache) Remove(siteReferenceId string) {
+	sfc.lock.Lock()
+	defer sfc.lock.Unlock()
+	delete(sfc.folders, siteReferenceId)
+}
+
+// All returns a copy of the folders by the site reference id
+func (sfc *SiteFolderCache) All() map[string]*AssetFolderCache {
+	sfc.lock.RLock()
+	defer sfc.lock.RUnlock()
+	folders := make(map[string]*AssetFolderCache, len(sfc.folders))
+	for siteId, folder := range sfc.folders {
+		folders[siteId] = folder
+	}
+	return folders
+}
@@ -0,0 +1,56 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const SSH_KEY_TABLE_NAME = "ssh_key"
+
+// GetUserSshPublicKeys are the public keys the user added to the ssh_key table, keys which cannot be parsed
+// are skipped
+func (dr *DbResource) GetUserSshPublicKeys(userId int64) ([]ssh.PublicKey, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("public_key").
+		From(SSH_KEY_TABLE_NAME).
+		Where(goqu.Ex{USER_ACCOUNT_ID_COLUMN: userId}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]ssh.PublicKey, 0)
+	for rows.Next() {
+		var authorizedKey string
+		err = rows.Scan(&authorizedKey)
+		if err != nil {
+			return keys, err
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+		if err != nil {
+			log.Warnf("Skipping ssh key of user [%v] which cannot be parsed: %v", userId, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,659 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/artpar/rclone/fs"
+	"github.com/artpar/rclone/fs/config"
+	"github.com/artpar/rclone/fs/filter"
+	"github.com/artpar/rclone/fs/operations"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SyncModePull only copies the cloud store down to the local folder
+	SyncModePull = "pull"
+	// SyncModeBidirectional also writes the changes made in the local folder back to the cloud store
+	SyncModeBidirectional = "bidirectional"
+)
+
+// Conflict policies decide a file changed both in the local folder and in the cloud store since the last sync
+const (
+	ConflictPolicyNewestWins = "newest_wins"
+	ConflictPolicyRemoteWins = "remote_wins"
+	ConflictPolicyKeepBoth   = "keep_both"
+)
+
+const (
+	LocalChangeWrite  = "write"
+	LocalChangeDelete = "delete"
+	LocalChangeMkdir  = "mkdir"
+)
+
+// maxSyncConflicts is the number of recent conflicts kept for the status of a folder
+const maxSyncConflicts = 50
+
+// LocalChange is a file or folder changed in the local folder which is not yet in the cloud store
+type LocalChange struct {
+	Path      string    `json:"path"`
+	Operation string    `json:"operation"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SyncConflict records how a change made on both sides was resolved
+type SyncConflict struct {
+	Path       string    `json:"path"`
+	Policy     string    `json:"policy"`
+	Resolution string    `json:"resolution"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// AssetFolderSyncStatus is the state of the sync between a local folder and its cloud store
+type AssetFolderSyncStatus struct {
+	CloudStore     string         `json:"cloud_store"`
+	RemotePath     string         `json:"remote_path"`
+	LocalPath      string         `json:"local_path"`
+	SyncMode       string         `json:"sync_mode"`
+	ConflictPolicy string         `json:"conflict_policy"`
+	PendingChanges []LocalChange  `json:"pending_changes"`
+	LastPull       *time.Time     `json:"last_pull"`
+	LastPush       *time.Time     `json:"last_push"`
+	LastError      string         `json:"last_error"`
+	Conflicts      []SyncConflict `json:"conflicts"`
+}
+
+type folderSyncState struct {
+	// syncLock allows one push or pull of the folder at a time
+	syncLock sync.Mutex
+	lock     sync.Mutex
+	pending  map[string]LocalChange
+	// pushing are the changes taken by the running push, they stay in the journal until the push is done
+	pushing map[string]LocalChange
+	// journalChecked is set once the journals left by earlier folders of the same cloud store path were pushed
+	journalChecked bool
+	// lastSync is when the last completed pull started, files changed in the cloud store after it are
+	// changed on both sides when they were also changed locally
+	lastSync time.Time
+	// pushedAt is when a file was last written to the cloud store since the last pull, so our own writes are
+	// not taken for changes made in the cloud store
+	pushedAt  map[string]time.Time
+	lastPull  time.Time
+	lastPush  time.Time
+	lastError string
+	conflicts []SyncConflict
+}
+
+// IsBidirectional tells if local changes are written back to the cloud store. Folders of a local store are the
+// store itself and need no sync
+func (afc *AssetFolderCache) IsBidirectional() bool {
+	return afc.SyncMode == SyncModeBidirectional && afc.CloudStore.StoreProvider != "local"
+}
+
+// RemotePath is the folder of the cloud store synced with the local folder
+func (afc *AssetFolderCache) RemotePath() string {
+	root := afc.CloudStore.RootPath
+	if afc.Keyname == "" {
+		return root
+	}
+	if !strings.HasSuffix(root, "/") && !strings.HasPrefix(afc.Keyname, "/") {
+		root = root + "/"
+	}
+	return root + afc.Keyname
+}
+
+// RecordChange notes a change to the file or folder at localPath, to be written back to the cloud store.
+// Changes are only tracked for bidirectional folders
+func (afc *AssetFolderCache) RecordChange(localPath string, operation string) {
+	if !afc.IsBidirectional() {
+		return
+	}
+	relativePath, err := filepath.Rel(afc.LocalSyncPath, localPath)
+	if err != nil || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+		log.Printf("Not tracking change outside of synced folder [%v]: %v", afc.LocalSyncPath, localPath)
+		return
+	}
+	relativePath = filepath.ToSlash(relativePath)
+
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.pending == nil {
+		state.pending = make(map[string]LocalChange)
+	}
+	state.pending[relativePath] = LocalChange{
+		Path:      relativePath,
+		Operation: operation,
+		ChangedAt: time.Now(),
+	}
+	afc.writeJournal()
+}
+
+// RecordTreeChange notes a change to every file and folder below localPath, as after a folder was renamed
+func (afc *AssetFolderCache) RecordTreeChange(localPath string, operation string) {
+	if !afc.IsBidirectional() {
+		return
+	}
+	err := filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && operation == LocalChangeWrite {
+			afc.RecordChange(filePath, LocalChangeMkdir)
+		} else {
+			afc.RecordChange(filePath, operation)
+		}
+		return nil
+	})
+	CheckErr(err, "Failed to record changes below [%v]", localPath)
+}
+
+// MarkSynced sets the time the local folder last matched the cloud store
+func (afc *AssetFolderCache) MarkSynced(syncedAt time.Time) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.lastSync = syncedAt
+	state.pushedAt = nil
+}
+
+// SyncStatus reports the pending changes, the last push and pull and the recent conflicts of the folder
+func (afc *AssetFolderCache) SyncStatus() AssetFolderSyncStatus {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	syncMode := afc.SyncMode
+	if syncMode == "" {
+		syncMode = SyncModePull
+	}
+	status := AssetFolderSyncStatus{
+		CloudStore:     afc.CloudStore.Name,
+		RemotePath:     afc.RemotePath(),
+		LocalPath:      afc.LocalSyncPath,
+		SyncMode:       syncMode,
+		ConflictPolicy: afc.conflictPolicy(),
+		PendingChanges: sortedChanges(state.pending),
+		LastError:      state.lastError,
+		Conflicts:      append([]SyncConflict{}, state.conflicts...),
+	}
+	if !state.lastPull.IsZero() {
+		lastPull := state.lastPull
+		status.LastPull = &lastPull
+	}
+	if !state.lastPush.IsZero() {
+		lastPush := state.lastPush
+		status.LastPush = &lastPush
+	}
+	return status
+}
+
+func (afc *AssetFolderCache) conflictPolicy() string {
+	switch afc.ConflictPolicy {
+	case ConflictPolicyRemoteWins, ConflictPolicyKeepBoth:
+		return afc.ConflictPolicy
+	}
+	return ConflictPolicyNewestWins
+}
+
+// takePendingChanges removes the pending changes to push them
+func (afc *AssetFolderCache) takePendingChanges() []LocalChange {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	changes := sortedChanges(state.pending)
+	state.pushing = state.pending
+	state.pending = nil
+	return changes
+}
+
+// requeueChange keeps a change which failed to push, unless the path was changed again meanwhile
+func (afc *AssetFolderCache) requeueChange(change LocalChange) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.pending == nil {
+		state.pending = make(map[string]LocalChange)
+	}
+	if _, ok := state.pending[change.Path]; !ok {
+		state.pending[change.Path] = change
+	}
+	afc.writeJournal()
+}
+
+// excludePending keeps the pull from overwriting or removing the local changes not pushed yet
+func (afc *AssetFolderCache) excludePending(ctx context.Context) context.Context {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if len(state.pending) == 0 {
+		return ctx
+	}
+
+	pullFilter, err := filter.NewFilter(nil)
+	if CheckErr(err, "Failed to create filter of pending changes") {
+		return ctx
+	}
+	for changedPath := range state.pending {
+		glob := "/" + escapeGlob(changedPath)
+		CheckErr(pullFilter.Add(false, glob), "Failed to exclude [%v] from pull", changedPath)
+		CheckErr(pullFilter.Add(false, glob+"/**"), "Failed to exclude [%v] from pull", changedPath)
+	}
+	return filter.ReplaceConfig(ctx, pullFilter)
+}
+
+func (afc *AssetFolderCache) remoteChangedAt(remotePath string, modTime time.Time) bool {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.lastSync.IsZero() {
+		return false
+	}
+	baseline := state.lastSync
+	if pushedAt, ok := state.pushedAt[remotePath]; ok && pushedAt.After(baseline) {
+		baseline = pushedAt
+	}
+	return modTime.After(baseline)
+}
+
+func (afc *AssetFolderCache) markPushed(remotePath string) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.pushedAt == nil {
+		state.pushedAt = make(map[string]time.Time)
+	}
+	state.pushedAt[remotePath] = time.Now()
+}
+
+func (afc *AssetFolderCache) addConflict(conflict SyncConflict) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	log.Printf("Sync conflict on [%v] in [%v] resolved by %v: %v", conflict.Path, afc.LocalSyncPath, conflict.Policy, conflict.Resolution)
+	state.conflicts = append(state.conflicts, conflict)
+	if len(state.conflicts) > maxSyncConflicts {
+		state.conflicts = state.conflicts[len(state.conflicts)-maxSyncConflicts:]
+	}
+}
+
+func (afc *AssetFolderCache) finishPush(err error) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.lastPush = time.Now()
+	state.lastError = ""
+	if err != nil {
+		state.lastError = err.Error()
+	}
+	state.pushing = nil
+	afc.writeJournal()
+}
+
+func (afc *AssetFolderCache) finishPull(startedAt time.Time, err error) {
+	state := &afc.syncState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.lastPull = time.Now()
+	state.lastError = ""
+	if err != nil {
+		state.lastError = err.Error()
+		return
+	}
+	state.lastSync = startedAt
+	state.pushedAt = nil
+}
+
+// SyncAssetFolder runs the pull of a folder from its cloud store. Bidirectional folders first push their local
+// changes, and the changes made while pushing are left out of the pull
+func (dr *DbResource) SyncAssetFolder(ctx context.Context, afc *AssetFolderCache, pull func(ctx context.Context) error) error {
+	afc.syncState.syncLock.Lock()
+	defer afc.syncState.syncLock.Unlock()
+
+	if afc.IsBidirectional() {
+		dr.pushJournaledChanges(ctx, afc)
+		err := dr.pushLocalChanges(ctx, afc)
+		CheckErr(err, "Failed to push local changes of [%v] before pull", afc.LocalSyncPath)
+		ctx = afc.excludePending(ctx)
+	}
+
+	startedAt := time.Now()
+	err := pull(ctx)
+	afc.finishPull(startedAt, err)
+	return err
+}
+
+// PushLocalChanges writes the changes made in the local folder to the cloud store
+func (dr *DbResource) PushLocalChanges(afc *AssetFolderCache) error {
+	afc.syncState.syncLock.Lock()
+	defer afc.syncState.syncLock.Unlock()
+	return dr.pushLocalChanges(context.Background(), afc)
+}
+
+func (dr *DbResource) pushLocalChanges(ctx context.Context, afc *AssetFolderCache) error {
+
+	changes := afc.takePendingChanges()
+	if len(changes) == 0 {
+		return nil
+	}
+
+	dr.configureCloudStoreRemote(afc.CloudStore)
+	remoteFs, err := fs.NewFs(ctx, afc.RemotePath())
+	var localFs fs.Fs
+	if err == nil {
+		localFs, err = fs.NewFs(ctx, afc.LocalSyncPath)
+	}
+	if err != nil {
+		for _, change := range changes {
+			afc.requeueChange(change)
+		}
+		afc.finishPush(err)
+		return err
+	}
+
+	log.Printf("Pushing %d local changes of [%v] to [%v]", len(changes), afc.LocalSyncPath, afc.RemotePath())
+	err = dr.pushChanges(ctx, afc, localFs, remoteFs, changes)
+	afc.finishPush(err)
+	return err
+}
+
+// pushChanges creates folders and writes files before deleting, deepest paths first
+func (dr *DbResource) pushChanges(ctx context.Context, afc *AssetFolderCache, localFs fs.Fs, remoteFs fs.Fs, changes []LocalChange) error {
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		iDelete := changes[i].Operation == LocalChangeDelete
+		jDelete := changes[j].Operation == LocalChangeDelete
+		if iDelete != jDelete {
+			return jDelete
+		}
+		if iDelete {
+			return changes[i].Path > changes[j].Path
+		}
+		return changes[i].Path < changes[j].Path
+	})
+
+	var lastErr error
+	for _, change := range changes {
+		var err error
+		switch change.Operation {
+		case LocalChangeMkdir:
+			err = operations.Mkdir(ctx, remoteFs, change.Path)
+		case LocalChangeWrite:
+			err = dr.pushWrite(ctx, afc, localFs, remoteFs, change)
+		case LocalChangeDelete:
+			err = dr.pushDelete(ctx, afc, remoteFs, change)
+		}
+		if CheckErr(err, "Failed to push %v of [%v] to [%v]", change.Operation, change.Path, afc.RemotePath()) {
+			afc.requeueChange(change)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (dr *DbResource) pushWrite(ctx context.Context, afc *AssetFolderCache, localFs fs.Fs, remoteFs fs.Fs, change LocalChange) error {
+
+	localFile := filepath.Join(afc.LocalSyncPath, filepath.FromSlash(change.Path))
+	localInfo, err := os.Stat(localFile)
+	if os.IsNotExist(err) {
+		// removed again, the delete is pushed instead
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if localInfo.IsDir() {
+		return operations.Mkdir(ctx, remoteFs, change.Path)
+	}
+
+	remoteObject, err := remoteFs.NewObject(ctx, change.Path)
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return err
+	}
+	if err == nil && afc.remoteChangedAt(change.Path, remoteObject.ModTime(ctx)) {
+		conflict := SyncConflict{Path: change.Path, Policy: afc.conflictPolicy(), ResolvedAt: time.Now()}
+		switch conflict.Policy {
+		case ConflictPolicyRemoteWins:
+			conflict.Resolution = "kept the cloud store version"
+			afc.addConflict(conflict)
+			return nil
+		case ConflictPolicyKeepBoth:
+			conflictPath := conflictFileName(change.Path, conflict.ResolvedAt)
+			err = os.Rename(localFile, filepath.Join(afc.LocalSyncPath, filepath.FromSlash(conflictPath)))
+			if err != nil {
+				return err
+			}
+			conflict.Resolution = "kept the local version as " + conflictPath
+			afc.addConflict(conflict)
+			change.Path = conflictPath
+		default:
+			if !localInfo.ModTime().After(remoteObject.ModTime(ctx)) {
+				conflict.Resolution = "kept the newer cloud store version"
+				afc.addConflict(conflict)
+				return nil
+			}
+			conflict.Resolution = "pushed the newer local version"
+			afc.addConflict(conflict)
+		}
+	}
+
+	err = operations.CopyFile(ctx, remoteFs, localFs, change.Path, change.Path)
+	if err == nil {
+		afc.markPushed(change.Path)
+	}
+	return err
+}
+
+func (dr *DbResource) pushDelete(ctx context.Context, afc *AssetFolderCache, remoteFs fs.Fs, change LocalChange) error {
+
+	if _, err := os.Stat(filepath.Join(afc.LocalSyncPath, filepath.FromSlash(change.Path))); err == nil {
+		// created again, the write is pushed instead
+		return nil
+	}
+
+	remoteObject, err := remoteFs.NewObject(ctx, change.Path)
+	if err == fs.ErrorObjectNotFound || err == fs.ErrorNotAFile {
+		err = operations.Rmdir(ctx, remoteFs, change.Path)
+		if err == fs.ErrorDirNotFound {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if afc.remoteChangedAt(change.Path, remoteObject.ModTime(ctx)) {
+		conflict := SyncConflict{Path: change.Path, Policy: afc.conflictPolicy(), ResolvedAt: time.Now()}
+		if conflict.Policy != ConflictPolicyNewestWins || !change.ChangedAt.After(remoteObject.ModTime(ctx)) {
+			conflict.Resolution = "kept the file changed in the cloud store"
+			afc.addConflict(conflict)
+			return nil
+		}
+		conflict.Resolution = "deleted the file from the cloud store"
+		afc.addConflict(conflict)
+	}
+	return operations.DeleteFile(ctx, remoteObject)
+}
+
+// syncJournal keeps the changes of a folder which are not in the cloud store yet on disk. The local folders are
+// created again on every start, so the journal lets the changes made before a restart be pushed from the old
+// folder before the new folder is pulled
+type syncJournal struct {
+	CloudStore string        `json:"cloud_store"`
+	RemotePath string        `json:"remote_path"`
+	LocalPath  string        `json:"local_path"`
+	LastSync   time.Time     `json:"last_sync"`
+	Changes    []LocalChange `json:"changes"`
+}
+
+// syncJournalFolder is next to the local folders, in DAPTIN_CACHE_FOLDER or the temp directory
+func syncJournalFolder() string {
+	cacheFolder := os.Getenv("DAPTIN_CACHE_FOLDER")
+	if cacheFolder == "" {
+		cacheFolder = os.TempDir()
+	}
+	return filepath.Join(cacheFolder, "daptin-sync-journal")
+}
+
+func (afc *AssetFolderCache) journalPath() string {
+	hash := sha256.Sum256([]byte(afc.LocalSyncPath))
+	return filepath.Join(syncJournalFolder(), hex.EncodeToString(hash[:8])+".json")
+}
+
+// writeJournal stores the pending changes and the ones being pushed, the journal is removed once there are none.
+// Called with the state lock held
+func (afc *AssetFolderCache) writeJournal() {
+	state := &afc.syncState
+	journalPath := afc.journalPath()
+
+	changes := make(map[string]LocalChange, len(state.pushing)+len(state.pending))
+	for changedPath, change := range state.pushing {
+		changes[changedPath] = change
+	}
+	for changedPath, change := range state.pending {
+		changes[changedPath] = change
+	}
+	if len(changes) == 0 {
+		err := os.Remove(journalPath)
+		if err != nil && !os.IsNotExist(err) {
+			CheckErr(err, "Failed to remove sync journal of [%v]", afc.LocalSyncPath)
+		}
+		return
+	}
+
+	contents, err := json.Marshal(syncJournal{
+		CloudStore: afc.CloudStore.Name,
+		RemotePath: afc.RemotePath(),
+		LocalPath:  afc.LocalSyncPath,
+		LastSync:   state.lastSync,
+		Changes:    sortedChanges(changes),
+	})
+	if CheckErr(err, "Failed to serialize sync journal of [%v]", afc.LocalSyncPath) {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(journalPath), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(journalPath+".tmp", contents, 0600)
+	}
+	if err == nil {
+		err = os.Rename(journalPath+".tmp", journalPath)
+	}
+	CheckErr(err, "Failed to write sync journal of [%v]", afc.LocalSyncPath)
+}
+
+// pushJournaledChanges pushes the changes left in the journals of earlier folders of the same cloud store path,
+// as by a run of the server which stopped before its push. It runs once per folder, before its first pull
+func (dr *DbResource) pushJournaledChanges(ctx context.Context, afc *AssetFolderCache) {
+	afc.syncState.lock.Lock()
+	checked := afc.syncState.journalChecked
+	afc.syncState.journalChecked = true
+	afc.syncState.lock.Unlock()
+	if checked {
+		return
+	}
+
+	journalFiles, err := filepath.Glob(filepath.Join(syncJournalFolder(), "*.json"))
+	if CheckErr(err, "Failed to list sync journals") {
+		return
+	}
+	for _, journalFile := range journalFiles {
+		contents, err := ioutil.ReadFile(journalFile)
+		if CheckErr(err, "Failed to read sync journal [%v]", journalFile) {
+			continue
+		}
+		var journal syncJournal
+		err = json.Unmarshal(contents, &journal)
+		if CheckErr(err, "Failed to parse sync journal [%v]", journalFile) {
+			continue
+		}
+		if journal.CloudStore != afc.CloudStore.Name || journal.RemotePath != afc.RemotePath() ||
+			journal.LocalPath == afc.LocalSyncPath {
+			continue
+		}
+		if _, err = os.Stat(journal.LocalPath); err != nil {
+			log.Errorf("Dropping %d changes of [%v] not pushed to [%v], the folder is gone: %v",
+				len(journal.Changes), journal.LocalPath, journal.RemotePath, err)
+			CheckErr(os.Remove(journalFile), "Failed to remove sync journal [%v]", journalFile)
+			continue
+		}
+
+		previous := &AssetFolderCache{
+			LocalSyncPath:  journal.LocalPath,
+			Keyname:        afc.Keyname,
+			CloudStore:     afc.CloudStore,
+			SyncMode:       afc.SyncMode,
+			ConflictPolicy: afc.ConflictPolicy,
+		}
+		previous.syncState.lastSync = journal.LastSync
+		previous.syncState.pending = make(map[string]LocalChange, len(journal.Changes))
+		for _, change := range journal.Changes {
+			previous.syncState.pending[change.Path] = change
+		}
+		log.Printf("Pushing %d changes left in [%v] before pulling [%v]", len(journal.Changes), journal.LocalPath, afc.LocalSyncPath)
+		err = dr.pushLocalChanges(ctx, previous)
+		CheckErr(err, "Failed to push changes left in [%v], they are kept for the next start", journal.LocalPath)
+	}
+}
+
+// configureCloudStoreRemote sets the oauth token of the cloud store in the rclone config
+func (dr *DbResource) configureCloudStoreRemote(cloudStore CloudStore) {
+	if cloudStore.StoreProvider == "local" || cloudStore.OAutoTokenId == "" {
+		return
+	}
+	token, oauthConf, err := dr.Cruds["oauth_token"].GetTokenByTokenReferenceId(cloudStore.OAutoTokenId)
+	if CheckErr(err, "Failed to get oauth2 token for storage sync") {
+		return
+	}
+	jsonToken, err := json.Marshal(token)
+	CheckErr(err, "Failed to convert token to json")
+	config.FileSet(cloudStore.StoreProvider, "client_id", oauthConf.ClientID)
+	config.FileSet(cloudStore.StoreProvider, "type", cloudStore.StoreProvider)
+	config.FileSet(cloudStore.StoreProvider, "client_secret", oauthConf.ClientSecret)
+	config.FileSet(cloudStore.StoreProvider, "token", string(jsonToken))
+	config.FileSet(cloudStore.StoreProvider, "client_scopes", strings.Join(oauthConf.Scopes, ","))
+	config.FileSet(cloudStore.StoreProvider, "redirect_url", oauthConf.RedirectURL)
+}
+
+// NewCloudStoreFs opens the root path of the cloud store
+func (dr *DbResource) NewCloudStoreFs(ctx context.Context, cloudStore CloudStore) (fs.Fs, error) {
+	dr.configureCloudStoreRemote(cloudStore)
+	return fs.NewFs(ctx, cloudStore.RootPath)
+}
+
+// conflictFileName is the name a local version is kept under next to the cloud store version
+func conflictFileName(filePath string, at time.Time) string {
+	extension := path.Ext(filePath)
+	return strings.TrimSuffix(filePath, extension) + ".conflict-" + at.Format("20060102-150405") + extension
+}
+
+func sortedChanges(changes map[string]LocalChange) []LocalChange {
+	sorted := make([]LocalChange, 0, len(changes))
+	for _, change := range changes {
+		sorted = append(sorted, change)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
+
+// escapeGlob makes a path match itself in an rclone filter
+func escapeGlob(filePath string) string {
+	var escaped strings.Builder
+	for _, c := range filePath {
+		if strings.ContainsRune(`\*?[]{}`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
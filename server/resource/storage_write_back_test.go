@@ -0,0 +1,235 @@
+package resource
+
+import (
+	"context"
+	_ "github.com/artpar/rclone/backend/local"
+	"github.com/artpar/rclone/fs/config/configfile"
+	"github.com/artpar/rclone/fs/filter"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWriteBackTestFolder syncs a local folder with a "cloud store" which is another local folder
+func newWriteBackTestFolder(t *testing.T, conflictPolicy string) (*AssetFolderCache, string) {
+	configfile.LoadConfig(context.Background())
+	localPath, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	remotePath, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	cacheFolder, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	previousCacheFolder := os.Getenv("DAPTIN_CACHE_FOLDER")
+	os.Setenv("DAPTIN_CACHE_FOLDER", cacheFolder)
+	t.Cleanup(func() {
+		os.Setenv("DAPTIN_CACHE_FOLDER", previousCacheFolder)
+		os.RemoveAll(localPath)
+		os.RemoveAll(remotePath)
+		os.RemoveAll(cacheFolder)
+	})
+
+	folder := &AssetFolderCache{
+		LocalSyncPath:  localPath,
+		CloudStore:     CloudStore{Name: "test", StoreProvider: "test", RootPath: remotePath},
+		SyncMode:       SyncModeBidirectional,
+		ConflictPolicy: conflictPolicy,
+	}
+	folder.MarkSynced(time.Now().Add(-time.Hour))
+	return folder, remotePath
+}
+
+func writeTestFile(t *testing.T, filePath string, contents string, modTime time.Time) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filePath, []byte(contents), 0644)
+	}
+	if err == nil {
+		err = os.Chtimes(filePath, modTime, modTime)
+	}
+	if err != nil {
+		t.Fatalf("Failed to write [%v]: %v", filePath, err)
+	}
+}
+
+func readTestFile(filePath string) string {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return ""
+	}
+	return string(contents)
+}
+
+func TestPushLocalChanges(t *testing.T) {
+	folder, remotePath := newWriteBackTestFolder(t, ConflictPolicyNewestWins)
+	dr := &DbResource{}
+
+	localFile := filepath.Join(folder.LocalSyncPath, "posts", "hello.md")
+	writeTestFile(t, localFile, "hello", time.Now())
+	folder.RecordChange(localFile, LocalChangeWrite)
+	writeTestFile(t, filepath.Join(remotePath, "old.md"), "old", time.Now().Add(-2*time.Hour))
+	folder.RecordChange(filepath.Join(folder.LocalSyncPath, "old.md"), LocalChangeDelete)
+
+	if len(folder.SyncStatus().PendingChanges) != 2 {
+		t.Fatalf("expected 2 pending changes, found %v", folder.SyncStatus().PendingChanges)
+	}
+
+	err := dr.PushLocalChanges(folder)
+	if err != nil {
+		t.Fatalf("Failed to push changes: %v", err)
+	}
+	if readTestFile(filepath.Join(remotePath, "posts", "hello.md")) != "hello" {
+		t.Errorf("expected the local file to be written to the store")
+	}
+	if _, err = os.Stat(filepath.Join(remotePath, "old.md")); !os.IsNotExist(err) {
+		t.Errorf("expected the deleted file to be removed from the store")
+	}
+	status := folder.SyncStatus()
+	if len(status.PendingChanges) != 0 || status.LastPush == nil || status.LastError != "" {
+		t.Errorf("unexpected status after push %v", status)
+	}
+
+	// a pushed file changed again locally is not a conflict with our own write
+	writeTestFile(t, localFile, "hello again", time.Now().Add(time.Second))
+	folder.RecordChange(localFile, LocalChangeWrite)
+	err = dr.PushLocalChanges(folder)
+	if err != nil || readTestFile(filepath.Join(remotePath, "posts", "hello.md")) != "hello again" ||
+		len(folder.SyncStatus().Conflicts) != 0 {
+		t.Errorf("expected the second write to be pushed without conflict %v", err)
+	}
+}
+
+func TestPushLocalChangesConflicts(t *testing.T) {
+	dr := &DbResource{}
+
+	// the store changed the file after the last sync, and later than the local change
+	for _, test := range []struct {
+		policy       string
+		remoteResult string
+		conflictFile bool
+	}{
+		{ConflictPolicyNewestWins, "remote", false},
+		{ConflictPolicyRemoteWins, "remote", false},
+		{ConflictPolicyKeepBoth, "remote", true},
+	} {
+		folder, remotePath := newWriteBackTestFolder(t, test.policy)
+		localFile := filepath.Join(folder.LocalSyncPath, "page.md")
+		writeTestFile(t, localFile, "local", time.Now().Add(-30*time.Minute))
+		folder.RecordChange(localFile, LocalChangeWrite)
+		writeTestFile(t, filepath.Join(remotePath, "page.md"), "remote", time.Now())
+
+		err := dr.PushLocalChanges(folder)
+		if err != nil {
+			t.Fatalf("Failed to push changes with %v: %v", test.policy, err)
+		}
+		if readTestFile(filepath.Join(remotePath, "page.md")) != test.remoteResult {
+			t.Errorf("unexpected store file with %v", test.policy)
+		}
+		conflicts := folder.SyncStatus().Conflicts
+		if len(conflicts) != 1 || conflicts[0].Policy != test.policy {
+			t.Errorf("expected a conflict with %v, found %v", test.policy, conflicts)
+		}
+
+		matches, _ := filepath.Glob(filepath.Join(remotePath, "page.conflict-*.md"))
+		if test.conflictFile != (len(matches) == 1) {
+			t.Errorf("unexpected conflict copies with %v: %v", test.policy, matches)
+		}
+		if test.conflictFile && readTestFile(matches[0]) != "local" {
+			t.Errorf("expected the local version to be kept next to the store version")
+		}
+	}
+
+	// the local change is the newest
+	folder, remotePath := newWriteBackTestFolder(t, ConflictPolicyNewestWins)
+	localFile := filepath.Join(folder.LocalSyncPath, "page.md")
+	writeTestFile(t, filepath.Join(remotePath, "page.md"), "remote", time.Now().Add(-30*time.Minute))
+	writeTestFile(t, localFile, "local", time.Now())
+	folder.RecordChange(localFile, LocalChangeWrite)
+	err := dr.PushLocalChanges(folder)
+	if err != nil || readTestFile(filepath.Join(remotePath, "page.md")) != "local" {
+		t.Errorf("expected the newer local version to be pushed %v", err)
+	}
+}
+
+func TestPullExcludesPendingChanges(t *testing.T) {
+	folder, _ := newWriteBackTestFolder(t, ConflictPolicyNewestWins)
+	folder.RecordChange(filepath.Join(folder.LocalSyncPath, "draft [1].md"), LocalChangeWrite)
+	folder.RecordChange(filepath.Join(folder.LocalSyncPath, "images"), LocalChangeMkdir)
+
+	pullFilter := filter.GetConfig(folder.excludePending(context.Background()))
+	for remote, included := range map[string]bool{
+		"draft [1].md":     false,
+		"images/a.png":     false,
+		"draft 1.md":       true,
+		"posts/hello.md":   true,
+		"images-other.png": true,
+	} {
+		if pullFilter.Include(remote, 0, time.Now()) != included {
+			t.Errorf("expected [%v] included in the pull to be %v", remote, included)
+		}
+	}
+
+	folder.SyncMode = SyncModePull
+	folder.RecordChange(filepath.Join(folder.LocalSyncPath, "other.md"), LocalChangeWrite)
+	for _, change := range folder.SyncStatus().PendingChanges {
+		if strings.HasSuffix(change.Path, "other.md") {
+			t.Errorf("expected changes of a pull only folder to not be tracked")
+		}
+	}
+}
+
+func TestPushJournaledChangesAfterRestart(t *testing.T) {
+	folder, remotePath := newWriteBackTestFolder(t, ConflictPolicyNewestWins)
+	dr := &DbResource{}
+
+	localFile := filepath.Join(folder.LocalSyncPath, "posts", "draft.md")
+	writeTestFile(t, localFile, "draft", time.Now())
+	folder.RecordChange(localFile, LocalChangeWrite)
+	writeTestFile(t, filepath.Join(remotePath, "posts", "draft.md"), "old draft", time.Now().Add(-2*time.Hour))
+	if _, err := os.Stat(folder.journalPath()); err != nil {
+		t.Fatalf("expected the pending change to be journaled: %v", err)
+	}
+
+	// the server restarts before the push, the site gets a new local folder which is pulled from the store
+	restartedPath, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(restartedPath)
+	restarted := &AssetFolderCache{
+		LocalSyncPath:  restartedPath,
+		CloudStore:     folder.CloudStore,
+		SyncMode:       SyncModeBidirectional,
+		ConflictPolicy: ConflictPolicyNewestWins,
+	}
+	pulled := ""
+	err = dr.SyncAssetFolder(context.Background(), restarted, func(ctx context.Context) error {
+		pulled = readTestFile(filepath.Join(remotePath, "posts", "draft.md"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if pulled != "draft" {
+		t.Errorf("expected the change made before the restart to be pushed before the pull, pulled [%v]", pulled)
+	}
+	if _, err = os.Stat(folder.journalPath()); !os.IsNotExist(err) {
+		t.Errorf("expected the journal to be removed once pushed: %v", err)
+	}
+
+	// a push removes the journal of the folder itself
+	writeTestFile(t, localFile, "draft 2", time.Now())
+	folder.RecordChange(localFile, LocalChangeWrite)
+	err = dr.PushLocalChanges(folder)
+	if _, statErr := os.Stat(folder.journalPath()); err != nil || !os.IsNotExist(statErr) {
+		t.Errorf("expected no journal after the push %v %v", err, statErr)
+	}
+}
@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"time"
+)
+
+const TASK_EXECUTION_TABLE_NAME = "task_execution"
+
+const (
+	TaskExecutionStatusRunning = "running"
+	TaskExecutionStatusSuccess = "success"
+	TaskExecutionStatusFailed  = "failed"
+	TaskExecutionStatusSkipped = "skipped"
+)
+
+const (
+	TaskTriggerSchedule = "schedule"
+	TaskTriggerManual   = "manual"
+)
+
+// CreateTaskExecution adds a row for a run of the task in task_execution and returns its reference id.
+// Runs are owned by the admin so they can be read along with the task
+func (dr *DbResource) CreateTaskExecution(task Task, trigger string, status string) (string, error) {
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	referenceId := u.String()
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	now := time.Now()
+
+	record := goqu.Record{
+		"reference_id":         referenceId,
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"task_reference_id":    task.ReferenceId,
+		"task_name":            task.Name,
+		"action_name":          task.ActionName,
+		"status":               status,
+		"triggered_by":         trigger,
+		"started_at":           now,
+		"created_at":           now,
+	}
+	if status == TaskExecutionStatusSkipped {
+		record["ended_at"] = now
+		record["duration_ms"] = 0
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(TASK_EXECUTION_TABLE_NAME).Rows(record).ToSQL()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return "", err
+	}
+	return referenceId, nil
+}
+
+// FinishTaskExecution records the result of the action run against the task_execution row
+func (dr *DbResource) FinishTaskExecution(referenceId string, startedAt time.Time, responses []ActionResponse, failure error) error {
+
+	now := time.Now()
+	record := goqu.Record{
+		"status":      TaskExecutionStatusSuccess,
+		"ended_at":    now,
+		"duration_ms": now.Sub(startedAt).Milliseconds(),
+		"responses":   toJson(responses),
+		"updated_at":  now,
+	}
+	if failure != nil {
+		record["status"] = TaskExecutionStatusFailed
+		record["error"] = failure.Error()
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update(TASK_EXECUTION_TABLE_NAME).
+		Set(record).
+		Where(goqu.Ex{"reference_id": referenceId}).ToSQL()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
@@ -4,28 +4,46 @@ import (
 	"context"
 	"fmt"
 	"github.com/artpar/api2go"
+	"github.com/buraksezer/olric"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type Task struct {
-	Id             int64
-	ReferenceId    string
-	Schedule       string
-	Active         bool
-	Name           string
-	Attributes     map[string]interface{}
-	AsUserEmail    string
-	ActionName     string
-	EntityName     string
-	AttributesJson string
+	Id                int64
+	ReferenceId       string
+	Schedule          string
+	Active            bool
+	Name              string
+	Attributes        map[string]interface{}
+	AsUserEmail       string
+	ActionName        string
+	EntityName        string
+	AttributesJson    string
+	ConcurrencyPolicy string
 }
 
+// Concurrency policies decide what happens when a task is triggered while its previous run is still going on
+const (
+	// TaskConcurrencyAllow starts the new run alongside the running one
+	TaskConcurrencyAllow = "allow"
+	// TaskConcurrencySkip drops the new run
+	TaskConcurrencySkip = "skip"
+	// TaskConcurrencyQueue starts the new run once the running one is complete
+	TaskConcurrencyQueue = "queue"
+)
+
 type TaskScheduler interface {
 	StartTasks()
 	AddTask(task Task) error
+	UpdateTask(task Task) error
+	RemoveTask(referenceId string)
+	RunTask(referenceId string) error
+	ListenForTaskUpdates(topic *olric.DTopic) error
 	StopTasks()
 }
 
@@ -35,6 +53,12 @@ type DefaultTaskScheduler struct {
 	configStore *ConfigStore
 	cronService *cron.Cron
 	activeTasks []*ActiveTaskInstance
+	// tasks from the task table by their reference id, these can be updated and removed
+	scheduledTasks map[string]*ActiveTaskInstance
+	lock           sync.Mutex
+	// taskTopic is listened on for task edits, the listener is removed by StopTasks
+	taskTopic      *olric.DTopic
+	taskListenerId uint64
 }
 
 func NewTaskScheduler(cmsConfig *CmsConfig, cruds map[string]*DbResource, configStore *ConfigStore) TaskScheduler {
@@ -42,16 +66,59 @@ func NewTaskScheduler(cmsConfig *CmsConfig, cruds map[string]*DbResource, config
 	cronService.Start()
 	dts := &DefaultTaskScheduler{
 		//cmsConfig:   cmsConfig,
-		cruds:       cruds,
-		configStore: configStore,
-		cronService: cronService,
-		activeTasks: make([]*ActiveTaskInstance, 0),
+		cruds:          cruds,
+		configStore:    configStore,
+		cronService:    cronService,
+		activeTasks:    make([]*ActiveTaskInstance, 0),
+		scheduledTasks: make(map[string]*ActiveTaskInstance),
 	}
 	return dts
 }
 
+// StopTasks stops scheduling the tasks and listening for task edits, the scheduler replacing this one after a
+// restart schedules them instead
 func (dts *DefaultTaskScheduler) StopTasks() {
 	dts.cronService.Stop()
+
+	dts.lock.Lock()
+	defer dts.lock.Unlock()
+	if dts.taskTopic != nil {
+		err := dts.taskTopic.RemoveListener(dts.taskListenerId)
+		CheckErr(err, "Failed to remove task update listener")
+		dts.taskTopic = nil
+	}
+}
+
+// ListenForTaskUpdates reschedules tasks as they are edited on the task table, without waiting for a restart
+func (dts *DefaultTaskScheduler) ListenForTaskUpdates(topic *olric.DTopic) error {
+	listenerId, err := topic.AddListener(func(message olric.DTopicMessage) {
+		eventMessage := message.Message.(EventMessage)
+		referenceId, ok := eventMessage.EventData["reference_id"].(string)
+		if !ok {
+			return
+		}
+
+		switch eventMessage.EventType {
+		case "delete":
+			dts.RemoveTask(referenceId)
+		case "create", "update":
+			task, err := dts.cruds["task"].GetTaskByReferenceId(referenceId)
+			if CheckErr(err, "Failed to load updated task [%v]", referenceId) {
+				return
+			}
+			err = dts.UpdateTask(task)
+			CheckErr(err, "Failed to reschedule task [%v]", task.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	dts.lock.Lock()
+	defer dts.lock.Unlock()
+	dts.taskTopic = topic
+	dts.taskListenerId = listenerId
+	return nil
 }
 
 func (dts *DefaultTaskScheduler) StartTasks() {
@@ -62,6 +129,11 @@ func (dts *DefaultTaskScheduler) StartTasks() {
 	}
 	for _, cronjob := range tasks {
 
+		if !cronjob.Active {
+			log.Printf("Task [%v] is not active", cronjob.Name)
+			continue
+		}
+
 		err := dts.AddTask(cronjob)
 		if CheckErr(err, fmt.Sprintf("Failed to start scheduled job: %v", cronjob.Name)) {
 			continue
@@ -75,9 +147,46 @@ type ActiveTaskInstance struct {
 	Task          Task
 	ActionRequest ActionRequest
 	DbResource    *DbResource
+	entryId       cron.EntryID
+	running       bool
+	runningLock   sync.Mutex
+	queueLock     sync.Mutex
 }
 
+// Run is called by the cron service on every tick of the task schedule
 func (ati *ActiveTaskInstance) Run() {
+	ati.Execute(TaskTriggerSchedule)
+}
+
+// Execute runs the task action as per the concurrency policy of the task and records the run in task_execution
+func (ati *ActiveTaskInstance) Execute(trigger string) {
+
+	switch ati.Task.ConcurrencyPolicy {
+	case TaskConcurrencySkip:
+		ati.runningLock.Lock()
+		if ati.running {
+			ati.runningLock.Unlock()
+			log.Printf("Skip task [%v][%v], previous run is still going on", ati.Task.ReferenceId, ati.Task.ActionName)
+			_, err := ati.DbResource.CreateTaskExecution(ati.Task, trigger, TaskExecutionStatusSkipped)
+			CheckErr(err, "Failed to record skipped run of task [%v]", ati.Task.ActionName)
+			return
+		}
+		ati.running = true
+		ati.runningLock.Unlock()
+		defer func() {
+			ati.runningLock.Lock()
+			ati.running = false
+			ati.runningLock.Unlock()
+		}()
+	case TaskConcurrencyQueue:
+		ati.queueLock.Lock()
+		defer ati.queueLock.Unlock()
+	}
+
+	ati.execute(trigger)
+}
+
+func (ati *ActiveTaskInstance) execute(trigger string) {
 	log.Printf("Execute task 81 [%v][%v] as user [%v]", ati.Task.ReferenceId, ati.Task.ActionName, ati.Task.AsUserEmail)
 
 	sessionUser := &auth.SessionUser{}
@@ -95,6 +204,10 @@ func (ati *ActiveTaskInstance) Run() {
 		}
 	}
 
+	executionId, err := ati.DbResource.CreateTaskExecution(ati.Task, trigger, TaskExecutionStatusRunning)
+	CheckErr(err, "Failed to record run of task [%v]", ati.Task.ActionName)
+	startedAt := time.Now()
+
 	pr1 := http.Request{
 		Method: "EXECUTE",
 	}
@@ -103,7 +216,7 @@ func (ati *ActiveTaskInstance) Run() {
 	req := api2go.Request{
 		PlainRequest: pr,
 	}
-	_, err := ati.DbResource.Cruds[ati.ActionRequest.Type].HandleActionRequest(ati.ActionRequest, req)
+	responses, err := ati.DbResource.Cruds[ati.ActionRequest.Type].HandleActionRequest(ati.ActionRequest, req)
 
 	if err != nil {
 		log.Errorf("Errors while executing action 109: %v", err)
@@ -111,15 +224,79 @@ func (ati *ActiveTaskInstance) Run() {
 		//log.Printf("Response from action: %v", res)
 	}
 
+	if executionId != "" {
+		err = ati.DbResource.FinishTaskExecution(executionId, startedAt, responses, err)
+		CheckErr(err, "Failed to record result of task [%v]", ati.Task.ActionName)
+	}
+
 }
 
 func (dts *DefaultTaskScheduler) AddTask(task Task) error {
 	log.Printf("Register task [%v] at %v", task.ActionName, task.Schedule)
 	at := dts.cruds["task"].NewActiveTaskInstance(task)
-	dts.activeTasks = append(dts.activeTasks, at)
-	_, err := dts.cronService.AddJob(task.Schedule, at)
 
-	return err
+	dts.lock.Lock()
+	defer dts.lock.Unlock()
+
+	entryId, err := dts.cronService.AddJob(task.Schedule, at)
+	if err != nil {
+		return err
+	}
+	at.entryId = entryId
+
+	if task.ReferenceId == "" {
+		dts.activeTasks = append(dts.activeTasks, at)
+		return nil
+	}
+
+	if existing, ok := dts.scheduledTasks[task.ReferenceId]; ok {
+		dts.cronService.Remove(existing.entryId)
+	}
+	dts.scheduledTasks[task.ReferenceId] = at
+
+	return nil
+}
+
+// RemoveTask stops scheduling the task, a run which is already going on is not interrupted
+func (dts *DefaultTaskScheduler) RemoveTask(referenceId string) {
+	dts.lock.Lock()
+	defer dts.lock.Unlock()
+
+	at, ok := dts.scheduledTasks[referenceId]
+	if !ok {
+		return
+	}
+	log.Printf("Remove task [%v] at %v", at.Task.ActionName, at.Task.Schedule)
+	dts.cronService.Remove(at.entryId)
+	delete(dts.scheduledTasks, referenceId)
+}
+
+// UpdateTask reschedules the task with its new schedule and attributes, or removes it if it is not active anymore
+func (dts *DefaultTaskScheduler) UpdateTask(task Task) error {
+	dts.RemoveTask(task.ReferenceId)
+	if !task.Active {
+		return nil
+	}
+	return dts.AddTask(task)
+}
+
+// RunTask runs the task right away in the background, inactive tasks can also be run this way
+func (dts *DefaultTaskScheduler) RunTask(referenceId string) error {
+
+	dts.lock.Lock()
+	at, ok := dts.scheduledTasks[referenceId]
+	dts.lock.Unlock()
+
+	if !ok {
+		task, err := dts.cruds["task"].GetTaskByReferenceId(referenceId)
+		if err != nil {
+			return err
+		}
+		at = dts.cruds["task"].NewActiveTaskInstance(task)
+	}
+
+	go at.Execute(TaskTriggerManual)
+	return nil
 }
 
 func (db *DbResource) NewActiveTaskInstance(task Task) *ActiveTaskInstance {
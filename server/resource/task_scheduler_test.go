@@ -0,0 +1,212 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"testing"
+	"time"
+)
+
+func TestTaskSchedulerUpdateAndRemove(t *testing.T) {
+	cruds := map[string]*DbResource{
+		"task": {},
+	}
+	scheduler := NewTaskScheduler(nil, cruds, nil).(*DefaultTaskScheduler)
+	defer scheduler.StopTasks()
+
+	task := Task{
+		ReferenceId: "task-1",
+		Name:        "cleanup",
+		ActionName:  "cleanup",
+		EntityName:  "world",
+		Schedule:    "@every 1h",
+		Active:      true,
+	}
+
+	err := scheduler.AddTask(task)
+	if err != nil {
+		t.Fatalf("Failed to add task: %v", err)
+	}
+	if len(scheduler.cronService.Entries()) != 1 {
+		t.Errorf("expected 1 scheduled entry, found %d", len(scheduler.cronService.Entries()))
+	}
+
+	task.Schedule = "@every 2h"
+	err = scheduler.UpdateTask(task)
+	if err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if len(scheduler.cronService.Entries()) != 1 {
+		t.Errorf("expected the updated task to replace the old entry, found %d", len(scheduler.cronService.Entries()))
+	}
+	if scheduler.scheduledTasks["task-1"].Task.Schedule != "@every 2h" {
+		t.Errorf("expected the new schedule to be used")
+	}
+
+	task.Active = false
+	err = scheduler.UpdateTask(task)
+	if err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if len(scheduler.cronService.Entries()) != 0 {
+		t.Errorf("expected inactive task to be unscheduled")
+	}
+
+	task.Active = true
+	err = scheduler.AddTask(task)
+	if err != nil {
+		t.Fatalf("Failed to add task: %v", err)
+	}
+	scheduler.RemoveTask("task-1")
+	if len(scheduler.cronService.Entries()) != 0 {
+		t.Errorf("expected removed task to be unscheduled")
+	}
+
+	err = scheduler.UpdateTask(Task{ReferenceId: "task-2", Schedule: "not a schedule", Active: true})
+	if err == nil {
+		t.Errorf("expected an error for an invalid schedule")
+	}
+}
+
+// blockingActionPerformer holds every run of the action until it is released
+type blockingActionPerformer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingActionPerformer) Name() string {
+	return "test.block"
+}
+
+func (p *blockingActionPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+	p.started <- struct{}{}
+	<-p.release
+	return nil, []ActionResponse{}, nil
+}
+
+// newBlockingTaskInstance is a task running an action on the task table which blocks until it is released
+func newBlockingTaskInstance(t *testing.T, concurrencyPolicy string) (*ActiveTaskInstance, *blockingActionPerformer, *sqlx.DB) {
+	db, cruds := newStandardTestCruds(t)
+
+	actionSchema := `{"Name": "block", "InstanceOptional": true, "OutFields": [{"Type": "test.block", "Method": "EXECUTE", "Attributes": {}}]}`
+	// the task runs as the admin, who can run every action
+	addTestAdministrator(t, db)
+	for _, statement := range []string{
+		fmt.Sprintf("insert into world (id, reference_id, table_name, world_schema_json, default_permission, permission) "+
+			"values (1, 'w1', 'task', '{}', %d, %d)", auth.DEFAULT_PERMISSION, auth.DEFAULT_PERMISSION),
+		fmt.Sprintf("insert into action (id, reference_id, action_name, label, world_id, action_schema, permission) "+
+			"values (1, 'a1', 'block', 'block', 1, '%s', %d)", actionSchema, auth.DEFAULT_PERMISSION),
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to prepare database: %v", err)
+		}
+	}
+
+	performer := &blockingActionPerformer{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	cruds["task"].ActionHandlerMap = map[string]ActionPerformerInterface{
+		performer.Name(): performer,
+	}
+
+	return cruds["task"].NewActiveTaskInstance(Task{
+		ReferenceId:       "task-1",
+		Name:              "block",
+		ActionName:        "block",
+		EntityName:        "task",
+		AsUserEmail:       "admin@example.com",
+		ConcurrencyPolicy: concurrencyPolicy,
+	}), performer, db
+}
+
+// taskExecutions are the trigger and status of the recorded runs of the task, in the order they started
+func taskExecutions(t *testing.T, db *sqlx.DB) []string {
+	rows, err := db.Queryx("select triggered_by, status from task_execution order by id")
+	if err != nil {
+		t.Fatalf("Failed to query task executions: %v", err)
+	}
+	defer rows.Close()
+
+	executions := make([]string, 0)
+	for rows.Next() {
+		var trigger, status string
+		err = rows.Scan(&trigger, &status)
+		if err != nil {
+			t.Fatalf("Failed to scan task execution: %v", err)
+		}
+		executions = append(executions, trigger+":"+status)
+	}
+	return executions
+}
+
+func TestTaskConcurrencySkip(t *testing.T) {
+	at, performer, db := newBlockingTaskInstance(t, TaskConcurrencySkip)
+
+	done := make(chan struct{})
+	go func() {
+		at.Execute(TaskTriggerSchedule)
+		close(done)
+	}()
+	<-performer.started
+
+	// returns right away, the first run is still going on
+	at.Execute(TaskTriggerManual)
+
+	performer.release <- struct{}{}
+	<-done
+
+	// a run after the first one is complete is not skipped
+	done = make(chan struct{})
+	go func() {
+		at.Execute(TaskTriggerManual)
+		close(done)
+	}()
+	<-performer.started
+	performer.release <- struct{}{}
+	<-done
+
+	executions := fmt.Sprintf("%v", taskExecutions(t, db))
+	if executions != "[schedule:success manual:skipped manual:success]" {
+		t.Errorf("expected only the run started during the first one to be skipped, got %v", executions)
+	}
+}
+
+func TestTaskConcurrencyQueue(t *testing.T) {
+	at, performer, db := newBlockingTaskInstance(t, TaskConcurrencyQueue)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		at.Execute(TaskTriggerSchedule)
+		done <- struct{}{}
+	}()
+	<-performer.started
+
+	go func() {
+		at.Execute(TaskTriggerManual)
+		done <- struct{}{}
+	}()
+	select {
+	case <-performer.started:
+		t.Fatalf("expected the second run to wait for the first one")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	performer.release <- struct{}{}
+	select {
+	case <-performer.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the second run to start after the first one")
+	}
+	performer.release <- struct{}{}
+	<-done
+	<-done
+
+	executions := fmt.Sprintf("%v", taskExecutions(t, db))
+	if executions != "[schedule:success manual:success]" {
+		t.Errorf("expected both runs to complete one after the other, got %v", executions)
+	}
+}
@@ -0,0 +1,498 @@
+package resource
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/artpar/go.uuid"
+	"github.com/artpar/ydb"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DOCUMENT_UPDATE_TABLE_NAME = "document_update"
+const DOCUMENT_SNAPSHOT_TABLE_NAME = "document_snapshot"
+
+// yjsStateFileType is the type of the file entry holding the yjs state next to the edited file in a file column
+const yjsStateFileType = "x-crdt/yjs"
+
+// YjsDocument is the row and file column a collaboratively edited document belongs to, the document name
+// is "<table>.<reference id>.<column>"
+type YjsDocument struct {
+	TypeName    string
+	ReferenceId string
+	ColumnName  string
+}
+
+// ParseYjsDocumentName splits a document name into the table, reference id and column it is stored in
+func ParseYjsDocumentName(documentName string) (YjsDocument, error) {
+	parts := strings.Split(documentName, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return YjsDocument{}, fmt.Errorf("invalid document name [%v]", documentName)
+	}
+	return YjsDocument{
+		TypeName:    parts[0],
+		ReferenceId: parts[1],
+		ColumnName:  parts[2],
+	}, nil
+}
+
+func (d YjsDocument) Name() string {
+	return fmt.Sprintf("%v.%v.%v", d.TypeName, d.ReferenceId, d.ColumnName)
+}
+
+// DocumentSnapshot is a saved version of a document listed in its history
+type DocumentSnapshot struct {
+	ReferenceId string `db:"reference_id" json:"reference_id"`
+	Size        int64  `db:"size" json:"size"`
+	CreatedAt   string `db:"created_at" json:"created_at"`
+}
+
+// YjsDocumentStore persists the documents served by ydb. ydb keeps the updates of a document appended to a
+// file in the temp directory, the store copies the bytes appended since the last pass into document_update
+// and regularly writes the complete state as a document_snapshot, which replaces the updates before it.
+// Documents opened after a restart start from the latest snapshot and the updates after it
+type YjsDocumentStore struct {
+	cruds            map[string]*DbResource
+	provider         ydb.DocumentProvider
+	snapshotInterval time.Duration
+	// syncLock is held while the state of a document is read and written, documentsLock only guards the map
+	// since GetDocumentInitialContent is called by the provider while it holds its own lock
+	syncLock      sync.Mutex
+	documentsLock sync.Mutex
+	documents     map[string]*yjsDocumentState
+	// stop ends the loop started by Start, stopped is closed once it has returned
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+type yjsDocumentState struct {
+	persisted  int
+	snapshot   int
+	snapshotAt time.Time
+}
+
+func NewYjsDocumentStore(cruds map[string]*DbResource, snapshotInterval time.Duration) *YjsDocumentStore {
+	return &YjsDocumentStore{
+		cruds:            cruds,
+		snapshotInterval: snapshotInterval,
+		documents:        make(map[string]*yjsDocumentState),
+	}
+}
+
+// SetDocumentProvider is the provider serving the documents, it is created with the store as its listener
+func (s *YjsDocumentStore) SetDocumentProvider(provider ydb.DocumentProvider) {
+	s.provider = provider
+}
+
+// DocumentListener loads the initial content of documents from the store
+func (s *YjsDocumentStore) DocumentListener() ydb.DocumentListener {
+	return ydb.DocumentListener{
+		GetDocumentInitialContent: s.GetDocumentInitialContent,
+	}
+}
+
+// GetDocumentInitialContent is the stored state of a document. Documents without a stored state start from
+// the yjs file entry of their column, which is persisted on the next pass
+func (s *YjsDocumentStore) GetDocumentInitialContent(documentName string) []byte {
+	log.Printf("Get initial content for document: %v", documentName)
+
+	state, snapshotSize, err := s.cruds[DOCUMENT_SNAPSHOT_TABLE_NAME].GetYjsDocumentState(documentName)
+	CheckErr(err, "Failed to load stored state of document [%v]", documentName)
+	persisted := len(state)
+
+	if len(state) == 0 {
+		document, err := ParseYjsDocumentName(documentName)
+		if err == nil && s.cruds[document.TypeName] != nil {
+			state = s.cruds[document.TypeName].GetYjsColumnState(document)
+		}
+	}
+
+	s.documentsLock.Lock()
+	s.documents[documentName] = &yjsDocumentState{
+		persisted:  persisted,
+		snapshot:   snapshotSize,
+		snapshotAt: time.Now(),
+	}
+	s.documentsLock.Unlock()
+
+	return state
+}
+
+// Start persists the open documents every interval until Stop is called
+func (s *YjsDocumentStore) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(s.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Sync()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the loop started by Start and persists the open documents one last time, before the database is
+// closed on a restart
+func (s *YjsDocumentStore) Stop() {
+	if s.stop == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.stopped
+		s.Sync()
+	})
+}
+
+// Sync persists the updates of every open document and snapshots the ones due
+func (s *YjsDocumentStore) Sync() {
+	s.documentsLock.Lock()
+	names := make([]string, 0, len(s.documents))
+	for name := range s.documents {
+		names = append(names, name)
+	}
+	s.documentsLock.Unlock()
+
+	for _, name := range names {
+		err := s.persist(name, false)
+		CheckErr(err, "Failed to persist document [%v]", name)
+	}
+}
+
+// Snapshot persists the document and saves its current state as a new version
+func (s *YjsDocumentStore) Snapshot(documentName string) error {
+	return s.persist(documentName, true)
+}
+
+func (s *YjsDocumentStore) persist(documentName string, forceSnapshot bool) error {
+	s.syncLock.Lock()
+	defer s.syncLock.Unlock()
+
+	contents := s.provider.GetDocument(ydb.YjsRoomName(documentName)).GetInitialContentBytes()
+	state := s.documentState(documentName)
+
+	if len(contents) < state.persisted {
+		// the file was replaced, the stored updates do not lead to it anymore
+		return s.snapshot(documentName, contents)
+	}
+
+	if len(contents) > state.persisted {
+		err := s.cruds[DOCUMENT_UPDATE_TABLE_NAME].InsertYjsDocumentUpdate(documentName, state.persisted, contents[state.persisted:])
+		if err != nil {
+			return err
+		}
+		s.updateDocumentState(documentName, func(state *yjsDocumentState) {
+			state.persisted = len(contents)
+		})
+	}
+
+	if len(contents) > state.snapshot && (forceSnapshot || time.Since(state.snapshotAt) >= s.snapshotInterval) {
+		return s.snapshot(documentName, contents)
+	}
+	return nil
+}
+
+// Restore replaces the document with a version from its history. The restored version is saved as the
+// newest version, clients connected to the document have to reconnect to load it
+func (s *YjsDocumentStore) Restore(documentName string, snapshotReferenceId string) error {
+	contents, err := s.cruds[DOCUMENT_SNAPSHOT_TABLE_NAME].GetYjsDocumentSnapshot(documentName, snapshotReferenceId)
+	if err != nil {
+		return err
+	}
+
+	s.syncLock.Lock()
+	defer s.syncLock.Unlock()
+
+	s.provider.GetDocument(ydb.YjsRoomName(documentName)).SetInitialContent(contents)
+	return s.snapshot(documentName, contents)
+}
+
+// RefreshFromColumn loads the yjs file entry of the column into the document after the row was updated.
+// Entries the document already contains, as written by the yjs middleware, are ignored
+func (s *YjsDocumentStore) RefreshFromColumn(document YjsDocument) error {
+	contents := s.cruds[document.TypeName].GetYjsColumnState(document)
+	if len(contents) == 0 {
+		return nil
+	}
+
+	s.syncLock.Lock()
+	defer s.syncLock.Unlock()
+
+	ydbDocument := s.provider.GetDocument(ydb.YjsRoomName(document.Name()))
+	if bytes.HasPrefix(ydbDocument.GetInitialContentBytes(), contents) {
+		return nil
+	}
+	ydbDocument.SetInitialContent(contents)
+	return s.snapshot(document.Name(), contents)
+}
+
+func (s *YjsDocumentStore) snapshot(documentName string, contents []byte) error {
+	err := s.cruds[DOCUMENT_SNAPSHOT_TABLE_NAME].InsertYjsDocumentSnapshot(documentName, contents)
+	if err != nil {
+		return err
+	}
+	s.updateDocumentState(documentName, func(state *yjsDocumentState) {
+		state.persisted = len(contents)
+		state.snapshot = len(contents)
+		state.snapshotAt = time.Now()
+	})
+	return nil
+}
+
+func (s *YjsDocumentStore) documentState(documentName string) yjsDocumentState {
+	s.documentsLock.Lock()
+	defer s.documentsLock.Unlock()
+	state, ok := s.documents[documentName]
+	if !ok {
+		return yjsDocumentState{snapshotAt: time.Now()}
+	}
+	return *state
+}
+
+func (s *YjsDocumentStore) updateDocumentState(documentName string, update func(state *yjsDocumentState)) {
+	s.documentsLock.Lock()
+	defer s.documentsLock.Unlock()
+	state, ok := s.documents[documentName]
+	if !ok {
+		state = &yjsDocumentState{}
+		s.documents[documentName] = state
+	}
+	update(state)
+}
+
+// GetYjsColumnState is the yjs state stored as a file entry of the column of the row
+func (dr *DbResource) GetYjsColumnState(document YjsDocument) []byte {
+
+	object, _, err := dr.GetSingleRowByReferenceId(document.TypeName, document.ReferenceId, map[string]bool{
+		document.ColumnName: true,
+	})
+	if err != nil {
+		return []byte{}
+	}
+
+	columnValueArray, ok := object[document.ColumnName].([]map[string]interface{})
+	if !ok {
+		return []byte{}
+	}
+
+	state := []byte{}
+	for _, file := range columnValueArray {
+		if file["type"] != yjsStateFileType {
+			continue
+		}
+		contents, _ := file["contents"].(string)
+		// the yjs middleware stores the contents as a data url
+		contents = strings.TrimPrefix(contents, yjsStateFileType+",")
+		state, _ = base64.StdEncoding.DecodeString(contents)
+	}
+	return state
+}
+
+// GetYjsDocumentState is the latest snapshot of the document followed by the updates persisted after it,
+// with the size of the snapshot
+func (dr *DbResource) GetYjsDocumentState(documentName string) ([]byte, int, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("contents").From(DOCUMENT_SNAPSHOT_TABLE_NAME).
+		Where(goqu.Ex{"document_name": documentName}).Order(goqu.C("id").Desc()).Limit(1).ToSQL()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var snapshot string
+	err = dr.queryRow(query, args, &snapshot)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, 0, err
+	}
+	state, err := base64.StdEncoding.DecodeString(snapshot)
+	if err != nil {
+		return nil, 0, err
+	}
+	snapshotSize := len(state)
+
+	query, args, err = statementbuilder.Squirrel.Select("update_offset", "contents").From(DOCUMENT_UPDATE_TABLE_NAME).
+		Where(goqu.Ex{
+			"document_name": documentName,
+			"update_offset": goqu.Op{"gte": snapshotSize},
+		}).Order(goqu.C("update_offset").Asc()).ToSQL()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var offset int
+		var contents string
+		err = rows.Scan(&offset, &contents)
+		if err != nil {
+			return nil, 0, err
+		}
+		if offset != len(state) {
+			log.Warnf("Document [%v] has no update at offset %v, ignoring the updates after it", documentName, len(state))
+			break
+		}
+		update, err := base64.StdEncoding.DecodeString(contents)
+		if err != nil {
+			return nil, 0, err
+		}
+		state = append(state, update...)
+	}
+
+	return state, snapshotSize, nil
+}
+
+// InsertYjsDocumentUpdate stores the bytes appended to the document at offset
+func (dr *DbResource) InsertYjsDocumentUpdate(documentName string, offset int, update []byte) error {
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	u, _ := uuid.NewV4()
+	query, args, err := statementbuilder.Squirrel.Insert(DOCUMENT_UPDATE_TABLE_NAME).Rows(goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"document_name":        documentName,
+		"update_offset":        offset,
+		"contents":             base64.StdEncoding.EncodeToString(update),
+		"created_at":           time.Now(),
+	}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// InsertYjsDocumentSnapshot saves the complete state of the document as a new version and removes the
+// updates it contains
+func (dr *DbResource) InsertYjsDocumentSnapshot(documentName string, contents []byte) error {
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	u, _ := uuid.NewV4()
+	query, args, err := statementbuilder.Squirrel.Insert(DOCUMENT_SNAPSHOT_TABLE_NAME).Rows(goqu.Record{
+		"reference_id":         u.String(),
+		"permission":           auth.DEFAULT_PERMISSION,
+		USER_ACCOUNT_ID_COLUMN: adminUserId,
+		"document_name":        documentName,
+		"size":                 len(contents),
+		"contents":             base64.StdEncoding.EncodeToString(contents),
+		"created_at":           time.Now(),
+	}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	query, args, err = statementbuilder.Squirrel.Delete(DOCUMENT_UPDATE_TABLE_NAME).
+		Where(goqu.Ex{"document_name": documentName}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// ListYjsDocumentSnapshots is the history of the document, newest version first
+func (dr *DbResource) ListYjsDocumentSnapshots(documentName string) ([]DocumentSnapshot, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("reference_id", "size", "created_at").
+		From(DOCUMENT_SNAPSHOT_TABLE_NAME).Where(goqu.Ex{"document_name": documentName}).
+		Order(goqu.C("id").Desc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	rows, err := stmt1.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make([]DocumentSnapshot, 0)
+	for rows.Next() {
+		var snapshot DocumentSnapshot
+		err = rows.StructScan(&snapshot)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// GetYjsDocumentSnapshot is the state saved in a version of the document
+func (dr *DbResource) GetYjsDocumentSnapshot(documentName string, snapshotReferenceId string) ([]byte, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("contents").From(DOCUMENT_SNAPSHOT_TABLE_NAME).
+		Where(goqu.Ex{
+			"document_name": documentName,
+			"reference_id":  snapshotReferenceId,
+		}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var contents string
+	err = dr.queryRow(query, args, &contents)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(contents)
+}
+
+func (dr *DbResource) queryRow(query string, args []interface{}, dest ...interface{}) error {
+	stmt1, err := dr.connection.Preparex(query)
+	if err != nil {
+		return err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+	return stmt1.QueryRowx(args...).Scan(dest...)
+}
@@ -0,0 +1,190 @@
+package resource
+
+import (
+	"github.com/artpar/ydb"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newYjsDocumentTestStore(t *testing.T, db *sqlx.DB) (*YjsDocumentStore, string) {
+	dir, err := ioutil.TempDir("", "yjs")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	cruds := make(map[string]*DbResource)
+	for _, tableName := range []string{DOCUMENT_UPDATE_TABLE_NAME, DOCUMENT_SNAPSHOT_TABLE_NAME} {
+		cruds[tableName] = &DbResource{
+			db:           db,
+			connection:   db,
+			Cruds:        cruds,
+			contextCache: make(map[string]interface{}),
+			contextLock:  &sync.RWMutex{},
+		}
+	}
+
+	store := NewYjsDocumentStore(cruds, time.Hour)
+	store.SetDocumentProvider(ydb.NewDiskDocumentProvider(dir, 10, store.DocumentListener()))
+	return store, dir
+}
+
+func newYjsDocumentTestDb(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"create table document_update (id integer primary key, reference_id varchar(40), permission int, " +
+			"user_account_id int, document_name varchar(200), update_offset int, contents text, created_at timestamp)",
+		"create table document_snapshot (id integer primary key, reference_id varchar(40), permission int, " +
+			"user_account_id int, document_name varchar(200), size int, contents text, created_at timestamp)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+	return db
+}
+
+// appendYjsUpdate appends to the document file the way the ydb writer does
+func appendYjsUpdate(t *testing.T, dir string, documentName string, update string) {
+	f, err := os.OpenFile(filepath.Join(dir, documentName), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open document file: %v", err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(update)
+	if err != nil {
+		t.Fatalf("Failed to write document file: %v", err)
+	}
+}
+
+func TestParseYjsDocumentName(t *testing.T) {
+	document, err := ParseYjsDocumentName("page.3c5e4a.content")
+	if err != nil || document.TypeName != "page" || document.ReferenceId != "3c5e4a" || document.ColumnName != "content" {
+		t.Errorf("unexpected document %v %v", document, err)
+	}
+	if document.Name() != "page.3c5e4a.content" {
+		t.Errorf("unexpected document name %v", document.Name())
+	}
+
+	for _, name := range []string{"page", "page.3c5e4a", "page..content", "page.3c5e4a.content.yjs"} {
+		_, err = ParseYjsDocumentName(name)
+		if err == nil {
+			t.Errorf("expected [%v] to be invalid", name)
+		}
+	}
+}
+
+func TestYjsDocumentStore(t *testing.T) {
+
+	db := newYjsDocumentTestDb(t)
+	store, dir := newYjsDocumentTestStore(t, db)
+	dr := store.cruds[DOCUMENT_SNAPSHOT_TABLE_NAME]
+	documentName := "page.3c5e4a.content"
+
+	document := store.provider.GetDocument(ydb.YjsRoomName(documentName))
+	if len(document.GetInitialContentBytes()) != 0 {
+		t.Fatalf("expected a new document to be empty")
+	}
+
+	appendYjsUpdate(t, dir, documentName, "ab")
+	store.Sync()
+	appendYjsUpdate(t, dir, documentName, "cd")
+	store.Sync()
+	store.Sync()
+
+	state, snapshotSize, err := dr.GetYjsDocumentState(documentName)
+	if err != nil || string(state) != "abcd" || snapshotSize != 0 {
+		t.Fatalf("unexpected state [%s] %v %v", state, snapshotSize, err)
+	}
+
+	err = store.Snapshot(documentName)
+	if err != nil {
+		t.Fatalf("Failed to snapshot document: %v", err)
+	}
+	var updateCount int
+	err = db.QueryRowx("select count(*) from document_update").Scan(&updateCount)
+	if err != nil || updateCount != 0 {
+		t.Errorf("expected the snapshot to replace the updates, found %v %v", updateCount, err)
+	}
+
+	appendYjsUpdate(t, dir, documentName, "ef")
+	store.Sync()
+	state, snapshotSize, err = dr.GetYjsDocumentState(documentName)
+	if err != nil || string(state) != "abcdef" || snapshotSize != 4 {
+		t.Fatalf("unexpected state [%s] %v %v", state, snapshotSize, err)
+	}
+
+	err = store.Snapshot(documentName)
+	if err != nil {
+		t.Fatalf("Failed to snapshot document: %v", err)
+	}
+	snapshots, err := dr.ListYjsDocumentSnapshots(documentName)
+	if err != nil || len(snapshots) != 2 || snapshots[0].Size != 6 || snapshots[1].Size != 4 {
+		t.Fatalf("unexpected snapshots %v %v", snapshots, err)
+	}
+
+	err = store.Restore(documentName, snapshots[1].ReferenceId)
+	if err != nil {
+		t.Fatalf("Failed to restore document: %v", err)
+	}
+	if string(document.GetInitialContentBytes()) != "abcd" {
+		t.Errorf("unexpected restored document [%s]", document.GetInitialContentBytes())
+	}
+	snapshots, err = dr.ListYjsDocumentSnapshots(documentName)
+	if err != nil || len(snapshots) != 3 || snapshots[0].Size != 4 {
+		t.Errorf("expected the restore to be the newest version %v %v", snapshots, err)
+	}
+
+	err = store.Restore(documentName, "missing")
+	if err == nil {
+		t.Errorf("expected restoring an unknown snapshot to fail")
+	}
+
+	// the next run of the server starts from the stored state
+	appendYjsUpdate(t, dir, documentName, "gh")
+	store.Sync()
+	restarted, _ := newYjsDocumentTestStore(t, db)
+	contents := restarted.provider.GetDocument(ydb.YjsRoomName(documentName)).GetInitialContentBytes()
+	if string(contents) != "abcdgh" {
+		t.Errorf("unexpected document after restart [%s]", contents)
+	}
+}
+
+func TestYjsDocumentStoreStop(t *testing.T) {
+
+	db := newYjsDocumentTestDb(t)
+	store, dir := newYjsDocumentTestStore(t, db)
+	documentName := "page.3c5e4a.content"
+	store.provider.GetDocument(ydb.YjsRoomName(documentName))
+
+	// stopping a store which was never started does nothing
+	store.Stop()
+
+	store.Start(time.Hour)
+	appendYjsUpdate(t, dir, documentName, "ab")
+	store.Stop()
+	store.Stop()
+
+	select {
+	case <-store.stopped:
+	default:
+		t.Errorf("expected the persist loop to have returned")
+	}
+	state, _, err := store.cruds[DOCUMENT_SNAPSHOT_TABLE_NAME].GetYjsDocumentState(documentName)
+	if err != nil || string(state) != "ab" {
+		t.Errorf("expected stop to persist the open documents, got [%s] %v", state, err)
+	}
+}
@@ -2,12 +2,12 @@ package server
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"github.com/artpar/rclone/fs/config/configfile"
 	"github.com/buraksezer/olric"
 	"github.com/sadlil/go-trigger"
 	"os"
+	"path/filepath"
 	"strings"
 	//"sync"
 	"time"
@@ -44,8 +44,8 @@ var TaskScheduler resource.TaskScheduler
 var Stats = stats.New()
 
 func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStoragePath string, olricDb *olric.Olric) (
-	HostSwitch, *guerrilla.Daemon, resource.TaskScheduler, *resource.ConfigStore, *resource.CertificateManager,
-	*server2.FtpServer, *server.Server, *olric.Olric) {
+	*HostSwitch, *guerrilla.Daemon, resource.TaskScheduler, *resource.YjsDocumentStore, *resource.OutboxDeliveryWorker, *resource.ConfigStore, *resource.CertificateManager,
+	*server2.FtpServer, *DaptinSftpServer, *server.Server, *olric.Olric) {
 
 	fmt.Print(`                                                                           
                               
@@ -212,13 +212,12 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		c.AbortWithStatus(429) // handle exceed rate limit request
 	}))
 
-	jwtSecret, err := configStore.GetConfigValueFor("jwt.secret", "backend")
+	_, err = configStore.GetConfigValueFor("jwt.secret", "backend")
 	if err != nil {
 		u, _ := uuid.NewV4()
 		newSecret := u.String()
 		err = configStore.SetConfigValueFor("jwt.secret", newSecret, "backend")
 		resource.CheckErr(err, "Failed to store secret in database")
-		jwtSecret = newSecret
 	}
 
 	enableGraphql, err := configStore.GetConfigValueFor("graphql.enable", "backend")
@@ -244,7 +243,16 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		err = configStore.SetConfigValueFor("jwt.token.issuer", jwtTokenIssuer, "backend")
 	}
 	authMiddleware := auth.NewAuthMiddlewareBuilder(db, jwtTokenIssuer, olricDb)
-	auth.InitJwtMiddleware([]byte(jwtSecret), jwtTokenIssuer, olricDb)
+	jwtSigningKeys, err := resource.LoadJwtSigningKeys(configStore)
+	if err != nil {
+		log.Fatalf("Failed to load jwt signing keys: %v", err)
+	}
+	auth.InitJwtMiddleware(jwtSigningKeys, jwtTokenIssuer, olricDb)
+	err = resource.LoadRevokedTokens(db)
+	resource.CheckErr(err, "Failed to load revoked tokens")
+	defaultRouter.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(200, jwtSigningKeys.Jwks())
+	})
 	defaultRouter.Use(authMiddleware.AuthCheckMiddleware)
 
 	cruds := make(map[string]*resource.DbResource)
@@ -264,38 +272,38 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		configStore.SetConfigValueFor("yjs.temp.path", yjs_temp_directory, "backend")
 	}
 
-	documentProvider := ydb.NewDiskDocumentProvider(yjs_temp_directory, 10000, ydb.DocumentListener{
-		GetDocumentInitialContent: func(documentPath string) []byte {
-			log.Printf("Get initial content for document: %v", documentPath)
-			pathParts := strings.Split(documentPath, ".")
-			typeName := pathParts[0]
-			referenceId := pathParts[1]
-			columnName := pathParts[2]
-
-			object, _, _ := cruds[typeName].GetSingleRowByReferenceId(typeName, referenceId, map[string]bool{
-				columnName: true,
-			})
-
-			originalFile := object[columnName]
-			if originalFile == nil {
-				return []byte{}
-			}
-			columnValueArray := originalFile.([]map[string]interface{})
+	yjsSnapshotInterval, err := configStore.GetConfigIntValueFor("yjs.snapshot.interval", "backend")
+	if err != nil {
+		yjsSnapshotInterval = 600
+		_ = configStore.SetConfigIntValueFor("yjs.snapshot.interval", yjsSnapshotInterval, "backend")
+	}
 
-			fileContentsJson := []byte{}
-			for _, file := range columnValueArray {
-				if file["type"] != "x-crdt/yjs" {
-					continue
-				}
+	yjsPersistInterval, err := configStore.GetConfigIntValueFor("yjs.persist.interval", "backend")
+	if err != nil {
+		yjsPersistInterval = 5
+		_ = configStore.SetConfigIntValueFor("yjs.persist.interval", yjsPersistInterval, "backend")
+	}
 
-				fileContentsJson, _ = base64.StdEncoding.DecodeString(file["contents"].(string))
+	documentStore := resource.NewYjsDocumentStore(cruds, time.Duration(yjsSnapshotInterval)*time.Second)
+	documentProvider := ydb.NewDiskDocumentProvider(yjs_temp_directory, 10000, documentStore.DocumentListener())
+	documentStore.SetDocumentProvider(documentProvider)
+	documentStore.Start(time.Duration(yjsPersistInterval) * time.Second)
 
-			}
+	uploadDirectory, err := configStore.GetConfigValueFor("upload.path", "backend")
+	if err != nil {
+		cacheFolder := os.Getenv("DAPTIN_CACHE_FOLDER")
+		if cacheFolder == "" {
+			cacheFolder = os.TempDir()
+		}
+		uploadDirectory = filepath.Join(cacheFolder, "daptin-uploads")
+		_ = configStore.SetConfigValueFor("upload.path", uploadDirectory, "backend")
+	}
 
-			return fileContentsJson
-		},
-		SetDocumentInitialContent: nil,
-	})
+	uploadExpiry, err := configStore.GetConfigIntValueFor("upload.expiry", "backend")
+	if err != nil {
+		uploadExpiry = 86400
+		_ = configStore.SetConfigIntValueFor("upload.expiry", uploadExpiry, "backend")
+	}
 
 	ms := BuildMiddlewareSet(&initConfig, &cruds, documentProvider, &dtopicMap)
 	AddResourcesToApi2Go(api, initConfig.Tables, db, &ms, configStore, olricDb, cruds)
@@ -305,6 +313,9 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		err = nil
 	}
 
+	err = resource.InitLoginAttemptTracker(configStore, cruds, olricDb)
+	resource.CheckErr(err, "Failed to initialise login attempt tracker")
+
 	rcloneRetries, err := configStore.GetConfigIntValueFor("rclone.retries", "backend")
 	if err != nil {
 		rcloneRetries = 5
@@ -318,7 +329,10 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	AddStreamsToApi2Go(api, streamProcessors, db, &ms, configStore)
 	feedHandler := CreateFeedHandler(cruds, streamProcessors)
 
-	mailDaemon, err := StartSMTPMailServer(cruds["mail"], certificateManager, hostname)
+	outboxWorker := resource.NewOutboxDeliveryWorker(cruds, configStore)
+	outboxWorker.Start()
+
+	mailDaemon, err := StartSMTPMailServer(cruds["mail"], certificateManager, hostname, outboxWorker)
 
 	if err == nil {
 		err = mailDaemon.Start()
@@ -353,7 +367,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		imapServer.Addr = imapListenInterface
 		imapServer.Debug = nil
 		imapServer.AllowInsecureAuth = false
-		imapServer.Enable(idle.NewExtension())
+		imapServer.Enable(idle.NewExtension(), resource.NewImapMoveExtension(), resource.NewImapCondStoreExtension())
 		//imapServer.Debug = os.Stdout
 		//imapServer.EnableAuth("CRAM-MD5", func(conn server.Conn) sasl.Server {
 		//
@@ -391,16 +405,16 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	TaskScheduler = resource.NewTaskScheduler(&initConfig, cruds, configStore)
 
-	hostSwitch, subsiteCacheFolders := CreateSubSites(&initConfig, db, cruds, authMiddleware, configStore)
-
+	subsiteCacheFolders := resource.NewSiteFolderCache()
 	for k := range cruds {
 		cruds[k].SubsiteFolderCache = subsiteCacheFolders
 	}
 
-	hostSwitch.handlerMap["api"] = defaultRouter
-	hostSwitch.handlerMap["dashboard"] = defaultRouter
+	hostSwitch := CreateSubSites(&initConfig, db, cruds, authMiddleware, configStore, certificateManager)
+	hostSwitch.SetHandler("api", defaultRouter)
+	hostSwitch.SetHandler("dashboard", defaultRouter)
 
-	actionPerformers := GetActionPerformers(&initConfig, configStore, cruds, mailDaemon, hostSwitch, certificateManager)
+	actionPerformers := GetActionPerformers(&initConfig, configStore, cruds, mailDaemon, hostSwitch, certificateManager, outboxWorker)
 	initConfig.ActionPerformers = actionPerformers
 
 	// todo : move this somewhere and make it part of something
@@ -432,17 +446,45 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	TaskScheduler.StartTasks()
 
+	// reschedule tasks as they are edited, without waiting for a restart
+	if taskTopic, ok := dtopicMap["task"]; ok {
+		err = TaskScheduler.ListenForTaskUpdates(taskTopic)
+		resource.CheckErr(err, "Failed to listen for task updates")
+	}
+
 	assetColumnFolders := CreateAssetColumnSync(cruds)
 	for k := range cruds {
 		cruds[k].AssetFolderCache = assetColumnFolders
 	}
 
+	storagePushInterval, err := configStore.GetConfigIntValueFor("storage.push.interval", "backend")
+	if err != nil {
+		storagePushInterval = 60
+		_ = configStore.SetConfigIntValueFor("storage.push.interval", storagePushInterval, "backend")
+	}
+	hostSwitch.StartStorageWriteBack(time.Duration(storagePushInterval) * time.Second)
+
 	authMiddleware.SetUserCrud(cruds[resource.USER_ACCOUNT_TABLE_NAME])
 	authMiddleware.SetUserGroupCrud(cruds["usergroup"])
 	authMiddleware.SetUserUserGroupCrud(cruds["user_account_user_account_id_has_usergroup_usergroup_id"])
 
 	fsmManager := resource.NewFsmManager(db, cruds)
 
+	_, err = configStore.GetConfigIntValueFor("ftp.upload.quota", "backend")
+	if err != nil {
+		// bytes a user can upload in 24 hours over ftp, sftp and webdav, 0 for no limit
+		err = configStore.SetConfigIntValueFor("ftp.upload.quota", 0, "backend")
+		resource.CheckErr(err, "Failed to store default value for ftp.upload.quota")
+	}
+
+	virtualFs, err := NewVirtualFs(cruds, configStore)
+	resource.CheckErr(err, "Failed to load sites and cloud stores for ftp, sftp and webdav")
+	hostSwitch.SetVirtualFs(virtualFs)
+
+	// host new, changed and removed sites without waiting for a restart
+	err = hostSwitch.ListenForChanges(dtopicMap)
+	resource.CheckErr(err, "Failed to listen for site updates")
+
 	enableFtp, err := configStore.GetConfigValueFor("ftp.enable", "backend")
 	if err != nil {
 		enableFtp = "false"
@@ -451,7 +493,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	}
 
 	var ftpServer *server2.FtpServer
-	if enableFtp == "true" {
+	if enableFtp == "true" && virtualFs != nil {
 
 		ftp_interface, err := configStore.GetConfigValueFor("ftp.listen_interface", "backend")
 		if err != nil {
@@ -461,7 +503,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		}
 		// ftpListener, err := net.Listen("tcp", ftp_interface)
 		// resource.CheckErr(err, "Failed to create listener for FTP")
-		ftpServer, err = CreateFtpServers(cruds, certificateManager, ftp_interface)
+		ftpServer, err = CreateFtpServers(virtualFs, certificateManager, ftp_interface)
 		auth.CheckErr(err, "Failed to creat FTP server")
 		go func() {
 			log.Printf("FTP server started at %v", ftp_interface)
@@ -470,6 +512,33 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		}()
 	}
 
+	enableSftp, err := configStore.GetConfigValueFor("sftp.enable", "backend")
+	if err != nil {
+		enableSftp = "false"
+		err = configStore.SetConfigValueFor("sftp.enable", enableSftp, "backend")
+		auth.CheckErr(err, "Failed to store default value for sftp.enable")
+	}
+
+	var sftpServer *DaptinSftpServer
+	if enableSftp == "true" && virtualFs != nil {
+
+		sftpInterface, err := configStore.GetConfigValueFor("sftp.listen_interface", "backend")
+		if err != nil {
+			sftpInterface = "0.0.0.0:2022"
+			err = configStore.SetConfigValueFor("sftp.listen_interface", sftpInterface, "backend")
+			resource.CheckErr(err, "Failed to store default value for sftp.listen_interface")
+		}
+		hostKey, err := GetSftpHostKey(configStore)
+		if !resource.CheckErr(err, "Failed to load sftp host key") {
+			sftpServer = NewDaptinSftpServer(virtualFs, hostKey, sftpInterface)
+			go func() {
+				log.Printf("SFTP server started at %v", sftpInterface)
+				err := sftpServer.ListenAndServe()
+				resource.CheckErr(err, "Failed to listen at sftp interface")
+			}()
+		}
+	}
+
 	defaultRouter.GET("/ping", func(c *gin.Context) {
 		_, err := cruds["world"].GetObjectByWhereClause("world", "table_name", "world")
 		if err != nil {
@@ -483,6 +552,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	metaHandler := CreateMetaHandler(&initConfig)
 	blueprintHandler := CreateApiBlueprintHandler(&initConfig, cruds)
 	statsHandler := CreateStatsHandler(&initConfig, cruds)
+	exportHandler := CreateExportHandler(cruds)
 	resource.InitialiseColumnManager()
 
 	dbAssetHandler := CreateDbAssetHandler(cruds)
@@ -493,6 +563,15 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	configHandler := CreateConfigHandler(&initConfig, cruds, configStore)
 	defaultRouter.GET("/_config/:end/:key", configHandler)
 	defaultRouter.GET("/_config", configHandler)
+	defaultRouter.GET("/_sync", CreateStorageSyncStatusHandler(cruds))
+
+	if virtualFs != nil {
+		webDavHandler := CreateWebDavHandler(virtualFs, authMiddleware)
+		for _, method := range WebDavMethods {
+			defaultRouter.Handle(method, "/webdav", webDavHandler)
+			defaultRouter.Handle(method, "/webdav/*path", webDavHandler)
+		}
+	}
 	defaultRouter.POST("/_config/:end/:key", configHandler)
 	defaultRouter.PATCH("/_config/:end/:key", configHandler)
 	defaultRouter.PUT("/_config/:end/:key", configHandler)
@@ -512,8 +591,14 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 			GraphiQL:   true,
 		})
 
-		// serve HTTP
+		graphqlSubscriptionHandler := CreateGraphqlSubscriptionHandler(graphqlSchema, &dtopicMap)
+
+		// serve HTTP, and subscriptions over websocket
 		defaultRouter.Handle("GET", "/graphql", func(c *gin.Context) {
+			if IsWebsocketUpgrade(c.Request) {
+				graphqlSubscriptionHandler(c)
+				return
+			}
 			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
 		})
 		// serve HTTP
@@ -536,6 +621,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	defaultRouter.GET("/jsmodel/:typename", handler)
 	defaultRouter.GET("/aggregate/:typename", statsHandler)
+	defaultRouter.GET("/export/:typename", exportHandler)
 	defaultRouter.GET("/meta", metaHandler)
 	defaultRouter.GET("/openapi.yaml", blueprintHandler)
 	defaultRouter.OPTIONS("/jsmodel/:typename", handler)
@@ -561,16 +647,16 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	yjsConnectionHandler := ydb.YdbWsConnectionHandler(ydbInstance)
 
-	defaultRouter.GET("/yjs/:documentName", func(ginContext *gin.Context) {
+	defaultRouter.GET("/yjs/:documentName", CreateYjsDocumentHandler(cruds, yjsConnectionHandler))
+	defaultRouter.GET("/yjs/:documentName/history", CreateYjsDocumentHistoryHandler(cruds))
+	defaultRouter.POST("/yjs/:documentName/history/:snapshotId", CreateYjsDocumentRestoreHandler(cruds, documentStore))
 
-		sessionUser := ginContext.Request.Context().Value("user")
-		if sessionUser == nil {
-			ginContext.AbortWithStatus(403)
-		}
-
-		yjsConnectionHandler(ginContext.Writer, ginContext.Request)
-
-	})
+	uploadHandler := NewResumableUploadHandler(cruds, uploadDirectory, time.Duration(uploadExpiry)*time.Second)
+	uploadHandler.StartCleanup(time.Hour)
+	defaultRouter.POST("/upload", uploadHandler.Create)
+	defaultRouter.HEAD("/upload/:uploadId", uploadHandler.Head)
+	defaultRouter.PATCH("/upload/:uploadId", uploadHandler.Patch)
+	defaultRouter.DELETE("/upload/:uploadId", uploadHandler.Delete)
 
 	for typename, crud := range cruds {
 
@@ -584,69 +670,31 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 			defaultRouter.GET(path, func(typename string, columnInfo api2go.ColumnInfo) func(ginContext *gin.Context) {
 
 				dtopicMap[typename].AddListener(func(message olric.DTopicMessage) {
-					eventMessage := message.Message.(resource.EventMessage)
-
-					if eventMessage.EventType == "update" && eventMessage.ObjectType == typename {
-						referenceId := eventMessage.EventData["reference_id"].(string)
-
-						object, _, _ := cruds[typename].GetSingleRowByReferenceId(typename, referenceId, map[string]bool{
-							columnInfo.ColumnName: true,
-						})
-
-						colValue := object[columnInfo.ColumnName]
-						if colValue == nil {
-							return
-						}
-						columnValueArray, ok := colValue.([]map[string]interface{})
-						if !ok {
-							log.Warnf("value is not of type array - %v", colValue)
-							return
-						}
-
-						fileContentsJson := []byte{}
-						for _, file := range columnValueArray {
-							if file["type"] != "x-crdt/yjs" {
-								continue
-							}
-							fileContentsJson, _ = base64.StdEncoding.DecodeString(file["contents"].(string))
-						}
-
-						documentName := fmt.Sprintf("%v.%v.%v", typename, referenceId, columnInfo.ColumnName)
-						document := documentProvider.GetDocument(ydb.YjsRoomName(documentName))
-						if document != nil {
-							document.SetInitialContent(fileContentsJson)
-						}
-
-					}
-
-				})
-
-				return func(ginContext *gin.Context) {
-
-					sessionUser := ginContext.Request.Context().Value("user")
-					if sessionUser == nil {
-						ginContext.AbortWithStatus(403)
+					eventMessage, ok := message.Message.(resource.EventMessage)
+					if !ok || eventMessage.EventType != "update" || eventMessage.ObjectType != typename {
 						return
 					}
-					user := sessionUser.(*auth.SessionUser)
-
-					referenceId := ginContext.Param("referenceId")
-
-					object, _, err := cruds[typename].GetSingleRowByReferenceId(typename, referenceId, nil)
-					if err != nil {
-						ginContext.AbortWithStatus(404)
+					referenceId, ok := eventMessage.EventData["reference_id"].(string)
+					if !ok {
 						return
 					}
 
-					objectPermission := cruds[typename].GetRowPermission(object)
+					err := documentStore.RefreshFromColumn(resource.YjsDocument{
+						TypeName:    typename,
+						ReferenceId: referenceId,
+						ColumnName:  columnInfo.ColumnName,
+					})
+					resource.CheckErr(err, "Failed to refresh document from [%v][%v]", typename, referenceId)
+				})
 
-					if !objectPermission.CanUpdate(user.UserReferenceId, user.Groups) {
-						ginContext.AbortWithStatus(401)
+				return func(ginContext *gin.Context) {
+					documentName := fmt.Sprintf("%v.%v.%v", typename, ginContext.Param("referenceId"), columnInfo.ColumnName)
+					document, ok := yjsDocumentAccess(ginContext, cruds, documentName, true)
+					if !ok {
 						return
 					}
 
-					roomName := fmt.Sprintf("%v%v%v%v%v", typename, ".", referenceId, ".", columnInfo.ColumnName)
-					ginContext.Request = ginContext.Request.WithContext(context.WithValue(ginContext.Request.Context(), "roomname", roomName))
+					ginContext.Request = ginContext.Request.WithContext(context.WithValue(ginContext.Request.Context(), "roomname", document.Name()))
 
 					yjsConnectionHandler(ginContext.Writer, ginContext.Request)
 
@@ -700,47 +748,13 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	}
 	log.Printf("Our admin is [%v]", adminEmail)
 
-	return hostSwitch, mailDaemon, TaskScheduler, configStore, certificateManager, ftpServer, imapServer, olricDb
+	return hostSwitch, mailDaemon, TaskScheduler, documentStore, outboxWorker, configStore, certificateManager, ftpServer, sftpServer, imapServer, olricDb
 
 }
 
-func CreateFtpServers(resources map[string]*resource.DbResource, certManager *resource.CertificateManager, ftp_interface string) (*server2.FtpServer, error) {
-
-	subsites, err := resources["site"].GetAllSites()
-	if err != nil {
-		return nil, err
-	}
-	cloudStores, err := resources["cloud_store"].GetAllCloudStores()
-
-	if err != nil {
-		return nil, err
-	}
-	cloudStoreMap := make(map[string]resource.CloudStore)
-	for _, cloudStore := range cloudStores {
-		cloudStoreMap[cloudStore.ReferenceId] = cloudStore
-	}
-	var driver *DaptinFtpDriver
-
-	sites := make([]SubSiteAssetCache, 0)
-	for _, ftpServer := range subsites {
+func CreateFtpServers(virtualFs *VirtualFs, certManager *resource.CertificateManager, ftp_interface string) (*server2.FtpServer, error) {
 
-		if !ftpServer.FtpEnabled {
-			continue
-		}
-
-		assetCacheFolder, ok := resources["site"].SubsiteFolderCache[ftpServer.ReferenceId]
-		if !ok {
-			continue
-		}
-		site := SubSiteAssetCache{
-			SubSite:          ftpServer,
-			AssetFolderCache: assetCacheFolder,
-		}
-		sites = append(sites, site)
-
-	}
-
-	driver, err = NewDaptinFtpDriver(resources, certManager, ftp_interface, sites)
+	driver, err := NewDaptinFtpDriver(virtualFs, certManager, ftp_interface)
 	ftpS := server2.NewFtpServer(driver)
 	resource.CheckErr(err, "Failed to create daptin ftp driver [%v]", driver)
 	return ftpS, err
@@ -816,6 +830,12 @@ func initialiseResources(initConfig *resource.CmsConfig, db database.DatabaseCon
 		resource.CheckErr(errc, "Failed to commit transaction after creating indexes")
 	}
 
+	schemaMigrationMode, ok := os.LookupEnv("DAPTIN_SCHEMA_MIGRATION")
+	if !ok {
+		schemaMigrationMode = resource.SchemaMigrationModeDryRun
+	}
+	resource.RunSchemaMigration(initConfig, db, schemaMigrationMode)
+
 	tx, errb = db.Beginx()
 	resource.CheckErr(errb, "Failed to begin transaction")
 
@@ -901,6 +921,7 @@ func MergeTables(existingTables []resource.TableInfo, initConfigTables []resourc
 						existableTable.Columns[colIndex].ForeignKeyData = newColumnDef.ForeignKeyData
 						existableTable.Columns[colIndex].IsForeignKey = newColumnDef.IsForeignKey
 						existableTable.Columns[colIndex].IsPrimaryKey = newColumnDef.IsPrimaryKey
+						existableTable.Columns[colIndex].Permission = newColumnDef.Permission
 
 					} else {
 						existableTable.Columns = append(existableTable.Columns, newColumnDef)
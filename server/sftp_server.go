@@ -0,0 +1,292 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// DaptinSftpServer serves the virtual filesystem of the sites and cloud stores over sftp. Users log in with the
+// email and password of their account, or with one of the public keys they added to the ssh_key table
+type DaptinSftpServer struct {
+	VirtualFs     *VirtualFs
+	ListenAddress string
+	config        *ssh.ServerConfig
+	lock          sync.Mutex
+	listener      net.Listener
+	connections   map[net.Conn]bool
+	closed        bool
+}
+
+// NewDaptinSftpServer creates a sftp server using the host key
+func NewDaptinSftpServer(virtualFs *VirtualFs, hostKey ssh.Signer, listenAddress string) *DaptinSftpServer {
+
+	sftpServer := &DaptinSftpServer{
+		VirtualFs:     virtualFs,
+		ListenAddress: listenAddress,
+		connections:   make(map[net.Conn]bool),
+	}
+
+	sftpServer.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			err := virtualFs.CheckPassword(conn.User(), string(password), remoteIp(conn.RemoteAddr()), "sftp")
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{}, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			err := virtualFs.CheckPublicKey(conn.User(), key, remoteIp(conn.RemoteAddr()), "sftp")
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{}, nil
+		},
+	}
+	sftpServer.config.AddHostKey(hostKey)
+
+	return sftpServer
+}
+
+// GetSftpHostKey loads the host key from the sftp.host_key config, a new key is generated and stored the first
+// time
+func GetSftpHostKey(configStore *resource.ConfigStore) (ssh.Signer, error) {
+
+	hostKeyPem, err := configStore.GetConfigValueFor("sftp.host_key", "backend")
+	if err != nil || hostKeyPem == "" {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		hostKeyPem = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}))
+		err = configStore.SetConfigValueFor("sftp.host_key", hostKeyPem, "backend")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ssh.ParsePrivateKey([]byte(hostKeyPem))
+}
+
+// ListenAndServe accepts connections until the server is closed
+func (s *DaptinSftpServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.ListenAddress)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConnection(conn)
+	}
+}
+
+// Close stops accepting connections and closes the open ones
+func (s *DaptinSftpServer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for conn := range s.connections {
+		_ = conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *DaptinSftpServer) serveConnection(conn net.Conn) {
+	s.lock.Lock()
+	s.connections[conn] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.connections, conn)
+		s.lock.Unlock()
+		_ = conn.Close()
+	}()
+
+	// rejected public keys count as one failed login of the connection, clients offer their keys one by one
+	keyRejectedFor := ""
+	config := *s.config
+	config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		permissions, err := s.config.PublicKeyCallback(conn, key)
+		if _, locked := err.(*auth.LoginLockedError); err != nil && !locked {
+			keyRejectedFor = conn.User()
+		}
+		return permissions, err
+	}
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, &config)
+	if err != nil {
+		log.Printf("Failed sftp handshake with [%v]: %v", conn.RemoteAddr(), err)
+		if keyRejectedFor != "" {
+			s.VirtualFs.PublicKeyLoginFailed(keyRejectedFor, remoteIp(conn.RemoteAddr()), "sftp")
+		}
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	session, err := s.VirtualFs.NewSession(serverConn.User(), remoteIp(serverConn.RemoteAddr()), "sftp")
+	if err != nil {
+		log.Printf("Failed to start sftp session for [%v]: %v", serverConn.User(), err)
+		return
+	}
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("Failed to accept sftp channel: %v", err)
+			continue
+		}
+		go s.serveChannel(channel, channelRequests, session)
+	}
+}
+
+// serveChannel serves the sftp subsystem, shell and exec requests are refused
+func (s *DaptinSftpServer) serveChannel(channel ssh.Channel, requests <-chan *ssh.Request, session *VirtualFsSession) {
+	defer channel.Close()
+	for request := range requests {
+		isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+		_ = request.Reply(isSftp, nil)
+		if !isSftp {
+			continue
+		}
+
+		handler := &sftpHandler{session: session}
+		requestServer := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  handler,
+			FilePut:  handler,
+			FileCmd:  handler,
+			FileList: handler,
+		})
+		err := requestServer.Serve()
+		if err != nil && err != io.EOF {
+			log.Printf("Sftp session of [%v] ended: %v", session.SessionUser.UserReferenceId, err)
+		}
+		_ = requestServer.Close()
+		return
+	}
+}
+
+// sftpHandler maps sftp requests to the virtual filesystem session
+type sftpHandler struct {
+	session *VirtualFsSession
+}
+
+func (h *sftpHandler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	return h.session.OpenFile(request.Filepath, os.O_RDONLY, 0)
+}
+
+func (h *sftpHandler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	flags := request.Pflags()
+	flag := os.O_WRONLY | os.O_CREATE
+	if flags.Read {
+		flag = os.O_RDWR | os.O_CREATE
+	}
+	if flags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if flags.Excl {
+		flag |= os.O_EXCL
+	}
+	return h.session.OpenFile(request.Filepath, flag, 0644)
+}
+
+func (h *sftpHandler) Filecmd(request *sftp.Request) error {
+	switch request.Method {
+	case "Setstat":
+		attrFlags := request.AttrFlags()
+		attributes := request.Attributes()
+		if attrFlags.Permissions {
+			err := h.session.Chmod(request.Filepath, attributes.FileMode()&os.ModePerm)
+			if err != nil {
+				return err
+			}
+		}
+		if attrFlags.Acmodtime {
+			return h.session.Chtimes(request.Filepath, time.Unix(int64(attributes.Mtime), 0))
+		}
+		return nil
+	case "Rename":
+		return h.session.Rename(request.Filepath, request.Target)
+	case "Rmdir", "Remove":
+		return h.session.Remove(request.Filepath)
+	case "Mkdir":
+		return h.session.Mkdir(request.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *sftpHandler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	switch request.Method {
+	case "List":
+		files, err := h.session.ReadDir(request.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList(files), nil
+	case "Stat":
+		info, err := h.session.Stat(request.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpFileList []os.FileInfo
+
+func (l sftpFileList) ListAt(files []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(files, l[offset:])
+	if n < len(files) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func remoteIp(address net.Addr) string {
+	ip, _, err := net.SplitHostPort(address.String())
+	if err != nil {
+		return address.String()
+	}
+	return ip
+}
@@ -12,7 +12,8 @@ import (
 	"strconv"
 )
 
-func StartSMTPMailServer(resource *resource.DbResource, certificateManager *resource.CertificateManager, primaryHostname string) (*guerrilla.Daemon, error) {
+func StartSMTPMailServer(resource *resource.DbResource, certificateManager *resource.CertificateManager, primaryHostname string,
+	outboxWorker *resource.OutboxDeliveryWorker) (*guerrilla.Daemon, error) {
 
 	servers, err := resource.GetAllObjects("mail_server")
 
@@ -128,7 +129,7 @@ func StartSMTPMailServer(resource *resource.DbResource, certificateManager *reso
 		},
 	}
 
-	smtpResource := DaptinSmtpDbResource(resource, certificateManager)
+	smtpResource := DaptinSmtpDbResource(resource, certificateManager, outboxWorker)
 
 	d.AddProcessor("DaptinSql", smtpResource)
 	d.AddAuthenticator(DaptinSmtpAuthenticatorCreator(resource))
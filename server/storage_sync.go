@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"time"
+)
+
+// StorageSyncStatus is the sync status of the cache folder of an asset column or a site
+type StorageSyncStatus struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	resource.AssetFolderSyncStatus
+}
+
+type storageSyncFolder struct {
+	name       string
+	folderType string
+	folder     *resource.AssetFolderCache
+}
+
+// storageSyncFolders lists the local folders synced with a cloud store, asset columns as "<table>.<column>" and
+// sites by their reference id
+func storageSyncFolders(cruds map[string]*resource.DbResource) []storageSyncFolder {
+	folders := make([]storageSyncFolder, 0)
+	for tableName, columns := range cruds["world"].AssetFolderCache {
+		for columnName, folder := range columns {
+			folders = append(folders, storageSyncFolder{name: tableName + "." + columnName, folderType: "column", folder: folder})
+		}
+	}
+	for siteId, folder := range cruds["site"].SubsiteFolderCache.All() {
+		folders = append(folders, storageSyncFolder{name: siteId, folderType: "site", folder: folder})
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].folderType != folders[j].folderType {
+			return folders[i].folderType < folders[j].folderType
+		}
+		return folders[i].name < folders[j].name
+	})
+	return folders
+}
+
+// StartStorageWriteBack pushes the local changes of bidirectional folders every interval, between the
+// scheduled pulls, until the HostSwitch is closed
+func (hs *HostSwitch) StartStorageWriteBack(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hs.stop:
+				return
+			case <-ticker.C:
+			}
+			for _, syncFolder := range storageSyncFolders(hs.cruds) {
+				if !syncFolder.folder.IsBidirectional() {
+					continue
+				}
+				err := hs.cruds["world"].PushLocalChanges(syncFolder.folder)
+				resource.CheckErr(err, "Failed to push local changes of %v [%v]", syncFolder.folderType, syncFolder.name)
+			}
+		}
+	}()
+}
+
+// CreateStorageSyncStatusHandler lists the sync status of every synced folder, for administrators
+func CreateStorageSyncStatusHandler(cruds map[string]*resource.DbResource) func(*gin.Context) {
+	return func(c *gin.Context) {
+		sessionUser, ok := c.Request.Context().Value("user").(*auth.SessionUser)
+		if !ok || !cruds[resource.USER_ACCOUNT_TABLE_NAME].IsAdmin(sessionUser.UserReferenceId) {
+			c.AbortWithStatus(403)
+			return
+		}
+
+		statuses := make([]StorageSyncStatus, 0)
+		for _, syncFolder := range storageSyncFolders(cruds) {
+			statuses = append(statuses, StorageSyncStatus{
+				Name:                  syncFolder.name,
+				Type:                  syncFolder.folderType,
+				AssetFolderSyncStatus: syncFolder.folder.SyncStatus(),
+			})
+		}
+		c.JSON(200, statuses)
+	}
+}
@@ -6,26 +6,58 @@ import (
 	_ "github.com/artpar/rclone/backend/all" // import all fs
 	"github.com/artpar/stats"
 	"github.com/aviddiviner/gin-limit"
+	"github.com/buraksezer/olric"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/resource"
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
-	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
 	limit2 "github.com/yangxikun/gin-limit-by-key"
 	"golang.org/x/time/rate"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
+// HostSwitch routes each request to the router of the site for its hostname, or to the dashboard. Sites are
+// added, replaced and removed while the server is running, as their site and cloud_store rows change
 type HostSwitch struct {
+	lock           sync.RWMutex
 	handlerMap     map[string]*gin.Engine
 	siteMap        map[string]resource.SubSite
 	authMiddleware *auth.AuthMiddleware
+
+	// reloadLock serializes the reloads, hostedSites is only accessed while holding it
+	reloadLock         sync.Mutex
+	hostedSites        map[string]*hostedSite
+	cmsConfig          *resource.CmsConfig
+	cruds              map[string]*resource.DbResource
+	configStore        *resource.ConfigStore
+	certificateManager *resource.CertificateManager
+	virtualFs          *VirtualFs
+	listeners          map[*olric.DTopic]uint64
+	closed             bool
+
+	// reloadRequests wakes the reload worker after a change to the sites, stop ends it and the storage
+	// write back on Close
+	reloadRequests chan struct{}
+	stop           chan struct{}
+}
+
+// siteReloadDelay is how long the reload worker waits after a change, so changes made together, as a site and
+// its cloud store, are loaded by one reload
+const siteReloadDelay = time.Second
+
+// hostedSite is a site served by the HostSwitch from the local folder its files are synced to
+type hostedSite struct {
+	information resource.SubSiteInformation
+	router      *gin.Engine
+	cacheFolder *resource.AssetFolderCache
 }
 
 type JsonApiError struct {
@@ -70,10 +102,13 @@ func CreateAssetColumnSync(cruds map[string]*resource.DbResource) map[string]map
 				}
 
 				assetCacheFolder := &resource.AssetFolderCache{
-					CloudStore:    cloudStore,
-					LocalSyncPath: tempDirectoryPath,
-					Keyname:       column.ForeignKeyData.KeyName,
+					CloudStore:     cloudStore,
+					LocalSyncPath:  tempDirectoryPath,
+					Keyname:        column.ForeignKeyData.KeyName,
+					SyncMode:       cloudStore.SyncMode,
+					ConflictPolicy: cloudStore.ConflictPolicy,
 				}
+				assetCacheFolder.MarkSynced(time.Now())
 
 				colCache[columnName] = assetCacheFolder
 				log.Infof("Sync table columnd [%v][%v] at %v", tableName, columnName, tempDirectoryPath)
@@ -106,44 +141,147 @@ func CreateAssetColumnSync(cruds map[string]*resource.DbResource) map[string]map
 // CreateSubSites creates a router which can route based on hostname to one of the hosted static subsites
 func CreateSubSites(cmsConfig *resource.CmsConfig, db database.DatabaseConnection,
 	cruds map[string]*resource.DbResource, authMiddleware *auth.AuthMiddleware,
-	configStore *resource.ConfigStore) (HostSwitch, map[string]*resource.AssetFolderCache) {
+	configStore *resource.ConfigStore, certificateManager *resource.CertificateManager) *HostSwitch {
+
+	hs := &HostSwitch{
+		handlerMap:         make(map[string]*gin.Engine),
+		siteMap:            make(map[string]resource.SubSite),
+		authMiddleware:     authMiddleware,
+		hostedSites:        make(map[string]*hostedSite),
+		cmsConfig:          cmsConfig,
+		cruds:              cruds,
+		configStore:        configStore,
+		certificateManager: certificateManager,
+		listeners:          make(map[*olric.DTopic]uint64),
+		reloadRequests:     make(chan struct{}, 1),
+		stop:               make(chan struct{}),
+	}
 
-	router := httprouter.New()
-	router.ServeFiles("/*filepath", http.Dir("./scripts"))
+	err := hs.ReloadSites()
+	if err != nil {
+		log.Errorf("Failed to load sites from database: %v", err)
+	}
 
-	hs := HostSwitch{}
-	subsiteCacheFolders := make(map[string]*resource.AssetFolderCache)
-	hs.handlerMap = make(map[string]*gin.Engine)
-	hs.siteMap = make(map[string]resource.SubSite)
-	hs.authMiddleware = authMiddleware
+	return hs
+}
 
-	//log.Printf("Cruds before making sub sits: %v", cruds)
-	sites, err := cruds["site"].GetAllSites()
-	if err != nil {
-		log.Printf("Failed to get all sites 117: %v", err)
+// SetVirtualFs sets the virtual filesystem of the ftp, sftp and webdav servers, it is reloaded along with the sites
+func (hs *HostSwitch) SetVirtualFs(virtualFs *VirtualFs) {
+	hs.reloadLock.Lock()
+	defer hs.reloadLock.Unlock()
+	hs.virtualFs = virtualFs
+}
+
+// ListenForChanges reloads the sites on changes in the site and cloud_store tables, on this server or any other
+// server in the cluster. The listeners only wake the reload worker, the reload runs outside of olric
+func (hs *HostSwitch) ListenForChanges(dtopicMap map[string]*olric.DTopic) error {
+	hs.reloadLock.Lock()
+	defer hs.reloadLock.Unlock()
+
+	if hs.closed {
+		return nil
 	}
-	stores, err := cruds["cloud_store"].GetAllCloudStores()
-	if err != nil {
-		log.Printf("Failed to get all cloudstores 121: %v", err)
+	if len(hs.listeners) == 0 {
+		go debounceReloads(hs.reloadRequests, hs.stop, siteReloadDelay, func() {
+			err := hs.ReloadSites()
+			resource.CheckErr(err, "Failed to reload sites after a change of the sites or cloud stores")
+		})
 	}
-	cloudStoreMap := make(map[int64]resource.CloudStore)
 
-	adminEmailId := cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId()
-	log.Printf("Admin email id: %s", adminEmailId)
+	for _, tableName := range []string{"site", "cloud_store"} {
+		topic, ok := dtopicMap[tableName]
+		if !ok {
+			continue
+		}
+		listenerId, err := topic.AddListener(func(message olric.DTopicMessage) {
+			eventMessage := message.Message.(resource.EventMessage)
+			log.Debugf("Reloading sites after %v of [%v]", eventMessage.EventType, eventMessage.ObjectType)
+			select {
+			case hs.reloadRequests <- struct{}{}:
+			default:
+				// a reload is already waiting
+			}
+		})
+		if err != nil {
+			return err
+		}
+		hs.listeners[topic] = listenerId
+	}
+	return nil
+}
 
-	for _, store := range stores {
-		cloudStoreMap[store.Id] = store
+// debounceReloads calls reload once per burst of requests, delay after the first request of the burst. Requests
+// arriving while reload runs lead to one more reload
+func debounceReloads(requests chan struct{}, stop <-chan struct{}, delay time.Duration, reload func()) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-requests:
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// the requests of the burst are covered by this reload
+		select {
+		case <-requests:
+		default:
+		}
+		reload()
 	}
+}
 
-	siteMap := make(map[string]resource.SubSiteInformation)
+// Close stops listening for changes and ends the storage write back, the sites are reloaded and written back by
+// the HostSwitch replacing this one after a restart
+func (hs *HostSwitch) Close() {
+	hs.reloadLock.Lock()
+	defer hs.reloadLock.Unlock()
 
+	if !hs.closed {
+		close(hs.stop)
+	}
+	hs.closed = true
+	for topic, listenerId := range hs.listeners {
+		err := topic.RemoveListener(listenerId)
+		resource.CheckErr(err, "Failed to remove site update listener")
+	}
+	hs.listeners = make(map[*olric.DTopic]uint64)
+}
+
+// ReloadSites loads the sites and cloud stores from the database and updates the hosted sites to match them. New
+// and enabled sites are synced to a new folder and start getting requests, sites which moved to another path,
+// hostname or cloud store are synced again and swapped in once ready, and deleted or disabled sites are removed.
+// Sites which only changed their permission or name keep their folder
+func (hs *HostSwitch) ReloadSites() error {
+
+	hs.reloadLock.Lock()
+	defer hs.reloadLock.Unlock()
+
+	if hs.closed {
+		return nil
+	}
+
+	sites, err := hs.cruds["site"].GetAllSites()
 	if err != nil {
-		log.Errorf("Failed to load sites from database: %v", err)
-		return hs, subsiteCacheFolders
+		return err
+	}
+	stores, err := hs.cruds["cloud_store"].GetAllCloudStores()
+	if err != nil {
+		return err
+	}
+	cloudStoreMap := make(map[int64]resource.CloudStore)
+	for _, store := range stores {
+		cloudStoreMap[store.Id] = store
 	}
 
-	max_connections, err := configStore.GetConfigIntValueFor("limit.max_connections", "backend")
-	rate_limit, err := configStore.GetConfigIntValueFor("limit.rate", "backend")
+	hostedSites := make(map[string]*hostedSite)
+	replacedSites := make([]*hostedSite, 0)
 
 	for _, site := range sites {
 
@@ -151,129 +289,288 @@ func CreateSubSites(cmsConfig *resource.CmsConfig, db database.DatabaseConnectio
 			continue
 		}
 
-		subSiteInformation := resource.SubSiteInformation{}
-		//hs.siteMap[site.Path] = site
-
-		for _, hostname := range strings.Split(site.Hostname, ",") {
-			hs.siteMap[hostname] = site
-		}
-
-		subSiteInformation.SubSite = site
-
 		if site.CloudStoreId == nil {
 			log.Printf("Site [%v] does not have a associated storage", site.Name)
 			continue
 		}
-
-		u, _ := uuid.NewV4()
-		sourceDirectoryName := u.String()
-		tempDirectoryPath, err := ioutil.TempDir(os.Getenv("DAPTIN_CACHE_FOLDER"), sourceDirectoryName)
-		if resource.CheckErr(err, "Failed to create temp directory") {
-			continue
-		}
-		subSiteInformation.SourceRoot = tempDirectoryPath
 		cloudStore, ok := cloudStoreMap[*site.CloudStoreId]
-		subSiteInformation.CloudStore = cloudStore
 		if !ok {
 			log.Printf("Site [%v] does not have a associated storage", site.Name)
 			continue
 		}
 
-		err = cruds["task"].SyncStorageToPath(cloudStore, site.Path, tempDirectoryPath)
-		if resource.CheckErr(err, "Failed to setup sync to path for subsite [%v]", site.Name) {
+		existing := hs.hostedSites[site.ReferenceId]
+		if existing != nil && !existing.needsSync(site, cloudStore) {
+			existing.information.SubSite = site
+			existing.information.CloudStore = cloudStore
+			hostedSites[site.ReferenceId] = existing
+			continue
+		}
+
+		newSite, err := hs.hostSite(site, cloudStore)
+		if resource.CheckErr(err, "Failed to host site [%v]", site.Name) {
+			// keep serving the previous version of the site
+			if existing != nil {
+				hostedSites[site.ReferenceId] = existing
+			}
 			continue
 		}
+		hostedSites[site.ReferenceId] = newSite
+		if existing != nil {
+			replacedSites = append(replacedSites, existing)
+		}
+	}
 
-		syncTask := resource.Task{
-			EntityName: "site",
-			ActionName: "sync_site_storage",
-			Attributes: map[string]interface{}{
-				"site_id": site.ReferenceId,
-				"path":    tempDirectoryPath,
-			},
-			AsUserEmail: adminEmailId,
-			Schedule:    "@every 1h",
+	removedSiteIds := make([]string, 0)
+	for siteId, existing := range hs.hostedSites {
+		if _, ok := hostedSites[siteId]; !ok {
+			replacedSites = append(replacedSites, existing)
+			removedSiteIds = append(removedSiteIds, siteId)
 		}
+	}
 
-		activeTask := cruds["site"].NewActiveTaskInstance(syncTask)
+	previousHostnames := make(map[string]bool)
+	siteInformation := make(map[string]resource.SubSiteInformation)
 
-		func(task *resource.ActiveTaskInstance) {
-			go func() {
-				log.Info("Sleep 5 sec for running new sync task")
-				time.Sleep(5 * time.Second)
-				activeTask.Run()
-			}()
-		}(activeTask)
+	hs.lock.Lock()
+	for _, existing := range hs.hostedSites {
+		for _, hostname := range existing.hostnames() {
+			previousHostnames[hostname] = true
+			delete(hs.handlerMap, hostname)
+			delete(hs.siteMap, hostname)
+		}
+	}
+	for _, hosted := range hostedSites {
+		for _, hostname := range hosted.hostnames() {
+			hs.handlerMap[hostname] = hosted.router
+			hs.siteMap[hostname] = hosted.information.SubSite
+		}
+		siteInformation[hosted.information.SubSite.Hostname] = hosted.information
+	}
+	hs.hostedSites = hostedSites
+	hs.cmsConfig.SubSites = siteInformation
+	hs.lock.Unlock()
 
-		err = TaskScheduler.AddTask(syncTask)
+	siteFolders := hs.cruds["site"].SubsiteFolderCache
+	for siteId, hosted := range hostedSites {
+		siteFolders.Set(siteId, hosted.cacheFolder)
+	}
+	for _, siteId := range removedSiteIds {
+		siteFolders.Remove(siteId)
+		// replaced sites have their sync task rescheduled by AddTask
+		TaskScheduler.RemoveTask(siteSyncTaskId(siteId))
+	}
 
-		subsiteCacheFolders[site.ReferenceId] = &resource.AssetFolderCache{
-			LocalSyncPath: tempDirectoryPath,
-			Keyname:       site.Path,
-			CloudStore:    cloudStore,
+	for _, replaced := range replacedSites {
+		go hs.releaseSite(replaced)
+	}
+
+	if hs.certificateManager != nil {
+		for _, hosted := range hostedSites {
+			for _, hostname := range hosted.hostnames() {
+				if previousHostnames[hostname] {
+					continue
+				}
+				_, _, _, _, _, err = hs.certificateManager.GetTLSConfig(hostname, true)
+				resource.CheckErr(err, "Failed to generate certificate for [%v]", hostname)
+			}
 		}
+	}
 
-		resource.CheckErr(err, "Failed to register task to sync storage")
+	if hs.virtualFs != nil {
+		err = hs.virtualFs.Reload()
+		resource.CheckErr(err, "Failed to reload sites and cloud stores for ftp, sftp and webdav")
+	}
 
-		subsiteStats := stats.New()
-		hostRouter := gin.New()
+	return nil
+}
 
-		hostRouter.Use(func() gin.HandlerFunc {
-			return func(c *gin.Context) {
-				beginning, recorder := subsiteStats.Begin(c.Writer)
-				defer Stats.End(beginning, stats.WithRecorder(recorder))
-				c.Next()
-			}
-		}())
-
-		hostRouter.Use(limit.MaxAllowed(max_connections))
-		hostRouter.Use(limit2.NewRateLimiter(func(c *gin.Context) string {
-			return c.ClientIP() + strings.Split(c.Request.RequestURI, "?")[0] // limit rate by client ip
-		}, func(c *gin.Context) (*rate.Limiter, time.Duration) {
-			return rate.NewLimiter(rate.Every(100*time.Millisecond), rate_limit), time.Hour // limit 10 qps/clientIp and permit bursts of at most 10 tokens, and the limiter liveness time duration is 1 hour
-		}, func(c *gin.Context) {
-			c.AbortWithStatus(429) // handle exceed rate limit request
-		}))
-
-		hostRouter.GET("/stats", func(c *gin.Context) {
-			c.JSON(200, subsiteStats.Data())
-		})
+// siteSyncTaskId is the reference id of the scheduled task syncing the site folder with the cloud store, so the
+// task can be removed along with the site
+func siteSyncTaskId(siteReferenceId string) string {
+	return "sync_site_storage:" + siteReferenceId
+}
 
-		//hostRouter.ServeFiles("/*filepath", http.Dir(tempDirectoryPath))
-		hostRouter.Use(authMiddleware.AuthCheckMiddleware)
+// hostSite syncs the site from its cloud store to a new folder, schedules the sync task and creates the router
+// serving the folder
+func (hs *HostSwitch) hostSite(site resource.SubSite, cloudStore resource.CloudStore) (*hostedSite, error) {
 
-		if site.SiteType == "hugo" {
-			hostRouter.Use(static.Serve("/", static.LocalFile(tempDirectoryPath+"/public", true)))
-		} else {
-			hostRouter.Use(static.Serve("/", static.LocalFile(tempDirectoryPath, true)))
-		}
+	u, _ := uuid.NewV4()
+	sourceDirectoryName := u.String()
+	tempDirectoryPath, err := ioutil.TempDir(os.Getenv("DAPTIN_CACHE_FOLDER"), sourceDirectoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = hs.cruds["task"].SyncStorageToPath(cloudStore, site.Path, tempDirectoryPath)
+	if err != nil {
+		_ = os.RemoveAll(tempDirectoryPath)
+		return nil, err
+	}
+
+	syncTask := resource.Task{
+		ReferenceId: siteSyncTaskId(site.ReferenceId),
+		EntityName:  "site",
+		ActionName:  "sync_site_storage",
+		Attributes: map[string]interface{}{
+			"site_id": site.ReferenceId,
+			"path":    tempDirectoryPath,
+		},
+		AsUserEmail: hs.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(),
+		Schedule:    "@every 1h",
+	}
 
-		faviconPath := tempDirectoryPath + "/favicon.ico"
-		if site.SiteType == "hugo" {
-			faviconPath = tempDirectoryPath + "/public/favicon.ico"
+	activeTask := hs.cruds["site"].NewActiveTaskInstance(syncTask)
+
+	go func() {
+		log.Info("Sleep 5 sec for running new sync task")
+		time.Sleep(5 * time.Second)
+		activeTask.Run()
+	}()
+
+	err = TaskScheduler.AddTask(syncTask)
+	resource.CheckErr(err, "Failed to register task to sync storage")
+
+	siteCacheFolder := &resource.AssetFolderCache{
+		LocalSyncPath:  tempDirectoryPath,
+		Keyname:        site.Path,
+		CloudStore:     cloudStore,
+		SyncMode:       site.SyncMode,
+		ConflictPolicy: site.ConflictPolicy,
+	}
+	siteCacheFolder.MarkSynced(time.Now())
+
+	return &hostedSite{
+		information: resource.SubSiteInformation{
+			SubSite:    site,
+			CloudStore: cloudStore,
+			SourceRoot: tempDirectoryPath,
+		},
+		router:      hs.createSiteRouter(site, tempDirectoryPath),
+		cacheFolder: siteCacheFolder,
+	}, nil
+}
+
+// createSiteRouter serves the files of the site folder
+func (hs *HostSwitch) createSiteRouter(site resource.SubSite, tempDirectoryPath string) *gin.Engine {
+
+	max_connections, err := hs.configStore.GetConfigIntValueFor("limit.max_connections", "backend")
+	rate_limit, err := hs.configStore.GetConfigIntValueFor("limit.rate", "backend")
+	resource.CheckErr(err, "Failed to get rate limits for site [%v]", site.Name)
+
+	subsiteStats := stats.New()
+	hostRouter := gin.New()
+
+	hostRouter.Use(func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			beginning, recorder := subsiteStats.Begin(c.Writer)
+			defer Stats.End(beginning, stats.WithRecorder(recorder))
+			c.Next()
 		}
+	}())
+
+	hostRouter.Use(limit.MaxAllowed(max_connections))
+	hostRouter.Use(limit2.NewRateLimiter(func(c *gin.Context) string {
+		return c.ClientIP() + strings.Split(c.Request.RequestURI, "?")[0] // limit rate by client ip
+	}, func(c *gin.Context) (*rate.Limiter, time.Duration) {
+		return rate.NewLimiter(rate.Every(100*time.Millisecond), rate_limit), time.Hour // limit 10 qps/clientIp and permit bursts of at most 10 tokens, and the limiter liveness time duration is 1 hour
+	}, func(c *gin.Context) {
+		c.AbortWithStatus(429) // handle exceed rate limit request
+	}))
+
+	hostRouter.GET("/stats", func(c *gin.Context) {
+		c.JSON(200, subsiteStats.Data())
+	})
+
+	//hostRouter.ServeFiles("/*filepath", http.Dir(tempDirectoryPath))
+	hostRouter.Use(hs.authMiddleware.AuthCheckMiddleware)
+
+	if site.SiteType == "hugo" {
+		hostRouter.Use(static.Serve("/", static.LocalFile(tempDirectoryPath+"/public", true)))
+	} else {
+		hostRouter.Use(static.Serve("/", static.LocalFile(tempDirectoryPath, true)))
+	}
 
-		hostRouter.GET("/favicon.ico", func(c *gin.Context) {
-			c.File(faviconPath)
-		})
-		hostRouter.NoRoute(func(c *gin.Context) {
-			log.Printf("Found no route for [%v] [%v] [%v]", c.ClientIP(), c.Request.Header.Get("User-Agent"), c.Request.URL)
-			c.File(tempDirectoryPath + "/index.html")
-			c.AbortWithStatus(404)
-		})
+	faviconPath := tempDirectoryPath + "/favicon.ico"
+	if site.SiteType == "hugo" {
+		faviconPath = tempDirectoryPath + "/public/favicon.ico"
+	}
 
-		hostRouter.Handle("GET", "/statistics", func(c *gin.Context) {
-			c.JSON(http.StatusOK, Stats.Data())
-		})
+	hostRouter.GET("/favicon.ico", func(c *gin.Context) {
+		c.File(faviconPath)
+	})
+	hostRouter.NoRoute(func(c *gin.Context) {
+		log.Printf("Found no route for [%v] [%v] [%v]", c.ClientIP(), c.Request.Header.Get("User-Agent"), c.Request.URL)
+		c.File(tempDirectoryPath + "/index.html")
+		c.AbortWithStatus(404)
+	})
 
-		hs.handlerMap[site.Hostname] = hostRouter
-		siteMap[subSiteInformation.SubSite.Hostname] = subSiteInformation
-		//siteMap[subSiteInformation.SubSite.Path] = subSiteInformation
+	hostRouter.Handle("GET", "/statistics", func(c *gin.Context) {
+		c.JSON(http.StatusOK, Stats.Data())
+	})
+
+	return hostRouter
+}
+
+// releaseSite pushes the local changes of a replaced or removed site which are not on the cloud store yet, and
+// removes its folder
+func (hs *HostSwitch) releaseSite(site *hostedSite) {
+	if site.cacheFolder.IsBidirectional() {
+		err := hs.cruds["world"].PushLocalChanges(site.cacheFolder)
+		resource.CheckErr(err, "Failed to push local changes of site [%v]", site.information.SubSite.Name)
 	}
+	err := os.RemoveAll(site.cacheFolder.LocalSyncPath)
+	resource.CheckErr(err, "Failed to remove folder of site [%v]", site.information.SubSite.Name)
+}
+
+func (h *hostedSite) hostnames() []string {
+	return strings.Split(h.information.SubSite.Hostname, ",")
+}
+
+// needsSync is true when the site has to be synced to a new folder, because it is served from another path or
+// cloud store, or with another router
+func (h *hostedSite) needsSync(site resource.SubSite, cloudStore resource.CloudStore) bool {
+	current := h.information.SubSite
+	currentStore := h.information.CloudStore
+	return current.Hostname != site.Hostname ||
+		current.Path != site.Path ||
+		current.SiteType != site.SiteType ||
+		current.SyncMode != site.SyncMode ||
+		current.ConflictPolicy != site.ConflictPolicy ||
+		currentStore.Id != cloudStore.Id ||
+		currentStore.RootPath != cloudStore.RootPath ||
+		currentStore.StoreProvider != cloudStore.StoreProvider ||
+		currentStore.OAutoTokenId != cloudStore.OAutoTokenId ||
+		!reflect.DeepEqual(currentStore.StoreParameters, cloudStore.StoreParameters)
+}
+
+// handler is the router for the hostname or one of the "api", "dashboard" and "default" routers
+func (hs *HostSwitch) handler(name string) *gin.Engine {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+	return hs.handlerMap[name]
+}
 
-	cmsConfig.SubSites = siteMap
+// SubSites returns the hosted sites by their hostname. The sites in the config are replaced on every reload, so
+// they are only read through the HostSwitch
+func (hs *HostSwitch) SubSites() map[string]resource.SubSiteInformation {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+	return hs.cmsConfig.SubSites
+}
 
-	return hs, subsiteCacheFolders
+func (hs *HostSwitch) subSite(hostname string) (resource.SubSite, bool) {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+	site, ok := hs.siteMap[hostname]
+	return site, ok
+}
+
+// SetHandler registers a router which is not a site, like the "api" and "dashboard" routers
+func (hs *HostSwitch) SetHandler(name string, handler *gin.Engine) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.handlerMap[name] = handler
 }
 
 type StaticFsWithDefaultIndex struct {
@@ -299,28 +596,29 @@ var apiPaths = map[string]bool{
 	"feed":    true,
 	"asset":   true,
 	"jsmodel": true,
+	"webdav":  true,
 }
 
 // Implement the ServerHTTP method on our new type
-func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (hs *HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Check if a http.Handler is registered for the given host.
 	// If yes, use it to handle the request.
 	hostName := strings.Split(r.Host, ":")[0]
 	pathParts := strings.Split(r.URL.Path, "/")
 
 	if BeginsWithCheck(r.URL.Path, "/.well-known") {
-		hs.handlerMap["dashboard"].ServeHTTP(w, r)
+		hs.handler("dashboard").ServeHTTP(w, r)
 		return
 	}
 
-	if handler := hs.handlerMap[hostName]; handler != nil && !(len(pathParts) > 1 && apiPaths[pathParts[1]]) {
+	if handler := hs.handler(hostName); handler != nil && !(len(pathParts) > 1 && apiPaths[pathParts[1]]) {
 
 		ok, abort, modifiedRequest := hs.authMiddleware.AuthCheckMiddlewareWithHttp(r, w, true)
 		if ok {
 			r = modifiedRequest
 		}
 
-		subSite := hs.siteMap[hostName]
+		subSite, _ := hs.subSite(hostName)
 
 		permission := subSite.Permission
 		if abort {
@@ -353,7 +651,7 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if len(pathParts) > 1 && !apiPaths[pathParts[1]] {
 
 			firstSubFolder := pathParts[1]
-			subSite, isSubSite := hs.siteMap[firstSubFolder]
+			subSite, isSubSite := hs.subSite(firstSubFolder)
 			if isSubSite {
 
 				permission := subSite.Permission
@@ -370,7 +668,7 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 				if permission.CanExecute(user.UserReferenceId, user.Groups) {
 					r.URL.Path = "/" + strings.Join(pathParts[2:], "/")
-					handler := hs.handlerMap[subSite.Hostname]
+					handler := hs.handler(firstSubFolder)
 					handler.ServeHTTP(w, r)
 				} else {
 					w.WriteHeader(403)
@@ -381,8 +679,8 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if !BeginsWithCheck(r.Host, "dashboard.") && !BeginsWithCheck(r.Host, "api.") {
-			handler, ok := hs.handlerMap["default"]
-			if !ok {
+			handler := hs.handler("default")
+			if handler == nil {
 				//log.Errorf("Failed to find default route")
 			} else {
 				handler.ServeHTTP(w, r)
@@ -391,8 +689,8 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		//log.Printf("Serving from dashboard")
-		handler, ok := hs.handlerMap["dashboard"]
-		if !ok {
+		handler := hs.handler("dashboard")
+		if handler == nil {
 			log.Errorf("Failed to find dashboard route")
 			return
 		}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daptin/daptin/server/resource"
+)
+
+func TestHostedSiteNeedsSync(t *testing.T) {
+	storeId := int64(1)
+	site := resource.SubSite{
+		Name:         "blog",
+		Hostname:     "blog.example.com,www.blog.example.com",
+		Path:         "blog",
+		CloudStoreId: &storeId,
+		Enable:       true,
+	}
+	cloudStore := resource.CloudStore{
+		Id:              storeId,
+		RootPath:        "local-store:/sites",
+		StoreProvider:   "local",
+		StoreParameters: map[string]interface{}{"region": "eu"},
+	}
+	hosted := &hostedSite{
+		information: resource.SubSiteInformation{SubSite: site, CloudStore: cloudStore},
+	}
+
+	hostnames := hosted.hostnames()
+	if len(hostnames) != 2 || hostnames[0] != "blog.example.com" || hostnames[1] != "www.blog.example.com" {
+		t.Errorf("unexpected hostnames %v", hostnames)
+	}
+
+	renamed := site
+	renamed.Name = "new blog"
+	renamed.FtpEnabled = true
+	if hosted.needsSync(renamed, cloudStore) {
+		t.Errorf("expected a change of the name and ftp access to keep the site folder")
+	}
+
+	moved := site
+	moved.Path = "new-blog"
+	if !hosted.needsSync(moved, cloudStore) {
+		t.Errorf("expected a change of the path to sync the site again")
+	}
+
+	otherHostname := site
+	otherHostname.Hostname = "blog.example.com"
+	if !hosted.needsSync(otherHostname, cloudStore) {
+		t.Errorf("expected a change of the hostname to sync the site again")
+	}
+
+	otherRegion := cloudStore
+	otherRegion.StoreParameters = map[string]interface{}{"region": "us"}
+	if !hosted.needsSync(site, otherRegion) {
+		t.Errorf("expected a change of the cloud store parameters to sync the site again")
+	}
+}
+
+func TestDebounceReloads(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	var reloads int32
+	go debounceReloads(requests, stop, 50*time.Millisecond, func() {
+		atomic.AddInt32(&reloads, 1)
+	})
+
+	// a burst of changes, as from the olric listener which must not block
+	for i := 0; i < 10; i++ {
+		select {
+		case requests <- struct{}{}:
+		default:
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if count := atomic.LoadInt32(&reloads); count != 1 {
+		t.Errorf("expected one reload for a burst of changes, got %d", count)
+	}
+
+	requests <- struct{}{}
+	time.Sleep(200 * time.Millisecond)
+	if count := atomic.LoadInt32(&reloads); count != 2 {
+		t.Errorf("expected a reload for a later change, got %d", count)
+	}
+
+	close(stop)
+	select {
+	case requests <- struct{}{}:
+	default:
+	}
+	time.Sleep(100 * time.Millisecond)
+	if count := atomic.LoadInt32(&reloads); count != 2 {
+		t.Errorf("expected no reload after stop, got %d", count)
+	}
+}
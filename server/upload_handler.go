@@ -0,0 +1,351 @@
+package server
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+// statusChecksumMismatch is the status tus uses when a chunk or the file does not match its checksum
+const statusChecksumMismatch = 460
+
+// ResumableUploadHandler implements the core, creation, termination, checksum and expiration parts of the
+// tus protocol on /upload. Chunks are appended to a file in the upload directory and the file is moved to
+// the column or cloud store it was uploaded for once complete
+type ResumableUploadHandler struct {
+	cruds           map[string]*resource.DbResource
+	uploadDirectory string
+	expiry          time.Duration
+	// locks keeps chunks of the same upload from being written at the same time
+	locks sync.Map
+}
+
+func NewResumableUploadHandler(cruds map[string]*resource.DbResource, uploadDirectory string, expiry time.Duration) *ResumableUploadHandler {
+	return &ResumableUploadHandler{
+		cruds:           cruds,
+		uploadDirectory: uploadDirectory,
+		expiry:          expiry,
+	}
+}
+
+// StartCleanup removes abandoned uploads every interval
+func (h *ResumableUploadHandler) StartCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			removed, err := h.cruds[resource.FILE_UPLOAD_TABLE_NAME].CleanupAbandonedUploads(h.expiry)
+			resource.CheckErr(err, "Failed to clean up abandoned uploads")
+			if removed > 0 {
+				log.Printf("Removed %d abandoned uploads", removed)
+			}
+		}
+	}()
+}
+
+// Create starts an upload of Upload-Length bytes. Upload-Metadata carries the filename, the target and
+// optionally the path in the column and the checksum of the whole file
+func (h *ResumableUploadHandler) Create(c *gin.Context) {
+	h.setProtocolHeaders(c)
+	user, ok := h.sessionUser(c)
+	if !ok {
+		return
+	}
+
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError("invalid Upload-Length", "400"))
+		return
+	}
+
+	metadata, err := resource.ParseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+		return
+	}
+
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	fileName, err = resource.CleanUploadFileName(fileName)
+	if err != nil {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+		return
+	}
+	path, err := resource.CleanUploadPath(metadata["path"])
+	if err != nil {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+		return
+	}
+	target, err := resource.ParseUploadTarget(metadata["target"])
+	if err != nil {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+		return
+	}
+	checksum := metadata["checksum"]
+	if checksum != "" {
+		_, _, err = resource.NewUploadChecksum(checksum)
+		if err != nil {
+			c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+			return
+		}
+	}
+
+	status, err := h.authorizeTarget(user, target)
+	if err != nil {
+		c.AbortWithStatusJSON(status, resource.NewDaptinError(err.Error(), fmt.Sprintf("%d", status)))
+		return
+	}
+
+	upload := resource.FileUpload{
+		FileName:      fileName,
+		Size:          size,
+		Target:        metadata["target"],
+		UserAccountId: user.UserId,
+	}
+	upload.Checksum.String, upload.Checksum.Valid = checksum, checksum != ""
+	upload.Path.String, upload.Path.Valid = path, true
+
+	err = h.cruds[resource.FILE_UPLOAD_TABLE_NAME].CreateFileUpload(&upload, h.uploadDirectory)
+	if resource.CheckErr(err, "Failed to create upload of [%v]", fileName) {
+		c.AbortWithStatus(500)
+		return
+	}
+
+	if size == 0 {
+		if !h.complete(c, upload) {
+			return
+		}
+	} else {
+		c.Header("Upload-Expires", h.expires())
+	}
+	c.Header("Location", "/upload/"+upload.ReferenceId)
+	c.Status(201)
+}
+
+// Head tells the client the offset to resume the upload from
+func (h *ResumableUploadHandler) Head(c *gin.Context) {
+	h.setProtocolHeaders(c)
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.Status(200)
+}
+
+// Patch appends a chunk starting at Upload-Offset, verified against Upload-Checksum when set. The upload
+// is completed with the last chunk
+func (h *ResumableUploadHandler) Patch(c *gin.Context) {
+	h.setProtocolHeaders(c)
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(415)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError("invalid Upload-Offset", "400"))
+		return
+	}
+	checksum := c.GetHeader("Upload-Checksum")
+	if checksum != "" {
+		_, _, err = resource.NewUploadChecksum(checksum)
+		if err != nil {
+			c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "400"))
+			return
+		}
+	}
+
+	lock := h.lock(c.Param("uploadId"))
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+	if upload.Status == resource.FileUploadStatusComplete {
+		if offset != upload.Size {
+			c.AbortWithStatus(409)
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Size, 10))
+		c.Status(204)
+		return
+	}
+
+	newOffset, err := resource.WriteUploadChunk(upload, offset, c.Request.Body, checksum)
+	switch err {
+	case nil:
+	case resource.ErrUploadOffsetMismatch:
+		c.AbortWithStatus(409)
+		return
+	case resource.ErrUploadChecksumMismatch:
+		c.AbortWithStatus(statusChecksumMismatch)
+		return
+	default:
+		log.Printf("Upload [%v] interrupted at %d: %v", upload.ReferenceId, newOffset, err)
+	}
+
+	if newOffset != upload.Offset {
+		offsetErr := h.cruds[resource.FILE_UPLOAD_TABLE_NAME].UpdateFileUploadOffset(upload, newOffset)
+		if resource.CheckErr(offsetErr, "Failed to store offset of upload [%v]", upload.ReferenceId) {
+			c.AbortWithStatus(500)
+			return
+		}
+	}
+	if err != nil {
+		c.AbortWithStatus(500)
+		return
+	}
+
+	if newOffset == upload.Size {
+		if !h.complete(c, upload) {
+			return
+		}
+	} else {
+		c.Header("Upload-Expires", h.expires())
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(204)
+}
+
+// Delete stops an upload and removes what was received
+func (h *ResumableUploadHandler) Delete(c *gin.Context) {
+	h.setProtocolHeaders(c)
+	lock := h.lock(c.Param("uploadId"))
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+
+	err := h.cruds[resource.FILE_UPLOAD_TABLE_NAME].DeleteFileUpload(upload)
+	if resource.CheckErr(err, "Failed to delete upload [%v]", upload.ReferenceId) {
+		c.AbortWithStatus(500)
+		return
+	}
+	h.locks.Delete(upload.ReferenceId)
+	c.Status(204)
+}
+
+// complete moves the file to its target, an upload not matching the checksum of the file is removed
+func (h *ResumableUploadHandler) complete(c *gin.Context, upload resource.FileUpload) bool {
+	uploads := h.cruds[resource.FILE_UPLOAD_TABLE_NAME]
+	user, _ := c.Request.Context().Value("user").(*auth.SessionUser)
+	err := uploads.CompleteFileUpload(upload, user)
+	if err == resource.ErrUploadChecksumMismatch {
+		resource.CheckErr(uploads.DeleteFileUpload(upload), "Failed to remove upload [%v]", upload.ReferenceId)
+		c.AbortWithStatusJSON(statusChecksumMismatch, resource.NewDaptinError("file does not match the checksum", "460"))
+		return false
+	}
+	if httpErr, ok := err.(api2go.HTTPError); ok {
+		c.AbortWithStatusJSON(httpErr.Status(), resource.NewDaptinError(err.Error(), fmt.Sprintf("%d", httpErr.Status())))
+		return false
+	}
+	if resource.CheckErr(err, "Failed to complete upload [%v]", upload.ReferenceId) {
+		c.AbortWithStatus(500)
+		return false
+	}
+	h.locks.Delete(upload.ReferenceId)
+	log.Printf("Upload [%v] of [%v] complete", upload.ReferenceId, upload.FileName)
+	return true
+}
+
+// authorizeTarget checks the user can update the row and the column the file is uploaded to, or the cloud store
+func (h *ResumableUploadHandler) authorizeTarget(user *auth.SessionUser, target resource.UploadTarget) (int, error) {
+
+	crud, ok := h.cruds[target.TypeName]
+	if !ok {
+		return 404, fmt.Errorf("unknown table [%v]", target.TypeName)
+	}
+	if !user.AllowsTable(target.TypeName, "PATCH") {
+		return 403, fmt.Errorf("forbidden")
+	}
+
+	if !target.IsCloudStore() {
+		column, ok := crud.TableInfo().GetColumnByName(target.ColumnName)
+		if !ok || !column.IsForeignKey || column.ForeignKeyData.DataSource != "cloud_store" {
+			return 400, fmt.Errorf("[%v] is not a file column of [%v]", target.ColumnName, target.TypeName)
+		}
+		if _, ok := crud.AssetFolderCache[target.TypeName][target.ColumnName]; !ok {
+			return 400, fmt.Errorf("column [%v][%v] has no file storage", target.TypeName, target.ColumnName)
+		}
+	}
+
+	row, _, err := crud.GetSingleRowByReferenceId(target.TypeName, target.ReferenceId, nil)
+	if err != nil {
+		return 404, fmt.Errorf("[%v] not found", target.ReferenceId)
+	}
+	if !crud.GetRowPermission(row).CanUpdate(user.UserReferenceId, user.Groups) {
+		return 403, fmt.Errorf("forbidden")
+	}
+	if !target.IsCloudStore() && !crud.IsAdmin(user.UserReferenceId) {
+		err = crud.CheckWritableColumns(user, map[string]interface{}{target.ColumnName: nil}, row)
+		if err != nil {
+			return 403, err
+		}
+	}
+	return 200, nil
+}
+
+// getUpload loads the upload of the request, uploads of other users are not found
+func (h *ResumableUploadHandler) getUpload(c *gin.Context) (resource.FileUpload, bool) {
+	user, ok := h.sessionUser(c)
+	if !ok {
+		return resource.FileUpload{}, false
+	}
+
+	upload, err := h.cruds[resource.FILE_UPLOAD_TABLE_NAME].GetFileUpload(c.Param("uploadId"))
+	if err != nil || upload.UserAccountId != user.UserId {
+		c.AbortWithStatus(404)
+		return upload, false
+	}
+	return upload, true
+}
+
+func (h *ResumableUploadHandler) sessionUser(c *gin.Context) (*auth.SessionUser, bool) {
+	if version := c.GetHeader("Tus-Resumable"); version != "" && version != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(412)
+		return nil, false
+	}
+	user, ok := c.Request.Context().Value("user").(*auth.SessionUser)
+	if !ok || user == nil || user.UserId == 0 {
+		c.AbortWithStatus(401)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *ResumableUploadHandler) lock(uploadId string) *sync.Mutex {
+	lock, _ := h.locks.LoadOrStore(uploadId, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func (h *ResumableUploadHandler) expires() string {
+	return time.Now().Add(h.expiry).UTC().Format(http.TimeFormat)
+}
+
+func (h *ResumableUploadHandler) setProtocolHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination,checksum,expiration")
+	c.Header("Tus-Checksum-Algorithm", strings.Join(resource.UploadChecksumAlgorithms, ","))
+	c.Header("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, "+
+		"Tus-Checksum-Algorithm, Upload-Offset, Upload-Length, Upload-Expires")
+}
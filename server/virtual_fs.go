@@ -0,0 +1,916 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/rclone/vfs"
+	"github.com/artpar/rclone/vfs/vfscommon"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"golang.org/x/crypto/ssh"
+)
+
+// CloudStoreFolder is the folder listing the roots of the cloud stores, next to the folders of the sites
+const CloudStoreFolder = "cloud_store"
+
+// ErrUploadQuotaExceeded is returned for writes beyond the upload quota of the user
+var ErrUploadQuotaExceeded = errors.New("upload quota exceeded")
+
+// cloudStoreActions are the cloud_store actions a user needs to be allowed to do the same change over the
+// REST api
+var cloudStoreActions = map[string]string{
+	resource.FtpOperationUpload: "upload_file",
+	resource.FtpOperationMkdir:  "create_folder",
+	resource.FtpOperationDelete: "delete_path",
+	resource.FtpOperationRename: "move_path",
+	resource.FtpOperationChmod:  "upload_file",
+	resource.FtpOperationMtime:  "upload_file",
+}
+
+// VirtualFs is the tree of files served over ftp, sftp and webdav. The root folder has a folder for each ftp
+// enabled site, and a cloud_store folder with the root path of each cloud store
+type VirtualFs struct {
+	cruds       map[string]*resource.DbResource
+	configStore *resource.ConfigStore
+	lock        sync.RWMutex
+	sites       map[string]SubSiteAssetCache
+	cloudStores map[string]resource.CloudStore
+	storeRoots  map[string]*cloudStoreRoot
+}
+
+// NewVirtualFs loads the ftp enabled sites and the cloud stores
+func NewVirtualFs(cruds map[string]*resource.DbResource, configStore *resource.ConfigStore) (*VirtualFs, error) {
+
+	virtualFs := &VirtualFs{
+		cruds:       cruds,
+		configStore: configStore,
+		sites:       make(map[string]SubSiteAssetCache),
+		cloudStores: make(map[string]resource.CloudStore),
+		storeRoots:  make(map[string]*cloudStoreRoot),
+	}
+
+	err := virtualFs.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return virtualFs, nil
+}
+
+// Reload loads the ftp enabled sites hosted here and the cloud stores again, after they are changed
+func (v *VirtualFs) Reload() error {
+
+	subsites, err := v.cruds["site"].GetAllSites()
+	if err != nil {
+		return err
+	}
+	sites := make([]SubSiteAssetCache, 0)
+	for _, site := range subsites {
+		if !site.FtpEnabled {
+			continue
+		}
+		assetCacheFolder := v.cruds["site"].SubsiteFolderCache.Get(site.ReferenceId)
+		if assetCacheFolder == nil {
+			continue
+		}
+		sites = append(sites, SubSiteAssetCache{
+			SubSite:          site,
+			AssetFolderCache: assetCacheFolder,
+		})
+	}
+
+	cloudStores, err := v.cruds["cloud_store"].GetAllCloudStores()
+	if err != nil {
+		return err
+	}
+
+	v.SetSites(sites)
+	v.SetCloudStores(cloudStores)
+	return nil
+}
+
+// SetSites replaces the sites in the root folder
+func (v *VirtualFs) SetSites(sites []SubSiteAssetCache) {
+	siteMap := make(map[string]SubSiteAssetCache)
+	for _, site := range sites {
+		siteMap[site.Hostname] = site
+	}
+	v.lock.Lock()
+	v.sites = siteMap
+	v.lock.Unlock()
+}
+
+// SetCloudStores replaces the cloud stores in the cloud_store folder
+func (v *VirtualFs) SetCloudStores(cloudStores []resource.CloudStore) {
+	storeMap := make(map[string]resource.CloudStore)
+	for _, cloudStore := range cloudStores {
+		storeMap[cloudStore.Name] = cloudStore
+	}
+	v.lock.Lock()
+	v.cloudStores = storeMap
+	for name, root := range v.storeRoots {
+		if cloudStore, ok := storeMap[name]; !ok || cloudStore.RootPath != root.cloudStore.RootPath {
+			root.vfs.Shutdown()
+			delete(v.storeRoots, name)
+		}
+	}
+	v.lock.Unlock()
+}
+
+// Sites are the sites in the root folder by hostname
+func (v *VirtualFs) Sites() map[string]SubSiteAssetCache {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.sites
+}
+
+func (v *VirtualFs) site(hostname string) (SubSiteAssetCache, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	site, ok := v.sites[hostname]
+	return site, ok && site.AssetFolderCache != nil
+}
+
+func (v *VirtualFs) cloudStore(name string) (resource.CloudStore, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	cloudStore, ok := v.cloudStores[name]
+	return cloudStore, ok
+}
+
+func (v *VirtualFs) listCloudStores() []resource.CloudStore {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	cloudStores := make([]resource.CloudStore, 0, len(v.cloudStores))
+	for _, cloudStore := range v.cloudStores {
+		cloudStores = append(cloudStores, cloudStore)
+	}
+	return cloudStores
+}
+
+// cloudStoreRoot opens the cloud store on first use, files written to the store are cached locally until they
+// are uploaded
+func (v *VirtualFs) cloudStoreRoot(cloudStore resource.CloudStore) (*cloudStoreRoot, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if root, ok := v.storeRoots[cloudStore.Name]; ok {
+		return root, nil
+	}
+	storeFs, err := v.cruds["cloud_store"].NewCloudStoreFs(context.Background(), cloudStore)
+	if err != nil {
+		return nil, err
+	}
+	options := vfscommon.DefaultOpt
+	// writes are cached on disk so clients can seek, and uploaded to the store as soon as the file is closed
+	options.CacheMode = vfscommon.CacheModeWrites
+	options.WriteBack = 0
+	root := &cloudStoreRoot{
+		cloudStore: cloudStore,
+		vfs:        vfs.New(storeFs, &options),
+	}
+	v.storeRoots[cloudStore.Name] = root
+	return root, nil
+}
+
+// CheckPassword checks the password of the user account with this email
+func (v *VirtualFs) CheckPassword(email string, password string, clientIp string, protocol string) error {
+	err := auth.LoginAttempts.Check(email, clientIp)
+	if err != nil {
+		return err
+	}
+
+	userAccount, err := v.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetUserAccountRowByEmail(email)
+	if err != nil {
+		auth.LoginAttempts.Failure(protocol, email, clientIp)
+		return err
+	}
+
+	if !resource.BcryptCheckStringHash(password, userAccount["password"].(string)) {
+		auth.LoginAttempts.Failure(protocol, email, clientIp)
+		return fmt.Errorf("could not authenticate you")
+	}
+	auth.LoginAttempts.Success(email)
+	return nil
+}
+
+// CheckPublicKey checks the key is one of the ssh keys of the user account with this email. A rejected key is
+// not counted as a failed login, clients offer their keys one by one, PublicKeyLoginFailed counts the connection
+// once none of them is accepted
+func (v *VirtualFs) CheckPublicKey(email string, key ssh.PublicKey, clientIp string, protocol string) error {
+	err := auth.LoginAttempts.Check(email, clientIp)
+	if err != nil {
+		return err
+	}
+
+	userAccount, err := v.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetUserAccountRowByEmail(email)
+	if err != nil {
+		return err
+	}
+	userId, _ := userAccount["id"].(int64)
+	userKeys, err := v.cruds[resource.SSH_KEY_TABLE_NAME].GetUserSshPublicKeys(userId)
+	if err != nil {
+		return err
+	}
+	for _, userKey := range userKeys {
+		if userKey.Type() == key.Type() && string(userKey.Marshal()) == string(key.Marshal()) {
+			auth.LoginAttempts.Success(email)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown public key for %v", email)
+}
+
+// PublicKeyLoginFailed counts a connection whose public keys were all rejected as one failed login
+func (v *VirtualFs) PublicKeyLoginFailed(email string, clientIp string, protocol string) {
+	auth.LoginAttempts.Failure(protocol, email, clientIp)
+}
+
+// NewSession starts a session for an authenticated user
+func (v *VirtualFs) NewSession(email string, clientIp string, protocol string) (*VirtualFsSession, error) {
+	userAccount, err := v.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetUserAccountRowByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	userId, _ := userAccount["id"].(int64)
+	userReferenceId, _ := userAccount["reference_id"].(string)
+
+	return v.newSession(&auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: userReferenceId,
+		Groups:          v.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetObjectUserGroupsByWhere("user_account", "id", userId),
+	}, email, clientIp, protocol), nil
+}
+
+// SessionFor starts a session for the user of an api request
+func (v *VirtualFs) SessionFor(sessionUser *auth.SessionUser, clientIp string, protocol string) (*VirtualFsSession, error) {
+	userAccount, _, err := v.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetSingleRowByReferenceId("user_account", sessionUser.UserReferenceId, nil)
+	if err != nil {
+		return nil, err
+	}
+	email, _ := userAccount["email"].(string)
+	return v.newSession(sessionUser, email, clientIp, protocol), nil
+}
+
+func (v *VirtualFs) newSession(sessionUser *auth.SessionUser, email string, clientIp string, protocol string) *VirtualFsSession {
+	return &VirtualFsSession{
+		fs:          v,
+		SessionUser: sessionUser,
+		IsAdmin:     v.cruds[resource.USER_ACCOUNT_TABLE_NAME].IsAdmin(sessionUser.UserReferenceId),
+		ClientIp:    clientIp,
+		Protocol:    protocol,
+		UploadQuota: v.uploadQuota(email),
+	}
+}
+
+// uploadQuota is the ftp.upload.quota.<email> config of the user, or ftp.upload.quota when the user has none
+func (v *VirtualFs) uploadQuota(email string) int64 {
+	if v.configStore == nil {
+		return 0
+	}
+	quota, err := v.configStore.GetConfigIntValueFor("ftp.upload.quota."+email, "backend")
+	if err != nil {
+		quota, err = v.configStore.GetConfigIntValueFor("ftp.upload.quota", "backend")
+		if err != nil {
+			return 0
+		}
+	}
+	return int64(quota)
+}
+
+// VirtualFsSession is the virtual filesystem as seen by one user. Sites are visible to users who can read the
+// site row and changed by users who can update it. Cloud stores are visible to users who can read the
+// cloud_store row and changed by users allowed the matching cloud_store action
+type VirtualFsSession struct {
+	fs          *VirtualFs
+	SessionUser *auth.SessionUser
+	IsAdmin     bool
+	ClientIp    string
+	Protocol    string
+	UploadQuota int64 // bytes the user can upload in 24 hours, 0 for no limit
+}
+
+// VirtualFile is a file or folder opened in the virtual filesystem
+type VirtualFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Readdir(count int) ([]os.FileInfo, error)
+	Stat() (os.FileInfo, error)
+}
+
+// vfsRoot is a site folder or a cloud store, names are slash separated paths below the root
+type vfsRoot interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	OpenFile(name string, flag int, perm os.FileMode) (VirtualFile, error)
+	Mkdir(name string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(from string, to string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, mtime time.Time) error
+}
+
+// vfsPath is a path resolved to the site or cloud store it belongs to, root is nil for the virtual folders
+type vfsPath struct {
+	root         vfsRoot
+	name         string
+	siteId       string
+	cloudStoreId string
+}
+
+func (p vfsPath) sameRoot(other vfsPath) bool {
+	return p.siteId == other.siteId && p.cloudStoreId == other.cloudStoreId
+}
+
+// resolve finds the site or cloud store of a path and checks the user can do the operation there, "" is a
+// read. Paths the user cannot read are reported as missing
+func (s *VirtualFsSession) resolve(virtualPath string, operation string) (vfsPath, error) {
+	virtualPath = path.Clean("/" + virtualPath)
+	parts := strings.Split(strings.Trim(virtualPath, "/"), "/")
+	notFound := &os.PathError{Op: "open", Path: virtualPath, Err: os.ErrNotExist}
+	notAllowed := &os.PathError{Op: operation, Path: virtualPath, Err: os.ErrPermission}
+
+	if parts[0] == "" || (parts[0] == CloudStoreFolder && len(parts) == 1) {
+		if operation != "" {
+			return vfsPath{}, notAllowed
+		}
+		return vfsPath{}, nil
+	}
+
+	if parts[0] == CloudStoreFolder {
+		cloudStore, ok := s.fs.cloudStore(parts[1])
+		if !ok || !s.canAccessCloudStore(cloudStore, "") {
+			return vfsPath{}, notFound
+		}
+		name := strings.Join(parts[2:], "/")
+		if operation != "" && (name == "" || !s.canAccessCloudStore(cloudStore, operation)) {
+			return vfsPath{}, notAllowed
+		}
+		root, err := s.fs.cloudStoreRoot(cloudStore)
+		if err != nil {
+			return vfsPath{}, err
+		}
+		return vfsPath{root: root, name: name, cloudStoreId: cloudStore.ReferenceId}, nil
+	}
+
+	site, ok := s.fs.site(parts[0])
+	if !ok || !s.canAccessSite(site, false) {
+		return vfsPath{}, notFound
+	}
+	name := strings.Join(parts[1:], "/")
+	if operation != "" && (name == "" || !s.canAccessSite(site, true)) {
+		return vfsPath{}, notAllowed
+	}
+	return vfsPath{root: siteRoot{site}, name: name, siteId: site.ReferenceId}, nil
+}
+
+// canAccessSite checks the permission of the user on the site row, update permission is needed for changes
+func (s *VirtualFsSession) canAccessSite(site SubSiteAssetCache, update bool) bool {
+	method := "GET"
+	if update {
+		method = "PATCH"
+	}
+	if !s.SessionUser.AllowsTable("site", method) {
+		return false
+	}
+	if s.IsAdmin {
+		return true
+	}
+	permission := s.fs.cruds["site"].GetObjectPermissionByReferenceId("site", site.ReferenceId)
+	if update {
+		return permission.CanUpdate(s.SessionUser.UserReferenceId, s.SessionUser.Groups)
+	}
+	return permission.CanRead(s.SessionUser.UserReferenceId, s.SessionUser.Groups)
+}
+
+// canAccessCloudStore checks the permission of the user on the cloud_store row, changes need the same
+// permissions as the cloud_store action doing the change
+func (s *VirtualFsSession) canAccessCloudStore(cloudStore resource.CloudStore, operation string) bool {
+	actionName := cloudStoreActions[operation]
+	if operation == "" && !s.SessionUser.AllowsTable("cloud_store", "GET") {
+		return false
+	}
+	if operation != "" && !s.SessionUser.AllowsAction(actionName) {
+		return false
+	}
+	if s.IsAdmin {
+		return true
+	}
+	userId, groups := s.SessionUser.UserReferenceId, s.SessionUser.Groups
+	permission := s.fs.cruds["cloud_store"].GetObjectPermissionByReferenceId("cloud_store", cloudStore.ReferenceId)
+	if operation == "" {
+		return permission.CanRead(userId, groups)
+	}
+	return permission.CanExecute(userId, groups) &&
+		s.fs.cruds["cloud_store"].IsUserActionAllowed(userId, groups, "cloud_store", actionName)
+}
+
+// audit stores a change in the ftp_change_log table
+func (s *VirtualFsSession) audit(target vfsPath, operation string, newPath string, size int64) {
+	auditResource, ok := s.fs.cruds[resource.FTP_CHANGE_LOG_TABLE_NAME]
+	if !ok {
+		return
+	}
+	err := auditResource.InsertFtpChangeLog(resource.FtpChangeLogEntry{
+		SiteId:          target.siteId,
+		CloudStoreId:    target.cloudStoreId,
+		Protocol:        s.Protocol,
+		UserReferenceId: s.SessionUser.UserReferenceId,
+		Operation:       operation,
+		Path:            target.name,
+		NewPath:         newPath,
+		Size:            size,
+		ClientIp:        s.ClientIp,
+	})
+	resource.CheckErr(err, "Failed to store %v audit for [%v] of [%v]", s.Protocol, operation, target.name)
+}
+
+// RemainingQuota is the number of bytes the user can still upload, -1 when there is no limit
+func (s *VirtualFsSession) RemainingQuota() (int64, error) {
+	if s.UploadQuota <= 0 {
+		return -1, nil
+	}
+	uploaded, err := s.fs.cruds[resource.FTP_CHANGE_LOG_TABLE_NAME].GetFtpUploadedBytes(
+		s.SessionUser.UserReferenceId, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+	if uploaded >= s.UploadQuota {
+		return 0, nil
+	}
+	return s.UploadQuota - uploaded, nil
+}
+
+// CanAllocate checks the upload quota of the user allows a file of this size
+func (s *VirtualFsSession) CanAllocate(size int64) (bool, error) {
+	remainingQuota, err := s.RemainingQuota()
+	if err != nil {
+		return false, err
+	}
+	return remainingQuota < 0 || size <= remainingQuota, nil
+}
+
+// ReadDir lists the files of a folder
+func (s *VirtualFsSession) ReadDir(virtualPath string) ([]os.FileInfo, error) {
+	target, err := s.resolve(virtualPath, "")
+	if err != nil {
+		return nil, err
+	}
+	if target.root != nil {
+		return target.root.ReadDir(target.name)
+	}
+
+	cloudStores := make([]os.FileInfo, 0)
+	for _, cloudStore := range s.fs.listCloudStores() {
+		if s.canAccessCloudStore(cloudStore, "") {
+			cloudStores = append(cloudStores, virtualFileInfo{name: cloudStore.Name, mode: os.FileMode(0755) | os.ModeDir})
+		}
+	}
+
+	files := cloudStores
+	if strings.Trim(path.Clean("/"+virtualPath), "/") != CloudStoreFolder {
+		files = make([]os.FileInfo, 0)
+		for hostname, site := range s.fs.Sites() {
+			if site.AssetFolderCache != nil && s.canAccessSite(site, false) {
+				files = append(files, virtualFileInfo{name: hostname, mode: os.FileMode(0755) | os.ModeDir})
+			}
+		}
+		if len(cloudStores) > 0 {
+			files = append(files, virtualFileInfo{name: CloudStoreFolder, mode: os.FileMode(0755) | os.ModeDir})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// Stat describes a file or a folder
+func (s *VirtualFsSession) Stat(virtualPath string) (os.FileInfo, error) {
+	target, err := s.resolve(virtualPath, "")
+	if err != nil {
+		return nil, err
+	}
+	if target.root == nil {
+		return virtualFileInfo{name: path.Base(path.Clean("/" + virtualPath)), mode: os.FileMode(0755) | os.ModeDir}, nil
+	}
+	return target.root.Stat(target.name)
+}
+
+// OpenFile opens a file or a folder, files opened for writing count against the upload quota and are audited
+// when closed
+func (s *VirtualFsSession) OpenFile(virtualPath string, flag int, perm os.FileMode) (VirtualFile, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+	operation := ""
+	if writing {
+		operation = resource.FtpOperationUpload
+	}
+	target, err := s.resolve(virtualPath, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.root == nil {
+		files, err := s.ReadDir(virtualPath)
+		if err != nil {
+			return nil, err
+		}
+		info, _ := s.Stat(virtualPath)
+		return &virtualDirectory{info: info, files: files}, nil
+	}
+	if !writing {
+		return target.root.OpenFile(target.name, flag, perm)
+	}
+
+	remainingQuota, err := s.RemainingQuota()
+	if err != nil {
+		return nil, err
+	}
+	if remainingQuota == 0 {
+		return nil, ErrUploadQuotaExceeded
+	}
+	file, err := target.root.OpenFile(target.name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &auditedFile{
+		VirtualFile:    file,
+		session:        s,
+		target:         target,
+		remainingQuota: remainingQuota,
+	}, nil
+}
+
+// Mkdir creates a folder
+func (s *VirtualFsSession) Mkdir(virtualPath string) error {
+	target, err := s.resolve(virtualPath, resource.FtpOperationMkdir)
+	if err != nil {
+		return err
+	}
+	err = target.root.Mkdir(target.name)
+	if err == nil {
+		s.audit(target, resource.FtpOperationMkdir, "", 0)
+	}
+	return err
+}
+
+// Remove deletes a file or an empty folder
+func (s *VirtualFsSession) Remove(virtualPath string) error {
+	target, err := s.resolve(virtualPath, resource.FtpOperationDelete)
+	if err != nil {
+		return err
+	}
+	err = target.root.Remove(target.name)
+	if err == nil {
+		s.audit(target, resource.FtpOperationDelete, "", 0)
+	}
+	return err
+}
+
+// RemoveAll deletes a file or a folder with everything in it
+func (s *VirtualFsSession) RemoveAll(virtualPath string) error {
+	target, err := s.resolve(virtualPath, resource.FtpOperationDelete)
+	if err != nil {
+		return err
+	}
+	err = target.root.RemoveAll(target.name)
+	if err == nil {
+		s.audit(target, resource.FtpOperationDelete, "", 0)
+	}
+	return err
+}
+
+// Rename moves a file or a folder, both paths need to be in the same site or cloud store
+func (s *VirtualFsSession) Rename(from string, to string) error {
+	source, err := s.resolve(from, resource.FtpOperationRename)
+	if err != nil {
+		return err
+	}
+	destination, err := s.resolve(to, resource.FtpOperationRename)
+	if err != nil {
+		return err
+	}
+	if !source.sameRoot(destination) {
+		return errors.New("cannot move files between sites or cloud stores")
+	}
+	err = source.root.Rename(source.name, destination.name)
+	if err == nil {
+		s.audit(source, resource.FtpOperationRename, destination.name, 0)
+	}
+	return err
+}
+
+// Chmod changes the mode of a file
+func (s *VirtualFsSession) Chmod(virtualPath string, mode os.FileMode) error {
+	target, err := s.resolve(virtualPath, resource.FtpOperationChmod)
+	if err != nil {
+		return err
+	}
+	err = target.root.Chmod(target.name, mode)
+	if err == nil {
+		s.audit(target, resource.FtpOperationChmod, "", 0)
+	}
+	return err
+}
+
+// Chtimes changes the modification time of a file
+func (s *VirtualFsSession) Chtimes(virtualPath string, mtime time.Time) error {
+	target, err := s.resolve(virtualPath, resource.FtpOperationMtime)
+	if err != nil {
+		return err
+	}
+	err = target.root.Chtimes(target.name, mtime)
+	if err == nil {
+		s.audit(target, resource.FtpOperationMtime, "", 0)
+	}
+	return err
+}
+
+// auditedFile is a file opened for writing, the written bytes count against the upload quota of the user and
+// the upload is audited once the file is closed
+type auditedFile struct {
+	VirtualFile
+	session        *VirtualFsSession
+	target         vfsPath
+	lock           sync.Mutex
+	written        int64
+	remainingQuota int64 // -1 for no limit
+}
+
+func (f *auditedFile) allocate(size int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.remainingQuota >= 0 && f.written+int64(size) > f.remainingQuota {
+		return ErrUploadQuotaExceeded
+	}
+	f.written += int64(size)
+	return nil
+}
+
+func (f *auditedFile) Write(buffer []byte) (int, error) {
+	if err := f.allocate(len(buffer)); err != nil {
+		return 0, err
+	}
+	return f.VirtualFile.Write(buffer)
+}
+
+func (f *auditedFile) WriteAt(buffer []byte, offset int64) (int, error) {
+	if err := f.allocate(len(buffer)); err != nil {
+		return 0, err
+	}
+	return f.VirtualFile.WriteAt(buffer, offset)
+}
+
+func (f *auditedFile) Close() error {
+	err := f.VirtualFile.Close()
+	f.session.audit(f.target, resource.FtpOperationUpload, "", f.written)
+	return err
+}
+
+// siteRoot is the cache folder of a site, changes are recorded for sites syncing back to their cloud store
+type siteRoot struct {
+	site SubSiteAssetCache
+}
+
+func (r siteRoot) localPath(name string) string {
+	return filepath.Join(r.site.LocalSyncPath, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (r siteRoot) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(r.localPath(name))
+}
+
+func (r siteRoot) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(r.localPath(name))
+}
+
+func (r siteRoot) OpenFile(name string, flag int, perm os.FileMode) (VirtualFile, error) {
+	file, err := os.OpenFile(r.localPath(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return file, nil
+	}
+	return &changeTrackingFile{File: file, folder: r.site.AssetFolderCache}, nil
+}
+
+func (r siteRoot) Mkdir(name string) error {
+	err := os.Mkdir(r.localPath(name), 0750)
+	if err == nil {
+		r.site.RecordChange(r.localPath(name), resource.LocalChangeMkdir)
+	}
+	return err
+}
+
+func (r siteRoot) Remove(name string) error {
+	err := os.Remove(r.localPath(name))
+	if err == nil {
+		r.site.RecordChange(r.localPath(name), resource.LocalChangeDelete)
+	}
+	return err
+}
+
+func (r siteRoot) RemoveAll(name string) error {
+	// the files below the folder are recorded before they are gone
+	r.site.RecordTreeChange(r.localPath(name), resource.LocalChangeDelete)
+	return os.RemoveAll(r.localPath(name))
+}
+
+func (r siteRoot) Rename(from string, to string) error {
+	fromPath, toPath := r.localPath(from), r.localPath(to)
+	info, err := os.Stat(fromPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// the files below the folder are gone from their old paths too
+		r.site.RecordTreeChange(fromPath, resource.LocalChangeDelete)
+	} else {
+		r.site.RecordChange(fromPath, resource.LocalChangeDelete)
+	}
+
+	err = os.Rename(fromPath, toPath)
+	if err != nil {
+		return err
+	}
+	r.site.RecordTreeChange(toPath, resource.LocalChangeWrite)
+	return nil
+}
+
+func (r siteRoot) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(r.localPath(name), mode)
+}
+
+func (r siteRoot) Chtimes(name string, mtime time.Time) error {
+	err := os.Chtimes(r.localPath(name), mtime, mtime)
+	if err == nil {
+		r.site.RecordChange(r.localPath(name), resource.LocalChangeWrite)
+	}
+	return err
+}
+
+// changeTrackingFile records a file written in a site folder as changed once it is closed
+type changeTrackingFile struct {
+	*os.File
+	folder *resource.AssetFolderCache
+}
+
+func (f *changeTrackingFile) Close() error {
+	err := f.File.Close()
+	if f.folder != nil {
+		f.folder.RecordChange(f.Name(), resource.LocalChangeWrite)
+	}
+	return err
+}
+
+// cloudStoreRoot is the root path of a cloud store
+type cloudStoreRoot struct {
+	cloudStore resource.CloudStore
+	vfs        *vfs.VFS
+}
+
+func (r *cloudStoreRoot) Stat(name string) (os.FileInfo, error) {
+	return r.vfs.Stat(name)
+}
+
+func (r *cloudStoreRoot) ReadDir(name string) ([]os.FileInfo, error) {
+	return r.vfs.ReadDir(name)
+}
+
+func (r *cloudStoreRoot) OpenFile(name string, flag int, perm os.FileMode) (VirtualFile, error) {
+	handle, err := r.vfs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+func (r *cloudStoreRoot) Mkdir(name string) error {
+	return r.vfs.Mkdir(name, 0755)
+}
+
+func (r *cloudStoreRoot) Remove(name string) error {
+	return r.vfs.Remove(name)
+}
+
+func (r *cloudStoreRoot) RemoveAll(name string) error {
+	node, err := r.vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	return node.RemoveAll()
+}
+
+func (r *cloudStoreRoot) Rename(from string, to string) error {
+	return r.vfs.Rename(from, to)
+}
+
+// Chmod is ignored, cloud stores have no file modes
+func (r *cloudStoreRoot) Chmod(name string, mode os.FileMode) error {
+	_, err := r.vfs.Stat(name)
+	return err
+}
+
+func (r *cloudStoreRoot) Chtimes(name string, mtime time.Time) error {
+	node, err := r.vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	return node.SetModTime(mtime)
+}
+
+// virtualDirectory is an open virtual folder, the root folder or the cloud_store folder
+type virtualDirectory struct {
+	info   os.FileInfo
+	files  []os.FileInfo
+	offset int
+}
+
+func (d *virtualDirectory) Read(buffer []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *virtualDirectory) ReadAt(buffer []byte, offset int64) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *virtualDirectory) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *virtualDirectory) WriteAt(buffer []byte, offset int64) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *virtualDirectory) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (d *virtualDirectory) Close() error {
+	return nil
+}
+
+func (d *virtualDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := d.files[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.files)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+func (d *virtualDirectory) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+type virtualFileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func (f virtualFileInfo) Name() string {
+	return f.name
+}
+
+func (f virtualFileInfo) Size() int64 {
+	return f.size
+}
+
+func (f virtualFileInfo) Mode() os.FileMode {
+	return f.mode
+}
+
+func (f virtualFileInfo) IsDir() bool {
+	return f.mode.IsDir()
+}
+
+func (f virtualFileInfo) ModTime() time.Time {
+	return time.Now().UTC()
+}
+
+func (f virtualFileInfo) Sys() interface{} {
+	return nil
+}
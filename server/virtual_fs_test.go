@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/artpar/rclone/backend/local"
+	"github.com/artpar/rclone/fs/config/configfile"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"golang.org/x/net/webdav"
+)
+
+// newTestVirtualFs serves a site folder and a local cloud store to an administrator
+func newTestVirtualFs(t *testing.T) (*VirtualFsSession, string, string) {
+	configfile.LoadConfig(context.Background())
+	sitePath, err := ioutil.TempDir("", "site")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	storePath, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(sitePath)
+		os.RemoveAll(storePath)
+	})
+
+	virtualFs := &VirtualFs{
+		cruds:      map[string]*resource.DbResource{"cloud_store": {}},
+		storeRoots: make(map[string]*cloudStoreRoot),
+	}
+	virtualFs.SetSites([]SubSiteAssetCache{{
+		SubSite:          resource.SubSite{Hostname: "site.example.com", ReferenceId: "s1"},
+		AssetFolderCache: &resource.AssetFolderCache{LocalSyncPath: sitePath},
+	}})
+	virtualFs.SetCloudStores([]resource.CloudStore{
+		{Name: "backups", ReferenceId: "c1", StoreProvider: "local", RootPath: storePath},
+	})
+
+	session := &VirtualFsSession{
+		fs:          virtualFs,
+		SessionUser: &auth.SessionUser{UserReferenceId: "u1"},
+		IsAdmin:     true,
+		Protocol:    "sftp",
+	}
+	return session, sitePath, storePath
+}
+
+func writeVirtualFile(t *testing.T, session *VirtualFsSession, virtualPath string, contents string) {
+	file, err := session.OpenFile(virtualPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err == nil {
+		_, err = file.Write([]byte(contents))
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to write [%v]: %v", virtualPath, err)
+	}
+}
+
+func TestVirtualFsSession(t *testing.T) {
+	session, sitePath, storePath := newTestVirtualFs(t)
+
+	files, err := session.ReadDir("/")
+	if err != nil || len(files) != 2 || files[0].Name() != CloudStoreFolder || files[1].Name() != "site.example.com" {
+		t.Fatalf("unexpected root folder %v %v", files, err)
+	}
+	files, err = session.ReadDir("/cloud_store")
+	if err != nil || len(files) != 1 || files[0].Name() != "backups" {
+		t.Fatalf("unexpected cloud store folder %v %v", files, err)
+	}
+
+	err = session.Mkdir("/site.example.com/posts")
+	if err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	writeVirtualFile(t, session, "/site.example.com/posts/hello.md", "hello")
+	err = session.Rename("/site.example.com/posts/hello.md", "/site.example.com/hello.md")
+	if err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(sitePath, "hello.md"))
+	if string(contents) != "hello" {
+		t.Errorf("unexpected site file [%s]", contents)
+	}
+
+	writeVirtualFile(t, session, "/cloud_store/backups/dump.sql", "select 1")
+	session.fs.storeRoots["backups"].vfs.WaitForWriters(10 * time.Second)
+	contents, _ = ioutil.ReadFile(filepath.Join(storePath, "dump.sql"))
+	if string(contents) != "select 1" {
+		t.Errorf("unexpected cloud store file [%s]", contents)
+	}
+	info, err := session.Stat("/cloud_store/backups/dump.sql")
+	if err != nil || info.Size() != 8 {
+		t.Errorf("unexpected cloud store file info %v %v", info, err)
+	}
+
+	for _, invalid := range []string{
+		"/site.example.com/../other.example.com/a.txt", "/cloud_store/other/a.txt", "/missing",
+	} {
+		if _, err = session.Stat(invalid); !os.IsNotExist(err) {
+			t.Errorf("expected [%v] to not exist, got %v", invalid, err)
+		}
+	}
+	if err = session.Rename("/site.example.com/hello.md", "/cloud_store/backups/hello.md"); err == nil {
+		t.Errorf("expected renames between a site and a cloud store to fail")
+	}
+	for _, invalid := range []string{"/", "/site.example.com", "/cloud_store"} {
+		if err = session.Mkdir(invalid); !os.IsPermission(err) {
+			t.Errorf("expected [%v] to not be writable, got %v", invalid, err)
+		}
+	}
+}
+
+func TestVirtualFsUploadQuota(t *testing.T) {
+	session, _, _ := newTestVirtualFs(t)
+	file, err := session.OpenFile("/site.example.com/big.bin", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	file.(*auditedFile).remainingQuota = 4
+
+	n, err := file.Write([]byte("abc"))
+	if err != nil || n != 3 {
+		t.Errorf("expected the write to fit the quota %v %v", n, err)
+	}
+	_, err = file.Write([]byte("de"))
+	if err != ErrUploadQuotaExceeded {
+		t.Errorf("expected the write to exceed the quota, got %v", err)
+	}
+	file.Close()
+}
+
+func TestWebDavFileSystem(t *testing.T) {
+	session, sitePath, _ := newTestVirtualFs(t)
+	handler := &webdav.Handler{
+		Prefix:     "/webdav",
+		FileSystem: webDavFileSystem{session: session},
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	for _, test := range []struct {
+		method      string
+		path        string
+		body        string
+		destination string
+		status      int
+	}{
+		{"MKCOL", "/webdav/site.example.com/docs", "", "", http.StatusCreated},
+		{"PUT", "/webdav/site.example.com/docs/a.txt", "webdav", "", http.StatusCreated},
+		{"MOVE", "/webdav/site.example.com/docs/a.txt", "", "/webdav/site.example.com/b.txt", http.StatusCreated},
+		{"PROPFIND", "/webdav/", "", "", http.StatusMultiStatus},
+		{"GET", "/webdav/site.example.com/b.txt", "", "", http.StatusOK},
+		{"PUT", "/webdav/unknown.example.com/c.txt", "webdav", "", http.StatusNotFound},
+		{"DELETE", "/webdav/site.example.com/docs", "", "", http.StatusNoContent},
+	} {
+		request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.destination != "" {
+			request.Header.Set("Destination", test.destination)
+		}
+		if test.method == "PROPFIND" {
+			request.Header.Set("Depth", "1")
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != test.status {
+			t.Errorf("expected %v %v to return %v, got %v %v", test.method, test.path, test.status,
+				response.Code, response.Body.String())
+		}
+		if test.method == "PROPFIND" && !strings.Contains(response.Body.String(), "site.example.com") {
+			t.Errorf("expected the site in the root folder listing %v", response.Body.String())
+		}
+	}
+
+	contents, _ := ioutil.ReadFile(filepath.Join(sitePath, "b.txt"))
+	if string(contents) != "webdav" {
+		t.Errorf("unexpected file contents [%s]", contents)
+	}
+	if _, err := os.Stat(filepath.Join(sitePath, "docs")); !os.IsNotExist(err) {
+		t.Errorf("expected the folder to be deleted")
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// WebDavMethods are the http methods of the webdav protocol
+var WebDavMethods = []string{
+	"OPTIONS", "GET", "HEAD", "POST", "DELETE", "PUT", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "PROPFIND", "PROPPATCH",
+}
+
+// CreateWebDavHandler serves the virtual filesystem of the sites and cloud stores over webdav at /webdav.
+// Requests without a token can use basic auth with the email and password of the user
+func CreateWebDavHandler(virtualFs *VirtualFs, authMiddleware *auth.AuthMiddleware) func(*gin.Context) {
+
+	lockSystem := webdav.NewMemLS()
+
+	return func(c *gin.Context) {
+
+		request := c.Request
+		sessionUser, ok := request.Context().Value("user").(*auth.SessionUser)
+		if !ok || sessionUser == nil || sessionUser.UserReferenceId == "" {
+			okToContinue, abort, modifiedRequest := authMiddleware.AuthCheckMiddlewareWithHttp(request, c.Writer, true)
+			if okToContinue && !abort {
+				request = modifiedRequest
+			}
+			sessionUser, ok = request.Context().Value("user").(*auth.SessionUser)
+		}
+		if !ok || sessionUser == nil || sessionUser.UserReferenceId == "" {
+			c.Header("WWW-Authenticate", `Basic realm="daptin"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		session, err := virtualFs.SessionFor(sessionUser, auth.RequestClientIp(request), "webdav")
+		if err != nil {
+			log.Printf("Failed to start webdav session for [%v]: %v", sessionUser.UserReferenceId, err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler := &webdav.Handler{
+			Prefix:     "/webdav",
+			FileSystem: webDavFileSystem{session: session},
+			LockSystem: lockSystem,
+		}
+		handler.ServeHTTP(c.Writer, request)
+	}
+}
+
+// webDavFileSystem maps webdav requests to the virtual filesystem session
+type webDavFileSystem struct {
+	session *VirtualFsSession
+}
+
+func (w webDavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return w.session.Mkdir(name)
+}
+
+func (w webDavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := w.session.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (w webDavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return w.session.RemoveAll(name)
+}
+
+func (w webDavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return w.session.Rename(oldName, newName)
+}
+
+func (w webDavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return w.session.Stat(name)
+}
@@ -0,0 +1,119 @@
+package websockets
+
+import (
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/resource"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// DefaultEventLogSize is the number of recent messages kept for every topic
+const DefaultEventLogSize = 1000
+
+// EventLog keeps the last few messages published on every topic, so that a client which reconnects
+// with the id of the last message it saw can be sent the messages it missed
+type EventLog struct {
+	size   int
+	topics map[string]*topicEventLog
+	lock   sync.RWMutex
+}
+
+type topicEventLog struct {
+	messages []resource.EventMessage
+	// index of the oldest message in messages once the log is full
+	start int
+	lock  sync.RWMutex
+}
+
+func NewEventLog(size int) *EventLog {
+	if size < 1 {
+		size = DefaultEventLogSize
+	}
+	return &EventLog{
+		size:   size,
+		topics: make(map[string]*topicEventLog),
+	}
+}
+
+// Watch starts recording the messages published on the topic
+func (el *EventLog) Watch(topicName string, topic *olric.DTopic) {
+
+	el.lock.Lock()
+	if _, ok := el.topics[topicName]; ok {
+		el.lock.Unlock()
+		return
+	}
+	el.topics[topicName] = &topicEventLog{
+		messages: make([]resource.EventMessage, 0),
+	}
+	el.lock.Unlock()
+
+	_, err := topic.AddListener(func(message olric.DTopicMessage) {
+		eventMessage, ok := message.Message.(resource.EventMessage)
+		if !ok {
+			return
+		}
+		el.Append(topicName, eventMessage)
+	})
+	resource.CheckErr(err, "Failed to record events of topic [%v]", topicName)
+}
+
+// Forget drops the messages recorded for a destroyed topic
+func (el *EventLog) Forget(topicName string) {
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	delete(el.topics, topicName)
+}
+
+func (el *EventLog) Append(topicName string, message resource.EventMessage) {
+	if message.EventId == "" {
+		return
+	}
+
+	el.lock.RLock()
+	topicLog, ok := el.topics[topicName]
+	el.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	topicLog.lock.Lock()
+	defer topicLog.lock.Unlock()
+
+	if len(topicLog.messages) < el.size {
+		topicLog.messages = append(topicLog.messages, message)
+		return
+	}
+	topicLog.messages[topicLog.start] = message
+	topicLog.start = (topicLog.start + 1) % el.size
+}
+
+// Since returns the messages recorded on the topic after the message with lastEventId, oldest first.
+// complete is false when lastEventId is no longer in the log, in which case all the recorded
+// messages are returned and some messages were possibly missed
+func (el *EventLog) Since(topicName string, lastEventId string) (messages []resource.EventMessage, complete bool) {
+
+	el.lock.RLock()
+	topicLog, ok := el.topics[topicName]
+	el.lock.RUnlock()
+	if !ok {
+		log.Printf("No event log for topic [%v]", topicName)
+		return []resource.EventMessage{}, false
+	}
+
+	topicLog.lock.RLock()
+	defer topicLog.lock.RUnlock()
+
+	count := len(topicLog.messages)
+	ordered := make([]resource.EventMessage, 0, count)
+	for i := 0; i < count; i++ {
+		ordered = append(ordered, topicLog.messages[(topicLog.start+i)%count])
+	}
+
+	for i, message := range ordered {
+		if message.EventId == lastEventId {
+			return ordered[i+1:], true
+		}
+	}
+	return ordered, false
+}
@@ -0,0 +1,462 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/query"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// presenceName is the name of the olric DMap holding the members of every room and of the topic changes
+// are broadcast on to every node
+const presenceName = "presence"
+
+// DefaultPresenceTimeout is how long a member stays in a room without a heartbeat
+const DefaultPresenceTimeout = 30 * time.Second
+
+// PresenceMember is a connection in a room, State is the ephemeral state set by the client like the
+// cursor position or whether the user is typing
+type PresenceMember struct {
+	Id              string                 `json:"id"`
+	UserReferenceId string                 `json:"user_reference_id"`
+	JoinedAt        int64                  `json:"joined_at"`
+	LastSeen        int64                  `json:"last_seen"`
+	State           map[string]interface{} `json:"state"`
+}
+
+func (m PresenceMember) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":                m.Id,
+		"user_reference_id": m.UserReferenceId,
+		"joined_at":         m.JoinedAt,
+		"last_seen":         m.LastSeen,
+		"state":             m.State,
+	}
+}
+
+// PresenceStore keeps the members of the rooms for the whole cluster
+type PresenceStore interface {
+	Put(room string, member PresenceMember, timeout time.Duration) error
+	Remove(room string, memberId string) error
+	Members(room string) ([]PresenceMember, error)
+}
+
+// olricPresenceStore keeps every member as an entry of the presence DMap which expires when the heartbeats
+// of the member stop, as when its node goes down
+type olricPresenceStore struct {
+	dmap *olric.DMap
+}
+
+func NewOlricPresenceStore(olricDb *olric.Olric) (PresenceStore, error) {
+	dmap, err := olricDb.NewDMap(presenceName)
+	if err != nil {
+		return nil, err
+	}
+	return &olricPresenceStore{dmap: dmap}, nil
+}
+
+func presenceKey(room string, memberId string) string {
+	return room + "|" + memberId
+}
+
+func (s *olricPresenceStore) Put(room string, member PresenceMember, timeout time.Duration) error {
+	// values are stored as json since the default serializer needs every type registered
+	value, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	return s.dmap.PutEx(presenceKey(room, member.Id), string(value), timeout)
+}
+
+func (s *olricPresenceStore) Remove(room string, memberId string) error {
+	return s.dmap.Delete(presenceKey(room, memberId))
+}
+
+func (s *olricPresenceStore) Members(room string) ([]PresenceMember, error) {
+	cursor, err := s.dmap.Query(query.M{
+		"$onKey": query.M{
+			"$regexMatch": "^" + regexp.QuoteMeta(presenceKey(room, "")),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]PresenceMember, 0)
+	err = cursor.Range(func(key string, value interface{}) bool {
+		memberJson, ok := value.(string)
+		if !ok {
+			return true
+		}
+		var member PresenceMember
+		if json.Unmarshal([]byte(memberJson), &member) == nil {
+			members = append(members, member)
+		}
+		return true
+	})
+	return members, err
+}
+
+// Presence tracks which users are connected to the rooms bound to table rows, named "<table>:<reference id>".
+// Members are kept in the PresenceStore and every change is broadcast on the presence topic, each node
+// forwards it to its own clients in the room
+type Presence struct {
+	store   PresenceStore
+	topic   *olric.DTopic
+	timeout time.Duration
+	nodeId  string
+	cruds   map[string]*resource.DbResource
+	lock    sync.RWMutex
+	// rooms are the clients of this node in every room with their member
+	rooms map[string]map[int]*presenceConnection
+}
+
+type presenceConnection struct {
+	client *Client
+	member PresenceMember
+}
+
+// NewPresence uses olric to share the rooms with the cluster, without it only the clients of this node
+// are tracked
+func NewPresence(olricDb *olric.Olric, cruds map[string]*resource.DbResource) *Presence {
+
+	var store PresenceStore = NewMemoryPresenceStore()
+	var topic *olric.DTopic
+	if olricDb != nil {
+		olricStore, err := NewOlricPresenceStore(olricDb)
+		if !resource.CheckErr(err, "Failed to create presence store") {
+			store = olricStore
+		}
+		topic, err = olricDb.NewDTopic(presenceName, 4, 1)
+		resource.CheckErr(err, "Failed to create presence topic")
+	}
+
+	presence := newPresence(store, cruds)
+	if topic != nil {
+		presence.topic = topic
+		_, err := topic.AddListener(func(message olric.DTopicMessage) {
+			eventMessage, ok := message.Message.(resource.EventMessage)
+			if ok {
+				presence.deliver(eventMessage)
+			}
+		})
+		resource.CheckErr(err, "Failed to listen for presence changes")
+	}
+	return presence
+}
+
+func newPresence(store PresenceStore, cruds map[string]*resource.DbResource) *Presence {
+	return &Presence{
+		store:   store,
+		timeout: DefaultPresenceTimeout,
+		nodeId:  resource.NewEventId(),
+		cruds:   cruds,
+		rooms:   make(map[string]map[int]*presenceConnection),
+	}
+}
+
+// Join adds the client to the room after checking the user can read the row of the room
+func (p *Presence) Join(client *Client, room string, state map[string]interface{}) error {
+
+	err := p.authorize(client.user, room)
+	if err != nil {
+		return err
+	}
+	return p.join(client, room, state)
+}
+
+func (p *Presence) join(client *Client, room string, state map[string]interface{}) error {
+	if state == nil {
+		state = make(map[string]interface{})
+	}
+
+	now := time.Now().Unix()
+	member := PresenceMember{
+		Id:              fmt.Sprintf("%v-%d", p.nodeId, client.id),
+		UserReferenceId: client.user.UserReferenceId,
+		JoinedAt:        now,
+		LastSeen:        now,
+		State:           state,
+	}
+
+	p.lock.Lock()
+	connections, ok := p.rooms[room]
+	if !ok {
+		connections = make(map[int]*presenceConnection)
+		p.rooms[room] = connections
+	}
+	if existing, ok := connections[client.id]; ok {
+		member.JoinedAt = existing.member.JoinedAt
+	}
+	connections[client.id] = &presenceConnection{client: client, member: member}
+	p.lock.Unlock()
+
+	err := p.store.Put(room, member, p.timeout)
+	if err != nil {
+		return err
+	}
+	p.broadcast(room, "join", member)
+	return nil
+}
+
+// Leave removes the client from the room
+func (p *Presence) Leave(client *Client, room string) {
+
+	p.lock.Lock()
+	connection, ok := p.rooms[room][client.id]
+	if ok {
+		delete(p.rooms[room], client.id)
+		if len(p.rooms[room]) == 0 {
+			delete(p.rooms, room)
+		}
+	}
+	p.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	err := p.store.Remove(room, connection.member.Id)
+	resource.CheckErr(err, "Failed to remove member from room [%v]", room)
+	p.broadcast(room, "leave", connection.member)
+}
+
+// LeaveAll removes a disconnected client from its rooms
+func (p *Presence) LeaveAll(client *Client) {
+	p.lock.RLock()
+	rooms := make([]string, 0)
+	for room, connections := range p.rooms {
+		if _, ok := connections[client.id]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+	p.lock.RUnlock()
+
+	for _, room := range rooms {
+		p.Leave(client, room)
+	}
+}
+
+// Heartbeat keeps the client in the room and sends it the current members, which drops members whose
+// heartbeats stopped
+func (p *Presence) Heartbeat(client *Client, room string) error {
+	member, err := p.updateMember(client, room, nil)
+	if err != nil {
+		return err
+	}
+	err = p.store.Put(room, member, p.timeout)
+	if err != nil {
+		return err
+	}
+	return p.SendMembers(client, room)
+}
+
+// SetState merges the ephemeral state of the client in the room and broadcasts it to the room
+func (p *Presence) SetState(client *Client, room string, state map[string]interface{}) error {
+	member, err := p.updateMember(client, room, state)
+	if err != nil {
+		return err
+	}
+	err = p.store.Put(room, member, p.timeout)
+	if err != nil {
+		return err
+	}
+	p.publish(room, "state", &member, nil)
+	return nil
+}
+
+// SendMembers sends the members of a room the client joined to the client
+func (p *Presence) SendMembers(client *Client, room string) error {
+	p.lock.RLock()
+	_, ok := p.rooms[room][client.id]
+	p.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("not a member of room [%v]", room)
+	}
+
+	members, err := p.Members(room)
+	if err != nil {
+		return err
+	}
+	client.Write(p.message(room, "members", nil, members))
+	return nil
+}
+
+// Members are the members of the room across the cluster in the order they joined
+func (p *Presence) Members(room string) ([]PresenceMember, error) {
+	members, err := p.store.Members(room)
+	if err != nil {
+		return nil, err
+	}
+
+	expiredBefore := time.Now().Add(-p.timeout).Unix()
+	active := make([]PresenceMember, 0, len(members))
+	for _, member := range members {
+		if member.LastSeen >= expiredBefore {
+			active = append(active, member)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].JoinedAt != active[j].JoinedAt {
+			return active[i].JoinedAt < active[j].JoinedAt
+		}
+		return active[i].Id < active[j].Id
+	})
+	return active, nil
+}
+
+func (p *Presence) updateMember(client *Client, room string, state map[string]interface{}) (PresenceMember, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	connection, ok := p.rooms[room][client.id]
+	if !ok {
+		return PresenceMember{}, fmt.Errorf("not a member of room [%v]", room)
+	}
+	connection.member.LastSeen = time.Now().Unix()
+	if state != nil {
+		merged := make(map[string]interface{}, len(connection.member.State)+len(state))
+		for key, value := range connection.member.State {
+			merged[key] = value
+		}
+		for key, value := range state {
+			merged[key] = value
+		}
+		connection.member.State = merged
+	}
+	return connection.member, nil
+}
+
+// broadcast sends a join or leave to the room with the members after the change
+func (p *Presence) broadcast(room string, eventType string, member PresenceMember) {
+	members, err := p.Members(room)
+	if resource.CheckErr(err, "Failed to list members of room [%v]", room) {
+		members = []PresenceMember{}
+	}
+	p.publish(room, eventType, &member, members)
+}
+
+func (p *Presence) publish(room string, eventType string, member *PresenceMember, members []PresenceMember) {
+	message := p.message(room, eventType, member, members)
+	if p.topic == nil {
+		p.deliver(message)
+		return
+	}
+	err := p.topic.Publish(message)
+	resource.CheckErr(err, "Failed to publish presence %v in room [%v]", eventType, room)
+}
+
+// message carries the members as maps so clients get the same json whether it was sent by this node or
+// decoded from another one
+func (p *Presence) message(room string, eventType string, member *PresenceMember, members []PresenceMember) resource.EventMessage {
+	eventData := map[string]interface{}{
+		"room": room,
+	}
+	if member != nil {
+		eventData["member"] = member.toMap()
+	}
+	if members != nil {
+		memberList := make([]interface{}, len(members))
+		for i, m := range members {
+			memberList[i] = m.toMap()
+		}
+		eventData["members"] = memberList
+	}
+	return resource.EventMessage{
+		EventId:       resource.NewEventId(),
+		MessageSource: "presence",
+		EventType:     eventType,
+		ObjectType:    presenceName,
+		EventData:     eventData,
+	}
+}
+
+// deliver forwards a change to the clients of this node in the room
+func (p *Presence) deliver(message resource.EventMessage) {
+	room, ok := message.EventData["room"].(string)
+	if !ok {
+		return
+	}
+
+	p.lock.RLock()
+	clients := make([]*Client, 0, len(p.rooms[room]))
+	for _, connection := range p.rooms[room] {
+		clients = append(clients, connection.client)
+	}
+	p.lock.RUnlock()
+
+	for _, client := range clients {
+		client.Write(message)
+	}
+}
+
+// authorize checks the room is bound to a row the user can read
+func (p *Presence) authorize(user *auth.SessionUser, room string) error {
+	parts := strings.SplitN(room, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid room [%v], expected <table>:<reference id>", room)
+	}
+	typeName, referenceId := parts[0], parts[1]
+
+	crud, ok := p.cruds[typeName]
+	if !ok || !user.AllowsTable(typeName, "GET") {
+		return errors.New("forbidden")
+	}
+	row, _, err := crud.GetSingleRowByReferenceId(typeName, referenceId, nil)
+	if err != nil {
+		return errors.New("forbidden")
+	}
+	if !crud.GetRowPermission(row).CanRead(user.UserReferenceId, user.Groups) {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+// memoryPresenceStore keeps the members of a single node, when olric is not available
+type memoryPresenceStore struct {
+	lock    sync.Mutex
+	members map[string]map[string]PresenceMember
+}
+
+func NewMemoryPresenceStore() PresenceStore {
+	return &memoryPresenceStore{
+		members: make(map[string]map[string]PresenceMember),
+	}
+}
+
+func (s *memoryPresenceStore) Put(room string, member PresenceMember, timeout time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.members[room] == nil {
+		s.members[room] = make(map[string]PresenceMember)
+	}
+	s.members[room][member.Id] = member
+	return nil
+}
+
+func (s *memoryPresenceStore) Remove(room string, memberId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.members[room], memberId)
+	if len(s.members[room]) == 0 {
+		delete(s.members, room)
+	}
+	return nil
+}
+
+func (s *memoryPresenceStore) Members(room string) ([]PresenceMember, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	members := make([]PresenceMember, 0, len(s.members[room]))
+	for _, member := range s.members[room] {
+		members = append(members, member)
+	}
+	return members, nil
+}
@@ -0,0 +1,126 @@
+package websockets
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"testing"
+	"time"
+)
+
+func newPresenceTestClient(id int, userReferenceId string) *Client {
+	return &Client{
+		id:   id,
+		ch:   make(chan resource.EventMessage, channelBufSize),
+		user: &auth.SessionUser{UserReferenceId: userReferenceId},
+	}
+}
+
+func nextPresenceMessage(t *testing.T, client *Client) resource.EventMessage {
+	select {
+	case message := <-client.ch:
+		return message
+	default:
+		t.Fatalf("expected a message for client %d", client.id)
+	}
+	return resource.EventMessage{}
+}
+
+func presenceMemberIds(message resource.EventMessage) []string {
+	ids := make([]string, 0)
+	members, _ := message.EventData["members"].([]interface{})
+	for _, member := range members {
+		ids = append(ids, member.(map[string]interface{})["user_reference_id"].(string))
+	}
+	return ids
+}
+
+func TestPresenceRooms(t *testing.T) {
+
+	presence := newPresence(NewMemoryPresenceStore(), map[string]*resource.DbResource{})
+	alice := newPresenceTestClient(1, "alice")
+	bob := newPresenceTestClient(2, "bob")
+	room := "document:4b3a"
+
+	err := presence.join(alice, room, map[string]interface{}{"cursor": 1})
+	if err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	message := nextPresenceMessage(t, alice)
+	if message.EventType != "join" || len(presenceMemberIds(message)) != 1 {
+		t.Errorf("unexpected join message %v", message)
+	}
+
+	err = presence.join(bob, room, nil)
+	if err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	for _, client := range []*Client{alice, bob} {
+		message = nextPresenceMessage(t, client)
+		ids := presenceMemberIds(message)
+		if message.EventType != "join" || len(ids) != 2 {
+			t.Errorf("unexpected join message %v", message)
+		}
+	}
+
+	err = presence.SetState(bob, room, map[string]interface{}{"typing": true})
+	if err != nil {
+		t.Fatalf("Failed to set state: %v", err)
+	}
+	message = nextPresenceMessage(t, alice)
+	member := message.EventData["member"].(map[string]interface{})
+	if message.EventType != "state" || member["user_reference_id"] != "bob" || member["state"].(map[string]interface{})["typing"] != true {
+		t.Errorf("unexpected state message %v", message)
+	}
+	nextPresenceMessage(t, bob)
+
+	err = presence.Heartbeat(alice, room)
+	if err != nil {
+		t.Fatalf("Failed to send heartbeat: %v", err)
+	}
+	message = nextPresenceMessage(t, alice)
+	if message.EventType != "members" || len(presenceMemberIds(message)) != 2 {
+		t.Errorf("unexpected members message %v", message)
+	}
+	if len(bob.ch) != 0 {
+		t.Errorf("expected the heartbeat to be answered to the sender only")
+	}
+
+	presence.LeaveAll(bob)
+	message = nextPresenceMessage(t, alice)
+	ids := presenceMemberIds(message)
+	if message.EventType != "leave" || len(ids) != 1 || ids[0] != "alice" {
+		t.Errorf("unexpected leave message %v", message)
+	}
+	if len(bob.ch) != 0 {
+		t.Errorf("expected no messages after leaving")
+	}
+
+	err = presence.SetState(bob, room, map[string]interface{}{"typing": false})
+	if err == nil {
+		t.Errorf("expected state of a client outside the room to fail")
+	}
+}
+
+func TestPresenceMembersExpire(t *testing.T) {
+	store := NewMemoryPresenceStore()
+	presence := newPresence(store, map[string]*resource.DbResource{})
+
+	now := time.Now()
+	_ = store.Put("document:4b3a", PresenceMember{Id: "a", LastSeen: now.Unix(), JoinedAt: now.Unix()}, presence.timeout)
+	_ = store.Put("document:4b3a", PresenceMember{Id: "b", LastSeen: now.Add(-time.Hour).Unix(), JoinedAt: now.Unix()}, presence.timeout)
+
+	members, err := presence.Members("document:4b3a")
+	if err != nil || len(members) != 1 || members[0].Id != "a" {
+		t.Errorf("expected members without heartbeat to be dropped %v %v", members, err)
+	}
+}
+
+func TestPresenceAuthorize(t *testing.T) {
+	presence := newPresence(NewMemoryPresenceStore(), map[string]*resource.DbResource{})
+	user := &auth.SessionUser{UserReferenceId: "alice"}
+	for _, room := range []string{"", "document", "document:", ":4b3a", "missing:4b3a"} {
+		if presence.authorize(user, room) == nil {
+			t.Errorf("expected room [%v] to be rejected", room)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package websockets
 
 import (
+	"encoding/json"
 	"github.com/buraksezer/olric"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/resource"
 	log "github.com/sirupsen/logrus"
 	"strings"
+	"sync"
 )
 
 // WebSocketConnectionHandlerImpl : Each websocket connection has its own handler
@@ -14,6 +16,72 @@ type WebSocketConnectionHandlerImpl struct {
 	subscribedTopics map[string]uint64
 	olricDb          *olric.Olric
 	cruds            map[string]*resource.DbResource
+	eventLog         *EventLog
+	presence         *Presence
+}
+
+// subscription holds the filters a client subscribed to a topic with
+type subscription struct {
+	eventType string
+	// filters are matched for equality with the event data
+	filters map[string]interface{}
+	// queries use the same format as the query param of the find all api
+	queries []resource.Query
+	// ids of messages already sent while resuming, so they are not sent again by the listener
+	replayed map[string]bool
+	lock     sync.Mutex
+}
+
+func (s *subscription) matches(eventMessage resource.EventMessage) bool {
+	if s.eventType != "" && eventMessage.EventType != s.eventType {
+		return false
+	}
+	for key, val := range s.filters {
+		if eventMessage.EventData[key] != val {
+			return false
+		}
+	}
+	if len(s.queries) > 0 {
+		matched, err := resource.MatchQueries(eventMessage.EventData, s.queries)
+		if err != nil {
+			log.Printf("Failed to match subscription query: %v", err)
+			return false
+		}
+		return matched
+	}
+	return true
+}
+
+// send delivers the message to the client if the client can read it and it matches the subscription
+func (wsch *WebSocketConnectionHandlerImpl) send(client *Client, sub *subscription, eventMessage resource.EventMessage) {
+
+	typeName, _ := eventMessage.EventData["__type"]
+	tableExists := false
+	if typeName != nil {
+		_, tableExists = wsch.cruds[typeName.(string)]
+	}
+
+	permission := resource.PermissionInstance{Permission: auth.ALLOW_ALL_PERMISSIONS}
+
+	if tableExists {
+		if !client.user.AllowsTable(typeName.(string), "GET") {
+			return
+		}
+		permission = wsch.cruds["world"].GetRowPermission(eventMessage.EventData)
+
+	}
+	if !permission.CanRead(client.user.UserReferenceId, client.user.Groups) {
+		return
+	}
+
+	if tableExists {
+		// the event is shared by every client on the topic, restricted columns are removed from a copy
+		eventMessage.EventData = wsch.cruds["world"].StripUnreadableColumns(client.user, []map[string]interface{}{eventMessage.EventData})[0]
+	}
+
+	if sub.matches(eventMessage) {
+		client.ch <- eventMessage
+	}
 }
 
 func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketPayload, client *Client) {
@@ -30,66 +98,76 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 		filters, ok := message.Payload["filters"]
 		var filtersMap map[string]interface{}
 		if ok {
-			filtersMap = filters.(map[string]interface{})
+			filtersMap, _ = filters.(map[string]interface{})
+		}
+
+		queries, err := parseSubscriptionQuery(message.Payload["query"])
+		if err != nil {
+			log.Printf("Invalid query in subscription to [%v]: %v", topics, err)
+			return
 		}
 
 		topicsList := strings.Split(topics, ",")
 		for _, topic := range topicsList {
 			_, ok := wsch.subscribedTopics[topic]
+			if ok {
+				continue
+			}
+			dtopic, ok := (*wsch.DtopicMap)[topic]
 			if !ok {
-				var err error
-				eventType, ok := filtersMap["EventType"]
-				eventTypeString := ""
-				if ok {
-					eventTypeString = eventType.(string)
-					delete(filtersMap, "EventType")
+				log.Printf("topic does not exist: %v", topic)
+				continue
+			}
+
+			sub := &subscription{
+				filters:  make(map[string]interface{}),
+				queries:  queries,
+				replayed: make(map[string]bool),
+			}
+			for key, val := range filtersMap {
+				if key == "EventType" {
+					sub.eventType, _ = val.(string)
+					continue
 				}
-				wsch.subscribedTopics[topic], err = (*wsch.DtopicMap)[topic].AddListener(func(eventType string, filtersMap map[string]interface{}) func(olric.DTopicMessage) {
-					return func(message olric.DTopicMessage) {
-						eventMessage := message.Message.(resource.EventMessage)
-
-						typeName, _ := eventMessage.EventData["__type"]
-						tableExists := false
-						if typeName != nil {
-							_, tableExists = wsch.cruds[typeName.(string)]
-						}
-
-						permission := resource.PermissionInstance{Permission: auth.ALLOW_ALL_PERMISSIONS}
-
-						if tableExists {
-							permission = wsch.cruds["world"].GetRowPermission(eventMessage.EventData)
-
-						}
-						if permission.CanRead(client.user.UserReferenceId, client.user.Groups) {
-
-							sendMessage := true
-							if filtersMap != nil {
-
-								if eventType != "" {
-									if eventMessage.EventType != eventType {
-										return
-									}
-								}
-
-								for key, val := range filtersMap {
-									if eventMessage.EventData[key] != val {
-										sendMessage = false
-										break
-									}
-								}
-							}
-							if sendMessage {
-								client.ch <- eventMessage
-							}
-
-						}
-
-					}
-				}(eventTypeString, filtersMap))
-				if err != nil {
-					log.Printf("Failed to add listener to topic: %v", err)
+				sub.filters[key] = val
+			}
+
+			// messages received while the missed messages are being replayed wait for the replay to finish
+			sub.lock.Lock()
+			wsch.subscribedTopics[topic], err = dtopic.AddListener(func(message olric.DTopicMessage) {
+				eventMessage := message.Message.(resource.EventMessage)
+
+				sub.lock.Lock()
+				defer sub.lock.Unlock()
+				if sub.replayed[eventMessage.EventId] {
+					delete(sub.replayed, eventMessage.EventId)
+					return
+				}
+				wsch.send(client, sub, eventMessage)
+			})
+			if err != nil {
+				log.Printf("Failed to add listener to topic: %v", err)
+			}
+
+			lastEventId := lastEventIdForTopic(message.Payload["last_event_id"], topic)
+			if lastEventId != "" && wsch.eventLog != nil {
+				missed, complete := wsch.eventLog.Since(topic, lastEventId)
+				for _, eventMessage := range missed {
+					sub.replayed[eventMessage.EventId] = true
+					wsch.send(client, sub, eventMessage)
+				}
+				client.ch <- resource.EventMessage{
+					EventData: map[string]interface{}{
+						"topic":    topic,
+						"replayed": len(missed),
+						"complete": complete,
+					},
+					MessageSource: "system",
+					EventType:     "response",
+					ObjectType:    "resume",
 				}
 			}
+			sub.lock.Unlock()
 		}
 	case "create-topic":
 		topic, ok := message.Payload["name"].(string)
@@ -104,9 +182,14 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 		}
 
 		newTopic, err := wsch.olricDb.NewDTopic(topic, 4, 1)
-		resource.CheckErr(err, "Failed to create new topic on client request [%v]", topic)
+		if resource.CheckErr(err, "Failed to create new topic on client request [%v]", topic) {
+			return
+		}
 
 		(*wsch.DtopicMap)[topic] = newTopic
+		if wsch.eventLog != nil {
+			wsch.eventLog.Watch(topic, newTopic)
+		}
 
 	case "list-topic":
 		topics := make([]string, 0)
@@ -139,6 +222,9 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 		err := (*wsch.DtopicMap)[topic].Destroy()
 		resource.CheckErr(err, "failed to destroy topic")
 		delete(*wsch.DtopicMap, topic)
+		if wsch.eventLog != nil {
+			wsch.eventLog.Forget(topic)
+		}
 
 	case "new-message":
 		var err error
@@ -154,6 +240,7 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 		}
 
 		err = topic.Publish(resource.EventMessage{
+			EventId:       resource.NewEventId(),
 			MessageSource: client.user.UserReferenceId,
 			EventType:     "new-message",
 			ObjectType:    topicName,
@@ -162,6 +249,43 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 
 		resource.CheckErr(err, "Failed to publish message on topic")
 
+	case "presence-join":
+		room, _ := message.Payload["room"].(string)
+		state, _ := message.Payload["state"].(map[string]interface{})
+		err := wsch.presence.Join(client, room, state)
+		if err != nil {
+			wsch.presenceError(client, room, err)
+		}
+
+	case "presence-leave":
+		room, _ := message.Payload["room"].(string)
+		wsch.presence.Leave(client, room)
+
+	case "presence-heartbeat":
+		room, _ := message.Payload["room"].(string)
+		err := wsch.presence.Heartbeat(client, room)
+		if err != nil {
+			wsch.presenceError(client, room, err)
+		}
+
+	case "presence-state":
+		room, _ := message.Payload["room"].(string)
+		state, ok := message.Payload["state"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		err := wsch.presence.SetState(client, room, state)
+		if err != nil {
+			wsch.presenceError(client, room, err)
+		}
+
+	case "presence-members":
+		room, _ := message.Payload["room"].(string)
+		err := wsch.presence.SendMembers(client, room)
+		if err != nil {
+			wsch.presenceError(client, room, err)
+		}
+
 	case "unsubscribe":
 		topics := message.Payload["topic"].(string)
 		if len(topics) < 1 {
@@ -180,3 +304,53 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 		}
 	}
 }
+
+// presenceError tells the client a presence request for the room failed
+func (wsch *WebSocketConnectionHandlerImpl) presenceError(client *Client, room string, err error) {
+	log.Printf("Presence request for room [%v] failed: %v", room, err)
+	client.ch <- resource.EventMessage{
+		EventData: map[string]interface{}{
+			"room":  room,
+			"error": err.Error(),
+		},
+		MessageSource: "system",
+		EventType:     "response",
+		ObjectType:    "presence-error",
+	}
+}
+
+// parseSubscriptionQuery reads the query of a subscription, as a list of {column, operator, value}
+// objects or the same list as a json string
+func parseSubscriptionQuery(query interface{}) ([]resource.Query, error) {
+	queries := make([]resource.Query, 0)
+	if query == nil {
+		return queries, nil
+	}
+
+	var queryJson []byte
+	if queryString, ok := query.(string); ok {
+		queryJson = []byte(queryString)
+	} else {
+		var err error
+		queryJson, err = json.Marshal(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := json.Unmarshal(queryJson, &queries)
+	return queries, err
+}
+
+// lastEventIdForTopic reads the id of the last message seen by a resuming client, which is either one
+// id or a map of topic name to id when subscribing to many topics at once
+func lastEventIdForTopic(lastEventId interface{}, topic string) string {
+	switch v := lastEventId.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		id, _ := v[topic].(string)
+		return id
+	}
+	return ""
+}
@@ -40,6 +40,8 @@ func NewClient(ws *websocket.Conn, server *Server) (*Client, error) {
 		subscribedTopics: make(map[string]uint64),
 		olricDb:          server.olricDb,
 		cruds:            server.cruds,
+		eventLog:         server.eventLog,
+		presence:         server.presence,
 	}
 
 	maxId++
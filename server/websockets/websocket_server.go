@@ -26,6 +26,8 @@ type Server struct {
 	dtopicMap *map[string]*olric.DTopic
 	olricDb   *olric.Olric
 	cruds     map[string]*resource.DbResource
+	eventLog  *EventLog
+	presence  *Presence
 }
 
 // Create new chat server.
@@ -36,6 +38,11 @@ func NewServer(pattern string, dtopicMap *map[string]*olric.DTopic, cruds map[st
 	doneCh := make(chan bool)
 	errCh := make(chan error)
 
+	eventLog := NewEventLog(DefaultEventLogSize)
+	for topicName, topic := range *dtopicMap {
+		eventLog.Watch(topicName, topic)
+	}
+
 	return &Server{
 		pattern:   pattern,
 		clients:   clients,
@@ -46,6 +53,8 @@ func NewServer(pattern string, dtopicMap *map[string]*olric.DTopic, cruds map[st
 		dtopicMap: dtopicMap,
 		olricDb:   cruds["world"].OlricDb,
 		cruds:     cruds,
+		eventLog:  eventLog,
+		presence:  NewPresence(cruds["world"].OlricDb, cruds),
 	}
 }
 
@@ -126,6 +135,8 @@ func (s *Server) Listen(router *gin.Engine) {
 		case c := <-s.delCh:
 			log.Println("Delete client")
 			delete(s.clients, c.id)
+			// leaving writes to the other clients, which can call Del on a full buffer
+			go s.presence.LeaveAll(c)
 
 			//	// broadcast message for all clients
 			//case msg := <-s.sendAllCh:
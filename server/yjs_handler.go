@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// CreateYjsDocumentHandler joins the session user to a collaboratively edited document, which needs update
+// permission on the row the document belongs to
+func CreateYjsDocumentHandler(cruds map[string]*resource.DbResource, yjsConnectionHandler func(http.ResponseWriter, *http.Request)) func(*gin.Context) {
+	return func(ginContext *gin.Context) {
+
+		document, ok := yjsDocumentAccess(ginContext, cruds, ginContext.Param("documentName"), true)
+		if !ok {
+			return
+		}
+
+		ginContext.Request = ginContext.Request.WithContext(context.WithValue(ginContext.Request.Context(), "roomname", document.Name()))
+		yjsConnectionHandler(ginContext.Writer, ginContext.Request)
+	}
+}
+
+// CreateYjsDocumentHistoryHandler lists the saved versions of a document to users who can read its row. It only
+// reads, the versions are saved by the document store and before a restore
+func CreateYjsDocumentHistoryHandler(cruds map[string]*resource.DbResource) func(*gin.Context) {
+	return func(ginContext *gin.Context) {
+
+		document, ok := yjsDocumentAccess(ginContext, cruds, ginContext.Param("documentName"), false)
+		if !ok {
+			return
+		}
+
+		snapshots, err := cruds[resource.DOCUMENT_SNAPSHOT_TABLE_NAME].ListYjsDocumentSnapshots(document.Name())
+		if err != nil {
+			ginContext.AbortWithError(500, err)
+			return
+		}
+		ginContext.JSON(200, snapshots)
+	}
+}
+
+// CreateYjsDocumentRestoreHandler replaces a document with one of its saved versions, which needs update
+// permission on its row
+func CreateYjsDocumentRestoreHandler(cruds map[string]*resource.DbResource, documentStore *resource.YjsDocumentStore) func(*gin.Context) {
+	return func(ginContext *gin.Context) {
+
+		document, ok := yjsDocumentAccess(ginContext, cruds, ginContext.Param("documentName"), true)
+		if !ok {
+			return
+		}
+
+		// keep the current state in the history so the restore can be undone
+		err := documentStore.Snapshot(document.Name())
+		resource.CheckErr(err, "Failed to snapshot document [%v]", document.Name())
+
+		err = documentStore.Restore(document.Name(), ginContext.Param("snapshotId"))
+		if err != nil {
+			resource.CheckErr(err, "Failed to restore document [%v]", document.Name())
+			ginContext.AbortWithStatus(404)
+			return
+		}
+		ginContext.JSON(200, gin.H{
+			"document_name": document.Name(),
+			"snapshot_id":   ginContext.Param("snapshotId"),
+		})
+	}
+}
+
+// yjsDocumentAccess checks the document belongs to a file column of an existing row the session user can
+// read, or update when update is set, and that an api key session is scoped to the table. The request is
+// aborted when it does not
+func yjsDocumentAccess(ginContext *gin.Context, cruds map[string]*resource.DbResource, documentName string, update bool) (resource.YjsDocument, bool) {
+
+	sessionUser, ok := ginContext.Request.Context().Value("user").(*auth.SessionUser)
+	if !ok || sessionUser == nil {
+		ginContext.AbortWithStatus(401)
+		return resource.YjsDocument{}, false
+	}
+
+	document, err := resource.ParseYjsDocumentName(documentName)
+	if err != nil {
+		ginContext.AbortWithStatus(400)
+		return document, false
+	}
+
+	crud, ok := cruds[document.TypeName]
+	if !ok {
+		ginContext.AbortWithStatus(404)
+		return document, false
+	}
+
+	method := "GET"
+	if update {
+		method = "PATCH"
+	}
+	if !sessionUser.AllowsTable(document.TypeName, method) {
+		ginContext.AbortWithStatus(403)
+		return document, false
+	}
+
+	column, ok := crud.TableInfo().GetColumnByName(document.ColumnName)
+	if !ok || !BeginsWithCheck(column.ColumnType, "file.") {
+		ginContext.AbortWithStatus(404)
+		return document, false
+	}
+
+	object, _, err := crud.GetSingleRowByReferenceId(document.TypeName, document.ReferenceId, nil)
+	if err != nil {
+		ginContext.AbortWithStatus(404)
+		return document, false
+	}
+
+	permission := crud.GetRowPermission(object)
+	allowed := permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups)
+	if update {
+		allowed = permission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups)
+	}
+	if !allowed {
+		ginContext.AbortWithStatus(403)
+		return document, false
+	}
+
+	return document, true
+}
@@ -118,7 +118,7 @@ Imports:
     Entity: site
     FileType: json`
 
-func createServer() (server.HostSwitch, *guerrilla.Daemon, resource.TaskScheduler, *resource.ConfigStore,
+func createServer() (*server.HostSwitch, *guerrilla.Daemon, resource.TaskScheduler, *resource.ConfigStore,
 	*resource.CertificateManager, *server2.FtpServer, *ImapServer.Server, *olric.Olric) {
 
 	log.SetOutput(ioutil.Discard)
@@ -197,13 +197,16 @@ func createServer() (server.HostSwitch, *guerrilla.Daemon, resource.TaskSchedule
 	}
 	log.Printf("Connection acquired from database")
 
-	var hostSwitch server.HostSwitch
+	var hostSwitch *server.HostSwitch
 	var mailDaemon *guerrilla.Daemon
 	var taskScheduler resource.TaskScheduler
+	var documentStore *resource.YjsDocumentStore
+	var outboxWorker *resource.OutboxDeliveryWorker
 	var configStore *resource.ConfigStore
 	var certManager *resource.CertificateManager
 	//var imapServer *server2.Server
 	var ftpServer *server2.FtpServer
+	var sftpServer *server.DaptinSftpServer
 	var imapServer *ImapServer.Server
 	var olricDb *olric.Olric
 
@@ -233,20 +236,29 @@ func createServer() (server.HostSwitch, *guerrilla.Daemon, resource.TaskSchedule
 	configStore.SetConfigValueFor("limit.max_connectioins", "5000", "backend")
 	configStore.SetConfigValueFor("limit.rate", "5000", "backend")
 
-	hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, imapServer, olricDb = server.Main(boxRoot, db, "./local", olricDb)
+	hostSwitch, mailDaemon, taskScheduler, documentStore, outboxWorker, configStore, certManager, ftpServer, sftpServer, imapServer, olricDb = server.Main(boxRoot, db, "./local", olricDb)
 
 	rhs := TestRestartHandlerServer{
-		HostSwitch: &hostSwitch,
+		HostSwitch: hostSwitch,
 	}
 
 	trigger.On("restart", func() {
 		log.Printf("Trigger restart")
 
+		hostSwitch.Close()
 		taskScheduler.StopTasks()
+		documentStore.Stop()
+		outboxWorker.Stop()
 
 		mailDaemon.Shutdown()
 		ftpServer.Stop()
+		if sftpServer != nil {
+			sftpServer.Close()
+		}
 		imapServer.Close()
+		if imapBackend, ok := imapServer.Backend.(*resource.DaptinImapBackend); ok {
+			imapBackend.Close()
+		}
 		err = db.Close()
 		if err != nil {
 			log.Printf("Failed to close DB connections: %v", err)
@@ -254,8 +266,8 @@ func createServer() (server.HostSwitch, *guerrilla.Daemon, resource.TaskSchedule
 
 		db, err = server.GetDbConnection(*dbType, *connectionString)
 
-		hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, imapServer, olricDb = server.Main(boxRoot, db, "./local", olricDb)
-		rhs.HostSwitch = &hostSwitch
+		hostSwitch, mailDaemon, taskScheduler, documentStore, outboxWorker, configStore, certManager, ftpServer, sftpServer, imapServer, olricDb = server.Main(boxRoot, db, "./local", olricDb)
+		rhs.HostSwitch = hostSwitch
 	})
 
 	name, _ := os.Hostname()